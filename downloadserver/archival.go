@@ -0,0 +1,115 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	ocicommon "github.com/oracle/oci-go-sdk/common"
+	ocistorage "github.com/oracle/oci-go-sdk/objectstorage"
+)
+
+/*
+ * Archive storage. Objects in an Archive tier bucket have to be restored before
+ * they can be read, and a GET against one fails with an error that doesn't say
+ * so. When a GET fails, the object's archival state is looked up and archived
+ * objects are answered with 409 Conflict explaining that they must be restored
+ * first. With RestoreArchived set the restore is requested as well.
+ */
+
+// headerArchivalState is the header OCI reports the archival state of an object
+// in, and headerKeptArchivalState where archivalStateKeeper moves it to.
+const (
+	headerArchivalState     = "archival-state"
+	headerKeptArchivalState = "X-Kept-Archival-State"
+)
+
+// archivalStateKeeper passes the requests of an OCI SDK client on to its
+// dispatcher, moving the archival-state header of the responses out of the
+// SDK's way. The SDK panics setting its enum typed ArchivalState fields from
+// the header, so the state is read from the raw response instead.
+type archivalStateKeeper struct {
+	next ocicommon.HTTPRequestDispatcher
+}
+
+func (k *archivalStateKeeper) Do(r *http.Request) (*http.Response, error) {
+	resp, err := k.next.Do(r)
+	if err != nil {
+		return resp, err
+	}
+	if state := resp.Header.Get(headerArchivalState); state != "" {
+		resp.Header.Del(headerArchivalState)
+		resp.Header.Set(headerKeptArchivalState, state)
+	}
+	return resp, err
+}
+
+// archivalState returns the archival state of the object of an SDK response,
+// "" when OCI reported none.
+func archivalState(raw *http.Response) string {
+	if raw == nil {
+		return ""
+	}
+	return raw.Header.Get(headerKeptArchivalState)
+}
+
+// DefaultRestoreHours is how long a restored object stays readable when
+// RestoreHours isn't set.
+const DefaultRestoreHours = 24
+
+// restoreEstimate is the time a restore typically takes to complete.
+const restoreEstimate = time.Hour
+
+// archivedObject returns a 409 Conflict error when object in region is archived
+// or still being restored, requesting its restore first when RestoreArchived is
+// set. It returns nil when the object is readable or its state is unknown.
+func (ds *DownloadServer) archivedObject(ctx context.Context, region string, object string) error {
+	client, err := ds.objectStorageClient(region)
+	if err != nil {
+		return nil
+	}
+	head, err := client.HeadObject(ctx, ocistorage.HeadObjectRequest{
+		NamespaceName: &ds.Namespace,
+		BucketName:    &ds.BucketName,
+		ObjectName:    &object,
+	})
+	if err != nil {
+		return nil
+	}
+	return ds.archivalError(ctx, client, region, object, archivalState(head.RawResponse))
+}
+
+// archivalError returns the 409 Conflict error for object when its archival
+// state is archived or restoring, and nil otherwise.
+func (ds *DownloadServer) archivalError(ctx context.Context, client ocistorage.ObjectStorageClient, region string, object string, state string) error {
+	if state == string(ocistorage.HeadObjectArchivalStateRestoring) {
+		return &statusError{http.StatusConflict, "artifact is archived and is being restored, try again later"}
+	}
+	if state != string(ocistorage.HeadObjectArchivalStateArchived) {
+		return nil
+	}
+	if !ds.RestoreArchived {
+		return &statusError{http.StatusConflict, "artifact is archived and must be restored before it can be downloaded"}
+	}
+
+	hours := ds.RestoreHours
+	if hours <= 0 {
+		hours = DefaultRestoreHours
+	}
+	_, err := client.RestoreObjects(ctx, ocistorage.RestoreObjectsRequest{
+		NamespaceName:         &ds.Namespace,
+		BucketName:            &ds.BucketName,
+		RestoreObjectsDetails: ocistorage.RestoreObjectsDetails{ObjectName: &object, Hours: &hours},
+	})
+	if err != nil {
+		ds.logger().Warn("Failed to restore archived object", Fields{"object": object, "region": region, "error": err.Error()})
+		return &statusError{http.StatusConflict, "artifact is archived and must be restored before it can be downloaded"}
+	}
+	available := time.Now().Add(restoreEstimate).UTC().Format(time.RFC3339)
+	ds.logger().Info("Requested restore of archived object", Fields{"object": object, "region": region, "hours": hours})
+	msg := fmt.Sprintf("artifact is archived, a restore was requested and it should be available by about %s", available)
+	return &statusError{http.StatusConflict, msg}
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestArchivedObject(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/old.tar", []byte("archived"))
+	f.objects["a/old.tar"].archival = "ARCHIVED"
+	ds := f.server()
+
+	rec := testDownload("GET", "t=ten&a=a/old.tar")
+	if rec.Code != 409 || !strings.Contains(rec.Body.String(), "must be restored") {
+		t.Errorf("archived object = %d %q, want 409", rec.Code, rec.Body.String())
+	}
+	if n := f.count(&f.restores); n != 0 {
+		t.Errorf("%d restores requested without RestoreArchived", n)
+	}
+	// An archived object isn't a failure of the region.
+	if s := ds.statistics(); s.Regions != nil && s.Regions[ds.Region].Failures != 0 {
+		t.Errorf("region failures = %d", s.Regions[ds.Region].Failures)
+	}
+
+	ds.RestoreArchived = true
+	rec = testDownload("GET", "t=ten&a=a/old.tar")
+	if rec.Code != 409 || !strings.Contains(rec.Body.String(), "a restore was requested") {
+		t.Errorf("archived object with RestoreArchived = %d %q", rec.Code, rec.Body.String())
+	}
+	if n := f.count(&f.restores); n != 1 {
+		t.Errorf("%d restores requested, want 1", n)
+	}
+	rec = testDownload("GET", "t=ten&a=a/old.tar")
+	if rec.Code != 409 || !strings.Contains(rec.Body.String(), "being restored") {
+		t.Errorf("object being restored = %d %q", rec.Code, rec.Body.String())
+	}
+	if n := f.count(&f.restores); n != 1 {
+		t.Errorf("%d restores requested, want no new one while restoring", n)
+	}
+
+	// The direct path reads the state from its HEAD.
+	ds.DirectFetchThreshold = 1 << 20
+	f.objects["a/old.tar"].archival = "ARCHIVED"
+	ds.RestoreArchived = false
+	if rec := testDownload("GET", "t=ten&a=a/old.tar"); rec.Code != 409 {
+		t.Errorf("archived object fetched directly = %d, want 409", rec.Code)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+/*
+ * Multi-artifact archives. A request with archive=tar and several a= values is
+ * answered with a single tar containing all of the artifacts. The members are
+ * opened concurrently by a bounded pool of workers (OCI PAR creation and GET, or
+ * opening the local file) while a single writer serializes them into the tar in
+ * the order they were requested.
+ */
+
+// DefaultArchiveWorkers is the number of archive members fetched concurrently when
+// ArchiveWorkers isn't set.
+const DefaultArchiveWorkers = 4
+
+// archiveMember is an artifact opened for inclusion in an archive.
+type archiveMember struct {
+	name    string
+	body    io.ReadCloser
+	size    int64
+	modTime time.Time
+}
+
+// memberOpener opens one archive member from a backend.
+type memberOpener func(ctx context.Context, artifact string) (*archiveMember, error)
+
+type memberResult struct {
+	member *archiveMember
+	err    error
+}
+
+// localMember opens archive members from the local storepath.
+func (ds *DownloadServer) localMember(storepath string) memberOpener {
+	return func(ctx context.Context, artifact string) (*archiveMember, error) {
+		artifactPath, err := ds.localPath(storepath, artifact)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(artifactPath)
+		if err != nil {
+			return nil, err
+		}
+		stat, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &archiveMember{name: artifact, body: f, size: stat.Size(), modTime: stat.ModTime()}, nil
+	}
+}
+
+// ociMember opens archive members from OCI Object Storage.
+func (ds *DownloadServer) ociMember(ctx context.Context, artifact string) (*archiveMember, error) {
+	object := ds.ociObjectName(artifact)
+	stream, err := ds.fetchOCIObject(ctx, object, nil)
+	if err != nil {
+		return nil, err
+	}
+	if stream.ContentLength < 0 {
+		stream.Body.Close()
+		return nil, fmt.Errorf("unknown size for archive member %s", object)
+	}
+	modTime, err := http.ParseTime(stream.Header.Get("Last-Modified"))
+	if err != nil {
+		modTime = time.Now()
+	}
+	return &archiveMember{name: artifact, body: stream.Body, size: stream.ContentLength, modTime: modTime}, nil
+}
+
+// streamArchive writes a tar of artifacts to the client. An error opening the first
+// member is returned before anything has been written; any later failure aborts
+// the connection so that the client never sees a truncated archive as complete.
+func (ds *DownloadServer) streamArchive(w http.ResponseWriter, r *http.Request, artifacts []string, open memberOpener) error {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	workers := ds.ArchiveWorkers
+	if workers < 1 {
+		workers = DefaultArchiveWorkers
+	}
+	results := make([]chan memberResult, len(artifacts))
+	for i := range results {
+		results[i] = make(chan memberResult, 1)
+	}
+
+	// A slot is held from the moment a member starts to be opened until the
+	// writer has finished with it, which bounds both the concurrent backend
+	// requests and the number of open member streams.
+	slots := make(chan struct{}, workers)
+	go func() {
+		for i, artifact := range artifacts {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				for j := i; j < len(artifacts); j++ {
+					results[j] <- memberResult{err: ctx.Err()}
+				}
+				return
+			}
+			go func(i int, artifact string) {
+				m, err := open(ctx, artifact)
+				results[i] <- memberResult{m, err}
+			}(i, artifact)
+		}
+	}()
+
+	// On the way out, whether finished, failed or aborted, cancel the outstanding
+	// fetches and close any member that was opened but not written.
+	next := 0
+	defer func() {
+		cancel()
+		for ; next < len(artifacts); next++ {
+			if res := <-results[next]; res.member != nil {
+				res.member.body.Close()
+			}
+		}
+	}()
+
+	tw := tar.NewWriter(w)
+	var nbytes int64
+	for next < len(artifacts) {
+		res := <-results[next]
+		next++
+		if res.err != nil {
+			if next == 1 {
+				return res.err
+			}
+			ds.logger().Error("Archive download aborted", Fields{"artifact": artifacts[next-1], "error": res.err.Error()})
+			panic(http.ErrAbortHandler)
+		}
+		if next == 1 {
+			w.Header().Set("Content-Disposition", "attachment; filename=artifacts.tar")
+			w.Header().Set("Content-Type", "application/x-tar")
+		}
+		m := res.member
+		err := tw.WriteHeader(&tar.Header{
+			Name:     archiveName(m.name),
+			Mode:     0644,
+			Size:     m.size,
+			ModTime:  m.modTime,
+			Typeflag: tar.TypeReg,
+		})
+		if err == nil {
+			var n int64
+			n, err = io.CopyN(tw, m.body, m.size)
+			nbytes += n
+		}
+		m.body.Close()
+		<-slots
+		if err != nil {
+			if ds.Debug {
+				ds.logger().Error("Archive download failed", Fields{"artifact": m.name, "error": err.Error()})
+			}
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	ds.debug(ctx, "Archive download complete", Fields{"members": len(artifacts), "bytes": nbytes})
+	return nil
+}
+
+// archiveName is the name of an artifact inside an archive, kept relative so it
+// can't escape the directory the archive is extracted into.
+func archiveName(artifact string) string {
+	return strings.TrimPrefix(path.Clean("/"+artifact), "/")
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readTar returns the names and contents of the members of a tar.
+func readTar(t *testing.T, data []byte) ([]string, map[string]string) {
+	var names []string
+	contents := make(map[string]string)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names, contents
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(tr)
+		names = append(names, hdr.Name)
+		contents[hdr.Name] = string(content)
+	}
+}
+
+func TestLocalArchive(t *testing.T) {
+	dir := testStore(t, map[string]string{"a.txt": "aaa", "sub/b.txt": "bb", "c.txt": "c"})
+	defer os.RemoveAll(dir)
+	localServer()
+
+	rec := testDownload("GET", "archive=tar&a=c.txt&a=sub/b.txt&a=a.txt&s="+dir)
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "application/x-tar" {
+		t.Fatalf("archive = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	names, contents := readTar(t, rec.Body.Bytes())
+	if fmt.Sprint(names) != "[c.txt sub/b.txt a.txt]" {
+		t.Errorf("members = %v, want them in request order", names)
+	}
+	if contents["sub/b.txt"] != "bb" || contents["a.txt"] != "aaa" {
+		t.Errorf("contents = %v", contents)
+	}
+
+	// A missing first member fails the download before anything is sent.
+	if rec := testDownload("GET", "archive=tar&a=missing&a=a.txt&s="+dir); rec.Code == 200 {
+		t.Errorf("archive with a missing first member = %d", rec.Code)
+	}
+}
+
+func TestOCIArchiveWithObjectPrefix(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("builds/a/f.txt", []byte("fff"))
+	f.put("builds/b.txt", []byte("bb"))
+	ds := f.server()
+	ds.ObjectPrefix = "builds/"
+
+	rec := testDownload("GET", "t=ten&archive=tar&a=a/f.txt&a=b.txt")
+	if rec.Code != 200 {
+		t.Fatalf("archive = %d %q", rec.Code, rec.Body.String())
+	}
+	// The prefix is where the objects live in the bucket, not part of their name.
+	names, contents := readTar(t, rec.Body.Bytes())
+	if fmt.Sprint(names) != "[a/f.txt b.txt]" {
+		t.Errorf("members = %v, want the requested names", names)
+	}
+	if contents["a/f.txt"] != "fff" || contents["b.txt"] != "bb" {
+		t.Errorf("contents = %v", contents)
+	}
+}
+
+func TestArchiveWorkers(t *testing.T) {
+	ds := localServer()
+	ds.ArchiveWorkers = 2
+	var mu sync.Mutex
+	open, maxOpen := 0, 0
+	opener := func(ctx context.Context, artifact string) (*archiveMember, error) {
+		mu.Lock()
+		open++
+		if open > maxOpen {
+			maxOpen = open
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		return &archiveMember{name: artifact, body: &closeFunc{bytes.NewReader([]byte(artifact)), func() {
+			mu.Lock()
+			open--
+			mu.Unlock()
+		}}, size: int64(len(artifact)), modTime: time.Now()}, nil
+	}
+	artifacts := []string{"m0", "m1", "m2", "m3", "m4", "m5"}
+	rec := httptest.NewRecorder()
+	if err := ds.streamArchive(rec, httptest.NewRequest("GET", downloadPath, nil), artifacts, opener); err != nil {
+		t.Fatal(err)
+	}
+	names, _ := readTar(t, rec.Body.Bytes())
+	if fmt.Sprint(names) != fmt.Sprint(artifacts) {
+		t.Errorf("members = %v", names)
+	}
+	if maxOpen > ds.ArchiveWorkers {
+		t.Errorf("%d members open at once, want at most %d", maxOpen, ds.ArchiveWorkers)
+	}
+}
+
+// closeFunc is a reader calling a function when closed.
+type closeFunc struct {
+	io.Reader
+	close func()
+}
+
+func (c *closeFunc) Close() error {
+	c.close()
+	return nil
+}
+
+func TestArchiveName(t *testing.T) {
+	for name, want := range map[string]string{
+		"a/b.txt":          "a/b.txt",
+		"/abs/b.txt":       "abs/b.txt",
+		"../../etc/passwd": "etc/passwd",
+		"a/../../b":        "b",
+	} {
+		if got := archiveName(name); got != want {
+			t.Errorf("archiveName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestArchiveWritesNoTempFiles(t *testing.T) {
+	// Archives are streamed to the client without a spill to disk, so there is
+	// no free space to check before building one.
+	dir := testStore(t, map[string]string{"a.txt": "aaa", "b.txt": "bb"})
+	defer os.RemoveAll(dir)
+	tmp, err := ioutil.TempDir("", "tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", tmp)
+	localServer()
+
+	if rec := testDownload("GET", "archive=tar&a=a.txt&a=b.txt&s="+dir); rec.Code != 200 {
+		t.Fatalf("archive = %d", rec.Code)
+	}
+	if files, _ := ioutil.ReadDir(tmp); len(files) != 0 {
+		t.Errorf("archive left %d files in the temporary directory", len(files))
+	}
+}
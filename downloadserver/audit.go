@@ -0,0 +1,120 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+ * Audit log. With AuditLog set every refused download, the deny list, caller
+ * prefixes, the Authorizer, download tokens and the store boundary, is written
+ * as a line of JSON to a file of its own, apart from the access log, so that
+ * denials can be shipped to a SIEM. Entries are written synchronously, a
+ * denial is never served before it has been recorded, and a failed write is
+ * logged as an error.
+ */
+
+// Reasons of the audit log entries.
+const (
+	AuditDenyPattern    = "deny_pattern"
+	AuditPrefix         = "prefix_violation"
+	AuditWrongTenancy   = "wrong_tenancy"
+	AuditWrongNamespace = "wrong_namespace"
+	AuditAuthorizer     = "authorizer"
+	AuditInvalidToken   = "invalid_token"
+	AuditOutsideStore   = "outside_store"
+)
+
+// AuditEntry is the JSON line written to the audit log for a refused download.
+type AuditEntry struct {
+	Time     time.Time `json:"timestamp"`
+	Event    string    `json:"event"` // always download_denied
+	Reason   string    `json:"reason"`
+	Detail   string    `json:"detail,omitempty"`
+	Client   string    `json:"client"`
+	Identity string    `json:"identity,omitempty"`
+	Tenancy  string    `json:"tenancy,omitempty"`
+	Artifact string    `json:"artifact,omitempty"`
+	Status   int       `json:"status"`
+}
+
+// auditLog writes the audit entries. A nil auditLog writes nothing.
+type auditLog struct {
+	mu  sync.Mutex
+	out io.Writer
+	f   *os.File
+}
+
+// newAuditLog opens the audit log at path for appending, - being the standard
+// output.
+func newAuditLog(path string) (*auditLog, error) {
+	if path == "-" {
+		return &auditLog{out: os.Stdout}, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{out: f, f: f}, nil
+}
+
+// write appends entry as a single line.
+func (a *auditLog) write(entry *AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.out.Write(append(line, '\n'))
+	return err
+}
+
+// close closes the audit log file at shutdown.
+func (a *auditLog) close(ctx context.Context) error {
+	if a.f == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
+
+// auditDenial records the refusal of the download of artifact, with status, for
+// reason. req is nil when the request was refused before it was parsed.
+func (ds *DownloadServer) auditDenial(r *http.Request, req *DownloadRequest, artifact string, reason string, detail string, status int) {
+	if ds.audit == nil {
+		return
+	}
+	entry := &AuditEntry{
+		Time:     time.Now().UTC(),
+		Event:    "download_denied",
+		Reason:   reason,
+		Detail:   detail,
+		Client:   clientIP(ds.clientAddr(r)),
+		Artifact: artifact,
+		Status:   status,
+	}
+	if req != nil {
+		entry.Tenancy = req.Tenancy
+		entry.Identity = ds.callerIdentity(r, req)
+	}
+	if err := ds.audit.write(entry); err != nil {
+		ds.logger().Error("Unable to write the audit log", Fields{"reason": reason, "error": err.Error()})
+	}
+}
+
+// auditError records a download that failed with err when that is a refusal
+// found while serving it.
+func (ds *DownloadServer) auditError(r *http.Request, req *DownloadRequest, err error) {
+	if err == errOutsideStore {
+		ds.auditDenial(r, req, req.Artifacts[0], AuditOutsideStore, errOutsideStore.msg, errOutsideStore.code)
+	}
+}
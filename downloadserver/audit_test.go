@@ -0,0 +1,97 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// auditEntries reads back the entries of the audit log at path.
+func auditEntries(t *testing.T, path string) []AuditEntry {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("audit line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.txt": "hello", "server.key": "key"})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	ds := localServer()
+	ds.deny, _ = newDenyList([]string{"*.key"})
+	audit, err := newAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds.audit = audit
+	defer audit.close(context.Background())
+	ds.TokenKey = []byte("secret")
+
+	testDownload("GET", "a=f.txt&s="+dir)
+	testDownload("GET", "a=server.key&s="+dir)
+	testDownload("GET", "a=../f.txt&s="+dir)
+	rec := httptest.NewRecorder()
+	download(rec, httptest.NewRequest("GET", downloadPath+"/forged", nil))
+	if rec.Code != 403 {
+		t.Errorf("forged token = %d, want 403", rec.Code)
+	}
+
+	entries := auditEntries(t, path)
+	if len(entries) != 3 {
+		t.Fatalf("audit entries = %+v, want the three refusals", entries)
+	}
+	for i, want := range []AuditEntry{
+		{Reason: AuditDenyPattern, Artifact: "server.key", Status: 403},
+		{Reason: AuditOutsideStore, Artifact: "../f.txt", Status: 403},
+		{Reason: AuditInvalidToken, Status: 403},
+	} {
+		got := entries[i]
+		if got.Event != "download_denied" || got.Reason != want.Reason || got.Artifact != want.Artifact || got.Status != want.Status {
+			t.Errorf("audit entry %d = %+v, want %+v", i, got, want)
+		}
+		if got.Time.IsZero() || got.Client != "192.0.2.1" {
+			t.Errorf("audit entry %d time %s client %q", i, got.Time, got.Client)
+		}
+	}
+}
+
+func TestAuditWrongTenancy(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	dir := testStore(t, nil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	ds := f.server()
+	var err error
+	if ds.audit, err = newAuditLog(path); err != nil {
+		t.Fatal(err)
+	}
+	defer ds.audit.close(context.Background())
+
+	if rec := testDownload("GET", "t=other&a=a/f.txt"); rec.Code != 403 {
+		t.Errorf("download for another tenancy = %d, want 403", rec.Code)
+	}
+	entries := auditEntries(t, path)
+	if len(entries) != 1 || entries[0].Reason != AuditWrongTenancy || entries[0].Tenancy != "other" {
+		t.Errorf("audit entries = %+v", entries)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Authorizer decides whether a download may be served. Embedders set
+// DownloadServer.Authorizer to apply their own authorization scheme; it is
+// called for every download after the request has been validated and before
+// any of the artifact is fetched. A returned error refuses the download with
+// 403 Forbidden, or with its own status when it is one of the package's status
+// errors.
+type Authorizer interface {
+	Authorize(ctx context.Context, req *DownloadRequest) error
+}
+
+// AuthorizerFunc adapts a function to the Authorizer interface.
+type AuthorizerFunc func(ctx context.Context, req *DownloadRequest) error
+
+// Authorize calls f(ctx, req).
+func (f AuthorizerFunc) Authorize(ctx context.Context, req *DownloadRequest) error {
+	return f(ctx, req)
+}
+
+// Errors of the TenancyAuthorizer.
+var (
+	errWrongTenancy   = errors.New("wrong tenancy")
+	errWrongNamespace = errors.New("wrong namespace")
+)
+
+// TenancyAuthorizer is the default Authorizer. OCI downloads must name the
+// server's tenancy and, when they give a namespace, the server's namespace.
+// Local downloads aren't restricted.
+type TenancyAuthorizer struct {
+	Tenancy   string
+	Namespace string
+}
+
+// Authorize checks the tenancy and namespace of OCI downloads.
+func (a TenancyAuthorizer) Authorize(ctx context.Context, req *DownloadRequest) error {
+	// A request without a tenancy isn't an OCI download, which the handler
+	// reports itself.
+	if req.Local() || req.Tenancy == "" {
+		return nil
+	}
+	if req.Tenancy != a.Tenancy {
+		return errWrongTenancy
+	}
+	// The namespace is optional but when given it must be the one this server
+	// is configured for, making the namespace boundary explicit.
+	if req.Namespace != "" && req.Namespace != a.Namespace {
+		return errWrongNamespace
+	}
+	return nil
+}
+
+// authorizer returns the configured Authorizer, or the TenancyAuthorizer for
+// the server's tenancy when none is set.
+func (ds *DownloadServer) authorizer() Authorizer {
+	if ds.Authorizer == nil {
+		return TenancyAuthorizer{Tenancy: ds.Tenancy, Namespace: ds.Namespace}
+	}
+	return ds.Authorizer
+}
+
+// authorizeMember reports whether the Authorizer accepts the download of the
+// single member name of req, such as an object listed for mode=urls prefix=1.
+func (ds *DownloadServer) authorizeMember(ctx context.Context, req *DownloadRequest, name string) bool {
+	member := *req
+	member.Artifacts = []string{name}
+	member.Prefix = false
+	return ds.authorizer().Authorize(ctx, &member) == nil
+}
+
+// authorize runs the configured Authorizer, or the TenancyAuthorizer for the
+// server's tenancy when none is set, answering a refusal itself. It reports
+// whether the download may go ahead.
+func (ds *DownloadServer) authorize(w http.ResponseWriter, r *http.Request, req *DownloadRequest) bool {
+	err := ds.authorizer().Authorize(r.Context(), req)
+	if err == nil {
+		return true
+	}
+	ds.logger().Warn("Download refused by authorizer", Fields{"artifact": req.Artifacts[0], "client": ds.clientAddr(r), "error": err.Error()})
+	reason := AuditAuthorizer
+	if err == errWrongTenancy {
+		reason = AuditWrongTenancy
+	} else if err == errWrongNamespace {
+		reason = AuditWrongNamespace
+	}
+	msg, code := err.Error(), http.StatusForbidden
+	if se, ok := err.(*statusError); ok {
+		msg, code = se.msg, se.code
+	}
+	ds.auditDenial(r, req, req.Artifacts[0], reason, msg, code)
+	httpError(w, r, msg, code)
+	return false
+}
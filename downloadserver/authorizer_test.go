@@ -0,0 +1,109 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTenancyAuthorizer(t *testing.T) {
+	a := TenancyAuthorizer{Tenancy: "ten", Namespace: "ns"}
+	for _, tc := range []struct {
+		req  DownloadRequest
+		want error
+	}{
+		{DownloadRequest{Tenancy: "ten"}, nil},
+		{DownloadRequest{Tenancy: "ten", Namespace: "ns"}, nil},
+		{DownloadRequest{Tenancy: "other"}, errWrongTenancy},
+		{DownloadRequest{Tenancy: "ten", Namespace: "other"}, errWrongNamespace},
+		{DownloadRequest{StorePath: "/store", Tenancy: "other"}, nil},
+		{DownloadRequest{}, nil},
+	} {
+		req := tc.req
+		if err := a.Authorize(context.Background(), &req); err != tc.want {
+			t.Errorf("Authorize(%+v) = %v, want %v", tc.req, err, tc.want)
+		}
+	}
+}
+
+func TestDownloadAuthorizer(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	f.put("secret/key", []byte("key"))
+	ds := f.server()
+
+	if rec := testDownload("GET", "t=other&a=a/f.txt"); rec.Code != 403 || !strings.Contains(rec.Body.String(), "wrong tenancy") {
+		t.Errorf("default authorizer, wrong tenancy = %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := testDownload("GET", "t=ten&n=other&a=a/f.txt"); rec.Code != 403 {
+		t.Errorf("default authorizer, wrong namespace = %d", rec.Code)
+	}
+
+	var seen *DownloadRequest
+	ds.Authorizer = AuthorizerFunc(func(ctx context.Context, req *DownloadRequest) error {
+		seen = req
+		switch {
+		case strings.HasPrefix(req.Artifacts[0], "secret/"):
+			return errors.New("secrets are off limits")
+		case req.Tenancy == "":
+			return &statusError{http.StatusUnauthorized, "sign in first"}
+		}
+		return nil
+	})
+	// The configured authorizer replaces the tenancy check.
+	if rec := testDownload("GET", "t=other&a=a/f.txt"); rec.Code != 200 || rec.Body.String() != "hello" {
+		t.Errorf("authorized download = %d %q", rec.Code, rec.Body.String())
+	}
+	if seen == nil || seen.Tenancy != "other" || seen.Artifacts[0] != "a/f.txt" {
+		t.Errorf("authorizer saw %+v", seen)
+	}
+	if rec := testDownload("GET", "t=ten&a=secret/key"); rec.Code != 403 || !strings.Contains(rec.Body.String(), "off limits") {
+		t.Errorf("refused download = %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := testDownload("GET", "a=a/f.txt&s=/store"); rec.Code != 401 || !strings.Contains(rec.Body.String(), "sign in first") {
+		t.Errorf("refused with a status error = %d %q", rec.Code, rec.Body.String())
+	}
+	if n := f.count(&f.pars); n != 1 {
+		t.Errorf("%d PARs created, want only the authorized download's", n)
+	}
+}
+
+func TestNamespaceCheck(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	dir := testStore(t, nil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	ds := f.server()
+	var err error
+	if ds.audit, err = newAuditLog(path); err != nil {
+		t.Fatal(err)
+	}
+	defer ds.audit.close(context.Background())
+
+	// The namespace is optional, and may name the configured one.
+	if rec := testDownload("GET", "t=ten&a=a/f.txt"); rec.Code != 200 {
+		t.Errorf("download without n= = %d, want 200", rec.Code)
+	}
+	if rec := testDownload("GET", "t=ten&n=ns&a=a/f.txt"); rec.Code != 200 || rec.Body.String() != "hello" {
+		t.Errorf("download with the configured namespace = %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := testDownload("GET", "t=ten&n=other&a=a/f.txt"); rec.Code != 403 || !strings.Contains(rec.Body.String(), "wrong namespace") {
+		t.Errorf("download from another namespace = %d %q, want 403", rec.Code, rec.Body.String())
+	}
+	if n := f.count(&f.pars); n != 2 {
+		t.Errorf("%d PARs created, want none for the other namespace", n)
+	}
+	entries := auditEntries(t, path)
+	if last := entries[len(entries)-1]; last.Reason != AuditWrongNamespace {
+		t.Errorf("audit entry for another namespace = %+v", last)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// localPath returns the path of artifact below storepath. With CaseInsensitive
+// set, an artifact that doesn't exist under its exact name is looked up ignoring
+// case. The exact path is returned when nothing matches, so that opening it
+// fails as it would otherwise. The path is checked against LocalSymlinks.
+func (ds *DownloadServer) localPath(storepath string, artifact string) (string, error) {
+	artifactPath := fmt.Sprintf("%s/%s", storepath, artifact)
+	if ds.CaseInsensitive {
+		if _, err := os.Lstat(artifactPath); os.IsNotExist(err) {
+			resolved, err := resolveCase(storepath, artifact)
+			if err != nil {
+				return artifactPath, err
+			}
+			if resolved != "" {
+				if ds.Debug {
+					ds.logger().Debug("Resolved artifact ignoring case", Fields{"artifact": artifact, "path": resolved})
+				}
+				artifactPath = resolved
+			}
+		}
+	}
+	if err := ds.checkSymlinks(storepath, artifactPath); err != nil {
+		return artifactPath, err
+	}
+	return artifactPath, nil
+}
+
+// resolveCase walks artifact below storepath one segment at a time, taking each
+// segment as named when it exists and otherwise the single directory entry that
+// matches it ignoring case. It returns "" when a segment has no match, and a 409
+// Conflict listing the candidates when a segment matches several entries.
+func resolveCase(storepath string, artifact string) (string, error) {
+	dir := storepath
+	for _, name := range strings.Split(artifact, "/") {
+		if name == "" {
+			continue
+		}
+		exact := dir + "/" + name
+		if _, err := os.Lstat(exact); err == nil {
+			dir = exact
+			continue
+		}
+		names, err := readDirNames(dir)
+		if err != nil {
+			return "", nil
+		}
+		var matches []string
+		for _, n := range names {
+			if strings.EqualFold(n, name) {
+				matches = append(matches, n)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return "", nil
+		case 1:
+			dir = dir + "/" + matches[0]
+		default:
+			sort.Strings(matches)
+			msg := fmt.Sprintf("artifact name is ambiguous, %s matches %s", name, strings.Join(matches, ", "))
+			return "", &statusError{http.StatusConflict, msg}
+		}
+	}
+	return dir, nil
+}
+
+// readDirNames returns the names of the entries in dir.
+func readDirNames(dir string) ([]string, error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	return d.Readdirnames(-1)
+}
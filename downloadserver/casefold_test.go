@@ -0,0 +1,44 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCaseInsensitive(t *testing.T) {
+	dir := testStore(t, map[string]string{"Build/App.TXT": "app", "x.txt": "lower", "X.TXT": "upper"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+
+	if rec := testDownload("GET", "a=build/app.txt&s="+dir); rec.Code == 200 {
+		t.Error("artifact found ignoring case without CaseInsensitive")
+	}
+
+	ds.CaseInsensitive = true
+	if rec := testDownload("GET", "a=build/app.txt&s="+dir); rec.Code != 200 || rec.Body.String() != "app" {
+		t.Errorf("lookup ignoring case = %d %q", rec.Code, rec.Body.String())
+	}
+	// An exact match wins over the entries differing only in case.
+	if rec := testDownload("GET", "a=X.TXT&s="+dir); rec.Body.String() != "upper" {
+		t.Errorf("exact name served %q", rec.Body.String())
+	}
+	rec := testDownload("GET", "a=x.Txt&s="+dir)
+	if rec.Code != 409 || !strings.Contains(rec.Body.String(), "X.TXT, x.txt") {
+		t.Errorf("ambiguous name = %d %q, want 409 listing both", rec.Code, rec.Body.String())
+	}
+	if rec := testDownload("GET", "a=build/missing.txt&s="+dir); rec.Code == 200 {
+		t.Error("missing artifact served")
+	}
+
+	// Archive members are looked up the same way.
+	rec = testDownload("GET", "archive=tar&a=BUILD/app.txt&s="+dir)
+	if rec.Code != 200 {
+		t.Fatalf("archive = %d %q", rec.Code, rec.Body.String())
+	}
+	if _, contents := readTar(t, rec.Body.Bytes()); contents["BUILD/app.txt"] != "app" {
+		t.Errorf("archive contents = %v", contents)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+)
+
+/*
+ * Chunk hashes. A client resuming a large download can't check the whole file
+ * hash until it has all of it. chunks=1 answers a download with a JSON list of
+ * the SHA-256 of every HashChunkSize chunk of the artifact as stored instead,
+ * so that each chunk can be verified as it arrives, whichever range it came in.
+ * The hashes are computed on the fly by reading the whole artifact, which for
+ * OCI objects costs as much as a download of them.
+ */
+
+// DefaultHashChunkSize is the size of the chunks hashed for chunks=1 when
+// HashChunkSize isn't set.
+const DefaultHashChunkSize = 4 << 20
+
+// chunkHashes is the JSON document returned for chunks=1.
+type chunkHashes struct {
+	Artifact  string   `json:"artifact"`
+	Size      int64    `json:"size"`
+	ChunkSize int64    `json:"chunkSize"`
+	Algorithm string   `json:"algorithm"`
+	Chunks    []string `json:"chunks"`
+}
+
+// hashChunks hashes the content of body a chunk at a time, stopping when ctx is
+// done.
+func (ds *DownloadServer) hashChunks(ctx context.Context, artifact string, body io.Reader) (*chunkHashes, error) {
+	size := ds.HashChunkSize
+	if size <= 0 {
+		size = DefaultHashChunkSize
+	}
+	c := &chunkHashes{Artifact: artifact, ChunkSize: size, Algorithm: "sha256", Chunks: []string{}}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		sum := sha256.New()
+		n, err := io.CopyN(sum, body, size)
+		if n > 0 {
+			c.Size += n
+			c.Chunks = append(c.Chunks, hex.EncodeToString(sum.Sum(nil)))
+		}
+		if err == io.EOF {
+			return c, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sendChunkHashes writes the chunk hashes as the JSON response.
+func sendChunkHashes(w http.ResponseWriter, c *chunkHashes) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	return json.NewEncoder(w).Encode(c)
+}
+
+// localChunks answers chunks=1 for a local artifact.
+func (ds *DownloadServer) localChunks(w http.ResponseWriter, r *http.Request, artifact string, storepath string) error {
+	artifactPath, err := ds.localPath(storepath, artifact)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(artifactPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	c, err := ds.hashChunks(r.Context(), artifact, f)
+	if err != nil {
+		return err
+	}
+	return sendChunkHashes(w, c)
+}
+
+// ociChunks answers chunks=1 for an OCI artifact.
+func (ds *DownloadServer) ociChunks(w http.ResponseWriter, r *http.Request, artifact string) error {
+	stream, err := ds.fetchOCIObject(r.Context(), ds.ociObjectName(artifact), nil)
+	if err != nil {
+		return err
+	}
+	defer stream.Body.Close()
+	c, err := ds.hashChunks(r.Context(), artifact, stream.Body)
+	if err != nil {
+		return err
+	}
+	return sendChunkHashes(w, c)
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHashChunks(t *testing.T) {
+	ds := &DownloadServer{HashChunkSize: 4}
+	for content, want := range map[string][]string{
+		"":           {},
+		"abc":        {sha256Hex("abc")},
+		"abcd":       {sha256Hex("abcd")},
+		"abcdefgh":   {sha256Hex("abcd"), sha256Hex("efgh")},
+		"abcdefghij": {sha256Hex("abcd"), sha256Hex("efgh"), sha256Hex("ij")},
+	} {
+		c, err := ds.hashChunks(context.Background(), "f", strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Size != int64(len(content)) || c.ChunkSize != 4 || fmt.Sprint(c.Chunks) != fmt.Sprint(want) {
+			t.Errorf("%q: %+v, want chunks %v", content, c, want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ds.hashChunks(ctx, "f", strings.NewReader("abcdefgh")); err != context.Canceled {
+		t.Errorf("cancelled hashing: %v", err)
+	}
+
+	if c, _ := (&DownloadServer{}).hashChunks(context.Background(), "f", strings.NewReader("x")); c.ChunkSize != DefaultHashChunkSize {
+		t.Errorf("default chunk size = %d", c.ChunkSize)
+	}
+}
+
+func TestChunksDownload(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.bin": "0123456789"})
+	defer os.RemoveAll(dir)
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.bin", []byte("0123456789"))
+
+	for query, server := range map[string]func() *DownloadServer{
+		"chunks=1&a=f.bin&s=" + dir: localServer,
+		"chunks=1&t=ten&a=a/f.bin":  f.server,
+	} {
+		server().HashChunkSize = 4
+		rec := testDownload("GET", query)
+		if rec.Code != 200 || rec.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("%s: %d %s", query, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		var c chunkHashes
+		if err := json.Unmarshal(rec.Body.Bytes(), &c); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{sha256Hex("0123"), sha256Hex("4567"), sha256Hex("89")}
+		if c.Size != 10 || c.Algorithm != "sha256" || fmt.Sprint(c.Chunks) != fmt.Sprint(want) {
+			t.Errorf("%s: %+v", query, c)
+		}
+		if rec.Header().Get("Content-Disposition") != "" {
+			t.Errorf("%s: chunk hashes sent as an attachment", query)
+		}
+	}
+}
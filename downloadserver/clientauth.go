@@ -0,0 +1,39 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// clientTLSConfig returns the TLS configuration requiring clients to present a
+// certificate signed by one of the CAs in ClientCAFile. Connections without a
+// valid client certificate are rejected during the handshake, before any
+// request is read.
+func (ds *DownloadServer) clientTLSConfig() (*tls.Config, error) {
+	pem, err := ioutil.ReadFile(ds.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", ds.ClientCAFile)
+	}
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// clientName returns the common name of the verified client certificate of r, or
+// "" when the client didn't authenticate with one.
+func clientName(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
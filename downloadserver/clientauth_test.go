@@ -0,0 +1,109 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// testCertificate creates a certificate for name signed by parent, or self
+// signed when parent is nil.
+func testCertificate(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey.(*rsa.PrivateKey)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestClientTLSConfig(t *testing.T) {
+	ca := testCertificate(t, "test CA", nil)
+	caFile, err := ioutil.TempFile("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(caFile.Name())
+	pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]})
+	caFile.Close()
+
+	ds := &DownloadServer{ClientCAFile: caFile.Name()}
+	config, err := ds.clientTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name = clientName(r)
+	}))
+	server.TLS = config
+	server.StartTLS()
+	defer server.Close()
+
+	transport := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       certs,
+		}}}
+	}
+	resp, err := transport(testCertificate(t, "runner-1", &ca)).Get(server.URL)
+	if err != nil {
+		t.Fatalf("client with a certificate: %v", err)
+	}
+	resp.Body.Close()
+	if name != "runner-1" {
+		t.Errorf("clientName = %q, want runner-1", name)
+	}
+
+	if _, err := transport().Get(server.URL); err == nil {
+		t.Error("client without a certificate accepted")
+	}
+	if _, err := transport(testCertificate(t, "intruder", nil)).Get(server.URL); err == nil {
+		t.Error("client with a certificate of another CA accepted")
+	}
+
+	ds.ClientCAFile = os.DevNull
+	if _, err := ds.clientTLSConfig(); err == nil {
+		t.Error("client CA file without certificates accepted")
+	}
+}
+
+func TestClientNameWithoutTLS(t *testing.T) {
+	if name := clientName(httptest.NewRequest("GET", downloadPath, nil)); name != "" {
+		t.Errorf("clientName = %q, want none", name)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// clientLimiter caps the number of downloads each client address has in progress
+// at once.
+type clientLimiter struct {
+	max    int
+	mu     sync.Mutex
+	active map[string]int
+	// typical is a moving average of how long downloads take, which is when a
+	// refused client can expect one of its downloads to have finished.
+	typical time.Duration
+}
+
+func newClientLimiter(max int) *clientLimiter {
+	return &clientLimiter{max: max, active: make(map[string]int)}
+}
+
+// acquire starts a download for client, reporting false when the client already
+// has the maximum in progress, along with how long it should wait before trying
+// again. A successful acquire must be paired with release.
+func (l *clientLimiter) acquire(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[client] >= l.max {
+		return false, retryHint(l.typical)
+	}
+	l.active[client]++
+	return true, 0
+}
+
+// release ends a download for client, which took took.
+func (l *clientLimiter) release(client string, took time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.typical = averageDuration(l.typical, took)
+	if l.active[client] <= 1 {
+		delete(l.active, client)
+		return
+	}
+	l.active[client]--
+}
+
+// clientIP returns the IP address of a remote address, which is the whole
+// address when it has no port (as for Unix domain sockets).
+func clientIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// averageDuration folds took into the moving average of download durations avg,
+// which is zero before any download finished.
+func averageDuration(avg time.Duration, took time.Duration) time.Duration {
+	if avg == 0 {
+		return took
+	}
+	return avg + (took-avg)/8
+}
+
+// retryHint is how long a refused download is told to wait when downloads
+// typically take typical, which is never less than the one second Retry-After
+// can express.
+func retryHint(typical time.Duration) time.Duration {
+	if typical < time.Second {
+		return time.Second
+	}
+	return typical
+}
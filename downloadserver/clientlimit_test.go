@@ -0,0 +1,83 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClientLimiter(t *testing.T) {
+	l := newClientLimiter(2)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.acquire("192.0.2.1"); !ok {
+			t.Fatal("downloads within the limit refused")
+		}
+	}
+	if ok, wait := l.acquire("192.0.2.1"); ok || wait != time.Second {
+		t.Errorf("download beyond the limit = %v, wait %s, want refused with 1s", ok, wait)
+	}
+	if ok, _ := l.acquire("192.0.2.2"); !ok {
+		t.Error("download of another client refused")
+	}
+	l.release("192.0.2.1", 8*time.Second)
+	if ok, _ := l.acquire("192.0.2.1"); !ok {
+		t.Error("download after a release refused")
+	}
+	// A refused client is told to wait about as long as downloads take.
+	if _, wait := l.acquire("192.0.2.1"); wait != 8*time.Second {
+		t.Errorf("wait = %s, want the 8s downloads take", wait)
+	}
+	l.release("192.0.2.1", 16*time.Second)
+	if l.typical != 9*time.Second {
+		t.Errorf("typical download = %s, want the 9s moving average", l.typical)
+	}
+	l.release("192.0.2.1", 8*time.Second)
+	l.release("192.0.2.2", 8*time.Second)
+	if len(l.active) != 0 {
+		t.Errorf("clients left active: %v", l.active)
+	}
+
+	for addr, want := range map[string]string{"192.0.2.1:1234": "192.0.2.1", "[2001:db8::1]:80": "2001:db8::1", "@": "@"} {
+		if got := clientIP(addr); got != want {
+			t.Errorf("clientIP(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestClientDownloadLimit(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	ds := f.server()
+	ds.MaxClientDownloads = 1
+	ds.clients = newClientLimiter(1)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	f.onParGet = func(r *http.Request) {
+		close(started)
+		<-release
+	}
+	done := make(chan int)
+	go func() {
+		done <- testDownload("GET", "t=ten&a=a/f.txt").Code
+	}()
+	<-started
+	if rec := testDownload("GET", "t=ten&a=a/f.txt"); rec.Code != 429 || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("second concurrent download = %d Retry-After %q, want 429 and 1", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	f.onParGet = nil
+	close(release)
+	select {
+	case code := <-done:
+		if code != 200 {
+			t.Errorf("first download = %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("first download didn't complete")
+	}
+	if rec := testDownload("GET", "t=ten&a=a/f.txt"); rec.Code != 200 {
+		t.Errorf("download after the first completed = %d", rec.Code)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"mime"
+	"path"
+	"strings"
+)
+
+// DefaultCompressMinSize is the size below which responses aren't compressed when
+// CompressMinSize isn't set. Compressing small bodies costs more than it saves
+// and can even make them larger.
+const DefaultCompressMinSize = 1024
+
+// compressedExtensions are the file types that are already compressed and aren't
+// worth compressing again.
+var compressedExtensions = map[string]bool{
+	".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".zip": true,
+	".jar": true, ".war": true, ".7z": true, ".png": true, ".jpg": true, ".jpeg": true,
+}
+
+// compressedTypes are the media types that are already compressed, besides
+// images, audio and video.
+var compressedTypes = map[string]bool{
+	"application/gzip": true, "application/x-gzip": true, "application/zip": true,
+	"application/x-bzip2": true, "application/x-xz": true, "application/zstd": true,
+	"application/x-7z-compressed": true, "application/java-archive": true,
+	"font/woff": true, "font/woff2": true,
+}
+
+// compressedType reports whether contentType is an already compressed type.
+// Unknown and generic types are not.
+func compressedType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if mediaType == "image/svg+xml" {
+		return false
+	}
+	for _, prefix := range []string{"image/", "audio/", "video/"} {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return compressedTypes[mediaType]
+}
+
+// compressible reports whether the artifact is eligible for gzip compression of
+// the response. An artifact of unknown size is compressed. Both the name and
+// the content type, the one sent or the one the artifact is stored with, are
+// checked for content that is already compressed.
+func (ds *DownloadServer) compressible(a *artifactStream, opts transferOptions) bool {
+	if !ds.Compress || opts.encrypt || opts.follow || opts.resume != "" || a.encoding != "" || a.contentRange != "" {
+		return false
+	}
+	if compressedExtensions[strings.ToLower(path.Ext(a.filename))] {
+		return false
+	}
+	if compressedType(a.contentType) || compressedType(a.storedType) {
+		return false
+	}
+	min := ds.CompressMinSize
+	if min <= 0 {
+		min = DefaultCompressMinSize
+	}
+	return a.size < 0 || a.size >= min
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompressedType(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"application/gzip":          true,
+		"image/png":                 true,
+		"video/mp4":                 true,
+		"application/zip; foo=bar":  true,
+		"image/svg+xml":             false,
+		"text/plain; charset=utf-8": false,
+		"application/octet-stream":  false,
+		"":                          false,
+	} {
+		if got := compressedType(contentType); got != want {
+			t.Errorf("compressedType(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
+
+func TestCompressMinSize(t *testing.T) {
+	large := strings.Repeat("compressible ", 200)
+	dir := testStore(t, map[string]string{"small.txt": "hello", "large.txt": large, "large.tgz": large})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.Compress = true
+
+	rec := testDownload("GET", "a=large.txt&s="+dir, "Accept-Encoding", "gzip")
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("large artifact encoding %q, Vary %q", rec.Header().Get("Content-Encoding"), rec.Header().Get("Vary"))
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := ioutil.ReadAll(zr); err != nil || string(body) != large {
+		t.Errorf("decompressed body %d bytes, %v", len(body), err)
+	}
+
+	// Below the default minimum size and already compressed artifacts are sent
+	// as they are.
+	for _, name := range []string{"small.txt", "large.tgz"} {
+		rec := testDownload("GET", "a="+name+"&s="+dir, "Accept-Encoding", "gzip")
+		if rec.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s compressed", name)
+		}
+	}
+
+	rec = testDownload("GET", "a=large.txt&s="+dir, "Accept-Encoding", "identity")
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != large {
+		t.Error("compressed for a client that doesn't accept gzip")
+	}
+	if rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Vary = %q", rec.Header().Get("Vary"))
+	}
+
+	ds.CompressMinSize = 4
+	rec = testDownload("GET", "a=small.txt&s="+dir, "Accept-Encoding", "gzip")
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Error("small artifact not compressed above CompressMinSize")
+	}
+}
+
+func TestCompressOCIObject(t *testing.T) {
+	large := strings.Repeat("compressible ", 200)
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("logs/build.log", []byte(large))
+	f.put("logs/screen", []byte(large))
+	f.objects["logs/screen"].contentType = "image/png"
+	ds := f.server()
+
+	// Off by default.
+	rec := testDownload("GET", "t=ten&a=logs/build.log", "Accept-Encoding", "gzip")
+	if rec.Code != 200 || rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != large {
+		t.Fatalf("download without Compress = %d, encoding %q", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+
+	ds.Compress = true
+	rec = testDownload("GET", "t=ten&a=logs/build.log", "Accept-Encoding", "gzip")
+	if rec.Code != 200 || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("download = %d, encoding %q", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+	// The upstream length is that of the uncompressed object.
+	if cl := rec.Header().Get("Content-Length"); cl != "" {
+		t.Errorf("Content-Length = %s for a compressed stream", cl)
+	}
+	if rec.Body.Len() >= len(large) {
+		t.Errorf("compressed body is %d bytes for a %d byte object", rec.Body.Len(), len(large))
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := ioutil.ReadAll(zr); err != nil || string(body) != large {
+		t.Errorf("decompressed body %d bytes, %v", len(body), err)
+	}
+
+	// A client that doesn't accept gzip gets the object as it is stored.
+	rec = testDownload("GET", "t=ten&a=logs/build.log")
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != large {
+		t.Error("compressed for a client that doesn't accept gzip")
+	}
+	if rec.Header().Get("Content-Length") != strconv.Itoa(len(large)) {
+		t.Errorf("Content-Length = %q", rec.Header().Get("Content-Length"))
+	}
+
+	// So does an object stored with an already compressed content type.
+	rec = testDownload("GET", "t=ten&a=logs/screen", "Accept-Encoding", "gzip")
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != large {
+		t.Error("object stored as image/png compressed")
+	}
+}
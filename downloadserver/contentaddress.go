@@ -0,0 +1,84 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+)
+
+/*
+ * Content addressed downloads. When a request carries h=sha256 the artifact a= is
+ * the hex SHA-256 digest of the content rather than a path. The digest is
+ * mapped to a storage location (local path below the storepath, or OCI object
+ * name) using a layout template, and the content is verified against the
+ * digest while it is streamed.
+ */
+
+// DefaultCASLayout is the layout used to map a digest to a storage location when
+// none is configured. {digest} is replaced by the full hex digest and {prefix} by
+// its first two characters.
+const DefaultCASLayout = "sha256/{prefix}/{digest}"
+
+// errDigestMismatch is returned when streamed content doesn't hash to the
+// requested digest.
+var errDigestMismatch = errors.New("artifact content does not match requested digest")
+
+// validSHA256 returns true if digest is a lower case hex encoded SHA-256 value.
+func validSHA256(digest string) bool {
+	if len(digest) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(digest)
+	return err == nil && digest == strings.ToLower(digest)
+}
+
+// casObjectName returns the storage location for digest using the configured layout.
+func (ds *DownloadServer) casObjectName(digest string) string {
+	layout := ds.CASLayout
+	if layout == "" {
+		layout = DefaultCASLayout
+	}
+	return strings.NewReplacer("{digest}", digest, "{prefix}", digest[:2]).Replace(layout)
+}
+
+// copyVerified copies src to dst while hashing it. The final chunk is held back
+// until the digest has been checked so that a mismatching artifact is never
+// delivered in full. An empty digest copies without verification.
+func copyVerified(dst io.Writer, src io.Reader, digest string) (int64, error) {
+	if digest == "" {
+		return io.Copy(dst, src)
+	}
+	hash := sha256.New()
+	buf := make([]byte, 32*1024)
+	pending := make([]byte, 0, len(buf))
+	var written int64
+	for {
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			if len(pending) > 0 {
+				nw, werr := dst.Write(pending)
+				written += int64(nw)
+				if werr != nil {
+					return written, werr
+				}
+			}
+			hash.Write(buf[:n])
+			pending = append(pending[:0], buf[:n]...)
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != digest {
+		return written, errDigestMismatch
+	}
+	nw, err := dst.Write(pending)
+	return written + int64(nw), err
+}
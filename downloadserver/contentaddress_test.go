@@ -0,0 +1,86 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestValidSHA256(t *testing.T) {
+	digest := sha256Hex("hello")
+	for value, want := range map[string]bool{
+		digest:                  true,
+		strings.ToUpper(digest): false,
+		digest[:62]:             false,
+		strings.Repeat("g", 64): false,
+	} {
+		if got := validSHA256(value); got != want {
+			t.Errorf("validSHA256(%s) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestCASObjectName(t *testing.T) {
+	digest := sha256Hex("hello")
+	ds := &DownloadServer{}
+	if got := ds.casObjectName(digest); got != "sha256/"+digest[:2]+"/"+digest {
+		t.Errorf("default layout = %s", got)
+	}
+	ds.CASLayout = "cas/{digest}.blob"
+	if got := ds.casObjectName(digest); got != "cas/"+digest+".blob" {
+		t.Errorf("configured layout = %s", got)
+	}
+}
+
+func TestCopyVerified(t *testing.T) {
+	content := strings.Repeat("0123456789", 10000)
+	var dst bytes.Buffer
+	if n, err := copyVerified(&dst, strings.NewReader(content), sha256Hex(content)); err != nil || n != int64(len(content)) || dst.String() != content {
+		t.Errorf("matching copy = %d %v", n, err)
+	}
+
+	// The last chunk of a mismatching artifact is held back.
+	dst.Reset()
+	n, err := copyVerified(&dst, strings.NewReader(content), sha256Hex("other"))
+	if err != errDigestMismatch || n >= int64(len(content)) || dst.Len() >= len(content) {
+		t.Errorf("mismatching copy = %d %v, %d bytes written", n, err, dst.Len())
+	}
+}
+
+func TestContentAddressedDownload(t *testing.T) {
+	digest, other := sha256Hex("hello"), sha256Hex("other")
+	dir := testStore(t, map[string]string{
+		"sha256/" + digest[:2] + "/" + digest: "hello",
+		"sha256/" + other[:2] + "/" + other:   "tampered",
+	})
+	defer os.RemoveAll(dir)
+	localServer()
+
+	if rec := testDownload("GET", "h=sha256&a="+digest+"&s="+dir); rec.Code != 200 || rec.Body.String() != "hello" {
+		t.Errorf("content addressed download = %d %q", rec.Code, rec.Body.String())
+	}
+	for _, query := range []string{"h=md5&a=" + digest, "h=sha256&a=f.txt", "h=sha256&a=" + strings.ToUpper(digest)} {
+		if rec := testDownload("GET", query+"&s="+dir); rec.Code != 400 {
+			t.Errorf("%s = %d, want 400", query, rec.Code)
+		}
+	}
+
+	// Content not matching its digest aborts the response.
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("mismatching content panicked with %v, want ErrAbortHandler", p)
+		}
+	}()
+	testDownload("GET", "h=sha256&a="+other+"&s="+dir)
+}
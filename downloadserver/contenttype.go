@@ -0,0 +1,130 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Content type detection. With DetectContentType set, local files are sent with
+ * the type registered for their extension or, failing that, the type sniffed
+ * from their first 512 bytes instead of binary/octet-stream. Sniffed types are
+ * cached for as long as the file is unchanged so repeat downloads of the same
+ * file skip the extra read. ContentTypes pins the type of extensions that are
+ * registered wrongly or not at all, for downloads from either backend and
+ * whether or not detection is on.
+ */
+
+// contentTypeCacheSize bounds the number of sniffed content types kept.
+const contentTypeCacheSize = 4096
+
+// sniffLen is the number of bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+// contentTypeCache holds the sniffed content types of local files keyed by path,
+// valid while the size and modification time of the file are unchanged.
+type contentTypeCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedContentType
+}
+
+type cachedContentType struct {
+	size    int64
+	modTime time.Time
+	value   string
+}
+
+func newContentTypeCache() *contentTypeCache {
+	return &contentTypeCache{entries: make(map[string]cachedContentType)}
+}
+
+// fileContentType returns the content type of the open file f at path. The file
+// position is left unchanged. fresh sniffs it even when it is cached.
+func (c *contentTypeCache) fileContentType(path string, f *os.File, stat os.FileInfo, fresh bool) (string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[path]
+	if ok && (fresh || entry.size != stat.Size() || !entry.modTime.Equal(stat.ModTime())) {
+		delete(c.entries, path)
+		ok = false
+	}
+	c.mu.Unlock()
+	if ok {
+		return entry.value, nil
+	}
+
+	buf := make([]byte, sniffLen)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	value := http.DetectContentType(buf[:n])
+
+	c.mu.Lock()
+	if len(c.entries) >= contentTypeCacheSize {
+		c.entries = make(map[string]cachedContentType)
+	}
+	c.entries[path] = cachedContentType{size: stat.Size(), modTime: stat.ModTime(), value: value}
+	c.mu.Unlock()
+	return value, nil
+}
+
+// localContentType returns the content type for the local file f, from its
+// extension when one is registered and sniffed from its content otherwise.
+func (ds *DownloadServer) localContentType(artifactPath string, f *os.File, stat os.FileInfo, fresh bool) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(artifactPath)); contentType != "" {
+		return contentType, nil
+	}
+	return ds.contentTypes.fileContentType(artifactPath, f, stat, fresh)
+}
+
+// newContentTypes compiles the ext=type ContentTypes entries into a map from the
+// lower case extension, with its leading dot, to the content type.
+func newContentTypes(entries []string) (map[string]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	types := make(map[string]string)
+	for _, entry := range entries {
+		kv := strings.SplitN(entry, "=", 2)
+		ext := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(kv[0]), "."))
+		if len(kv) != 2 || ext == "" || strings.Contains(ext, "/") {
+			return nil, fmt.Errorf("invalid content type: %s", entry)
+		}
+		contentType := strings.TrimSpace(kv[1])
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			return nil, fmt.Errorf("invalid content type %s: %s", entry, err)
+		}
+		types["."+ext] = contentType
+	}
+	return types, nil
+}
+
+// pinnedContentType returns the ContentTypes entry for filename, trying the
+// longest extension first so that .tar.gz wins over .gz, and "" when none is
+// configured.
+func (ds *DownloadServer) pinnedContentType(filename string) string {
+	if ds.contentTypeOf == nil {
+		return ""
+	}
+	name := strings.ToLower(path.Base(filename))
+	for i := strings.Index(name, "."); i >= 0; {
+		if contentType, ok := ds.contentTypeOf[name[i:]]; ok {
+			return contentType
+		}
+		next := strings.Index(name[i+1:], ".")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewContentTypes(t *testing.T) {
+	if types, err := newContentTypes(nil); types != nil || err != nil {
+		t.Errorf("no entries = %v %v", types, err)
+	}
+	types, err := newContentTypes([]string{".JSON=application/json", "tar.gz = application/gzip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if types[".json"] != "application/json" || types[".tar.gz"] != "application/gzip" {
+		t.Errorf("content types = %v", types)
+	}
+	for _, entry := range []string{"json", "=application/json", "a/b=text/plain", "json=not a type"} {
+		if _, err := newContentTypes([]string{entry}); err == nil {
+			t.Errorf("entry %q accepted", entry)
+		}
+	}
+}
+
+func TestPinnedContentType(t *testing.T) {
+	ds := &DownloadServer{}
+	if got := ds.pinnedContentType("f.json"); got != "" {
+		t.Errorf("pinned type without ContentTypes = %q", got)
+	}
+	ds.contentTypeOf, _ = newContentTypes([]string{"gz=application/gzip", "tar.gz=application/x-tgz", "log=text/plain"})
+	for _, tc := range []struct {
+		filename, want string
+	}{
+		{"app.tar.gz", "application/x-tgz"},
+		{"dir.v1/app.gz", "application/gzip"},
+		{"BUILD.LOG", "text/plain"},
+		{"gz", ""},
+		{"app.tar", ""},
+	} {
+		if got := ds.pinnedContentType(tc.filename); got != tc.want {
+			t.Errorf("pinnedContentType(%q) = %q, want %q", tc.filename, got, tc.want)
+		}
+	}
+}
+
+func TestContentTypesDownload(t *testing.T) {
+	dir := testStore(t, map[string]string{"build.log": "log", "page.html": "<html></html>"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.DetectContentType = true
+	ds.contentTypeOf, _ = newContentTypes([]string{"html=text/plain; charset=utf-8", "log=text/x-log"})
+
+	for artifact, want := range map[string]string{
+		"page.html": "text/plain; charset=utf-8",
+		"build.log": "text/x-log",
+	} {
+		if rec := testDownload("GET", "a="+artifact+"&s="+dir); rec.Header().Get("Content-Type") != want {
+			t.Errorf("local %s Content-Type = %q, want %q", artifact, rec.Header().Get("Content-Type"), want)
+		}
+	}
+
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/build.log", []byte("log"))
+	f.server().contentTypeOf = ds.contentTypeOf
+	if rec := testDownload("GET", "t=ten&a=a/build.log"); rec.Header().Get("Content-Type") != "text/x-log" {
+		t.Errorf("OCI Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestFileContentType(t *testing.T) {
+	dir := testStore(t, map[string]string{"page": "<html><body></body></html>"})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "page")
+	c := newContentTypeCache()
+	sniff := func(fresh bool) string {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		stat, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := c.fileContentType(path, f, stat, fresh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The file is left where it was for the download.
+		if pos, _ := f.Seek(0, io.SeekCurrent); pos != 0 {
+			t.Errorf("file left at %d", pos)
+		}
+		return value
+	}
+	if got := sniff(false); got != "text/html; charset=utf-8" {
+		t.Errorf("sniffed type = %q", got)
+	}
+	// The sniffed type is cached while the file is unchanged.
+	entry := c.entries[path]
+	entry.value = "cached/type"
+	c.entries[path] = entry
+	if got := sniff(false); got != "cached/type" {
+		t.Errorf("type of an unchanged file = %q, want the cached one", got)
+	}
+	if got := sniff(true); got != "text/html; charset=utf-8" {
+		t.Errorf("fresh type = %q, want the file sniffed", got)
+	}
+	if err := ioutil.WriteFile(path, []byte("%PDF-1.4 changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := sniff(false); got != "application/pdf" {
+		t.Errorf("type of a changed file = %q", got)
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	dir := testStore(t, map[string]string{"page": "<html></html>", "data.json": "{}"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+
+	if rec := testDownload("GET", "a=page&s="+dir); rec.Header().Get("Content-Type") != "binary/octet-stream" {
+		t.Errorf("Content-Type without DetectContentType = %q", rec.Header().Get("Content-Type"))
+	}
+	ds.DetectContentType = true
+	ds.contentTypes = newContentTypeCache()
+	for artifact, want := range map[string]string{
+		"page":      "text/html; charset=utf-8",
+		"data.json": "application/json",
+	} {
+		if rec := testDownload("GET", "a="+artifact+"&s="+dir); rec.Header().Get("Content-Type") != want || rec.Body.Len() == 0 {
+			t.Errorf("%s: Content-Type = %q, want %q", artifact, rec.Header().Get("Content-Type"), want)
+		}
+	}
+	// Only the file that was sniffed is cached.
+	if len(ds.contentTypes.entries) != 1 {
+		t.Errorf("cached types = %v", ds.contentTypes.entries)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net/http"
+	"sync"
+
+	ocicommon "github.com/oracle/oci-go-sdk/common"
+)
+
+/*
+ * Backend credentials. When the OCI credentials expire or are revoked while the
+ * server runs, every OCI call is refused with 401 NotAuthenticated. The
+ * responses to the OCI SDK clients are watched, and after credentialFailures
+ * of them in a row are 401s the credentials are taken to be invalid: /readyz
+ * answers 503, and downloads refused by OCI for authentication are answered
+ * with 503 Service Unavailable "backend authentication failed" rather than a
+ * 500. The first OCI response that isn't a 401, to a download or to a backend
+ * check of /readyz, restores the credentials.
+ */
+
+// credentialFailures is the number of consecutive 401 responses from OCI that
+// make the credentials invalid.
+const credentialFailures = 3
+
+// errBackendAuth answers downloads while the credentials are invalid.
+var errBackendAuth = &statusError{http.StatusServiceUnavailable, "backend authentication failed"}
+
+// credentialState tracks whether OCI accepts the credentials. The zero value
+// has them valid.
+type credentialState struct {
+	mu       sync.Mutex
+	failures int
+	rejected bool
+}
+
+// observe records the status of an OCI response, reporting whether it changed
+// the state.
+func (c *credentialState) observe(status int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if status != http.StatusUnauthorized {
+		c.failures = 0
+		changed := c.rejected
+		c.rejected = false
+		return changed
+	}
+	c.failures++
+	if !c.rejected && c.failures >= credentialFailures {
+		c.rejected = true
+		return true
+	}
+	return false
+}
+
+// invalid reports whether the credentials are taken to be invalid.
+func (c *credentialState) invalid() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rejected
+}
+
+// authWatcher passes the requests of an OCI SDK client on to its dispatcher,
+// recording the status of the responses in the server's credential state.
+type authWatcher struct {
+	ds   *DownloadServer
+	next ocicommon.HTTPRequestDispatcher
+}
+
+func (a *authWatcher) Do(r *http.Request) (*http.Response, error) {
+	resp, err := a.next.Do(r)
+	if err != nil {
+		return resp, err
+	}
+	if a.ds.creds.observe(resp.StatusCode) {
+		if a.ds.creds.invalid() {
+			a.ds.logger().Error("OCI credentials rejected, server is not ready", Fields{"failures": credentialFailures})
+		} else {
+			a.ds.logger().Info("OCI credentials accepted again", nil)
+		}
+	}
+	return resp, err
+}
+
+// authFailure returns errBackendAuth in place of err when err is OCI refusing
+// the credentials while they are taken to be invalid.
+func (ds *DownloadServer) authFailure(err error) error {
+	if se, ok := ocicommon.IsServiceError(err); ok && se.GetHTTPStatusCode() == http.StatusUnauthorized && ds.creds.invalid() {
+		return errBackendAuth
+	}
+	return err
+}
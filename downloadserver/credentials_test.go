@@ -0,0 +1,70 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCredentialState(t *testing.T) {
+	var c credentialState
+	for i := 1; i < credentialFailures; i++ {
+		if c.observe(401) || c.invalid() {
+			t.Fatalf("credentials invalid after %d 401s", i)
+		}
+	}
+	if !c.observe(401) || !c.invalid() {
+		t.Fatalf("credentials valid after %d 401s", credentialFailures)
+	}
+	if c.observe(401) {
+		t.Error("another 401 reported as a change")
+	}
+	if !c.observe(404) || c.invalid() {
+		t.Error("a response other than 401 didn't restore the credentials")
+	}
+	c.observe(401)
+	c.observe(200)
+	c.observe(401)
+	if c.invalid() {
+		t.Error("401s that aren't consecutive made the credentials invalid")
+	}
+}
+
+func TestRejectedCredentials(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	logger := &testLogger{}
+	f.server().Logger = logger
+
+	f.mu.Lock()
+	f.status = 401
+	f.mu.Unlock()
+	rec := testDownload("GET", "t=ten&a=a/f.txt")
+	for i := 1; i < 2*credentialFailures && rec.Code != 503; i++ {
+		rec = testDownload("GET", "t=ten&a=a/f.txt")
+	}
+	if rec.Code != 503 || !strings.Contains(rec.Body.String(), "backend authentication failed") {
+		t.Fatalf("download with rejected credentials = %d %q", rec.Code, rec.Body.String())
+	}
+	if code, state := testReadyz(t); code != 503 || state.Status != "backend authentication failed" {
+		t.Errorf("readyz with rejected credentials = %d %+v", code, state)
+	}
+	if len(logger.find("OCI credentials rejected, server is not ready")) != 1 {
+		t.Error("rejected credentials not logged once")
+	}
+
+	f.mu.Lock()
+	f.status = 0
+	f.mu.Unlock()
+	if rec := testDownload("GET", "t=ten&a=a/f.txt"); rec.Code != 200 || rec.Body.String() != "hello" {
+		t.Errorf("download with accepted credentials = %d %q", rec.Code, rec.Body.String())
+	}
+	if code, _ := testReadyz(t); code != 200 {
+		t.Errorf("readyz with accepted credentials = %d", code)
+	}
+	if len(logger.find("OCI credentials accepted again")) != 1 {
+		t.Error("accepted credentials not logged")
+	}
+}
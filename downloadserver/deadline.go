@@ -0,0 +1,67 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+ * Request deadlines. A client with a limited time budget sends it in the
+ * DeadlineHeader, either as an RFC 3339 timestamp or as a duration such as 30s,
+ * and the download runs under a context with that deadline, capped to
+ * MaxDownloadDuration. A download that can't start in time is answered with 504
+ * Gateway Timeout; one that runs out of time part way is aborted, so the client
+ * never mistakes a partial body for the whole artifact.
+ */
+
+// DefaultDeadlineHeader is the request header carrying the client's deadline.
+const DefaultDeadlineHeader = "X-Download-Deadline"
+
+// errDeadlineExceeded is returned by reads after the request deadline passed.
+var errDeadlineExceeded = errors.New("download deadline exceeded")
+
+// requestDeadline returns the deadline the client asked for in the
+// DeadlineHeader, capped to MaxDownloadDuration. ok is false when none was
+// given. A deadline that can't be parsed is a 400 Bad Request.
+func (ds *DownloadServer) requestDeadline(r *http.Request) (time.Time, bool, error) {
+	if ds.DeadlineHeader == "" {
+		return time.Time{}, false, nil
+	}
+	value := strings.TrimSpace(r.Header.Get(ds.DeadlineHeader))
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+	now := time.Now()
+	deadline, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return time.Time{}, false, badRequest(ds.DeadlineHeader + " must be an RFC 3339 time or a positive duration")
+		}
+		deadline = now.Add(d)
+	}
+	if ds.MaxDownloadDuration > 0 && deadline.After(now.Add(ds.MaxDownloadDuration)) {
+		deadline = now.Add(ds.MaxDownloadDuration)
+	}
+	return deadline, true, nil
+}
+
+// deadlineReader fails reads once the request deadline has passed, which ends a
+// local download that doesn't otherwise watch the request context.
+type deadlineReader struct {
+	io.Reader
+	ctx context.Context
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	if d.ctx.Err() != nil {
+		return 0, errDeadlineExceeded
+	}
+	return d.Reader.Read(p)
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestDeadline(t *testing.T) {
+	ds := &DownloadServer{DeadlineHeader: DefaultDeadlineHeader, MaxDownloadDuration: time.Hour}
+	deadline := func(value string) (time.Time, bool, error) {
+		r := httptest.NewRequest("GET", downloadPath, nil)
+		if value != "" {
+			r.Header.Set(DefaultDeadlineHeader, value)
+		}
+		return ds.requestDeadline(r)
+	}
+
+	if _, ok, err := deadline(""); ok || err != nil {
+		t.Errorf("no deadline = %v %v", ok, err)
+	}
+	if d, ok, err := deadline("30s"); !ok || err != nil || time.Until(d) > 30*time.Second || time.Until(d) < 29*time.Second {
+		t.Errorf("30s deadline = %s %v %v", d, ok, err)
+	}
+	at := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+	if d, ok, err := deadline(at.Format(time.RFC3339)); !ok || err != nil || !d.Equal(at) {
+		t.Errorf("RFC 3339 deadline = %s %v %v, want %s", d, ok, err, at)
+	}
+	// Deadlines are capped to MaxDownloadDuration.
+	if d, _, _ := deadline("48h"); time.Until(d) > time.Hour {
+		t.Errorf("48h deadline = %s, want it capped to an hour", d)
+	}
+	for _, bad := range []string{"soon", "-5s", "0s"} {
+		if _, _, err := deadline(bad); err == nil {
+			t.Errorf("deadline %q accepted", bad)
+		}
+	}
+
+	ds.DeadlineHeader = ""
+	if _, ok, _ := deadline("30s"); ok {
+		t.Error("deadline honored without DeadlineHeader")
+	}
+}
+
+func TestDeadlineExceeded(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	ds := f.server()
+	ds.DeadlineHeader = DefaultDeadlineHeader
+
+	release := make(chan struct{})
+	defer close(release)
+	f.onParGet = func(r *http.Request) { <-release }
+	started := time.Now()
+	rec := testDownload("GET", "t=ten&a=a/f.txt", DefaultDeadlineHeader, "50ms")
+	if rec.Code != 504 {
+		t.Errorf("download past its deadline = %d, want 504", rec.Code)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("download took %s with a 50ms deadline", elapsed)
+	}
+
+	if rec := testDownload("GET", "t=ten&a=a/f.txt", DefaultDeadlineHeader, "later"); rec.Code != 400 {
+		t.Errorf("invalid deadline = %d, want 400", rec.Code)
+	}
+}
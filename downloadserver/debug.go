@@ -0,0 +1,118 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+/*
+ * Request debugging. A single problematic download can be debugged without
+ * running the whole server with --debug: a download carrying DebugToken in an
+ * X-Debug header has its debug messages logged, covering the parsed request,
+ * the backend it went to, the PARs created and the time spent, while other
+ * downloads log as before. The messages are logged at the info level, as the
+ * log level of the server would drop them otherwise, and carry a "debug" field
+ * with an id that the response returns in X-Debug-Id. The token, credentials,
+ * PAR URLs and resume tokens are never part of them.
+ */
+
+// headerDebug carries the DebugToken of a download to debug.
+const headerDebug = "X-Debug"
+
+// headerDebugID returns the id of the log messages of a debugged download.
+const headerDebugID = "X-Debug-Id"
+
+type requestDebugKey struct{}
+
+// debugRequest reports whether r carries the DebugToken.
+func (ds *DownloadServer) debugRequest(r *http.Request) bool {
+	token := r.Header.Get(headerDebug)
+	return ds.DebugToken != "" && token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(ds.DebugToken)) == 1
+}
+
+// withRequestDebug returns ctx marked for debug logging under a new id, with
+// the id.
+func withRequestDebug(ctx context.Context) (context.Context, string) {
+	byt := make([]byte, 8)
+	rand.Read(byt)
+	id := hex.EncodeToString(byt)
+	return context.WithValue(ctx, requestDebugKey{}, id), id
+}
+
+// requestDebugID returns the debug id of the download of ctx, "" when it isn't
+// being debugged.
+func requestDebugID(ctx context.Context) string {
+	id, _ := ctx.Value(requestDebugKey{}).(string)
+	return id
+}
+
+// debug logs a debug message for the download of ctx when the server runs with
+// Debug or the download is being debugged.
+func (ds *DownloadServer) debug(ctx context.Context, msg string, fields Fields) {
+	id := requestDebugID(ctx)
+	if id == "" {
+		if ds.Debug {
+			ds.logger().Debug(msg, fields)
+		}
+		return
+	}
+	marked := Fields{"debug": id}
+	for k, v := range fields {
+		marked[k] = v
+	}
+	ds.logger().Info(msg, marked)
+}
+
+// debugRequestFields describes req for the debug log.
+func debugRequestFields(req *DownloadRequest) Fields {
+	fields := Fields{
+		"artifacts": req.Artifacts,
+		"storepath": req.StorePath,
+		"tenancy":   req.Tenancy,
+		"namespace": req.Namespace,
+		"noStore":   req.NoStore,
+		"gzip":      req.AcceptGzip,
+	}
+	for name, value := range map[string]string{
+		"digest": req.Digest, "archive": req.Archive, "entry": req.Entry, "mode": req.Mode, "clientName": req.ClientName,
+	} {
+		if value != "" {
+			fields[name] = value
+		}
+	}
+	for name, set := range map[string]bool{
+		"manifest": req.Manifest, "chunks": req.Chunks, "exists": req.Exists, "info": req.Info, "prefix": req.Prefix,
+		"fallback": req.Fallback, "trailers": req.Trailers, "follow": req.Follow, "encrypt": req.Encrypt, "resume": req.Resume != "",
+	} {
+		if set {
+			fields[name] = true
+		}
+	}
+	if req.Entries != nil {
+		fields["entries"] = req.Entries
+	}
+	if req.Parts != 0 {
+		fields["parts"] = req.Parts
+	}
+	if req.Range != nil {
+		fields["rangeFirst"], fields["rangeLast"] = req.Range.first, req.Range.last
+	}
+	return fields
+}
+
+// fields returns the timings, in milliseconds, for the debug log.
+func (t *serverTiming) fields() Fields {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fields := Fields{"totalMs": milliseconds(time.Since(t.start))}
+	for _, m := range t.metrics {
+		fields[m.name+"Ms"] = milliseconds(m.dur)
+	}
+	return fields
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRequestDebugging(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.txt": "hello"})
+	defer os.RemoveAll(dir)
+	logger := &testLogger{}
+	ds := localServer()
+	ds.Logger = logger
+	ds.DebugToken = "let-me-see"
+
+	for _, token := range []string{"", "wrong"} {
+		rec := testDownload("GET", "a=f.txt&s="+dir, headerDebug, token)
+		if rec.Code != 200 || rec.Header().Get(headerDebugID) != "" {
+			t.Errorf("download with debug token %q = %d, %s %q", token, rec.Code, headerDebugID, rec.Header().Get(headerDebugID))
+		}
+	}
+	if parsed := logger.find("Download request parsed"); len(parsed) != 0 {
+		t.Fatalf("downloads without the token logged %+v", parsed)
+	}
+
+	rec := testDownload("GET", "a=f.txt&s="+dir, headerDebug, "let-me-see")
+	id := rec.Header().Get(headerDebugID)
+	if rec.Code != 200 || rec.Body.String() != "hello" || len(id) != 16 {
+		t.Fatalf("debugged download = %d %q, %s %q", rec.Code, rec.Body.String(), headerDebugID, id)
+	}
+	parsed := logger.find("Download request parsed")
+	if len(parsed) != 1 || parsed[0].level != "info" || parsed[0].fields["debug"] != id {
+		t.Fatalf("debug messages = %+v", parsed)
+	}
+	if parsed[0].fields["storepath"] != dir {
+		t.Errorf("parsed request fields = %v", parsed[0].fields)
+	}
+	// None of the messages give the token away.
+	logger.mu.Lock()
+	for _, entry := range logger.entries {
+		for k, v := range entry.fields {
+			if s, ok := v.(string); ok && strings.Contains(s, "let-me-see") {
+				t.Errorf("%q field %s has the debug token", entry.msg, k)
+			}
+		}
+	}
+	logger.mu.Unlock()
+
+	// Without DebugToken no header turns debugging on.
+	ds.DebugToken = ""
+	if rec := testDownload("GET", "a=f.txt&s="+dir, headerDebug, ""); rec.Header().Get(headerDebugID) != "" {
+		t.Error("download debugged without a DebugToken")
+	}
+}
+
+func TestDebugFields(t *testing.T) {
+	req := &DownloadRequest{Artifacts: []string{"f.txt"}, Tenancy: "ten", Mode: "url", Exists: true, Parts: 2}
+	fields := debugRequestFields(req)
+	if fields["mode"] != "url" || fields["exists"] != true || fields["parts"] != 2 || fields["tenancy"] != "ten" {
+		t.Errorf("fields = %v", fields)
+	}
+	for _, name := range []string{"digest", "archive", "manifest", "resume", "rangeFirst"} {
+		if _, ok := fields[name]; ok {
+			t.Errorf("unset %s in the fields", name)
+		}
+	}
+}
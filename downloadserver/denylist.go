@@ -0,0 +1,66 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// denyList holds the compiled DenyPatterns. Glob patterns match the artifact path
+// or any trailing part of it starting at a path segment, so *.key matches a key
+// file in any directory and .git/* the files of a .git directory anywhere.
+// Patterns prefixed with re: are regular expressions matched against the whole
+// path.
+type denyList struct {
+	globs []string
+	res   []*regexp.Regexp
+}
+
+func newDenyList(patterns []string) (*denyList, error) {
+	d := &denyList{}
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "re:") {
+			re, err := regexp.Compile(pattern[len("re:"):])
+			if err != nil {
+				return nil, fmt.Errorf("invalid deny pattern %s: %s", pattern, err)
+			}
+			d.res = append(d.res, re)
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid deny pattern %s: %s", pattern, err)
+		}
+		d.globs = append(d.globs, pattern)
+	}
+	return d, nil
+}
+
+// denied returns true if the artifact matches a deny pattern. The artifact path is
+// normalized first so that it can't dodge a pattern with ./ or .. segments.
+func (d *denyList) denied(artifact string) bool {
+	if d == nil {
+		return false
+	}
+	name := strings.TrimPrefix(path.Clean("/"+artifact), "/")
+	for _, re := range d.res {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	for _, glob := range d.globs {
+		for suffix := name; ; {
+			if ok, _ := path.Match(glob, suffix); ok {
+				return true
+			}
+			i := strings.Index(suffix, "/")
+			if i < 0 {
+				break
+			}
+			suffix = suffix[i+1:]
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDenyList(t *testing.T) {
+	d, err := newDenyList([]string{"*.key", ".git/*", `re:^secrets/.*\.json$`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for artifact, want := range map[string]bool{
+		"server.key":               true,
+		"a/b/server.key":           true,
+		"a/server.key.txt":         false,
+		"repo/.git/config":         true,
+		".git/HEAD":                true,
+		"repo/.github/workflow":    false,
+		"secrets/db.json":          true,
+		"build/secrets/db.json":    false,
+		"./a/../server.key":        true,
+		"x/../secrets/db.json":     true,
+		"builds/app.tar":           false,
+		"builds/key/app.tar":       false,
+		"/repo/.git/objects/aa/bb": false,
+	} {
+		if got := d.denied(artifact); got != want {
+			t.Errorf("denied(%q) = %v, want %v", artifact, got, want)
+		}
+	}
+	if (*denyList)(nil).denied("server.key") {
+		t.Error("nil deny list denies")
+	}
+	for _, bad := range []string{"[", "re:("} {
+		if _, err := newDenyList([]string{bad}); err == nil {
+			t.Errorf("invalid pattern %q accepted", bad)
+		}
+	}
+}
+
+func TestDeniedDownload(t *testing.T) {
+	dir := testStore(t, map[string]string{"server.key": "secret", "app.tar": "app"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.deny, _ = newDenyList([]string{"*.key"})
+
+	if rec := testDownload("GET", "a=server.key&s="+dir); rec.Code != 403 {
+		t.Errorf("denied artifact = %d, want 403", rec.Code)
+	}
+	if rec := testDownload("GET", "a=app.tar&s="+dir); rec.Code != 200 {
+		t.Errorf("allowed artifact = %d, want 200", rec.Code)
+	}
+	if rec := testDownload("GET", "archive=tar&a=app.tar&a=server.key&s="+dir); rec.Code != 403 {
+		t.Errorf("archive with a denied member = %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+ * Digest headers (RFC 3230). With DigestHeader set the content digest of the
+ * artifact is sent in a Digest header. Local files are hashed before they are
+ * streamed, with the result cached for as long as the file is unchanged. OCI
+ * objects carry the MD5 OCI stores for them (Content-MD5); when that isn't available the
+ * SHA-256 is computed while streaming and sent as a trailer with trailers=1.
+ */
+
+// digestCacheSize bounds the number of local file digests kept.
+const digestCacheSize = 1024
+
+// digestCache holds the digests of local files keyed by path, valid while the
+// size and modification time of the file are unchanged.
+type digestCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedDigest
+}
+
+type cachedDigest struct {
+	size    int64
+	modTime time.Time
+	value   string
+}
+
+func newDigestCache() *digestCache {
+	return &digestCache{entries: make(map[string]cachedDigest)}
+}
+
+// fileDigest returns the Digest header value for the open file f at path, leaving
+// f positioned at its start. fresh hashes the file even when its digest is cached.
+func (c *digestCache) fileDigest(path string, f *os.File, stat os.FileInfo, fresh bool) (string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && !fresh && entry.size == stat.Size() && entry.modTime.Equal(stat.ModTime()) {
+		return entry.value, nil
+	}
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	value := "sha-256=" + base64.StdEncoding.EncodeToString(sum.Sum(nil))
+
+	c.mu.Lock()
+	if len(c.entries) >= digestCacheSize {
+		c.entries = make(map[string]cachedDigest)
+	}
+	c.entries[path] = cachedDigest{size: stat.Size(), modTime: stat.ModTime(), value: value}
+	c.mu.Unlock()
+	return value, nil
+}
+
+// casDigest returns the Digest header value for a content addressed artifact,
+// which is known from the request without hashing anything.
+func casDigest(digest string) string {
+	sum, err := hex.DecodeString(digest)
+	if err != nil {
+		return ""
+	}
+	return "sha-256=" + base64.StdEncoding.EncodeToString(sum)
+}
+
+// objectMD5 returns the base64 MD5 of an OCI object from the headers of its GET
+// response, or "" when OCI doesn't know it. Objects uploaded in parts carry the
+// MD5 of their part MD5s in opc-multipart-md5 instead, which isn't a hash of
+// the content, so anything that isn't a plain MD5 is ignored.
+func objectMD5(header http.Header) string {
+	if header.Get("opc-multipart-md5") != "" {
+		return ""
+	}
+	value := header.Get("Content-MD5")
+	if sum, err := base64.StdEncoding.DecodeString(value); err != nil || len(sum) != md5.Size {
+		return ""
+	}
+	return value
+}
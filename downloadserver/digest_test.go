@@ -0,0 +1,140 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestObjectMD5(t *testing.T) {
+	const sum = "XUFAKrxLKna5cZ2REBfFkg=="
+	for _, tc := range []struct {
+		header http.Header
+		want   string
+	}{
+		{http.Header{"Content-Md5": {sum}}, sum},
+		{http.Header{}, ""},
+		{http.Header{"Content-Md5": {"not base64!"}}, ""},
+		{http.Header{"Content-Md5": {"c2hvcnQ="}}, ""},
+		{http.Header{"Content-Md5": {sum}, "Opc-Multipart-Md5": {"bXVsdGk=-2"}}, ""},
+	} {
+		if got := objectMD5(tc.header); got != tc.want {
+			t.Errorf("objectMD5(%v) = %q, want %q", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestContentMD5(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	f.put("a/parts.bin", []byte("hello"))
+	f.objects["a/parts.bin"].multipart = true
+	f.server()
+
+	if rec := testDownload("GET", "t=ten&a=a/f.txt"); rec.Header().Get("Content-MD5") != "XUFAKrxLKna5cZ2REBfFkg==" {
+		t.Errorf("Content-MD5 = %q", rec.Header().Get("Content-MD5"))
+	}
+	// The MD5 of the object doesn't hold for a part of it.
+	if rec := testDownload("GET", "t=ten&a=a/f.txt", "Range", "bytes=0-1"); rec.Code != 206 || rec.Header().Get("Content-MD5") != "" {
+		t.Errorf("range = %d Content-MD5 %q", rec.Code, rec.Header().Get("Content-MD5"))
+	}
+	if rec := testDownload("GET", "t=ten&a=a/parts.bin"); rec.Code != 200 || rec.Header().Get("Content-MD5") != "" {
+		t.Errorf("multipart object = %d Content-MD5 %q", rec.Code, rec.Header().Get("Content-MD5"))
+	}
+}
+
+// sha256Digest is the Digest header value of content.
+func sha256Digest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestCASDigest(t *testing.T) {
+	if got := casDigest(sha256Hex("hello")); got != sha256Digest("hello") {
+		t.Errorf("casDigest = %q, want %q", got, sha256Digest("hello"))
+	}
+	if got := casDigest("not hex"); got != "" {
+		t.Errorf("casDigest of a bad digest = %q", got)
+	}
+}
+
+func TestDigestCache(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.txt": "hello"})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "f.txt")
+	c := newDigestCache()
+	digest := func(fresh bool) string {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		stat, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := c.fileDigest(path, f, stat, fresh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The file is left at its start for the download.
+		if rest, _ := ioutil.ReadAll(f); int64(len(rest)) != stat.Size() {
+			t.Errorf("file left at %d", stat.Size()-int64(len(rest)))
+		}
+		return value
+	}
+	if got := digest(false); got != sha256Digest("hello") {
+		t.Errorf("digest = %q", got)
+	}
+	// A cached digest is used while the file is unchanged.
+	c.entries[path] = cachedDigest{size: 5, modTime: c.entries[path].modTime, value: "cached"}
+	if got := digest(false); got != "cached" {
+		t.Errorf("digest of an unchanged file = %q, want the cached one", got)
+	}
+	if got := digest(true); got != sha256Digest("hello") {
+		t.Errorf("fresh digest = %q, want the file hashed", got)
+	}
+	if err := ioutil.WriteFile(path, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := digest(false); got != sha256Digest("changed") {
+		t.Errorf("digest of a changed file = %q", got)
+	}
+}
+
+func TestDigestHeader(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	f.put("a/parts.bin", []byte("parts"))
+	f.objects["a/parts.bin"].multipart = true
+	dir := testStore(t, map[string]string{"f.txt": "hello"})
+	defer os.RemoveAll(dir)
+	ds := f.server()
+
+	if rec := testDownload("GET", "a=f.txt&s="+dir); rec.Header().Get("Digest") != "" {
+		t.Errorf("Digest = %q without DigestHeader", rec.Header().Get("Digest"))
+	}
+	ds.DigestHeader = true
+	ds.digests = newDigestCache()
+	if rec := testDownload("GET", "a=f.txt&s="+dir); rec.Header().Get("Digest") != sha256Digest("hello") {
+		t.Errorf("local Digest = %q", rec.Header().Get("Digest"))
+	}
+	// OCI objects give the MD5 OCI stores for them.
+	if rec := testDownload("GET", "t=ten&a=a/f.txt"); rec.Header().Get("Digest") != "md5=XUFAKrxLKna5cZ2REBfFkg==" {
+		t.Errorf("OCI Digest = %q", rec.Header().Get("Digest"))
+	}
+	// Without one the SHA-256 follows the body as a trailer.
+	rec := testDownload("GET", "t=ten&a=a/parts.bin&trailers=1")
+	resp := rec.Result()
+	if resp.Header.Get("Digest") != "" || resp.Trailer.Get("Digest") != sha256Digest("parts") {
+		t.Errorf("multipart object Digest header %q trailer %q", resp.Header.Get("Digest"), resp.Trailer.Get("Digest"))
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"os"
+	"path"
+)
+
+/*
+ * Directory defaults. A local download whose a= names a directory serves the
+ * first of DirectoryDefaults, say latest.tar.gz, found in that directory, so
+ * that clients can fetch the current build of a channel without knowing its
+ * name. The default is looked up like any artifact below the storepath, with
+ * the same case and symlink rules, and a denied default is passed over. A
+ * directory without one of its defaults is a 404 Not Found.
+ */
+
+// directoryDefault returns the artifact and path to serve for the local
+// artifact at artifactPath: the artifact itself unless it is a directory, and
+// otherwise its first default that is a regular file.
+func (ds *DownloadServer) directoryDefault(storepath string, artifact string, artifactPath string) (string, string, error) {
+	stat, err := os.Stat(artifactPath)
+	if err != nil || !stat.IsDir() {
+		return artifact, artifactPath, nil
+	}
+	for _, name := range ds.DirectoryDefaults {
+		candidate := path.Join(artifact, name)
+		if ds.deny.denied(candidate) {
+			continue
+		}
+		candidatePath, err := ds.localPath(storepath, candidate)
+		if err != nil {
+			return "", "", err
+		}
+		if stat, err := os.Stat(candidatePath); err == nil && stat.Mode().IsRegular() {
+			if ds.Debug {
+				ds.logger().Debug("Serving the directory default", Fields{"artifact": artifact, "default": candidate})
+			}
+			return candidate, candidatePath, nil
+		}
+	}
+	return "", "", errNoObject
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDirectoryDefaults(t *testing.T) {
+	dir := testStore(t, map[string]string{
+		"stable/latest.tar.gz": "stable",
+		"stable/latest.key":    "key",
+		"beta/latest.zip":      "beta",
+		"beta/latest.tar.gz/x": "not a file",
+		"nightly/build-1.tar":  "nightly",
+		"plain.txt":            "plain",
+	})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.deny, _ = newDenyList([]string{"*.key"})
+
+	if rec := testDownload("GET", "a=stable&s="+dir); rec.Code != 404 {
+		t.Errorf("directory without DirectoryDefaults = %d, want 404", rec.Code)
+	}
+
+	ds.DirectoryDefaults = []string{"latest.key", "latest.tar.gz", "latest.zip"}
+	for _, tc := range []struct {
+		artifact, body string
+		want           int
+	}{
+		{"stable", "stable", 200},
+		{"beta", "beta", 200},
+		{"nightly", "", 404},
+		{"plain.txt", "plain", 200},
+	} {
+		rec := testDownload("GET", "a="+tc.artifact+"&s="+dir)
+		if rec.Code != tc.want || (tc.want == 200 && rec.Body.String() != tc.body) {
+			t.Errorf("download of %s = %d %q, want %d %q", tc.artifact, rec.Code, rec.Body.String(), tc.want, tc.body)
+		}
+	}
+	rec := testDownload("GET", "a=stable&s="+dir)
+	if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "latest.tar.gz") {
+		t.Errorf("Content-Disposition = %q, want the default's filename", cd)
+	}
+}
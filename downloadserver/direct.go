@@ -0,0 +1,71 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	ocicommon "github.com/oracle/oci-go-sdk/common"
+	ocistorage "github.com/oracle/oci-go-sdk/objectstorage"
+)
+
+// fetchDirect reads object with the OCI client instead of through a PAR when it
+// is smaller than DirectFetchThreshold, which saves creating (and later
+// deleting) a PAR for each of the many small artifacts. With DirectRanges a
+// ranged read is always direct, so clients seeking around a large object don't
+// cost a PAR per request, and with an SSE-C key every read is. The object's
+// size and archival state are looked up first; ok is false when the object is
+// too large and a PAR should be used, its archival state already checked.
+func (ds *DownloadServer) fetchDirect(ctx context.Context, region string, object string, rng *byteRange) (*http.Response, bool, error) {
+	client, err := ds.objectStorageClient(region)
+	if err != nil {
+		return nil, false, err
+	}
+	timing := timingFrom(ctx)
+	started := time.Now()
+	head, err := client.HeadObject(ctx, ocistorage.HeadObjectRequest{
+		NamespaceName: &ds.Namespace,
+		BucketName:    &ds.BucketName,
+		ObjectName:    &object,
+	})
+	timing.since("head", "OCI object size", started)
+	if se, ok := ocicommon.IsServiceError(err); ok && se.GetHTTPStatusCode() == http.StatusNotFound {
+		return nil, false, errNoObject
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if err := ds.archivalError(ctx, client, region, object, archivalState(head.RawResponse)); err != nil {
+		return nil, false, err
+	}
+	if head.ContentLength == nil {
+		return nil, false, nil
+	}
+	if *head.ContentLength >= ds.DirectFetchThreshold && !(rng != nil && ds.DirectRanges) && ds.sseKey == nil {
+		return nil, false, nil
+	}
+	request := ocistorage.GetObjectRequest{
+		NamespaceName: &ds.Namespace,
+		BucketName:    &ds.BucketName,
+		ObjectName:    &object,
+	}
+	if rng != nil {
+		if _, _, ok := rng.resolve(*head.ContentLength); !ok {
+			return nil, false, &rangeError{size: *head.ContentLength}
+		}
+		header := rng.header()
+		request.Range = &header
+	}
+	started = time.Now()
+	response, err := client.GetObject(ctx, request)
+	timing.since("ttfb", "OCI first byte", started)
+	if err != nil {
+		return nil, false, err
+	}
+	stream := response.RawResponse
+	stream.Body = response.Content
+	ds.debug(ctx, "OCI object fetched directly", Fields{"object": object, "region": region, "bytes": stream.ContentLength})
+	return stream, true, nil
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDirectFetch(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	ds := f.server()
+	ds.DirectFetchThreshold = 1 << 20
+
+	rec := httptest.NewRecorder()
+	download(rec, httptest.NewRequest("GET", downloadPath+"?t=ten&a=a/f.txt", nil))
+	if rec.Code != 200 || rec.Body.String() != "hello" {
+		t.Fatalf("download = %d %q", rec.Code, rec.Body.String())
+	}
+	if heads, gets, pars := f.count(&f.heads), f.count(&f.gets), f.count(&f.pars); heads != 1 || gets != 1 || pars != 0 {
+		t.Errorf("small object: %d HEADs, %d GETs, %d PARs, want 1, 1, 0", heads, gets, pars)
+	}
+}
+
+func TestDirectFetchThresholdHeadsOnce(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	ds := f.server()
+	ds.DirectFetchThreshold = 2
+
+	rec := httptest.NewRecorder()
+	download(rec, httptest.NewRequest("GET", downloadPath+"?t=ten&a=a/f.txt", nil))
+	if rec.Code != 200 || rec.Body.String() != "hello" {
+		t.Fatalf("download = %d %q", rec.Code, rec.Body.String())
+	}
+	if heads, pars := f.count(&f.heads), f.count(&f.pars); heads != 1 || pars != 1 {
+		t.Errorf("large object: %d HEADs, %d PARs, want 1, 1", heads, pars)
+	}
+
+	// A failed GET through the PAR doesn't look the object up again for its
+	// archival state.
+	f.mu.Lock()
+	f.parStatus = 500
+	f.mu.Unlock()
+	rec = httptest.NewRecorder()
+	download(rec, httptest.NewRequest("GET", downloadPath+"?t=ten&a=a/f.txt", nil))
+	if rec.Code == 200 {
+		t.Errorf("download with a failing PAR = %d", rec.Code)
+	}
+	if heads := f.count(&f.heads); heads != 2 {
+		t.Errorf("%d HEADs after the failed download, want 2", heads)
+	}
+}
+
+func TestDirectRanges(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	ds := f.server()
+	ds.DirectRanges = true
+
+	rec := httptest.NewRecorder()
+	download(rec, httptest.NewRequest("GET", downloadPath+"?t=ten&a=a/f.txt&offset=1&length=3", nil))
+	if rec.Code != 206 || rec.Body.String() != "ell" {
+		t.Fatalf("ranged download = %d %q", rec.Code, rec.Body.String())
+	}
+	if gets, pars := f.count(&f.gets), f.count(&f.pars); gets != 1 || pars != 0 {
+		t.Errorf("ranged download: %d GETs, %d PARs, want 1, 0", gets, pars)
+	}
+
+	// Whole downloads still go through a PAR.
+	rec = httptest.NewRecorder()
+	download(rec, httptest.NewRequest("GET", downloadPath+"?t=ten&a=a/f.txt", nil))
+	if rec.Code != 200 || rec.Body.String() != "hello" {
+		t.Fatalf("download = %d %q", rec.Code, rec.Body.String())
+	}
+	if pars := f.count(&f.pars); pars != 1 {
+		t.Errorf("whole download: %d PARs, want 1", pars)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Content-Disposition modes. In both mode a filename that isn't plain ASCII is
+// sent as an RFC 6266 filename* parameter alongside an ASCII fallback filename;
+// legacy mode, for clients that mishandle filename*, sends only the fallback.
+const (
+	DispositionBoth   = "both"
+	DispositionLegacy = "legacy"
+)
+
+// contentDisposition returns the Content-Disposition header value for an
+// attachment named filename.
+func (ds *DownloadServer) contentDisposition(filename string) string {
+	fallback := asciiFilename(filename)
+	header := "attachment; filename=" + fallback
+	if !isToken(fallback) {
+		header = fmt.Sprintf("attachment; filename=%q", fallback)
+	}
+	if fallback != filename && ds.ContentDisposition != DispositionLegacy {
+		header += "; filename*=UTF-8''" + extValue(filename)
+	}
+	return header
+}
+
+// asciiFilename replaces the characters of filename that can't be sent in a plain
+// quoted filename parameter.
+func asciiFilename(filename string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+}
+
+// isToken returns true if s can be sent as an unquoted parameter value.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if c <= 0x20 || c >= 0x7f || strings.IndexByte("()<>@,;:\\\"/[]?={}", c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// extValue percent encodes s as an RFC 5987 ext-value.
+func extValue(s string) string {
+	var b bytes.Buffer
+	for _, c := range []byte(s) {
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
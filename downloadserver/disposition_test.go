@@ -0,0 +1,38 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"mime"
+	"testing"
+)
+
+func TestContentDisposition(t *testing.T) {
+	for _, tc := range []struct {
+		mode, filename, want string
+	}{
+		{DispositionBoth, "app.tar", "attachment; filename=app.tar"},
+		{DispositionBoth, "my app.tar", `attachment; filename="my app.tar"`},
+		{DispositionBoth, "résumé.pdf", `attachment; filename=r_sum_.pdf; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf`},
+		{DispositionBoth, `a"b.txt`, `attachment; filename=a_b.txt; filename*=UTF-8''a%22b.txt`},
+		{DispositionLegacy, "résumé.pdf", "attachment; filename=r_sum_.pdf"},
+		{DispositionLegacy, "app.tar", "attachment; filename=app.tar"},
+	} {
+		ds := &DownloadServer{ContentDisposition: tc.mode}
+		if got := ds.contentDisposition(tc.filename); got != tc.want {
+			t.Errorf("%s %q: %s, want %s", tc.mode, tc.filename, got, tc.want)
+		}
+	}
+}
+
+func TestContentDispositionParses(t *testing.T) {
+	ds := &DownloadServer{}
+	for _, filename := range []string{"app.tar", "my app (1).tar", "日本語.txt", "naïve;=.bin"} {
+		_, params, err := mime.ParseMediaType(ds.contentDisposition(filename))
+		if err != nil {
+			t.Errorf("%q: %v", filename, err)
+		} else if params["filename"] != filename {
+			t.Errorf("%q: parsed as %q", filename, params["filename"])
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultDownloadQueueWait is how long a queued download waits for a slot when
+// DownloadQueueWait isn't set.
+const DefaultDownloadQueueWait = 10 * time.Second
+
+// downloadLimiter caps the number of downloads in progress across all clients.
+// Downloads beyond the cap wait in a bounded queue for up to the queue wait, so
+// that bursts are smoothed out rather than refused.
+type downloadLimiter struct {
+	slots    chan struct{}
+	queue    int
+	wait     time.Duration
+	mu       sync.Mutex
+	queued   int
+	rejected uint64
+	timedOut uint64
+	// typical is a moving average of how long downloads hold a slot.
+	typical time.Duration
+}
+
+func newDownloadLimiter(max int, queue int, wait time.Duration) *downloadLimiter {
+	if wait <= 0 {
+		wait = DefaultDownloadQueueWait
+	}
+	return &downloadLimiter{slots: make(chan struct{}, max), queue: queue, wait: wait}
+}
+
+// acquire starts a download, waiting in the queue for a slot when all of them are
+// taken. A *rateLimitedError is returned when the queue is full or the wait ran
+// out, and ctx.Err() when the client went away while queued. A successful
+// acquire must be paired with release.
+func (l *downloadLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	l.mu.Lock()
+	if l.queued >= l.queue {
+		l.rejected++
+		wait := l.retryAfter()
+		l.mu.Unlock()
+		return &rateLimitedError{retryAfter: wait}
+	}
+	l.queued++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.queued--
+		l.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		l.mu.Lock()
+		l.timedOut++
+		wait := l.retryAfter()
+		l.mu.Unlock()
+		return &rateLimitedError{retryAfter: wait}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release ends a download, which held its slot for took.
+func (l *downloadLimiter) release(took time.Duration) {
+	l.mu.Lock()
+	l.typical = averageDuration(l.typical, took)
+	l.mu.Unlock()
+	<-l.slots
+}
+
+// retryAfter is how long until a refused download can expect a slot: the
+// downloads queued ahead of it take their turns on the slots as they free up.
+// l.mu must be held.
+func (l *downloadLimiter) retryAfter() time.Duration {
+	rounds := 1 + l.queued/cap(l.slots)
+	return retryHint(l.typical * time.Duration(rounds))
+}
+
+// downloadStats reports the downloads in progress and queued.
+type downloadStats struct {
+	Limit    int    `json:"limit"`
+	Active   int    `json:"active"`
+	Queued   int    `json:"queued"`
+	Rejected uint64 `json:"rejected"`
+	TimedOut uint64 `json:"timedOut"`
+}
+
+func (l *downloadLimiter) report() *downloadStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return &downloadStats{
+		Limit:    cap(l.slots),
+		Active:   len(l.slots),
+		Queued:   l.queued,
+		Rejected: l.rejected,
+		TimedOut: l.timedOut,
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDownloadLimiterQueue(t *testing.T) {
+	l := newDownloadLimiter(1, 1, 50*time.Millisecond)
+	if err := l.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The queued download gets the slot once it is released.
+	acquired := make(chan error)
+	go func() { acquired <- l.acquire(context.Background()) }()
+	for l.report().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := l.acquire(context.Background()).(*rateLimitedError); !ok {
+		t.Error("download beyond a full queue not refused")
+	}
+	l.release(time.Millisecond)
+	if err := <-acquired; err != nil {
+		t.Errorf("queued download = %v", err)
+	}
+
+	// A queued download gives up after the queue wait.
+	started := time.Now()
+	if _, ok := l.acquire(context.Background()).(*rateLimitedError); !ok {
+		t.Error("queued download didn't time out")
+	}
+	if elapsed := time.Since(started); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("queued download waited %s, want the 50ms queue wait", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.acquire(ctx); err != context.Canceled {
+		t.Errorf("download of a client that went away = %v", err)
+	}
+
+	if s := l.report(); s.Limit != 1 || s.Active != 1 || s.Queued != 0 || s.Rejected != 1 || s.TimedOut != 1 {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+func TestDownloadLimiterRetryAfter(t *testing.T) {
+	l := newDownloadLimiter(2, 4, time.Second)
+	if wait := l.retryAfter(); wait != time.Second {
+		t.Errorf("retry after before any download = %s, want 1s", wait)
+	}
+	l.slots <- struct{}{}
+	l.release(3 * time.Second)
+	if wait := l.retryAfter(); wait != 3*time.Second {
+		t.Errorf("retry after = %s, want the 3s downloads take", wait)
+	}
+	// Four queued downloads take two turns on the two slots ahead of the next.
+	l.queued = 4
+	if wait := l.retryAfter(); wait != 9*time.Second {
+		t.Errorf("retry after behind a full queue = %s, want 9s", wait)
+	}
+}
+
+func TestDownloadQueueTimeout(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	ds := f.server()
+	ds.MaxDownloads = 1
+	ds.downloads = newDownloadLimiter(1, 1, 20*time.Millisecond)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	f.onParGet = func(r *http.Request) {
+		close(started)
+		<-release
+	}
+	done := make(chan int)
+	go func() {
+		done <- testDownload("GET", "t=ten&a=a/f.txt").Code
+	}()
+	<-started
+	rec := testDownload("GET", "t=ten&a=a/f.txt")
+	if rec.Code != 503 || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("download timed out in the queue = %d Retry-After %q, want 503", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	// Without a queue the download is refused straight away.
+	ds.downloads.queue = 0
+	rec = testDownload("GET", "t=ten&a=a/f.txt")
+	if rec.Code != 503 || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("download beyond the cap = %d Retry-After %q, want 503", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	f.onParGet = nil
+	close(release)
+	if code := <-done; code != 200 {
+		t.Errorf("first download = %d", code)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net/http"
+)
+
+// Responses for zero-byte artifacts. Both carry the X-Artifact-Empty header so
+// clients can tell a legitimately empty artifact from a truncated download; ok
+// answers 200 with an empty body and no-content answers 204 No Content.
+const (
+	EmptyOK        = "ok"
+	EmptyNoContent = "no-content"
+)
+
+// headerEmpty marks the download of a zero-byte artifact.
+const headerEmpty = "X-Artifact-Empty"
+
+// sendEmpty handles the download of a zero-byte artifact, reporting whether
+// the response is complete. Trailers need a body to follow, so those downloads
+// get a 200 whatever the configuration.
+func (ds *DownloadServer) sendEmpty(w http.ResponseWriter, a *artifactStream, opts transferOptions) bool {
+	ds.logger().Info("Zero-byte artifact download", Fields{"artifact": a.name})
+	w.Header().Set(headerEmpty, "true")
+	if ds.EmptyArtifacts != EmptyNoContent || opts.trailers || opts.encrypt {
+		return false
+	}
+	w.Header().Del("Content-Length")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
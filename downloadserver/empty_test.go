@@ -0,0 +1,40 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"os"
+	"testing"
+)
+
+func TestEmptyArtifacts(t *testing.T) {
+	dir := testStore(t, map[string]string{"empty.txt": "", "f.txt": "hello"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+
+	rec := testDownload("GET", "a=empty.txt&s="+dir)
+	if rec.Code != 200 || rec.Body.Len() != 0 || rec.Header().Get(headerEmpty) != "true" {
+		t.Errorf("empty artifact = %d %q %s=%q, want a flagged empty 200", rec.Code, rec.Body.String(), headerEmpty, rec.Header().Get(headerEmpty))
+	}
+	if rec := testDownload("GET", "a=f.txt&s="+dir); rec.Header().Get(headerEmpty) != "" {
+		t.Error("non-empty artifact flagged as empty")
+	}
+
+	ds.EmptyArtifacts = EmptyNoContent
+	rec = testDownload("GET", "a=empty.txt&s="+dir)
+	if rec.Code != 204 || rec.Header().Get(headerEmpty) != "true" || rec.Header().Get("Content-Length") != "" {
+		t.Errorf("empty artifact with no-content = %d %s=%q Content-Length %q", rec.Code, headerEmpty, rec.Header().Get(headerEmpty), rec.Header().Get("Content-Length"))
+	}
+	// Trailers need a body to follow.
+	if rec := testDownload("GET", "a=empty.txt&trailers=1&s="+dir); rec.Code != 200 {
+		t.Errorf("empty artifact with trailers=1 = %d, want 200", rec.Code)
+	}
+
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("empty.bin", []byte{})
+	f.server().EmptyArtifacts = EmptyNoContent
+	if rec := testDownload("GET", "t=ten&a=empty.bin"); rec.Code != 204 || rec.Header().Get(headerEmpty) != "true" {
+		t.Errorf("empty OCI object = %d %s=%q", rec.Code, headerEmpty, rec.Header().Get(headerEmpty))
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// acceptsEncoding reports whether the request's Accept-Encoding allows coding.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	return headerAccepts(r.Header.Get("Accept-Encoding"), coding, "*")
+}
+
+// headerAccepts reports whether an Accept style header value lists any of names
+// with a non-zero quality.
+func headerAccepts(value string, names ...string) bool {
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.Split(item, ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		listed := false
+		for _, n := range names {
+			listed = listed || name == n
+		}
+		if !listed {
+			continue
+		}
+		accepted := true
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				accepted = err == nil && q > 0
+			}
+		}
+		if accepted {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipPassthrough prepares a pre-compressed (.gz) local artifact. A client that
+// accepts gzip, as told by acceptGzip, gets the stored bytes as they are with Content-Encoding: gzip;
+// any other client gets the content decompressed on the fly. Either way the
+// filename loses its .gz suffix since the client ends up with the decompressed
+// content. A file that turns out not to be gzip is served unchanged.
+func gzipPassthrough(acceptGzip bool, f *os.File, a *artifactStream) error {
+	stripped := strings.TrimSuffix(a.filename, ".gz")
+	if acceptGzip {
+		a.encoding = "gzip"
+	} else {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			_, err = f.Seek(0, io.SeekStart)
+			return err
+		}
+		a.body = zr
+		a.size = -1
+	}
+	a.filename = stripped
+	a.contentType = mime.TypeByExtension(path.Ext(stripped))
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHeaderAccepts(t *testing.T) {
+	for value, want := range map[string]bool{
+		"gzip":                 true,
+		"deflate, GZIP;q=0.5":  true,
+		"gzip;q=0":             false,
+		"*":                    true,
+		"br, *;q=0.1":          true,
+		"deflate, br":          false,
+		"":                     false,
+		"gzip;q=bogus, br":     false,
+		"identity, gzip ;q=1 ": true,
+	} {
+		if got := headerAccepts(value, "gzip", "*"); got != want {
+			t.Errorf("headerAccepts(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func gzipped(t *testing.T, content string) string {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(content))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestGzipPassthrough(t *testing.T) {
+	compressed := gzipped(t, "hello")
+	dir := testStore(t, map[string]string{"log.txt.gz": compressed, "bad.gz": "not gzip"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.GzipPassthrough = true
+
+	rec := testDownload("GET", "a=log.txt.gz&s="+dir, "Accept-Encoding", "gzip")
+	if rec.Body.String() != compressed || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("gzip client = %q encoding %q, want the stored bytes", rec.Body.String(), rec.Header().Get("Content-Encoding"))
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "log.txt") || strings.Contains(cd, ".gz") {
+		t.Errorf("Content-Disposition = %q, want log.txt", cd)
+	}
+	if rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Vary = %q", rec.Header().Get("Vary"))
+	}
+
+	rec = testDownload("GET", "a=log.txt.gz&s="+dir, "Accept-Encoding", "identity")
+	if rec.Body.String() != "hello" || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("other client = %q encoding %q, want it decompressed", rec.Body.String(), rec.Header().Get("Content-Encoding"))
+	}
+
+	// A .gz file that isn't gzip is served as it is.
+	if rec := testDownload("GET", "a=bad.gz&s="+dir); rec.Code != 200 || rec.Body.String() != "not gzip" {
+		t.Errorf("invalid gzip = %d %q", rec.Code, rec.Body.String())
+	}
+
+	ds.GzipPassthrough = false
+	rec = testDownload("GET", "a=log.txt.gz&s="+dir, "Accept-Encoding", "gzip")
+	if rec.Body.String() != compressed || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("without passthrough = encoding %q, want the file as is", rec.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestEncodingOverride(t *testing.T) {
+	compressed := gzipped(t, "hello")
+	dir := testStore(t, map[string]string{"log.txt.gz": compressed})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.GzipPassthrough = true
+
+	rec := testDownload("GET", "a=log.txt.gz&encoding=gzip&s="+dir)
+	if rec.Body.String() != compressed || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("encoding=gzip = %q encoding %q, want the stored bytes", rec.Body.String(), rec.Header().Get("Content-Encoding"))
+	}
+	rec = testDownload("GET", "a=log.txt.gz&encoding=none&s="+dir, "Accept-Encoding", "gzip")
+	if rec.Body.String() != "hello" || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("encoding=none = %q encoding %q, want it decompressed", rec.Body.String(), rec.Header().Get("Content-Encoding"))
+	}
+	if rec := testDownload("GET", "a=log.txt.gz&encoding=br&s="+dir); rec.Code != 400 {
+		t.Errorf("encoding=br = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+)
+
+/*
+ * Envelope encryption of downloads. With an EncryptionKey configured a request
+ * can ask for encrypt=1 to receive the artifact encrypted, independently of TLS.
+ * Each download is encrypted with its own random AES-256 data key, which is
+ * sent in the X-Encryption-Key header wrapped (AES-256-GCM) with the configured
+ * key encryption key. The body is a sequence of frames, each a 4 byte big endian
+ * length followed by an AES-256-GCM sealed chunk of up to encryptChunkSize bytes
+ * of plaintext. Chunk i uses a nonce of 4 zero bytes followed by i as a big
+ * endian uint64, and additional data of a single byte, 1 for the final chunk and
+ * 0 otherwise, so that a truncated stream fails to decrypt. The final chunk is
+ * the first one shorter than encryptChunkSize and may be empty.
+ */
+
+// encryptionScheme identifies the encryption scheme in the X-Encryption header.
+const encryptionScheme = "aes-256-gcm-chunked-v1"
+
+// encryptChunkSize is the amount of plaintext sealed in each frame.
+const encryptChunkSize = 64 * 1024
+
+// encryptedSize returns the size of the encrypted body for size bytes of
+// plaintext, or -1 when size is unknown.
+func encryptedSize(size int64) int64 {
+	if size < 0 {
+		return -1
+	}
+	chunks := size/encryptChunkSize + 1
+	return size + chunks*(4+16)
+}
+
+// wrapKey seals the data key with the key encryption key, returning the nonce
+// followed by the sealed key.
+func wrapKey(kek []byte, key []byte) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, key, nil), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptingWriter encrypts what is written through it into frames. Close must be
+// called once all plaintext has been written to emit the final frame.
+type encryptingWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+}
+
+// newEncryptingWriter generates the data key for a download, sets the response
+// headers describing the encryption and returns the writer for the body.
+func (ds *DownloadServer) newEncryptingWriter(w http.ResponseWriter) (*encryptingWriter, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	wrapped, err := wrapKey(ds.EncryptionKey, key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	w.Header().Set("X-Encryption", encryptionScheme)
+	w.Header().Set("X-Encryption-Key", base64.StdEncoding.EncodeToString(wrapped))
+	return &encryptingWriter{w: w, aead: aead, buf: make([]byte, 0, encryptChunkSize)}, nil
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+		// A full chunk is only sealed once more plaintext arrives, as the
+		// final chunk must be shorter than a full one.
+		if len(e.buf) == cap(e.buf) && len(p) > 0 {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close seals the remaining plaintext as the final frame.
+func (e *encryptingWriter) Close() error {
+	if len(e.buf) == cap(e.buf) {
+		if err := e.seal(false); err != nil {
+			return err
+		}
+	}
+	return e.seal(true)
+}
+
+func (e *encryptingWriter) seal(final bool) error {
+	nonce := make([]byte, e.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], e.counter)
+	e.counter++
+	ad := []byte{0}
+	if final {
+		ad[0] = 1
+	}
+	frame := make([]byte, 4, 4+len(e.buf)+e.aead.Overhead())
+	frame = e.aead.Seal(frame, nonce, e.buf, ad)
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(frame)
+	return err
+}
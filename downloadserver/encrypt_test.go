@@ -0,0 +1,173 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+)
+
+var testKEK = bytes.Repeat([]byte{7}, 32)
+
+// referenceDecrypt decrypts a body of the aes-256-gcm-chunked-v1 scheme as a
+// client would, working from the description of the scheme alone.
+func referenceDecrypt(kek []byte, wrappedKey string, body []byte) ([]byte, error) {
+	gcm := func(key []byte) (cipher.AEAD, error) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	kekGCM, err := gcm(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < kekGCM.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+	key, err := kekGCM.Open(nil, wrapped[:kekGCM.NonceSize()], wrapped[kekGCM.NonceSize():], nil)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := gcm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var plain []byte
+	for i := uint64(0); ; i++ {
+		if len(body) < 4 {
+			return nil, errors.New("stream truncated before the final chunk")
+		}
+		n := binary.BigEndian.Uint32(body)
+		if uint32(len(body)-4) < n {
+			return nil, errors.New("frame truncated")
+		}
+		sealed := body[4 : 4+n]
+		body = body[4+n:]
+		nonce := make([]byte, 12)
+		binary.BigEndian.PutUint64(nonce[4:], i)
+		// The final chunk is the first one shorter than a full chunk.
+		final := len(sealed)-aead.Overhead() < encryptChunkSize
+		ad := []byte{0}
+		if final {
+			ad[0] = 1
+		}
+		chunk, err := aead.Open(nil, nonce, sealed, ad)
+		if err != nil {
+			return nil, err
+		}
+		plain = append(plain, chunk...)
+		if final {
+			if len(body) != 0 {
+				return nil, errors.New("data after the final chunk")
+			}
+			return plain, nil
+		}
+	}
+}
+
+// encryptForTest encrypts plain through an encryptingWriter in writes of
+// random sizes, returning the wrapped key and the body.
+func encryptForTest(t *testing.T, plain []byte) (string, []byte) {
+	ds := &DownloadServer{EncryptionKey: testKEK}
+	rec := httptest.NewRecorder()
+	enc, err := ds.newEncryptingWriter(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Header().Get("X-Encryption") != encryptionScheme {
+		t.Errorf("X-Encryption = %q", rec.Header().Get("X-Encryption"))
+	}
+	rnd := rand.New(rand.NewSource(int64(len(plain))))
+	for rest := plain; len(rest) > 0; {
+		n := rnd.Intn(3*encryptChunkSize/2) + 1
+		if n > len(rest) {
+			n = len(rest)
+		}
+		if _, err := enc.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Header().Get("X-Encryption-Key"), rec.Body.Bytes()
+}
+
+func TestEncryptInteroperability(t *testing.T) {
+	for _, size := range []int{0, 1, encryptChunkSize - 1, encryptChunkSize, encryptChunkSize + 1, 3 * encryptChunkSize, 200000} {
+		plain := make([]byte, size)
+		rand.New(rand.NewSource(1)).Read(plain)
+		key, body := encryptForTest(t, plain)
+		if int64(len(body)) != encryptedSize(int64(size)) {
+			t.Errorf("size %d: body of %d bytes, encryptedSize %d", size, len(body), encryptedSize(int64(size)))
+		}
+		got, err := referenceDecrypt(testKEK, key, body)
+		if err != nil {
+			t.Errorf("size %d: %v", size, err)
+		} else if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: decrypted content differs", size)
+		}
+	}
+}
+
+func TestEncryptDetectsTampering(t *testing.T) {
+	plain := bytes.Repeat([]byte("x"), 2*encryptChunkSize+10)
+	key, body := encryptForTest(t, plain)
+
+	// Dropping the final frame leaves the stream without one.
+	last := len(body) - (4 + 10 + 16)
+	if _, err := referenceDecrypt(testKEK, key, body[:last]); err == nil {
+		t.Error("stream without its final frame decrypted")
+	}
+	corrupt := append([]byte(nil), body...)
+	corrupt[10] ^= 1
+	if _, err := referenceDecrypt(testKEK, key, corrupt); err == nil {
+		t.Error("corrupted stream decrypted")
+	}
+	if _, err := referenceDecrypt(bytes.Repeat([]byte{8}, 32), key, body); err == nil {
+		t.Error("data key unwrapped with another key")
+	}
+}
+
+func TestEncryptedDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("artifact "), 20000)
+	dir := testStore(t, map[string]string{"f.bin": string(content)})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	if rec := testDownload("GET", "a=f.bin&encrypt=1&s="+dir); rec.Code != 400 {
+		t.Errorf("encrypt=1 without a key = %d, want 400", rec.Code)
+	}
+
+	ds.EncryptionKey = testKEK
+	rec := testDownload("GET", "a=f.bin&encrypt=1&s="+dir)
+	if rec.Code != 200 {
+		t.Fatalf("encrypted download = %d %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Length"); got != strconv.FormatInt(encryptedSize(int64(len(content))), 10) {
+		t.Errorf("Content-Length = %s, want %d", got, encryptedSize(int64(len(content))))
+	}
+	plain, err := referenceDecrypt(testKEK, rec.Header().Get("X-Encryption-Key"), rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, content) {
+		t.Error("decrypted download differs from the artifact")
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+/*
+ * Archive subsets. entries= takes a comma separated list of entry names and
+ * path.Match globs, such as entries=docs/*,bin/tool, and answers the download
+ * of a tar or gzipped tar artifact with a new tar holding only the entries that
+ * match, under their names in the artifact so the directory structure is kept.
+ * A pattern matching a directory selects everything beneath it. The artifact is
+ * read as a stream and filtered on the fly; it is only scanned ahead up to the
+ * first match, so an artifact where nothing matches is still answered with a
+ * 404. Patterns that match nothing next to ones that do are not an error.
+ */
+
+// maxEntryPatterns bounds the number of entries= patterns of a request.
+const maxEntryPatterns = 100
+
+// entriesCopySize is how much of an entry is filtered at a time.
+const entriesCopySize = 32 << 10
+
+// parseEntryPatterns splits and validates an entries= value.
+func parseEntryPatterns(value string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.Trim(pattern, "/")
+		if pattern == "" {
+			return nil, badRequest("empty pattern in entries=")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, badRequest("invalid pattern in entries=: " + pattern)
+		}
+		patterns = append(patterns, pattern)
+	}
+	if len(patterns) > maxEntryPatterns {
+		return nil, badRequest("too many patterns in entries=")
+	}
+	return patterns, nil
+}
+
+// entryMatches reports whether a normalized entry name, or the name of a
+// directory it is in, matches any of patterns.
+func entryMatches(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		for n := name; n != "." && n != ""; n = path.Dir(n) {
+			if ok, _ := path.Match(pattern, n); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// entriesReader reads the tar of the entries of an archive that match the
+// patterns.
+type entriesReader struct {
+	tr       *tar.Reader
+	tw       *tar.Writer
+	buf      bytes.Buffer
+	patterns []string
+	// next is the matching header to write next, inEntry set while the content
+	// of the last header written is being copied.
+	next    *tar.Header
+	inEntry bool
+	err     error
+}
+
+// nextMatch returns the next header of the archive that matches the patterns,
+// with its name normalized.
+func (e *entriesReader) nextMatch() (*tar.Header, error) {
+	for {
+		hdr, err := e.tr.Next()
+		if err == io.EOF {
+			return nil, err
+		}
+		if err != nil {
+			return nil, &statusError{http.StatusBadRequest, "artifact is not a valid archive: " + err.Error()}
+		}
+		name := cleanEntryName(hdr.Name)
+		if !entryMatches(name, e.patterns) {
+			continue
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			name += "/"
+		}
+		hdr.Name = name
+		return hdr, nil
+	}
+}
+
+// fill writes the next piece of the filtered archive into the buffer.
+func (e *entriesReader) fill() error {
+	if e.inEntry {
+		if _, err := io.CopyN(e.tw, e.tr, entriesCopySize); err != io.EOF {
+			return err
+		}
+		e.inEntry = false
+		return nil
+	}
+	hdr := e.next
+	e.next = nil
+	if hdr == nil {
+		var err error
+		if hdr, err = e.nextMatch(); err == io.EOF {
+			if err := e.tw.Close(); err != nil {
+				return err
+			}
+			return io.EOF
+		} else if err != nil {
+			return err
+		}
+	}
+	if err := e.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	e.inEntry = true
+	return nil
+}
+
+func (e *entriesReader) Read(p []byte) (int, error) {
+	for e.buf.Len() == 0 {
+		if e.err != nil {
+			return 0, e.err
+		}
+		e.err = e.fill()
+	}
+	return e.buf.Read(p)
+}
+
+// selectEntries replaces the content of a with the tar of the entries matching
+// patterns, when a is a tar or gzipped tar archive. The archive is scanned up
+// to the first matching entry before anything is sent.
+func selectEntries(a *artifactStream, patterns []string) error {
+	isTar, gzipped := isTarArtifact(a.name)
+	if !isTar {
+		return &statusError{http.StatusBadRequest, "artifact is not a recognized archive"}
+	}
+	body := a.body
+	if gzipped {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return &statusError{http.StatusBadRequest, "artifact is not a gzip compressed archive"}
+		}
+		body = zr
+	}
+	e := &entriesReader{tr: tar.NewReader(body), patterns: patterns}
+	e.tw = tar.NewWriter(&e.buf)
+	first, err := e.nextMatch()
+	if err == io.EOF {
+		return &statusError{http.StatusNotFound, "no archive entries match entries="}
+	}
+	if err != nil {
+		return err
+	}
+	e.next = first
+	a.body = e
+	a.size = -1
+	a.filename = strings.TrimSuffix(strings.TrimSuffix(a.filename, ".gz"), ".tgz")
+	if !strings.HasSuffix(a.filename, ".tar") {
+		a.filename += ".tar"
+	}
+	a.contentType = "application/x-tar"
+	a.storedType = ""
+	a.encoding = ""
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testTar returns a tar of the entries, name and content pairs. Names ending in
+// a slash are directories.
+func testTar(t *testing.T, entries ...string) string {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i+1 < len(entries); i += 2 {
+		hdr := &tar.Header{Name: entries[i], Mode: 0644, Size: int64(len(entries[i+1])), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(entries[i], "/") {
+			hdr.Mode, hdr.Size, hdr.Typeflag = 0755, 0, tar.TypeDir
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entries[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestParseEntryPatterns(t *testing.T) {
+	patterns, err := parseEntryPatterns("/docs/*,bin/tool/")
+	if err != nil || fmt.Sprint(patterns) != "[docs/* bin/tool]" {
+		t.Errorf("patterns = %q %v", patterns, err)
+	}
+	for _, value := range []string{"docs/*,", "[", strings.Repeat("a,", maxEntryPatterns) + "a"} {
+		if _, err := parseEntryPatterns(value); err == nil {
+			t.Errorf("entries=%.20s accepted", value)
+		}
+	}
+}
+
+func TestEntryMatches(t *testing.T) {
+	patterns := []string{"docs/*.md", "bin"}
+	for name, want := range map[string]bool{
+		"docs/readme.md":     true,
+		"docs/sub/readme.md": false,
+		"docs/readme.txt":    false,
+		"bin/tool":           true,
+		"binary":             false,
+		"src/bin":            false,
+	} {
+		if got := entryMatches(name, patterns); got != want {
+			t.Errorf("entryMatches(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestEntriesDownload(t *testing.T) {
+	big := strings.Repeat("x", 3*entriesCopySize+7)
+	archive := testTar(t,
+		"./docs/", "",
+		"./docs/readme.md", "readme",
+		"./docs/guide.md", big,
+		"./bin/tool", "tool",
+		"./src/main.go", "main",
+	)
+	dir := testStore(t, map[string]string{
+		"app.tar":    archive,
+		"app.tar.gz": gzipped(t, archive),
+		"app.txt":    "not an archive",
+	})
+	defer os.RemoveAll(dir)
+	localServer()
+
+	for _, artifact := range []string{"app.tar", "app.tar.gz"} {
+		rec := testDownload("GET", "a="+artifact+"&entries=docs/*.md,bin&s="+dir)
+		if rec.Code != 200 {
+			t.Fatalf("entries= download of %s = %d %s", artifact, rec.Code, rec.Body.String())
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/x-tar" {
+			t.Errorf("%s Content-Type = %q", artifact, ct)
+		}
+		if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "app.tar") || strings.Contains(cd, ".gz") {
+			t.Errorf("%s Content-Disposition = %q", artifact, cd)
+		}
+		names, contents := readTar(t, rec.Body.Bytes())
+		if fmt.Sprint(names) != "[docs/readme.md docs/guide.md bin/tool]" {
+			t.Errorf("%s entries = %v", artifact, names)
+		}
+		if contents["docs/guide.md"] != big || contents["bin/tool"] != "tool" {
+			t.Errorf("%s entry contents differ", artifact)
+		}
+	}
+
+	// A pattern matching a directory selects everything beneath it.
+	rec := testDownload("GET", "a=app.tar&entries=docs&s="+dir)
+	if names, _ := readTar(t, rec.Body.Bytes()); fmt.Sprint(names) != "[docs/ docs/readme.md docs/guide.md]" {
+		t.Errorf("entries=docs = %v", names)
+	}
+	if rec := testDownload("GET", "a=app.tar&entries=lib/*&s="+dir); rec.Code != 404 {
+		t.Errorf("entries= matching nothing = %d, want 404", rec.Code)
+	}
+	if rec := testDownload("GET", "a=app.txt&entries=*&s="+dir); rec.Code != 400 {
+		t.Errorf("entries= of a non-archive = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// isTarArtifact reports whether name looks like a tar archive and whether the
+// archive is gzip compressed.
+func isTarArtifact(name string) (isTar bool, gzipped bool) {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return true, true
+	case strings.HasSuffix(name, ".tar"):
+		return true, false
+	}
+	return false, false
+}
+
+// cleanEntryName normalizes an archive entry name for comparison.
+func cleanEntryName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// selectEntry replaces the content of a with the single file entry from within
+// it, when a is a tar or gzipped tar archive. The archive is decompressed and
+// scanned up to the entry, so nothing beyond it is read.
+func selectEntry(a *artifactStream, entry string) error {
+	isTar, gzipped := isTarArtifact(a.name)
+	if !isTar {
+		return &statusError{http.StatusBadRequest, "artifact is not a recognized archive"}
+	}
+	body := a.body
+	if gzipped {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return &statusError{http.StatusBadRequest, "artifact is not a gzip compressed archive"}
+		}
+		body = zr
+	}
+	want := cleanEntryName(entry)
+	tr := tar.NewReader(body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return &statusError{http.StatusNotFound, "archive entry not found"}
+		}
+		if err != nil {
+			return &statusError{http.StatusBadRequest, "artifact is not a valid archive: " + err.Error()}
+		}
+		if cleanEntryName(hdr.Name) != want {
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			return &statusError{http.StatusBadRequest, "archive entry is not a regular file"}
+		}
+		a.body = tr
+		a.filename = path.Base(want)
+		a.size = hdr.Size
+		a.lastModified = hdr.ModTime.UTC().Format(http.TimeFormat)
+		a.contentType = ""
+		a.storedType = ""
+		a.encoding = ""
+		return nil
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestIsTarArtifact(t *testing.T) {
+	for name, want := range map[string][2]bool{
+		"build.tar":    {true, false},
+		"build.tar.gz": {true, true},
+		"build.tgz":    {true, true},
+		"build.gz":     {false, false},
+		"build.zip":    {false, false},
+	} {
+		if isTar, gzipped := isTarArtifact(name); isTar != want[0] || gzipped != want[1] {
+			t.Errorf("isTarArtifact(%q) = %v %v, want %v", name, isTar, gzipped, want)
+		}
+	}
+}
+
+func TestCleanEntryName(t *testing.T) {
+	for name, want := range map[string]string{
+		"bin/tool":      "bin/tool",
+		"./bin/tool":    "bin/tool",
+		"/bin//tool":    "bin/tool",
+		"../bin/tool":   "bin/tool",
+		"bin/../etc/rc": "etc/rc",
+	} {
+		if got := cleanEntryName(name); got != want {
+			t.Errorf("cleanEntryName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestEntryDownload(t *testing.T) {
+	archive := testTar(t, "bin/", "", "./bin/tool", "binary", "README", "read me")
+	dir := testStore(t, map[string]string{"build.tar": archive, "build.tgz": gzipped(t, archive), "build.zip": archive})
+	defer os.RemoveAll(dir)
+	localServer()
+
+	for _, artifact := range []string{"build.tar", "build.tgz"} {
+		rec := testDownload("GET", "a="+artifact+"&entry=bin/tool&s="+dir)
+		if rec.Code != 200 || rec.Body.String() != "binary" {
+			t.Errorf("%s: entry = %d %q", artifact, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Content-Disposition"); !strings.Contains(got, "filename=tool") {
+			t.Errorf("%s: Content-Disposition = %q, want the entry's name", artifact, got)
+		}
+		if got := rec.Header().Get("Content-Length"); got != "6" {
+			t.Errorf("%s: Content-Length = %q, want the entry's size", artifact, got)
+		}
+	}
+	for query, want := range map[string]int{
+		"a=build.tar&entry=missing": 404,
+		"a=build.tar&entry=bin":     400,
+		"a=build.zip&entry=README":  400,
+		"a=build.tar&entry=README":  200,
+	} {
+		if rec := testDownload("GET", query+"&s="+dir); rec.Code != want {
+			t.Errorf("%s = %d %q, want %d", query, rec.Code, rec.Body.String(), want)
+		}
+	}
+}
+
+func TestOCIEntryDownload(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/build.tgz", []byte(gzipped(t, testTar(t, "bin/tool", "binary"))))
+	f.server()
+
+	if rec := testDownload("GET", "t=ten&a=a/build.tgz&entry=bin/tool"); rec.Code != 200 || rec.Body.String() != "binary" {
+		t.Errorf("OCI entry = %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := testDownload("GET", "t=ten&a=a/build.tgz&entry=bin/other"); rec.Code != 404 {
+		t.Errorf("missing OCI entry = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// statusError is an error that should be reported to the client with a specific
+// HTTP status.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// errorBody is the JSON document sent for errors.
+type errorBody struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+// downloadHeaders are the headers describing the artifact being sent, which
+// don't apply to an error or to the other backend's copy of the artifact.
+var downloadHeaders = []string{
+	"Accept-Ranges", "Content-Disposition", "Content-Encoding", "Content-Length", "Content-MD5", "Digest",
+	"Last-Modified", "Trailer", "X-Encryption", "X-Encryption-Key",
+}
+
+// clearDownloadHeaders removes the download headers set on w.
+func clearDownloadHeaders(w http.ResponseWriter) {
+	for _, h := range downloadHeaders {
+		w.Header().Del(h)
+	}
+}
+
+// httpError replies to the request with an error message and status code. The
+// body is JSON unless the client's Accept header rules JSON out, in which case
+// it is plain text. Headers describing the download are removed first since
+// they don't apply to the error.
+func httpError(w http.ResponseWriter, r *http.Request, msg string, code int) {
+	clearDownloadHeaders(w)
+	if !wantsJSON(r) {
+		http.Error(w, msg, code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(errorBody{Error: msg, Status: code})
+}
+
+// wantsJSON reports whether the client accepts application/json. A client that
+// states no preference gets JSON.
+func wantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+	return headerAccepts(accept, "application/json", "application/*", "*/*")
+}
+
+// downloadError writes the error response for a download that failed before any of
+// the artifact was sent. A download that failed part way is aborted instead.
+func downloadError(w http.ResponseWriter, r *http.Request, err error) {
+	// The status is gone but the response can still be cut short, so that a
+	// chunked body isn't ended as if it were complete.
+	if err == errResponseCommitted {
+		panic(http.ErrAbortHandler)
+	}
+	err = downloadServer.authFailure(err)
+	// A download that ran out of the client's deadline before it could start.
+	if err == errDeadlineExceeded || r.Context().Err() == context.DeadlineExceeded {
+		httpError(w, r, errDeadlineExceeded.Error(), http.StatusGatewayTimeout)
+		return
+	}
+	if se, ok := err.(*statusError); ok {
+		httpError(w, r, se.msg, se.code)
+		return
+	}
+	if limited, ok := err.(*rateLimitedError); ok {
+		w.Header().Set("Retry-After", retryAfter(limited.retryAfter))
+		httpError(w, r, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if unsatisfiable, ok := err.(*rangeError); ok {
+		if unsatisfiable.size >= 0 {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", unsatisfiable.size))
+		}
+		httpError(w, r, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if err == errOCITimeout {
+		httpError(w, r, err.Error(), http.StatusGatewayTimeout)
+		return
+	}
+	msg := fmt.Sprintf("%s", err)
+	httpError(w, r, msg, 500)
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorNegotiation(t *testing.T) {
+	for accept, wantJSON := range map[string]bool{
+		"":                          true,
+		"application/json":          true,
+		"text/html, application/*":  true,
+		"*/*":                       true,
+		"text/plain":                false,
+		"text/html, */*;q=0":        false,
+		"application/json;q=0, */*": true,
+	} {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", downloadPath, nil)
+		r.Header.Set("Accept", accept)
+		rec.Header().Set("Content-Disposition", "attachment; filename=a")
+		httpError(rec, r, "artifact not found", 404)
+
+		if rec.Code != 404 || rec.Header().Get("Content-Disposition") != "" {
+			t.Errorf("Accept %q: %d Content-Disposition %q", accept, rec.Code, rec.Header().Get("Content-Disposition"))
+		}
+		if !wantJSON {
+			if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") || strings.TrimSpace(rec.Body.String()) != "artifact not found" {
+				t.Errorf("Accept %q: %s %q, want plain text", accept, rec.Header().Get("Content-Type"), rec.Body.String())
+			}
+			continue
+		}
+		var body errorBody
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("Accept %q: %s %q, want JSON", accept, rec.Header().Get("Content-Type"), rec.Body.String())
+		} else if body.Error != "artifact not found" || body.Status != 404 {
+			t.Errorf("Accept %q: body = %+v", accept, body)
+		}
+	}
+}
+
+func TestHTTPErrorClearsDownloadHeaders(t *testing.T) {
+	rec := httptest.NewRecorder()
+	for _, h := range downloadHeaders {
+		rec.Header().Set(h, "x")
+	}
+	rec.Header().Set("Retry-After", "1")
+	httpError(rec, httptest.NewRequest("GET", downloadPath, nil), "artifact not found", 404)
+	for _, h := range []string{"Digest", "Accept-Ranges", "Content-MD5", "X-Encryption-Key"} {
+		if got := rec.Header().Get(h); got != "" {
+			t.Errorf("%s = %q kept on the error", h, got)
+		}
+	}
+	if rec.Header().Get("Retry-After") != "1" {
+		t.Error("Retry-After removed from the error")
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+ * Download events. With EventWebhook set a JSON event is posted to the webhook
+ * for every download once its response is done, for auditing and analytics.
+ * Events are queued in a bounded buffer and posted by a single worker, so a slow
+ * or failing webhook never holds up or fails a download; events that don't fit
+ * in the buffer are dropped and counted instead.
+ */
+
+// DefaultEventBuffer is the number of events buffered when EventBuffer isn't set.
+const DefaultEventBuffer = 1024
+
+// eventPostTimeout bounds each POST to the webhook.
+const eventPostTimeout = 10 * time.Second
+
+// DownloadEvent is the JSON document posted for a download.
+type DownloadEvent struct {
+	Time      time.Time `json:"timestamp"`
+	Artifact  string    `json:"artifact,omitempty"`
+	Artifacts []string  `json:"artifacts,omitempty"`
+	Tenancy   string    `json:"tenancy,omitempty"`
+	Client    string    `json:"client"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	// Aborted is set when the response was cut short part way.
+	Aborted bool `json:"aborted,omitempty"`
+	// Duration is the time taken to serve the download, in milliseconds.
+	Duration float64 `json:"durationMs"`
+}
+
+// eventEmitter posts download events to a webhook in the background.
+type eventEmitter struct {
+	url    string
+	client *http.Client
+	events chan *DownloadEvent
+	done   chan struct{}
+	ds     *DownloadServer
+
+	mu      sync.Mutex
+	closed  bool
+	sent    uint64
+	failed  uint64
+	dropped uint64
+}
+
+func newEventEmitter(ds *DownloadServer, url string, buffer int) *eventEmitter {
+	if buffer <= 0 {
+		buffer = DefaultEventBuffer
+	}
+	e := &eventEmitter{
+		url:    url,
+		client: &http.Client{Timeout: eventPostTimeout},
+		events: make(chan *DownloadEvent, buffer),
+		done:   make(chan struct{}),
+		ds:     ds,
+	}
+	go e.run()
+	return e
+}
+
+// emit queues event for posting without blocking, dropping it when the buffer
+// is full or the emitter has been closed.
+func (e *eventEmitter) emit(event *DownloadEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		e.dropped++
+		return
+	}
+	select {
+	case e.events <- event:
+	default:
+		e.dropped++
+	}
+}
+
+// run posts the queued events until the emitter is closed and drained.
+func (e *eventEmitter) run() {
+	defer close(e.done)
+	for event := range e.events {
+		err := e.post(event)
+		e.mu.Lock()
+		if err != nil {
+			e.failed++
+		} else {
+			e.sent++
+		}
+		e.mu.Unlock()
+		if err != nil {
+			e.ds.logger().Warn("Failed to post download event", Fields{"artifact": event.Artifact, "error": err.Error()})
+		}
+	}
+}
+
+func (e *eventEmitter) post(event *DownloadEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	response, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", response.Status)
+	}
+	return nil
+}
+
+// close stops accepting events and waits for the buffered ones to be posted,
+// giving up once ctx is done. It is registered as a shutdown hook.
+func (e *eventEmitter) close(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.events)
+	}
+	e.mu.Unlock()
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// eventStats counts the events posted, failed and dropped.
+type eventStats struct {
+	Sent    uint64 `json:"sent"`
+	Failed  uint64 `json:"failed"`
+	Dropped uint64 `json:"dropped"`
+	Queued  int    `json:"queued"`
+}
+
+func (e *eventEmitter) report() *eventStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return &eventStats{Sent: e.sent, Failed: e.failed, Dropped: e.dropped, Queued: len(e.events)}
+}
+
+// downloadEvent describes the download of req by the client at clientAddr,
+// whose response was written through w, starting at started. A response of
+// which nothing was written yet is reported with status 0.
+func downloadEvent(clientAddr string, req *DownloadRequest, w *statusWriter, started time.Time) *DownloadEvent {
+	event := &DownloadEvent{
+		Time:     started.UTC(),
+		Tenancy:  req.Tenancy,
+		Client:   clientIP(clientAddr),
+		Status:   w.status,
+		Bytes:    w.written,
+		Duration: milliseconds(time.Since(started)),
+	}
+	if len(req.Artifacts) == 1 {
+		event.Artifact = req.Artifacts[0]
+	} else {
+		event.Artifacts = req.Artifacts
+	}
+	return event
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventSink is a webhook that records the events posted to it.
+type eventSink struct {
+	*httptest.Server
+	mu     sync.Mutex
+	events []DownloadEvent
+	// block, when set, holds every POST until it is closed.
+	block chan struct{}
+}
+
+func newEventSink() *eventSink {
+	s := &eventSink{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.block != nil {
+			<-s.block
+		}
+		var event DownloadEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		s.mu.Lock()
+		s.events = append(s.events, event)
+		s.mu.Unlock()
+	}))
+	return s
+}
+
+func TestDownloadEvents(t *testing.T) {
+	sink := newEventSink()
+	defer sink.Close()
+	dir := testStore(t, map[string]string{"f.txt": "hello"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.events = newEventEmitter(ds, sink.URL, 0)
+
+	testDownload("GET", "a=f.txt&s="+dir)
+	testDownload("GET", "a=missing.txt&s="+dir)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ds.events.close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.events) != 2 {
+		t.Fatalf("%d events posted, want 2", len(sink.events))
+	}
+	if e := sink.events[0]; e.Artifact != "f.txt" || e.Status != 200 || e.Bytes != 5 || e.Client != "192.0.2.1" || e.Aborted || e.Time.IsZero() {
+		t.Errorf("event = %+v", e)
+	}
+	if e := sink.events[1]; e.Artifact != "missing.txt" || e.Status < 400 {
+		t.Errorf("event of a failed download = %+v", e)
+	}
+	if s := ds.events.report(); s.Sent != 2 || s.Failed != 0 || s.Dropped != 0 {
+		t.Errorf("event stats = %+v", s)
+	}
+
+	// Events emitted after the close are dropped.
+	ds.events.emit(&DownloadEvent{Artifact: "late"})
+	if s := ds.events.report(); s.Dropped != 1 {
+		t.Errorf("event stats after close = %+v", s)
+	}
+}
+
+func TestDownloadEventsDropped(t *testing.T) {
+	sink := newEventSink()
+	sink.block = make(chan struct{})
+	defer sink.Close()
+	ds := &DownloadServer{}
+	e := newEventEmitter(ds, sink.URL, 1)
+
+	// The worker holds one event in its POST and the buffer another.
+	e.emit(&DownloadEvent{Artifact: "posting"})
+	for e.report().Queued != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	e.emit(&DownloadEvent{Artifact: "buffered"})
+	started := time.Now()
+	e.emit(&DownloadEvent{Artifact: "dropped"})
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("emit blocked for %s on a full buffer", elapsed)
+	}
+	close(sink.block)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	e.close(ctx)
+	if s := e.report(); s.Sent != 2 || s.Dropped != 1 {
+		t.Errorf("event stats = %+v, want 2 sent and 1 dropped", s)
+	}
+}
+
+func TestAbortedDownloadEvent(t *testing.T) {
+	sink := newEventSink()
+	defer sink.Close()
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("big.bin", make([]byte, 64<<10))
+	ds := f.server()
+	ds.events = newEventEmitter(ds, sink.URL, 0)
+	f.cutAfter = 1000
+
+	func() {
+		defer func() {
+			if p := recover(); p != http.ErrAbortHandler {
+				t.Errorf("cut download panic %v, want it aborted", p)
+			}
+		}()
+		testDownload("GET", "t=ten&a=big.bin")
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ds.events.close(ctx)
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.events) != 1 || !sink.events[0].Aborted || sink.events[0].Tenancy != "ten" {
+		t.Errorf("events = %+v, want one aborted download", sink.events)
+	}
+}
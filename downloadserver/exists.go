@@ -0,0 +1,69 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"net/http"
+	"os"
+)
+
+/*
+ * Existence checks. exists=1 answers whether an artifact is there without
+ * serving it: 200 when it exists and 404 when it doesn't, both without a body,
+ * so that clients can cheaply poll for an artifact being published. A local
+ * artifact is looked up with a stat and an OCI object with a HEAD, never a PAR.
+ * The checks, deny list and path rules of a download all apply, and with
+ * fallback=local a missing OCI object is looked for locally.
+ */
+
+// errNoObject is returned by ociExists for a missing object, so that the local
+// copy can be looked for with fallback=local.
+var errNoObject = &statusError{http.StatusNotFound, "artifact not found"}
+
+// sendExists writes the bodiless answer to an existence check.
+func sendExists(w http.ResponseWriter, exists bool) {
+	w.Header().Set("Cache-Control", "no-store")
+	if exists {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// localExists answers exists=1 for a local artifact. Directories don't count.
+func (ds *DownloadServer) localExists(w http.ResponseWriter, artifact string, storepath string) error {
+	artifactPath, err := ds.localPath(storepath, artifact)
+	if err != nil {
+		return err
+	}
+	stat, err := os.Stat(artifactPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	sendExists(w, err == nil && stat.Mode().IsRegular())
+	return nil
+}
+
+// ociExists answers exists=1 for an OCI artifact within OCITimeout, returning
+// errNoObject when it doesn't exist.
+func (ds *DownloadServer) ociExists(w http.ResponseWriter, r *http.Request, artifact string) error {
+	ctx := r.Context()
+	if ds.OCITimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ds.OCITimeout)
+		defer cancel()
+	}
+	_, exists, err := ds.headObject(ctx, ds.ociObjectName(artifact))
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded && r.Context().Err() == nil {
+			return errOCITimeout
+		}
+		return err
+	}
+	if !exists {
+		return errNoObject
+	}
+	sendExists(w, true)
+	return nil
+}
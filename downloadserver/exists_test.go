@@ -0,0 +1,62 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLocalExists(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.txt": "hello", "sub/g.txt": "sub"})
+	defer os.RemoveAll(dir)
+	localServer()
+
+	for _, tc := range []struct {
+		artifact string
+		want     int
+	}{
+		{"f.txt", 200},
+		{"missing.txt", 404},
+		{"sub", 404},
+		{"../f.txt", 403},
+	} {
+		rec := testDownload("GET", "exists=1&a="+tc.artifact+"&s="+dir)
+		if rec.Code != tc.want {
+			t.Errorf("exists=1 for %s = %d, want %d", tc.artifact, rec.Code, tc.want)
+		}
+		if tc.want != 403 && rec.Body.Len() != 0 {
+			t.Errorf("exists=1 for %s has a body %q", tc.artifact, rec.Body.String())
+		}
+	}
+	if rec := testDownload("GET", "exists=1&archive=tar&a=f.txt&s="+dir); rec.Code != 400 {
+		t.Errorf("exists=1 with archive=tar = %d, want 400", rec.Code)
+	}
+}
+
+func TestOCIExists(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	f.server()
+
+	if rec := testDownload("GET", "exists=1&t=ten&a=a/f.txt"); rec.Code != 200 || rec.Body.Len() != 0 {
+		t.Errorf("exists=1 for an object = %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := testDownload("GET", "exists=1&t=ten&a=a/missing.txt"); rec.Code != 404 || rec.Body.Len() != 0 {
+		t.Errorf("exists=1 for a missing object = %d %q", rec.Code, rec.Body.String())
+	}
+	if n := f.count(&f.pars); n != 0 {
+		t.Errorf("%d PARs created for existence checks", n)
+	}
+	if n := f.count(&f.heads); n != 2 {
+		t.Errorf("%d HEADs for two existence checks", n)
+	}
+
+	// With fallback=local a missing object is looked for locally.
+	dir := testStore(t, map[string]string{"a/missing.txt": "local"})
+	defer os.RemoveAll(dir)
+	if rec := testDownload("GET", "exists=1&fallback=local&t=ten&a=a/missing.txt&s="+dir); rec.Code != 200 {
+		t.Errorf("exists=1 with a local fallback = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeObject is an object kept by fakeOCI.
+type fakeObject struct {
+	content []byte
+	etag    string
+	// multipart marks an object uploaded in parts, which has no Content-MD5.
+	multipart bool
+	// archival is the archival-state of the object, "" when it is readable.
+	archival string
+	// metadata is the user metadata of the object, sent as opc-meta- headers.
+	metadata map[string]string
+	// contentType is the Content-Type the object is stored with, by default
+	// application/octet-stream.
+	contentType string
+}
+
+// fakeOCI is an Object Storage endpoint for the tests. It serves the bucket
+// ns/bk: HEAD, GET, PUT and DELETE of objects with the OCI client, PAR
+// listing and creation, and GETs through the PARs it handed out.
+type fakeOCI struct {
+	*httptest.Server
+	t  *testing.T
+	mu sync.Mutex
+	// objects are the objects of the bucket by name.
+	objects map[string]*fakeObject
+	// status, when set, answers every OCI client (signed) request.
+	status int
+	// parStatus, when set, answers every GET through a PAR.
+	parStatus int
+	// parDelay delays the creation of PARs.
+	parDelay time.Duration
+	// cutAfter, when set, cuts the connection of PAR GETs after that many
+	// bytes of the object were sent, or right after the headers when negative.
+	cutAfter int
+	// version numbers the ETags of the objects put.
+	version int
+	// Counters of the requests served.
+	pars, heads, gets, parGets, restores, deletes int
+	// parList are the PARs of the bucket, in creation order.
+	parList []*fakePAR
+	// ranges are the Range headers of the GETs through PARs.
+	ranges []string
+	// sseKeys are the SSE-C key headers of the object requests of the OCI
+	// client.
+	sseKeys []string
+	// onParGet, when set, is called before a PAR GET is answered.
+	onParGet func(r *http.Request)
+}
+
+// fakePAR is a PAR kept by fakeOCI, as it is listed and returned on creation.
+type fakePAR struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	ObjectName string    `json:"objectName"`
+	AccessType string    `json:"accessType"`
+	AccessURI  string    `json:"accessUri,omitempty"`
+	Created    time.Time `json:"timeCreated"`
+	Expires    time.Time `json:"timeExpires"`
+}
+
+var (
+	testKeyOnce sync.Once
+	testKeyPEM  string
+)
+
+// testPrivateKey returns a PEM encoded RSA key for signing OCI requests.
+func testPrivateKey(t *testing.T) string {
+	testKeyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testKeyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	})
+	return testKeyPEM
+}
+
+// newFakeOCI starts a fakeOCI, which the caller closes.
+func newFakeOCI(t *testing.T) *fakeOCI {
+	f := &fakeOCI{t: t, objects: make(map[string]*fakeObject)}
+	f.Server = httptest.NewTLSServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// put stores an object.
+func (f *fakeOCI) put(name string, content []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version++
+	f.objects[name] = &fakeObject{content: content, etag: fmt.Sprintf(`"v%d"`, f.version)}
+}
+
+// server returns a DownloadServer using f as its Object Storage endpoint, and
+// makes it the package's server.
+func (f *fakeOCI) server() *DownloadServer {
+	ds := &DownloadServer{
+		Tenancy:     "ten",
+		User:        "user",
+		Fingerprint: "aa:bb",
+		Privatekey:  testPrivateKey(f.t),
+		Region:      "us-phoenix-1",
+		Namespace:   "ns",
+		BucketName:  "bk",
+		OCIEndpoint: f.URL,
+		upstream:    f.Client(),
+	}
+	ds.quotas = newTenancyQuotas(nil, 0)
+	downloadServer = ds
+	return ds
+}
+
+func (f *fakeOCI) count(counter *int) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return *counter
+}
+
+// parIDs returns the sorted ids of the PARs of f.
+func (f *fakeOCI) parIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for _, par := range f.parList {
+		ids = append(ids, par.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+const fakeBucketPath = "/n/ns/b/bk"
+
+func (f *fakeOCI) serve(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	// GETs through a PAR aren't signed.
+	if strings.HasPrefix(path, "/p/") {
+		i := strings.Index(path, fakeBucketPath+"/o/")
+		if i < 0 {
+			http.NotFound(w, r)
+			return
+		}
+		f.mu.Lock()
+		f.parGets++
+		f.ranges = append(f.ranges, r.Header.Get("Range"))
+		hook, status, cut := f.onParGet, f.parStatus, f.cutAfter
+		f.mu.Unlock()
+		if hook != nil {
+			hook(r)
+		}
+		if status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		if cut < 0 {
+			w = &cuttingWriter{ResponseWriter: w}
+		} else if cut > 0 {
+			w = &cuttingWriter{ResponseWriter: w, left: cut}
+		}
+		f.serveObject(w, r, path[i+len(fakeBucketPath+"/o/"):])
+		return
+	}
+	f.mu.Lock()
+	status, delay := f.status, f.parDelay
+	f.mu.Unlock()
+	if status != 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"code":"Status%d","message":"forced"}`, status)
+		return
+	}
+	switch {
+	case path == fakeBucketPath || path == fakeBucketPath+"/":
+		w.WriteHeader(http.StatusOK)
+	case path == fakeBucketPath+"/p/" || path == fakeBucketPath+"/p":
+		if r.Method == "GET" {
+			f.mu.Lock()
+			list := make([]fakePAR, 0, len(f.parList))
+			for _, par := range f.parList {
+				list = append(list, *par)
+			}
+			f.mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(list)
+			return
+		}
+		var details struct {
+			Name        string    `json:"name"`
+			ObjectName  string    `json:"objectName"`
+			TimeExpires time.Time `json:"timeExpires"`
+		}
+		json.NewDecoder(r.Body).Decode(&details)
+		time.Sleep(delay)
+		f.mu.Lock()
+		f.pars++
+		par := &fakePAR{
+			ID:         fmt.Sprintf("par%d", f.pars),
+			Name:       details.Name,
+			ObjectName: details.ObjectName,
+			AccessType: "ObjectRead",
+			AccessURI:  fmt.Sprintf("/p/tok%d%s/o/%s", f.pars, fakeBucketPath, details.ObjectName),
+			Created:    time.Now().UTC(),
+			Expires:    details.TimeExpires,
+		}
+		f.parList = append(f.parList, par)
+		created := *par
+		f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(created)
+	case strings.HasPrefix(path, fakeBucketPath+"/p/"):
+		id := strings.TrimPrefix(path, fakeBucketPath+"/p/")
+		f.mu.Lock()
+		for i, par := range f.parList {
+			if par.ID == id {
+				f.parList = append(f.parList[:i], f.parList[i+1:]...)
+				f.deletes++
+				break
+			}
+		}
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case path == fakeBucketPath+"/actions/restoreObjects":
+		var details struct {
+			ObjectName string `json:"objectName"`
+		}
+		json.NewDecoder(r.Body).Decode(&details)
+		f.mu.Lock()
+		f.restores++
+		if obj := f.objects[details.ObjectName]; obj != nil {
+			obj.archival = "RESTORING"
+		}
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasPrefix(path, fakeBucketPath+"/o/") && r.Method == "PUT":
+		content, _ := ioutil.ReadAll(r.Body)
+		f.put(strings.TrimPrefix(path, fakeBucketPath+"/o/"), content)
+		w.WriteHeader(http.StatusOK)
+	case strings.HasPrefix(path, fakeBucketPath+"/o/") && r.Method == "DELETE":
+		f.mu.Lock()
+		delete(f.objects, strings.TrimPrefix(path, fakeBucketPath+"/o/"))
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(path, fakeBucketPath+"/o/"):
+		f.mu.Lock()
+		if r.Method == "HEAD" {
+			f.heads++
+		} else {
+			f.gets++
+		}
+		f.sseKeys = append(f.sseKeys, r.Header.Get(headerSSEKey))
+		f.mu.Unlock()
+		f.serveObject(w, r, strings.TrimPrefix(path, fakeBucketPath+"/o/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeOCI) serveObject(w http.ResponseWriter, r *http.Request, name string) {
+	f.mu.Lock()
+	obj := f.objects[name]
+	var archival string
+	if obj != nil {
+		archival = obj.archival
+	}
+	f.mu.Unlock()
+	if obj == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"code":"ObjectNotFound","message":"no such object"}`))
+		return
+	}
+	w.Header().Set("ETag", obj.etag)
+	for key, value := range obj.metadata {
+		w.Header().Set("opc-meta-"+key, value)
+	}
+	if archival != "" {
+		w.Header().Set("archival-state", archival)
+		if r.Method != "HEAD" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"code":"NotRestored","message":"archived object"}`))
+			return
+		}
+	}
+	if obj.multipart {
+		w.Header().Set("opc-multipart-md5", "bXVsdGlwYXJ0IG1kNXM=-2")
+	} else {
+		sum := md5.Sum(obj.content)
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	// Object Storage refuses any range of an empty object.
+	if len(obj.content) == 0 && r.Header.Get("Range") != "" {
+		w.Header().Set("Content-Range", "bytes */0")
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	contentType := obj.contentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, name, time.Unix(1500000000, 0), bytes.NewReader(obj.content))
+}
+
+// cuttingWriter aborts the response once left bytes of the body were written.
+type cuttingWriter struct {
+	http.ResponseWriter
+	left int
+}
+
+func (c *cuttingWriter) Write(p []byte) (int, error) {
+	if len(p) >= c.left {
+		c.ResponseWriter.Write(p[:c.left])
+		c.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	c.left -= len(p)
+	return c.ResponseWriter.Write(p)
+}
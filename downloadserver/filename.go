@@ -0,0 +1,49 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// How download filenames longer than MaxFilenameLength are handled.
+const (
+	FilenameTruncate = "truncate"
+	FilenameReject   = "reject"
+)
+
+// downloadFilename applies the filename length limit to the filename presented
+// to the client. Overlong names are rejected with 400 Bad Request or shortened,
+// keeping the extension (including a .tar before a compression extension) so
+// the file still opens with the right application.
+func (ds *DownloadServer) downloadFilename(filename string) (string, error) {
+	max := ds.MaxFilenameLength
+	if max <= 0 || len(filename) <= max {
+		return filename, nil
+	}
+	if ds.OverlongFilenames == FilenameReject {
+		return "", &statusError{http.StatusBadRequest, "artifact filename is too long"}
+	}
+	ext := path.Ext(filename)
+	if strings.HasSuffix(strings.TrimSuffix(filename, ext), ".tar") {
+		ext = ".tar" + ext
+	}
+	if len(ext) >= max {
+		return truncateUTF8(filename, max), nil
+	}
+	return truncateUTF8(strings.TrimSuffix(filename, ext), max-len(ext)) + ext, nil
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestDownloadFilename(t *testing.T) {
+	ds := &DownloadServer{MaxFilenameLength: 12}
+	for _, tc := range []struct {
+		filename, want string
+	}{
+		{"short.tgz", "short.tgz"},
+		{"exactly12.gz", "exactly12.gz"},
+		{"averylongname.zip", "averylon.zip"},
+		{"averylongname.tar.gz", "avery.tar.gz"},
+		{"noextensionatall", "noextensiona"},
+		{"a.verylongextension", "a.verylongex"},
+		{"ééééééé.txt", "éééé.txt"},
+	} {
+		got, err := ds.downloadFilename(tc.filename)
+		if err != nil || got != tc.want {
+			t.Errorf("downloadFilename(%q) = %q %v, want %q", tc.filename, got, err, tc.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("downloadFilename(%q) = %q split a character", tc.filename, got)
+		}
+	}
+
+	ds.OverlongFilenames = FilenameReject
+	if _, err := ds.downloadFilename("averylongname.zip"); err == nil {
+		t.Error("overlong filename accepted")
+	} else if se, ok := err.(*statusError); !ok || se.code != 400 {
+		t.Errorf("overlong filename: %v, want 400", err)
+	}
+	if got, err := ds.downloadFilename("short.tgz"); err != nil || got != "short.tgz" {
+		t.Errorf("downloadFilename(short.tgz) = %q %v", got, err)
+	}
+
+	ds.MaxFilenameLength = 0
+	if got, _ := ds.downloadFilename("averylongname.zip"); got != "averylongname.zip" {
+		t.Errorf("unlimited downloadFilename = %q", got)
+	}
+}
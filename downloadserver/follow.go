@@ -0,0 +1,79 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+/*
+ * Following growing files. A local download with follow=1 streams the content
+ * that is already there and then keeps streaming what is appended to the file,
+ * like tail -f, until nothing has been appended for FollowIdleTimeout, the
+ * client goes away or MaxDownloadDuration is reached. The length isn't known so
+ * the response is chunked.
+ */
+
+// DefaultFollowIdleTimeout is how long a followed file may go without growing
+// before the download ends, when FollowIdleTimeout isn't set.
+const DefaultFollowIdleTimeout = 30 * time.Second
+
+// followPollInterval is how often a followed file is checked for new content.
+const followPollInterval = 500 * time.Millisecond
+
+// followReader reads a file as it grows. Whatever has been written to the client
+// is flushed before waiting for more so that it is delivered as it happens.
+type followReader struct {
+	ctx      context.Context
+	f        *os.File
+	flush    func()
+	idle     time.Duration
+	deadline time.Time // zero for no deadline
+	lastData time.Time
+}
+
+func (ds *DownloadServer) newFollowReader(w http.ResponseWriter, r *http.Request, f *os.File) *followReader {
+	fr := &followReader{
+		ctx:      r.Context(),
+		f:        f,
+		flush:    func() {},
+		idle:     ds.FollowIdleTimeout,
+		lastData: time.Now(),
+	}
+	if fr.idle <= 0 {
+		fr.idle = DefaultFollowIdleTimeout
+	}
+	if ds.MaxDownloadDuration > 0 {
+		fr.deadline = fr.lastData.Add(ds.MaxDownloadDuration)
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		fr.flush = flusher.Flush
+	}
+	return fr
+}
+
+func (fr *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := fr.f.Read(p)
+		if n > 0 || err != io.EOF {
+			if n > 0 {
+				fr.lastData = time.Now()
+			}
+			return n, err
+		}
+		now := time.Now()
+		if now.Sub(fr.lastData) >= fr.idle || (!fr.deadline.IsZero() && now.After(fr.deadline)) {
+			return 0, io.EOF
+		}
+		fr.flush()
+		select {
+		case <-time.After(followPollInterval):
+		case <-fr.ctx.Done():
+			return 0, fr.ctx.Err()
+		}
+	}
+}
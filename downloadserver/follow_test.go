@@ -0,0 +1,86 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFollowReader(t *testing.T) {
+	dir := testStore(t, map[string]string{"build.log": "one\n"})
+	defer os.RemoveAll(dir)
+	f, err := os.Open(filepath.Join(dir, "build.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The reader ends once the file hasn't grown for the idle timeout.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fr := &followReader{ctx: ctx, f: f, flush: func() {}, idle: 10 * time.Millisecond, lastData: time.Now()}
+	started := time.Now()
+	content, err := ioutil.ReadAll(fr)
+	if err != nil || string(content) != "one\n" {
+		t.Errorf("followed file = %q %v", content, err)
+	}
+	if elapsed := time.Since(started); elapsed > 2*followPollInterval {
+		t.Errorf("idle file followed for %s", elapsed)
+	}
+
+	// A client that went away ends it at once.
+	fr.lastData = time.Now()
+	fr.idle = time.Hour
+	cancel()
+	if _, err := fr.Read(make([]byte, 10)); err != context.Canceled {
+		t.Errorf("read after the client went away = %v", err)
+	}
+
+	// So does the MaxDownloadDuration deadline.
+	fr = &followReader{ctx: context.Background(), f: f, flush: func() {}, idle: time.Hour, lastData: time.Now(), deadline: time.Now()}
+	if n, err := fr.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("read past the deadline = %d %v", n, err)
+	}
+}
+
+func TestFollowDownload(t *testing.T) {
+	dir := testStore(t, map[string]string{"build.log": "one\n"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.FollowIdleTimeout = 100 * time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(download))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + downloadPath + "?a=build.log&follow=1&s=" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 || resp.ContentLength != -1 {
+		t.Fatalf("follow=1 = %d Content-Length %d, want a chunked 200", resp.StatusCode, resp.ContentLength)
+	}
+	// What is there is flushed to the client before the file grows.
+	lines := bufio.NewReader(resp.Body)
+	if line, err := lines.ReadString('\n'); line != "one\n" || err != nil {
+		t.Fatalf("first line = %q %v", line, err)
+	}
+	log, err := os.OpenFile(filepath.Join(dir, "build.log"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Write([]byte("two\n"))
+	log.Close()
+	rest, err := ioutil.ReadAll(lines)
+	if err != nil || string(rest) != "two\n" {
+		t.Errorf("appended content = %q %v", rest, err)
+	}
+}
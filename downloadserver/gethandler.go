@@ -3,16 +3,17 @@
 package downloadserver
 
 import (
-	"crypto/rand"
+	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
-	"net/url"
 	"os"
+	"path"
 	"strings"
-
-	"github.com/wercker/pkg/log"
+	"sync"
+	"time"
 )
 
 /*
@@ -34,9 +35,314 @@ type DownloadServer struct {
 	Namespace   string
 	BucketName  string
 	Debug       bool
+	// OCIEndpoint is the Object Storage host used for Region instead of the
+	// endpoint derived from the region name.
+	OCIEndpoint string
+	// MaxDownloadDuration caps the total time allowed to write a single download
+	// response, regardless of activity. Zero disables the cap.
+	MaxDownloadDuration time.Duration
+	// IdleTimeout closes keep-alive connections that have waited this long for
+	// their next request. Defaults to DefaultIdleTimeout.
+	IdleTimeout time.Duration
+	// ObjectPrefix is prepended to artifact names to form OCI object names,
+	// keeping the storage layout out of client visible names. It ends with "/"
+	// when it names a directory.
+	ObjectPrefix string
+	// ObjectNameEncoding selects how object names are encoded in PAR URLs,
+	// ObjectNamePath (the default), ObjectNameStrict or ObjectNameNone.
+	ObjectNameEncoding string
+	// CASLayout maps a content digest to a storage location for h=sha256
+	// requests. Defaults to DefaultCASLayout.
+	CASLayout string
+	// ArtifactTemplate, when set, lays out the artifact of requests without a=
+	// from the template variables given as query parameters, such as
+	// {tenant}/{pipeline}/{artifact}.
+	ArtifactTemplate string
+	// TCPKeepAlive is the keep-alive period for accepted connections, zero
+	// disables keep-alive.
+	TCPKeepAlive time.Duration
+	// TCPReadBuffer and TCPWriteBuffer are the socket buffer sizes, in bytes,
+	// of client connections and of the connections to OCI. Zero keeps the
+	// system default.
+	TCPReadBuffer  int
+	TCPWriteBuffer int
+	// TCPDelay enables Nagle's algorithm on client connections, which are
+	// otherwise set to TCP_NODELAY.
+	TCPDelay bool
+	// MaxConnections caps the number of simultaneously open connections, zero
+	// means unlimited.
+	MaxConnections int
+	// SlowReadRate is the minimum rate, in bytes per second averaged over
+	// SlowReadWindow, at which clients must read what they are sent; a client
+	// more than SlowReadGrace behind it is disconnected. Zero disables it.
+	SlowReadRate   int64
+	SlowReadWindow time.Duration
+	SlowReadGrace  time.Duration
+	// MaxDownloads caps the number of downloads in progress across all
+	// clients, zero means unlimited. Up to DownloadQueue downloads beyond the
+	// cap wait for up to DownloadQueueWait for a slot.
+	MaxDownloads      int
+	DownloadQueue     int
+	DownloadQueueWait time.Duration
+	// EventWebhook, when set, is the URL a DownloadEvent is posted to for every
+	// download. Up to EventBuffer events are buffered while the webhook is slow.
+	EventWebhook string
+	EventBuffer  int
+	// AuditLog, when set, is the file every refused download is recorded in
+	// as an AuditEntry, - for the standard output.
+	AuditLog string
+	// CaseInsensitive looks up local artifacts that don't exist under their
+	// exact name ignoring case, refusing names that match several files.
+	CaseInsensitive bool
+	// LocalSymlinks is how symlinks on the path of local artifacts are treated,
+	// SymlinksRefuse (the default when empty), SymlinksContain or SymlinksFollow.
+	LocalSymlinks string
+	// DirectoryDefaults are the names of the files, tried in order, served for
+	// a local download of a directory, such as latest.tar.gz.
+	DirectoryDefaults []string
+	// ResponseHeaders are extra "Name: value" headers added to every download
+	// response, besides the default security headers.
+	ResponseHeaders []string
+	// Maintenance starts the server in maintenance mode, refusing downloads
+	// with a MaintenanceRetry Retry-After. MaintenanceToken, when set, enables
+	// the /maintenance endpoint switching the mode at run time.
+	Maintenance      bool
+	MaintenanceRetry time.Duration
+	MaintenanceToken string
+	// RestoreArchived requests the restore of archived OCI objects that are
+	// downloaded, keeping them readable for RestoreHours.
+	RestoreArchived bool
+	RestoreHours    int
+	// DeadlineHeader is the request header in which clients give a deadline
+	// for their download, capped to MaxDownloadDuration. Empty ignores it.
+	DeadlineHeader string
+	// Authorizer decides whether each download may be served. Defaults to a
+	// TenancyAuthorizer for Tenancy and Namespace.
+	Authorizer Authorizer
+	// HashChunkSize is the size of the chunks hashed for chunks=1. Defaults to
+	// DefaultHashChunkSize.
+	HashChunkSize int64
+	// WriteChunkSize, when set, has downloads written and flushed to the
+	// client in chunks of this many bytes rather than as they are read.
+	WriteChunkSize int
+	// ThroughputHalfLife is the half-life of the download throughput samples
+	// behind the reported percentiles. Defaults to DefaultThroughputHalfLife.
+	ThroughputHalfLife time.Duration
+	// SlowDownloadThreshold, when set, logs successful downloads taking longer
+	// than this at Warn rather than Info.
+	SlowDownloadThreshold time.Duration
+	// ResumeSessionTTL is how long the session of a resumable download (resume=)
+	// is kept after its last use. Defaults to DefaultResumeSessionTTL.
+	ResumeSessionTTL time.Duration
+	// ResumeMargin is how many bytes before the end of those written a
+	// resumed download carries on from, to cover the bytes lost with the
+	// connection. Negative for DefaultResumeMargin.
+	ResumeMargin int64
+	// Offload answers plain downloads with an internal redirect for a fronting
+	// proxy to follow, OffloadAccelRedirect or OffloadSendfile, instead of
+	// streaming them. Empty streams every download.
+	Offload string
+	// OffloadLocalPrefix and OffloadOCIPrefix are the proxy's internal
+	// locations for local files and PARs with X-Accel-Redirect. Empty leaves
+	// that backend streamed.
+	OffloadLocalPrefix string
+	OffloadOCIPrefix   string
+	// MaxClientDownloads caps the number of downloads a single client IP
+	// address can have in progress, zero means unlimited.
+	MaxClientDownloads int
+	// MaxQueryLength caps the length in bytes of the raw query string of a
+	// download, which is refused with 414 URI Too Long beyond it. Zero means
+	// no limit.
+	MaxQueryLength int
+	// SocketPath, when set, makes the server listen on this Unix domain socket
+	// (optionally prefixed with "unix:") instead of a TCP port.
+	SocketPath string
+	// DetectChanges checks that a local artifact's size and modification time are
+	// unchanged after it has been streamed and flags the download when they differ.
+	DetectChanges bool
+	// DetectContentType sends local artifacts with the content type of their
+	// extension or, failing that, their sniffed content type.
+	DetectContentType bool
+	// ContentTypes pins the content type of artifacts by extension, as
+	// ext=type entries taking precedence over the detected type.
+	ContentTypes []string
+	// ServerTiming sends a Server-Timing header with the time spent on the
+	// backend before the download started. It exposes internal timings.
+	ServerTiming bool
+	// GzipPassthrough serves local .gz artifacts with Content-Encoding: gzip to
+	// clients that accept it, and decompressed to clients that don't.
+	GzipPassthrough bool
+	// OCITimeout is the total time allowed for creating the PAR and receiving the
+	// response headers of the GET from it. Zero means no limit.
+	OCITimeout time.Duration
+	// Regions lists the regions the bucket is replicated to, in order of
+	// preference. Downloads fail over to the next region on error. When empty
+	// only Region is used.
+	Regions []string
+	// FollowIdleTimeout ends a follow=1 download once the file hasn't grown for
+	// this long. Defaults to DefaultFollowIdleTimeout.
+	FollowIdleTimeout time.Duration
+	// DigestHeader sends the RFC 3230 Digest of the artifact content.
+	DigestHeader bool
+	// ReadinessInterval is how often the backend is checked for /readyz, and
+	// ReadinessFailures the number of consecutive failed checks making the
+	// server not ready. Default to DefaultReadinessInterval and
+	// DefaultReadinessFailures.
+	ReadinessInterval time.Duration
+	ReadinessFailures int
+	// PARSweepInterval is how often expired download PARs are swept from the
+	// bucket in the background. Zero disables the sweeper.
+	PARSweepInterval time.Duration
+	// PARMaxAge, when set, has the sweeper also delete download PARs older than
+	// this, whether or not they have expired.
+	PARMaxAge time.Duration
+	// RedirectPARTTL is the lifetime of the PARs handed to clients with
+	// mode=redirect or mode=url. Defaults to DefaultRedirectPARTTL.
+	RedirectPARTTL time.Duration
+	// RedirectPARGrace, when set, has the sweeper delete the PARs handed out in
+	// redirect mode once they are older than this, ahead of their expiry.
+	RedirectPARGrace time.Duration
+	// FilenameMetadataKey is the OCI object metadata key whose value, when
+	// present, is used as the download filename instead of the object name.
+	FilenameMetadataKey string
+	// DenyPatterns are glob patterns, or regular expressions prefixed with re:,
+	// of artifact paths that are never served.
+	DenyPatterns []string
+	// IdentityPrefixes limits the artifacts each caller may download, as
+	// identity=prefix entries; an identity may be given several prefixes. The
+	// identity * applies to callers that aren't listed. Empty allows all.
+	IdentityPrefixes []string
+	// IdentityHeader names the request header identifying callers that don't
+	// present a client certificate. Only set it behind a proxy that sets the
+	// header itself.
+	IdentityHeader string
+	// TrustedProxies are the CIDRs, or single addresses, of the proxies whose
+	// X-Forwarded-For and X-Real-IP headers give the client address. Empty
+	// trusts no proxy.
+	TrustedProxies []string
+	// EncryptionKey is the AES-256 key encryption key that enables encrypt=1
+	// downloads. The per download data keys are wrapped with it.
+	EncryptionKey []byte
+	// TokenKey is the secret signing download tokens. When set, artifacts can
+	// be downloaded from downloadPath/<token> with a token from
+	// SignDownloadToken.
+	TokenKey []byte
+	// Compress gzip compresses responses for clients that accept it, except
+	// for artifacts smaller than CompressMinSize or that are already compressed.
+	Compress bool
+	// CompressMinSize is the size below which responses aren't compressed.
+	// Defaults to DefaultCompressMinSize.
+	CompressMinSize int64
+	// MaxFilenameLength limits the length in bytes of download filenames, zero
+	// for no limit.
+	MaxFilenameLength int
+	// OverlongFilenames selects whether longer filenames are shortened,
+	// FilenameTruncate (the default), or rejected, FilenameReject.
+	OverlongFilenames string
+	// ContentDisposition selects how download filenames are encoded,
+	// DispositionBoth (the default) or DispositionLegacy.
+	ContentDisposition string
+	// EmptyArtifacts is the response to the download of a zero-byte artifact,
+	// EmptyOK (the default) or EmptyNoContent.
+	EmptyArtifacts string
+	// SelftestToken enables the /selftest endpoint for requests presenting it as
+	// a bearer token.
+	SelftestToken string
+	// DebugToken, when set, has downloads carrying it in an X-Debug header
+	// logged in detail without debugging the whole server.
+	DebugToken string
+	// DirectFetchThreshold is the object size below which OCI objects are read
+	// directly with the OCI client rather than through a PAR. Zero always uses
+	// a PAR. When set every OCI download starts with a HEAD of the object, an
+	// extra round trip for those that go on to use a PAR.
+	DirectFetchThreshold int64
+	// DirectRanges reads ranged downloads of OCI objects directly with the
+	// OCI client whatever their size.
+	DirectRanges bool
+	// SSECustomerKeys are the AES-256 keys, by bucket name, that objects of
+	// the bucket are encrypted with on the OCI side (SSE-C).
+	SSECustomerKeys map[string][]byte
+	// OCIParallelParts is the number of byte ranges of an OCI object fetched
+	// concurrently. Zero or one fetches the object as a single stream.
+	OCIParallelParts int
+	// OCIPartSize is the size of the ranges fetched when OCIParallelParts is set.
+	// Defaults to DefaultOCIPartSize.
+	OCIPartSize int64
+	// ArchiveWorkers is the number of archive members fetched concurrently for
+	// archive=tar downloads. Defaults to DefaultArchiveWorkers.
+	ArchiveWorkers int
+	// TenancyQuotas limits the bytes served per tenancy (the t= value) within each
+	// QuotaWindow. The tenancy "*" sets the limit for tenancies not listed.
+	// Tenancies without a limit are unlimited.
+	TenancyQuotas map[string]int64
+	// QuotaWindow is the window over which TenancyQuotas are counted. Defaults to
+	// DefaultQuotaWindow.
+	QuotaWindow time.Duration
+	// PARCache reuses a PAR created for an object for later downloads of the
+	// same object while it remains valid.
+	PARCache bool
+	// PARRateLimit caps the number of PARs created per second, protecting the OCI
+	// API limits. Zero means unlimited.
+	PARRateLimit float64
+	// PARRateBurst is the number of PARs that may be created at once before
+	// PARRateLimit applies.
+	PARRateBurst int
+	// PARRateWait is how long a download waits for the PAR rate limit before
+	// failing with 503. Zero fails immediately.
+	PARRateWait time.Duration
+	// Logger receives all of the server's logging. Defaults to NewWerckerLogger.
+	Logger Logger
+	// ShutdownTimeout bounds the time Close spends running the hooks registered
+	// with OnShutdown. Defaults to DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+	// LameDuckPeriod, when set, has Close report the server not ready on
+	// /readyz for this long, while it keeps serving, before it stops.
+	LameDuckPeriod time.Duration
+	// DrainTimeout bounds the time Close waits for the downloads in flight to
+	// finish once the listener is closed; those still running then are cut.
+	// Defaults to DefaultDrainTimeout.
+	DrainTimeout time.Duration
 	// Following are values for HTTPS operation
 	CertPemFile string
 	KeyPemFile  string
+	// ClientCAFile is a PEM bundle of the CAs that sign client certificates.
+	// When set, HTTPS clients must authenticate with a certificate.
+	ClientCAFile string
+
+	mu            sync.Mutex
+	server        *http.Server
+	stopSweep     chan struct{}
+	stopReady     chan struct{}
+	closed        chan struct{}
+	readiness     *readinessGate
+	creds         credentialState
+	template      *artifactTemplate
+	contentTypeOf map[string]string
+	shutdownHooks []namedHook
+	quotas        *tenancyQuotas
+	regions       *regionHealth
+	digests       *digestCache
+	gunzipSizes   *decompressedSizeCache
+	contentTypes  *contentTypeCache
+	parFlight     flightGroup
+	deny          *denyList
+	identities    *identityPrefixes
+	proxies       trustedProxies
+	audit         *auditLog
+	sseKey        *sseCustomerKey
+	clients       *clientLimiter
+	downloads     *downloadLimiter
+	events        *eventEmitter
+	extraHeaders  http.Header
+	ttfb          *histogram
+	durations     *histogram
+	throughput    *throughputEstimator
+	resumes       *resumeSessions
+	maintenance   int32
+	lameDuck      int32
+	upstream      *http.Client
+	parCache      *parCache
+	parLimiter    *rateLimiter
 }
 
 var downloadServer *DownloadServer
@@ -59,7 +365,8 @@ func (ds *DownloadServer) getOCICredentials() {
 		keyfile := os.Getenv("WERCKER_OCI_PRIVATE_KEY_PATH")
 		filekey, err := ioutil.ReadFile(keyfile)
 		if err != nil {
-			log.Fatal(err)
+			ds.logger().Error("Unable to read OCI private key", Fields{"path": keyfile, "error": err.Error()})
+			os.Exit(1)
 		}
 		ds.Privatekey = string(filekey)
 	}
@@ -69,168 +376,601 @@ func (ds *DownloadServer) getOCICredentials() {
 	ds.BucketName = os.Getenv("WERCKER_OCI_BUCKETNAME")
 }
 
-// OCIdownloadSErver setsup the http protocol for the GETs
+// DefaultIdleTimeout is how long an idle keep-alive connection is kept open
+// when IdleTimeout isn't set.
+const DefaultIdleTimeout = 120 * time.Second
+
+// OCIdownloadSErver setsup the http protocol for the GETs. The port number is ignored
+// when a SocketPath is configured.
 func (ds *DownloadServer) OCIdownloadServer(portNumber int) error {
+	if err := ds.validateRegions(); err != nil {
+		return err
+	}
+	deny, err := newDenyList(ds.DenyPatterns)
+	if err != nil {
+		return err
+	}
+	ds.deny = deny
+	if ds.extraHeaders, err = newResponseHeaders(ds.ResponseHeaders); err != nil {
+		return err
+	}
+	if ds.template, err = newArtifactTemplate(ds.ArtifactTemplate); err != nil {
+		return err
+	}
+	if ds.contentTypeOf, err = newContentTypes(ds.ContentTypes); err != nil {
+		return err
+	}
+	if ds.identities, err = newIdentityPrefixes(ds.IdentityPrefixes); err != nil {
+		return err
+	}
+	if ds.proxies, err = newTrustedProxies(ds.TrustedProxies); err != nil {
+		return err
+	}
+	if ds.sseKey, err = newSSECustomerKey(ds.SSECustomerKeys, ds.BucketName); err != nil {
+		return err
+	}
+	if ds.sseKey != nil && (ds.CertPemFile == "" || ds.KeyPemFile == "") {
+		ds.logger().Warn("Objects encrypted with a customer key are served decrypted over plain HTTP", Fields{"bucket": ds.BucketName})
+	}
+	ds.quotas = newTenancyQuotas(ds.TenancyQuotas, ds.QuotaWindow)
+	if ds.DigestHeader {
+		ds.digests = newDigestCache()
+	}
+	if ds.DetectContentType {
+		ds.contentTypes = newContentTypeCache()
+	}
+	if ds.GzipPassthrough {
+		ds.gunzipSizes = newDecompressedSizeCache()
+	}
+	if len(ds.Regions) > 0 {
+		ds.regions = newRegionHealth(ds.Regions)
+	}
+	if ds.PARCache {
+		ds.parCache = newPARCache()
+	}
+	if ds.TCPReadBuffer > 0 || ds.TCPWriteBuffer > 0 {
+		ds.upstream = ds.newUpstreamClient()
+	}
+	if ds.MaxDownloads > 0 {
+		ds.downloads = newDownloadLimiter(ds.MaxDownloads, ds.DownloadQueue, ds.DownloadQueueWait)
+	}
+	if ds.MaxClientDownloads > 0 {
+		ds.clients = newClientLimiter(ds.MaxClientDownloads)
+	}
+	ds.SetMaintenance(ds.Maintenance)
+	ds.ttfb = newHistogram(latencyBuckets)
+	ds.durations = newHistogram(latencyBuckets)
+	ds.throughput = newThroughputEstimator(ds.ThroughputHalfLife)
+	ds.resumes = newResumeSessions(ds.ResumeSessionTTL, ds.ResumeMargin)
+	if ds.EventWebhook != "" {
+		ds.events = newEventEmitter(ds, ds.EventWebhook, ds.EventBuffer)
+		ds.OnShutdown("download events", ds.events.close)
+	}
+	if ds.AuditLog != "" {
+		if ds.audit, err = newAuditLog(ds.AuditLog); err != nil {
+			return err
+		}
+		ds.OnShutdown("audit log", ds.audit.close)
+	}
+	if ds.PARRateLimit > 0 {
+		ds.parLimiter = newRateLimiter(ds.PARRateLimit, ds.PARRateBurst)
+	}
+	if ds.BucketName != "" {
+		ds.readiness = newReadinessGate(ds.ReadinessFailures)
+	}
 	http.HandleFunc("/", download)
+	http.HandleFunc("/stats", stats)
+	http.HandleFunc("/metrics", metrics)
+	http.HandleFunc("/healthz", healthz)
+	http.HandleFunc("/readyz", readyz)
+	if ds.MaintenanceToken != "" {
+		http.HandleFunc("/maintenance", maintenance)
+	}
+	if ds.SelftestToken != "" {
+		http.HandleFunc("/selftest", selftest)
+	}
 	port := fmt.Sprintf(":%d", portNumber)
+	server := ds.httpServer(port)
+	if ds.ClientCAFile != "" {
+		if server.TLSConfig, err = ds.clientTLSConfig(); err != nil {
+			return err
+		}
+	}
+	listener, err := ds.listen(port)
+	if err != nil {
+		return err
+	}
+	ds.mu.Lock()
+	ds.server = server
+	ds.closed = make(chan struct{})
+	closed := ds.closed
+	if ds.PARSweepInterval > 0 {
+		ds.stopSweep = make(chan struct{})
+		go ds.sweepPARs(ds.stopSweep)
+	}
+	if ds.readiness != nil {
+		ds.stopReady = make(chan struct{})
+		go ds.watchReadiness(ds.stopReady)
+	}
+	ds.mu.Unlock()
+	ds.logConfig(port)
 
 	if ds.CertPemFile != "" && ds.KeyPemFile != "" {
-		log.Info("Artifact download server is using HTTPS protocol.")
+		ds.logger().Info("Artifact download server is using HTTPS protocol.", nil)
 		// When both certificate and key are present start the service accepting HTTPS
-		if err := http.ListenAndServeTLS(port, ds.CertPemFile, ds.KeyPemFile, nil); err != nil {
-			return err
-		}
+		err = server.ServeTLS(listener, ds.CertPemFile, ds.KeyPemFile)
 	} else {
-		log.Info("Artifact Download server is using HTTP protocol")
-		if err := http.ListenAndServe(port, nil); err != nil {
-			return err
-		}
+		ds.logger().Info("Artifact Download server is using HTTP protocol", nil)
+		err = server.Serve(listener)
 	}
-	return nil
+	if err == http.ErrServerClosed {
+		// Serve returns as soon as Close starts; the downloads in flight and
+		// the shutdown hooks are waited for.
+		<-closed
+		return nil
+	}
+	return err
+}
+
+// httpServer returns the HTTP server for the service on addr. The write timeout
+// bounds the whole response so a slow reader can't hold a connection (and its
+// goroutine) open forever. The idle timeout only runs between the requests of a
+// keep-alive connection.
+func (ds *DownloadServer) httpServer(addr string) *http.Server {
+	idleTimeout := ds.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
+	return &http.Server{
+		Addr:         addr,
+		WriteTimeout: ds.MaxDownloadDuration,
+		IdleTimeout:  idleTimeout,
+	}
+}
+
+// Close stops the server and closes its listener, which also removes the socket
+// file when listening on a Unix domain socket. The PAR sweeper and the backend
+// checks are stopped too, the downloads in flight are given DrainTimeout to
+// finish, then the shutdown hooks are run. With LameDuckPeriod set a serving
+// server first spends that long failing /readyz.
+func (ds *DownloadServer) Close() error {
+	ds.lameDuckWait()
+	ds.mu.Lock()
+	if ds.stopSweep != nil {
+		close(ds.stopSweep)
+		ds.stopSweep = nil
+	}
+	if ds.stopReady != nil {
+		close(ds.stopReady)
+		ds.stopReady = nil
+	}
+	server, closed := ds.server, ds.closed
+	ds.closed = nil
+	ds.mu.Unlock()
+	var err error
+	if server != nil {
+		err = ds.drain(server)
+	}
+	ds.runShutdownHooks()
+	if closed != nil {
+		close(closed)
+	}
+	return err
 }
 
+// allowedMethods are the methods supported by the download endpoint.
+const allowedMethods = "GET, HEAD, OPTIONS"
+
 // Download handler. Called by the http layer when a request is picked up. Verify the request
 // and do the appropirate processing.
 func download(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/api/v3/operator/artifact/download" {
-		http.Error(w, "Download URL is incorrect, 404 not found", http.StatusNotFound)
+	downloadServer.addResponseHeaders(w)
+	// The query is bounded before anything parses it.
+	if err := downloadServer.checkQueryLength(r); err != nil {
+		downloadError(w, r, err)
 		return
 	}
+	if r.URL.Path != downloadPath {
+		// Signed download tokens are given in the path.
+		token := strings.TrimPrefix(r.URL.Path, downloadPath+"/")
+		if len(downloadServer.TokenKey) == 0 || token == r.URL.Path || token == "" {
+			httpError(w, r, "Download URL is incorrect, 404 not found", http.StatusNotFound)
+			return
+		}
+		if err := downloadServer.applyDownloadToken(r, token); err != nil {
+			if se, ok := err.(*statusError); ok && se.code == http.StatusForbidden {
+				downloadServer.auditDenial(r, nil, "", AuditInvalidToken, se.msg, se.code)
+			}
+			downloadError(w, r, err)
+			return
+		}
+	}
 
 	// GET is provided specifically for unmanaged runners to fetch the artifact directly
-	// from the local file system and stream it back to the browser
-	if r.Method != "GET" {
-		http.Error(w, "protocol error", http.StatusMethodNotAllowed)
+	// from the local file system and stream it back to the browser. HEAD is served
+	// by the same code up to the headers of the artifact; it sends no body, isn't
+	// counted against the quota and isn't recorded as a download.
+	switch r.Method {
+	case "GET", "HEAD":
+	case "OPTIONS":
+		w.Header().Set("Allow", allowedMethods)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", allowedMethods)
+		httpError(w, r, "protocol error", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if downloadServer.debugRequest(r) {
+		ctx, id := withRequestDebug(r.Context())
+		r = r.WithContext(ctx)
+		w.Header().Set(headerDebugID, id)
+	}
+
+	if downloadServer.InMaintenance() {
+		downloadServer.maintenanceError(w, r)
 		return
 	}
 
-	// Break out query parameters
-	qstring := r.URL.RawQuery
-	parms, err := url.ParseQuery(qstring)
+	req, err := downloadServer.parseDownloadRequest(r)
 	if err != nil {
-		errstr := fmt.Sprintf("%s", err)
-		http.Error(w, errstr, http.StatusBadRequest)
+		downloadServer.debug(r.Context(), "Download request rejected", Fields{"error": err.Error()})
+		downloadError(w, r, err)
 		return
 	}
+	downloadServer.debug(r.Context(), "Download request parsed", debugRequestFields(req))
+	opts := req.transferOptions()
+	opts.head = r.Method == "HEAD"
+	if req.NoStore {
+		r = r.WithContext(withNoStore(r.Context()))
+	}
+	if deadline, ok, err := downloadServer.requestDeadline(r); err != nil {
+		downloadError(w, r, err)
+		return
+	} else if ok {
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+		r = r.WithContext(ctx)
+		opts.deadline = ctx
+	}
+	// Debugged downloads collect the timings for the log.
+	if downloadServer.ServerTiming || requestDebugID(r.Context()) != "" {
+		opts.timing = newServerTiming()
+		r = r.WithContext(withServerTiming(r.Context(), opts.timing))
+	}
+	recorder := &statusWriter{ResponseWriter: w}
+	w = recorder
+	started := time.Now()
+	backend := "oci"
+	if req.Local() {
+		backend = "local"
+	}
+	defer func() {
+		// An aborted download is reported too before the abort carries on.
+		p := recover()
+		if opts.timing != nil {
+			downloadServer.debug(r.Context(), "Download timings", opts.timing.fields())
+		}
+		if !opts.head {
+			downloadServer.downloadDone(r, req, recorder, backend, started, p != nil)
+		}
+		if p != nil {
+			panic(p)
+		}
+	}()
 
-	artifact := parms["a"]
-	storepath := parms["s"]
+	// Artifacts matching a deny pattern are never served, from either backend.
+	for _, name := range req.Artifacts {
+		if downloadServer.deny.denied(name) {
+			downloadServer.logger().Warn("Blocked download of denied artifact", Fields{"artifact": name, "client": downloadServer.clientAddr(r), "clientName": req.ClientName})
+			downloadServer.auditDenial(r, req, name, AuditDenyPattern, "artifact matches a deny pattern", http.StatusForbidden)
+			httpError(w, r, "artifact is not available for download", http.StatusForbidden)
+			return
+		}
+	}
 
-	if len(artifact) < 1 {
-		http.Error(w, "missing artifact a=", http.StatusBadRequest)
+	// A single client can only have so many downloads in progress.
+	if downloadServer.clients != nil {
+		client := clientIP(downloadServer.clientAddr(r))
+		ok, wait := downloadServer.clients.acquire(client)
+		if !ok {
+			downloadServer.logger().Warn("Too many concurrent downloads from client", Fields{"client": client, "limit": downloadServer.MaxClientDownloads})
+			w.Header().Set("Retry-After", retryAfter(wait))
+			httpError(w, r, "too many concurrent downloads", http.StatusTooManyRequests)
+			return
+		}
+		acquired := time.Now()
+		defer func() { downloadServer.clients.release(client, time.Since(acquired)) }()
 	}
-	if len(storepath) > 0 {
-		// Storepath is present so handle local file system download
-		err := downloadServer.streamTheArtifact(w, r, artifact[0], storepath[0])
-		if err != nil {
-			msg := fmt.Sprintf("%s", err)
-			http.Error(w, msg, 500)
+
+	// Downloads beyond the server wide cap wait in the queue for a slot.
+	if downloadServer.downloads != nil {
+		if err := downloadServer.downloads.acquire(r.Context()); err != nil {
+			// A client that went away while queued gets no response.
+			if limited, ok := err.(*rateLimitedError); ok {
+				downloadServer.logger().Warn("Too many downloads in progress", Fields{"client": downloadServer.clientAddr(r), "limit": downloadServer.MaxDownloads, "queue": downloadServer.DownloadQueue})
+				w.Header().Set("Retry-After", retryAfter(limited.retryAfter))
+				httpError(w, r, "too many downloads in progress, try again later", http.StatusServiceUnavailable)
+			}
+			return
 		}
-		r.Body.Close()
-		return
+		started := time.Now()
+		defer func() { downloadServer.downloads.release(time.Since(started)) }()
 	}
 
-	// Assume oci artifact when tenancy is provided
-	tenancy := parms["t"]
-	if len(tenancy) < 1 {
-		http.Error(w, "missing OCI specifier", http.StatusPartialContent)
-		return
+	// Callers are confined to the artifact prefixes of their identity.
+	if downloadServer.identities != nil {
+		identity := downloadServer.callerIdentity(r, req)
+		for _, name := range req.Artifacts {
+			if !downloadServer.identities.allowed(identity, name) {
+				downloadServer.logger().Warn("Blocked download outside of the caller's prefixes", Fields{"artifact": name, "client": downloadServer.clientAddr(r), "identity": identity})
+				downloadServer.auditDenial(r, req, name, AuditPrefix, "artifact is outside of the caller's prefixes", http.StatusForbidden)
+				httpError(w, r, "artifact is not available to this caller", http.StatusForbidden)
+				return
+			}
+		}
 	}
 
-	if tenancy[0] != downloadServer.Tenancy {
-		http.Error(w, "wrong tenancy", http.StatusForbidden)
+	if !downloadServer.authorize(w, r, req) {
 		return
 	}
 
-	// Strip off environment specific prefixes. OCI objects are store without these.
-	artstr := artifact[0]
-	prefixStaging := "wercker-development/"
-	if strings.HasPrefix(artstr, prefixStaging) {
-		artifact[0] = artstr[len(prefixStaging):]
+	// The tenancy is subject to its byte quota, which counts the bytes served to
+	// it from either backend as they are sent.
+	if req.Tenancy != "" {
+		if ok, reset := downloadServer.quotas.allow(req.Tenancy); !ok {
+			w.Header().Set("Retry-After", retryAfter(reset))
+			httpError(w, r, "tenancy download quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+		if !opts.head {
+			w = &quotaWriter{ResponseWriter: w, quotas: downloadServer.quotas, tenancy: req.Tenancy}
+		}
 	}
-	prefixProduction := "wercker-production/"
-	if strings.HasPrefix(artstr, prefixProduction) {
-		artifact[0] = artstr[len(prefixProduction):]
+
+	if req.Local() {
+		downloadServer.debug(r.Context(), "Serving the download from the local storepath", Fields{"storepath": req.StorePath})
+		// Storepath is present so handle local file system download
+		if req.Exists {
+			err = downloadServer.localExists(w, req.Artifacts[0], req.StorePath)
+		} else if req.Info {
+			err = downloadServer.localInfo(w, req.Artifacts[0], req.StorePath, req.NoStore)
+		} else if req.Archive != "" {
+			err = downloadServer.streamArchive(w, r, req.Artifacts, downloadServer.localMember(req.StorePath))
+		} else if req.Manifest {
+			err = downloadServer.localManifest(w, req.Artifacts[0], req.StorePath)
+		} else if req.Chunks {
+			err = downloadServer.localChunks(w, r, req.Artifacts[0], req.StorePath)
+		} else {
+			err = downloadServer.streamTheArtifact(w, r, req.Artifacts[0], req.StorePath, opts)
+		}
+		if err != nil {
+			downloadServer.auditError(r, req, err)
+			downloadError(w, r, err)
+		}
+		r.Body.Close()
+		return
 	}
 
-	// Get the PAR for this download.
-	parname := "download-parname"
-	// Create the derived value.
-	byt := make([]byte, 16)
-	_, err = rand.Read(byt)
-	if err == nil {
-		parname = fmt.Sprintf("download-%X-%X-%X-%X-%X", byt[0:4], byt[4:6], byt[6:8], byt[8:10], byt[10:])
+	// Otherwise the request has a tenancy and the artifact is an OCI object.
+	downloadServer.debug(r.Context(), "Serving the download from OCI", Fields{"bucket": downloadServer.BucketName, "regions": downloadServer.regionOrder(), "fallback": req.Fallback})
+
+	if req.Exists {
+		err = downloadServer.ociExists(w, r, req.Artifacts[0])
+	} else if req.Info {
+		err = downloadServer.ociInfo(w, r, req.Artifacts[0])
+	} else if req.Mode == modeURLs {
+		err = downloadServer.sendMemberLinks(w, r, req, func(name string) bool {
+			return !downloadServer.deny.denied(name) && downloadServer.identities.allowed(downloadServer.callerIdentity(r, req), name) && downloadServer.authorizeMember(r.Context(), req, name)
+		})
+	} else if req.Mode != "" {
+		err = downloadServer.redirectOCIArtifact(w, r, req.Artifacts[0], req.Mode)
+	} else if req.Archive != "" {
+		err = downloadServer.streamArchive(w, r, req.Artifacts, downloadServer.ociMember)
+	} else if req.Manifest {
+		err = downloadServer.ociManifest(w, r, req.Artifacts[0])
+	} else if req.Chunks {
+		err = downloadServer.ociChunks(w, r, req.Artifacts[0])
+	} else if req.Parts != 0 {
+		err = downloadServer.streamOCIParts(w, r, req.Artifacts[0], req.Parts, opts)
+	} else {
+		err = downloadServer.streamOCIArtifact(w, r, req.Artifacts[0], opts)
 	}
-	artifactUrl, err := downloadServer.CreateOCIPAR(parname, artifact[0])
-	if err != nil {
-		msg := fmt.Sprintf("%s", err)
-		http.Error(w, msg, 500)
+	if err != nil && err != errResponseCommitted && req.Fallback && req.Archive == "" {
+		downloadServer.logger().Warn("OCI download failed, falling back to local storepath", Fields{"artifact": req.Artifacts[0], "error": err.Error()})
+		// The local copy is described afresh, without what the OCI attempt set.
+		clearDownloadHeaders(w)
+		backend = "local"
+		if req.Exists {
+			err = downloadServer.localExists(w, req.Artifacts[0], req.StorePath)
+		} else if req.Info {
+			err = downloadServer.localInfo(w, req.Artifacts[0], req.StorePath, req.NoStore)
+		} else if req.Manifest {
+			err = downloadServer.localManifest(w, req.Artifacts[0], req.StorePath)
+		} else if req.Chunks {
+			err = downloadServer.localChunks(w, r, req.Artifacts[0], req.StorePath)
+		} else {
+			err = downloadServer.streamTheArtifact(w, r, req.Artifacts[0], req.StorePath, opts)
+		}
+		if err != nil {
+			downloadServer.auditError(r, req, err)
+			downloadError(w, r, err)
+		}
+		r.Body.Close()
 		return
 	}
+	if err == errNoObject && req.Exists {
+		sendExists(w, false)
+	} else if err != nil {
+		downloadError(w, r, err)
+	}
+	r.Body.Close()
+}
 
-	// Issue the GET using the preauthenticated URL and stream the result back
-	stream, err := http.Get(artifactUrl)
-	if err != nil {
-		errstr := fmt.Sprintf("%s", err)
-		http.Error(w, errstr, 500)
-		return
+// Stream the artifact from OCI Object Storage. A short lived PAR is created for the
+// object and the GET response from the PAR is streamed back to the client.
+func (ds *DownloadServer) streamOCIArtifact(w http.ResponseWriter, r *http.Request, artifact string, opts transferOptions) error {
+	artifact = ds.ociObjectName(artifact)
+	if offloaded, err := ds.offloadOCI(r.Context(), w, artifact, opts); offloaded {
+		return err
 	}
-	index := strings.LastIndex(artifact[0], "/")
-	filename := artifact[0][index+1:]
-	header := fmt.Sprintf("attachment; filename=%s", filename)
-	w.Header().Set("Content-Disposition", header)
-	w.Header().Set("Content-Type", "binary/octet-stream")
-	w.Header().Set("Accept-Ranges", "bytes")
-	w.Header().Set("Content-Length", stream.Header.Get("Content-Length"))
-	nbytes, err := io.Copy(w, stream.Body)
+	stream, err := ds.fetchOCIObject(r.Context(), artifact, opts.byteRange)
 	if err != nil {
-		if downloadServer.Debug {
-			// See broken pipe messages
-			errstr := fmt.Sprintf("%s", err)
-			log.Error(errstr)
+		return err
+	}
+	defer stream.Body.Close()
+	a := &artifactStream{
+		name:         artifact,
+		filename:     artifact[strings.LastIndex(artifact, "/")+1:],
+		body:         stream.Body,
+		size:         stream.ContentLength,
+		lastModified: stream.Header.Get("Last-Modified"),
+		contentRange: stream.Header.Get("Content-Range"),
+		storedType:   stream.Header.Get("Content-Type"),
+		ranges:       backendRanges(stream),
+	}
+	// Producers can name the download independently of the object key with a
+	// metadata value, which OCI returns as an opc-meta- header.
+	if ds.FilenameMetadataKey != "" {
+		if name := path.Base(stream.Header.Get("opc-meta-" + ds.FilenameMetadataKey)); name != "." && name != "/" {
+			a.filename = name
 		}
-		return
 	}
-	if downloadServer.Debug {
-		msg := fmt.Sprintf("OCI download complete (%d bytes) - %s", nbytes, artifact[0])
-		log.Debugln(msg)
+	if md5 := objectMD5(stream.Header); md5 != "" && a.contentRange == "" {
+		a.contentMD5 = md5
+		a.digest = "md5=" + md5
 	}
-	r.Body.Close()
+	if opts.digest != "" {
+		a.digest = casDigest(opts.digest)
+	}
+	if opts.entry != "" || opts.entries != nil {
+		a.digest = ""
+		a.contentMD5 = ""
+		a.ranges = false
+		if opts.entry != "" {
+			err = selectEntry(a, opts.entry)
+		} else {
+			err = selectEntries(a, opts.entries)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if a.filename, err = ds.downloadFilename(a.filename); err != nil {
+		return err
+	}
+	nbytes, err := ds.sendArtifact(w, a, opts)
+	if err != nil {
+		return err
+	}
+	ds.debug(r.Context(), "OCI download complete", Fields{"artifact": artifact, "bytes": nbytes})
+	return nil
 }
 
 // Stream the artifact from the local file system back to the web-api where it is
 // downloaded to the user's machine. This provides support to unmanaged runners with
 // the optional download service (this component) ties to the runner.
-func (ds *DownloadServer) streamTheArtifact(w http.ResponseWriter, r *http.Request, artifact string, storepath string) error {
-	artifactPath := fmt.Sprintf("%s/%s", storepath, artifact)
-	if ds.Debug {
-		log.Debugln(fmt.Sprintf("Downloading local file from %s", artifactPath))
+func (ds *DownloadServer) streamTheArtifact(w http.ResponseWriter, r *http.Request, artifact string, storepath string, opts transferOptions) error {
+	artifactPath, err := ds.localPath(storepath, artifact)
+	if err != nil {
+		return err
+	}
+	if artifact, artifactPath, err = ds.directoryDefault(storepath, artifact, artifactPath); err != nil {
+		return err
 	}
+	ds.debug(r.Context(), "Downloading local file", Fields{"path": artifactPath})
+	started := time.Now()
 	f, err := os.Open(artifactPath)
+	opts.timing.since("open", "local open", started)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	index := strings.LastIndex(artifact, "/")
-	filename := artifact[index+1:]
-	header := fmt.Sprintf("attachment; filename=%s", filename)
-	w.Header().Set("Content-Disposition", header)
-	w.Header().Set("Content-Type", "binary/octet-stream")
-	w.Header().Set("Accept-Ranges", "bytes")
+	started = time.Now()
 	stat, err := f.Stat()
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", stat.Size()))
-	nbytes, err := io.Copy(w, f)
+	opts.timing.since("stat", "local stat", started)
 	if err != nil {
-		if ds.Debug {
-			// See broken pipe signals
-			errstr := fmt.Sprintf("%s", err)
-			log.Error(errstr)
+		return err
+	}
+	if offloaded, err := ds.offloadLocal(w, artifactPath, artifact[strings.LastIndex(artifact, "/")+1:], stat, opts); offloaded {
+		return err
+	}
+	stream := &artifactStream{
+		name:         artifactPath,
+		filename:     artifact[strings.LastIndex(artifact, "/")+1:],
+		body:         f,
+		size:         stat.Size(),
+		lastModified: stat.ModTime().UTC().Format(http.TimeFormat),
+	}
+	// Content addressed artifacts are served as stored so the digest still holds.
+	if opts.follow {
+		stream.body = ds.newFollowReader(w, r, f)
+		stream.size = -1
+	} else if opts.entry != "" {
+		if err := selectEntry(stream, opts.entry); err != nil {
+			return err
 		}
-		return nil
+	} else if opts.entries != nil {
+		if err := selectEntries(stream, opts.entries); err != nil {
+			return err
+		}
+	} else if ds.GzipPassthrough && opts.digest == "" && !opts.encrypt && strings.HasSuffix(stream.filename, ".gz") {
+		w.Header().Set("Vary", "Accept-Encoding")
+		if err := gzipPassthrough(opts.acceptGzip, f, stream); err != nil {
+			return err
+		}
+	}
+	if ds.DetectContentType && stream.body == io.Reader(f) {
+		if stream.contentType, err = ds.localContentType(artifactPath, f, stat, opts.noStore); err != nil {
+			return err
+		}
+	}
+	_, gunzipped := stream.body.(*gzip.Reader)
+	stream.ranges = stream.body == io.Reader(f) || (gunzipped && ds.gunzipSizes != nil)
+	// A resumed download carries on as a range of the artifact.
+	if opts.resume != "" {
+		if !stream.ranges {
+			return badRequest("resume= isn't supported for this download")
+		}
+		if err := ds.resumeDownload(w, storepath, artifact, stat, &opts); err != nil {
+			return err
+		}
+	}
+	if opts.byteRange != nil {
+		if stream.body == io.Reader(f) {
+			if err := selectRange(stream, f, opts.byteRange); err != nil {
+				return err
+			}
+		} else if gunzipped && ds.gunzipSizes != nil {
+			if err := ds.selectDecompressedRange(stream, f, stat, opts.byteRange, opts.noStore); err != nil {
+				return err
+			}
+		} else if opts.byteRange.query {
+			return badRequest("offset= and length= aren't supported for this download")
+		}
+	}
+	// The digest is only known up front when the file is sent as stored.
+	if ds.DigestHeader && stream.body == io.Reader(f) && stream.contentRange == "" {
+		if opts.digest != "" {
+			stream.digest = casDigest(opts.digest)
+		} else if stream.digest, err = ds.digests.fileDigest(artifactPath, f, stat, opts.noStore); err != nil {
+			return err
+		}
+	}
+	if ds.DetectChanges && !opts.follow {
+		stream.changed = func() bool {
+			after, err := f.Stat()
+			return err != nil || after.Size() != stat.Size() || !after.ModTime().Equal(stat.ModTime())
+		}
+	}
+	if stream.filename, err = ds.downloadFilename(stream.filename); err != nil {
+		return err
 	}
-	if ds.Debug {
-		msg := fmt.Sprintf("Local File download complete (%d bytes) - %s", nbytes, artifact)
-		log.Debugln(msg)
+	nbytes, err := ds.sendArtifact(w, stream, opts)
+	if err != nil {
+		return err
 	}
+	ds.debug(r.Context(), "Local file download complete", Fields{"artifact": artifact, "bytes": nbytes})
 	return nil
 }
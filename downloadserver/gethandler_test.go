@@ -0,0 +1,229 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testStore creates a storepath holding files, which the caller removes.
+func testStore(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// testDownload serves a download with the query and request headers given as
+// name, value pairs.
+func testDownload(method string, query string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, downloadPath+"?"+query, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	download(rec, r)
+	return rec
+}
+
+// localServer makes a DownloadServer for local downloads the package's server.
+func localServer() *DownloadServer {
+	ds := &DownloadServer{}
+	ds.quotas = newTenancyQuotas(nil, 0)
+	downloadServer = ds
+	return ds
+}
+
+func TestHeadDownload(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	ds := f.server()
+	ds.durations = newHistogram(latencyBuckets)
+
+	rec := httptest.NewRecorder()
+	download(rec, httptest.NewRequest("HEAD", downloadPath+"?t=ten&a=a/f.txt", nil))
+	if rec.Code != 200 || rec.Body.Len() != 0 {
+		t.Errorf("HEAD = %d %q, want 200 without a body", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Length"); got != "5" {
+		t.Errorf("HEAD Content-Length = %q, want 5", got)
+	}
+	if used := ds.quotas.usage()["ten"]; used != 0 {
+		t.Errorf("HEAD counted %d bytes against the quota", used)
+	}
+	if len(ds.durations.series) != 0 {
+		t.Error("HEAD recorded as a download")
+	}
+
+	rec = httptest.NewRecorder()
+	download(rec, httptest.NewRequest("GET", downloadPath+"?t=ten&a=a/f.txt", nil))
+	if rec.Code != 200 || rec.Body.String() != "hello" {
+		t.Fatalf("GET = %d %q", rec.Code, rec.Body.String())
+	}
+	if used := ds.quotas.usage()["ten"]; used != 5 {
+		t.Errorf("GET counted %d bytes against the quota, want 5", used)
+	}
+	if len(ds.durations.series) != 1 {
+		t.Error("GET not recorded as a download")
+	}
+}
+
+func TestLastModified(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.txt": "hello"})
+	defer os.RemoveAll(dir)
+	modTime := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "f.txt"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	localServer()
+	rec := testDownload("GET", "a=f.txt&s="+dir)
+	if got := rec.Header().Get("Last-Modified"); got != modTime.Format(http.TimeFormat) {
+		t.Errorf("local Last-Modified = %q, want %q", got, modTime.Format(http.TimeFormat))
+	}
+
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	f.server()
+	rec = testDownload("GET", "t=ten&a=a/f.txt")
+	if got, want := rec.Header().Get("Last-Modified"), time.Unix(1500000000, 0).UTC().Format(http.TimeFormat); got != want {
+		t.Errorf("OCI Last-Modified = %q, want %q", got, want)
+	}
+}
+
+func TestMaxDownloadDuration(t *testing.T) {
+	const size = 32 << 20
+	dir := testStore(t, map[string]string{"big.bin": string(make([]byte, size))})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.MaxDownloadDuration = 100 * time.Millisecond
+	server := ds.httpServer("127.0.0.1:0")
+	server.Handler = http.HandlerFunc(download)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	defer server.Close()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + downloadPath + "?a=big.bin&s=" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// A client reading too slowly is cut off once the cap is reached.
+	time.Sleep(300 * time.Millisecond)
+	n, err := io.Copy(ioutil.Discard, resp.Body)
+	if err == nil || n >= size {
+		t.Errorf("download past MaxDownloadDuration read %d bytes, err %v", n, err)
+	}
+}
+
+func TestLocalFallback(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("from oci"))
+	dir := testStore(t, map[string]string{"a/f.txt": "local"})
+	defer os.RemoveAll(dir)
+	ds := f.server()
+	ds.durations = newHistogram(latencyBuckets)
+	logger := &testLogger{}
+	ds.Logger = logger
+
+	if rec := testDownload("GET", "t=ten&a=a/f.txt&fallback=local&s="+dir); rec.Code != 200 || rec.Body.String() != "from oci" {
+		t.Errorf("download with a working OCI = %d %q, want the OCI copy", rec.Code, rec.Body.String())
+	}
+
+	// OCI answering 500 falls back to the local copy.
+	f.mu.Lock()
+	f.parStatus = 500
+	f.mu.Unlock()
+	rec := testDownload("GET", "t=ten&a=a/f.txt&fallback=local&s="+dir)
+	if rec.Code != 200 || rec.Body.String() != "local" {
+		t.Errorf("download with a failing OCI = %d %q, want the local copy", rec.Code, rec.Body.String())
+	}
+	if len(logger.find("OCI download failed, falling back to local storepath")) != 1 {
+		t.Error("fallback not logged")
+	}
+	if rec := testDownload("GET", "t=ten&a=a/f.txt"); rec.Code != 500 {
+		t.Errorf("download with a failing OCI without a storepath = %d, want 500", rec.Code)
+	}
+
+	// The headers of an OCI response that failed before its body don't
+	// describe the local copy.
+	f.mu.Lock()
+	f.parStatus, f.cutAfter = 0, -1
+	f.mu.Unlock()
+	rec = testDownload("GET", "t=ten&a=a/f.txt&fallback=local&s="+dir)
+	if rec.Code != 200 || rec.Body.String() != "local" {
+		t.Fatalf("download with a cut OCI response = %d %q, want the local copy", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-MD5"); got != "" {
+		t.Errorf("Content-MD5 of the OCI object kept: %q", got)
+	}
+	if got := rec.Header().Get("Content-Length"); got != "5" {
+		t.Errorf("Content-Length = %q, want the local copy's 5", got)
+	}
+
+	// Downloads served by the fallback count as local.
+	ds.durations.mu.Lock()
+	local, oci := ds.durations.series["local"], ds.durations.series["oci"]
+	ds.durations.mu.Unlock()
+	if local == nil || local.counts[len(latencyBuckets)] != 2 || oci == nil || oci.counts[len(latencyBuckets)] != 1 {
+		t.Errorf("latency series local %+v oci %+v, want 2 local and 1 oci", local, oci)
+	}
+}
+
+func TestFilenameMetadata(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	for name, filename := range map[string]string{"a/named": "report.pdf", "a/path": "../../etc/passwd", "a/root": "/", "a/plain": ""} {
+		f.put(name, []byte("hello"))
+		if filename != "" {
+			f.objects[name].metadata = map[string]string{"filename": filename}
+		}
+	}
+	ds := f.server()
+
+	disposition := func(artifact string) string {
+		rec := testDownload("GET", "t=ten&a="+artifact)
+		if rec.Code != 200 {
+			t.Errorf("%s = %d", artifact, rec.Code)
+		}
+		return rec.Header().Get("Content-Disposition")
+	}
+	if got := disposition("a/named"); got != "attachment; filename=named" {
+		t.Errorf("Content-Disposition without FilenameMetadataKey = %q", got)
+	}
+	ds.FilenameMetadataKey = "filename"
+	for artifact, want := range map[string]string{
+		"a/named": "attachment; filename=report.pdf",
+		// Only the last element of a metadata path is taken.
+		"a/path":  "attachment; filename=passwd",
+		"a/root":  "attachment; filename=root",
+		"a/plain": "attachment; filename=plain",
+	} {
+		if got := disposition(artifact); got != want {
+			t.Errorf("%s: Content-Disposition = %q, want %q", artifact, got, want)
+		}
+	}
+}
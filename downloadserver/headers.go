@@ -0,0 +1,65 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// defaultHeaders are the security headers sent with every download response.
+// ResponseHeaders can give them a different value.
+var defaultHeaders = map[string]string{
+	"X-Content-Type-Options": "nosniff",
+}
+
+// reservedHeaders describe the download itself and can't be set with
+// ResponseHeaders.
+var reservedHeaders = map[string]bool{
+	"Accept-Ranges": true, "Connection": true, "Content-Disposition": true, "Content-Encoding": true,
+	"Content-Length": true, "Content-Md5": true, "Content-Range": true, "Content-Type": true,
+	"Digest": true, "Last-Modified": true, "Retry-After": true, "Server-Timing": true,
+	"Trailer": true, "Transfer-Encoding": true, "Vary": true, "X-Accel-Redirect": true, "X-Sendfile": true,
+	trailerBytes: true, trailerSHA256: true, trailerDuration: true, trailerChanged: true, headerEmpty: true,
+	headerResumeToken: true,
+}
+
+// newResponseHeaders compiles the "Name: value" ResponseHeaders entries,
+// together with the default headers, into the headers added to every download
+// response.
+func newResponseHeaders(entries []string) (http.Header, error) {
+	h := make(http.Header)
+	for name, value := range defaultHeaders {
+		h.Set(name, value)
+	}
+	custom := make(map[string]bool)
+	for _, entry := range entries {
+		kv := strings.SplitN(entry, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid response header: %s", entry)
+		}
+		name := http.CanonicalHeaderKey(strings.TrimSpace(kv[0]))
+		value := strings.TrimSpace(kv[1])
+		if name == "" || strings.ContainsAny(name, " \t\r\n") || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid response header: %s", entry)
+		}
+		if reservedHeaders[name] {
+			return nil, fmt.Errorf("response header %s can't be overridden", name)
+		}
+		// Entries for the same name add values, replacing a default.
+		if !custom[name] {
+			h.Del(name)
+			custom[name] = true
+		}
+		h.Add(name, value)
+	}
+	return h, nil
+}
+
+// addResponseHeaders adds the configured response headers to w.
+func (ds *DownloadServer) addResponseHeaders(w http.ResponseWriter) {
+	for name, values := range ds.extraHeaders {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestNewResponseHeaders(t *testing.T) {
+	h, err := newResponseHeaders([]string{
+		"cache-control: private, max-age=60",
+		"X-Content-Type-Options: nosniff",
+		"Link: <https://a>; rel=a",
+		"Link: <https://b>; rel=b",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := h.Get("Cache-Control"); got != "private, max-age=60" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+	if got := fmt.Sprint(h["Link"]); got != "[<https://a>; rel=a <https://b>; rel=b]" {
+		t.Errorf("Link = %s, want both values", got)
+	}
+	if got := h["X-Content-Type-Options"]; len(got) != 1 {
+		t.Errorf("X-Content-Type-Options = %q, want the default replaced", got)
+	}
+
+	if h, _ := newResponseHeaders(nil); h.Get("X-Content-Type-Options") != "nosniff" {
+		t.Error("default nosniff header missing")
+	}
+	for _, entry := range []string{"no colon", ": empty name", "Bad Name: x", "X-Split: a\r\nInjected: b", "Content-Type: text/html", "content-length: 0"} {
+		if _, err := newResponseHeaders([]string{entry}); err == nil {
+			t.Errorf("response header %q accepted", entry)
+		}
+	}
+}
+
+func TestResponseHeaders(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.txt": "hello"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	var err error
+	if ds.extraHeaders, err = newResponseHeaders([]string{"Cache-Control: no-cache"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, query := range []string{"a=f.txt&s=" + dir, "a=missing&s=" + dir} {
+		rec := testDownload("GET", query)
+		if rec.Header().Get("Cache-Control") != "no-cache" || rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: headers = %v", query, rec.Header())
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// identityPrefixes holds the compiled IdentityPrefixes: the artifact prefixes
+// each caller identity may download from. The identity * gives the prefixes of
+// identities that aren't listed, including callers without an identity. A
+// prefix is a path of whole segments, kept without its trailing slash: foo
+// allows foo and foo/..., but not foobar.
+type identityPrefixes struct {
+	prefixes map[string][]string
+}
+
+func newIdentityPrefixes(entries []string) (*identityPrefixes, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	p := &identityPrefixes{prefixes: make(map[string][]string)}
+	for _, entry := range entries {
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid identity prefix: %s", entry)
+		}
+		prefix := strings.TrimSuffix(strings.TrimPrefix(kv[1], "/"), "/")
+		if path.Clean("/"+prefix) != "/"+prefix {
+			return nil, fmt.Errorf("invalid identity prefix: %s", entry)
+		}
+		p.prefixes[kv[0]] = append(p.prefixes[kv[0]], prefix)
+	}
+	return p, nil
+}
+
+// allowed reports whether identity may download artifact. The artifact path is
+// normalized first so that .. segments can't reach outside an allowed prefix.
+func (p *identityPrefixes) allowed(identity string, artifact string) bool {
+	if p == nil {
+		return true
+	}
+	prefixes, ok := p.prefixes[identity]
+	if !ok || identity == "" {
+		prefixes = p.prefixes["*"]
+	}
+	name := strings.TrimPrefix(path.Clean("/"+artifact), "/")
+	for _, prefix := range prefixes {
+		if prefix == "" || name == prefix || strings.HasPrefix(name, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// callerIdentity returns the identity of the caller: the common name of its
+// client certificate or, failing that, the IdentityHeader it sent.
+func (ds *DownloadServer) callerIdentity(r *http.Request, req *DownloadRequest) string {
+	if req.ClientName != "" {
+		return req.ClientName
+	}
+	if ds.IdentityHeader != "" {
+		return r.Header.Get(ds.IdentityHeader)
+	}
+	return ""
+}
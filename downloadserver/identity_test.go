@@ -0,0 +1,68 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"os"
+	"testing"
+)
+
+func TestIdentityPrefixes(t *testing.T) {
+	p, err := newIdentityPrefixes([]string{"ci=builds/ci", "ci=/shared/", "*=public/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		identity, artifact string
+		want               bool
+	}{
+		{"ci", "builds/ci/app.tar", true},
+		{"ci", "builds/ci", true},
+		{"ci", "shared/lib.tar", true},
+		// Prefixes match whole path segments.
+		{"ci", "builds/cinema/app.tar", false},
+		{"ci", "sharedsecret/key", false},
+		{"ci", "builds/ci/../other/app.tar", false},
+		{"ci", "public/readme", false},
+		{"other", "public/readme", true},
+		{"other", "publicity/readme", false},
+		{"", "public/readme", true},
+		{"", "builds/ci/app.tar", false},
+	} {
+		if got := p.allowed(c.identity, c.artifact); got != c.want {
+			t.Errorf("allowed(%q, %q) = %v, want %v", c.identity, c.artifact, got, c.want)
+		}
+	}
+
+	all, err := newIdentityPrefixes([]string{"admin=/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !all.allowed("admin", "any/thing") {
+		t.Error("the root prefix doesn't allow everything")
+	}
+
+	for _, entry := range []string{"nobody", "=builds/", "ci=", "ci=builds/../other", "ci=builds//ci"} {
+		if _, err := newIdentityPrefixes([]string{entry}); err == nil {
+			t.Errorf("%q accepted", entry)
+		}
+	}
+}
+
+func TestIdentityPrefixesDownload(t *testing.T) {
+	dir := testStore(t, map[string]string{"foo/a.txt": "a", "foobar/b.txt": "b"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.IdentityHeader = "X-Identity"
+	var err error
+	if ds.identities, err = newIdentityPrefixes([]string{"ci=foo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := testDownload("GET", "a=foo/a.txt&s="+dir, "X-Identity", "ci"); rec.Code != 200 {
+		t.Errorf("allowed artifact = %d", rec.Code)
+	}
+	if rec := testDownload("GET", "a=foobar/b.txt&s="+dir, "X-Identity", "ci"); rec.Code != 403 {
+		t.Errorf("artifact sharing the prefix's leading characters = %d, want 403", rec.Code)
+	}
+}
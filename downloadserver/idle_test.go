@@ -0,0 +1,55 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	ds := &DownloadServer{MaxDownloadDuration: time.Hour}
+	if server := ds.httpServer(":0"); server.IdleTimeout != DefaultIdleTimeout || server.WriteTimeout != time.Hour {
+		t.Errorf("server timeouts idle %s write %s", server.IdleTimeout, server.WriteTimeout)
+	}
+
+	ds.IdleTimeout = 50 * time.Millisecond
+	server := ds.httpServer("127.0.0.1:0")
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	defer server.Close()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.WriteString(c, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// With no next request the server closes the connection.
+	start := time.Now()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read from the idle connection = %v, want EOF", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("idle connection closed after %s", elapsed)
+	}
+}
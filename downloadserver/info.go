@@ -0,0 +1,144 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+/*
+ * Artifact info. info=1 describes an artifact as JSON instead of serving it, so
+ * that a UI can show what it would download: the filename, size and content
+ * type the download would be served with, the modification time, the digest
+ * and, for OCI objects, the ETag and storage tier. Like exists=1 it takes a
+ * stat or a HEAD request and no PAR. Local files are hashed for the SHA-256,
+ * cached while unchanged, and OCI objects give the MD5 OCI stores for them,
+ * which multipart uploads don't have. A missing artifact is a 404 Not Found.
+ */
+
+// artifactInfo is the JSON document answering info=1.
+type artifactInfo struct {
+	Artifact     string    `json:"artifact"`
+	Backend      string    `json:"backend"` // local or oci
+	Filename     string    `json:"filename"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"contentType"`
+	LastModified time.Time `json:"lastModified"`
+	// Digest is in the form of a Digest header, sha-256= or md5=.
+	Digest      string `json:"digest,omitempty"`
+	ETag        string `json:"etag,omitempty"`
+	StorageTier string `json:"storageTier,omitempty"`
+}
+
+// sendInfo writes info as the answer to info=1.
+func sendInfo(w http.ResponseWriter, info *artifactInfo) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	return json.NewEncoder(w).Encode(info)
+}
+
+// localInfo answers info=1 for a local artifact. fresh hashes the file even when
+// its digest is cached.
+func (ds *DownloadServer) localInfo(w http.ResponseWriter, artifact string, storepath string, fresh bool) error {
+	artifactPath, err := ds.localPath(storepath, artifact)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(artifactPath)
+	if os.IsNotExist(err) {
+		return errNoObject
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !stat.Mode().IsRegular() {
+		return errNoObject
+	}
+	info := &artifactInfo{
+		Artifact:     artifact,
+		Backend:      "local",
+		Size:         stat.Size(),
+		LastModified: stat.ModTime().UTC(),
+	}
+	if info.Filename, err = ds.downloadFilename(path.Base(artifactPath)); err != nil {
+		return err
+	}
+	info.ContentType = ds.pinnedContentType(info.Filename)
+	if info.ContentType == "" && ds.DetectContentType {
+		if info.ContentType, err = ds.localContentType(artifactPath, f, stat, fresh); err != nil {
+			return err
+		}
+	}
+	if info.ContentType == "" {
+		info.ContentType = "binary/octet-stream"
+	}
+	digests := ds.digests
+	if digests == nil {
+		digests = newDigestCache()
+	}
+	if info.Digest, err = digests.fileDigest(artifactPath, f, stat, fresh); err != nil {
+		return err
+	}
+	return sendInfo(w, info)
+}
+
+// ociInfo answers info=1 for an OCI artifact within OCITimeout, returning
+// errNoObject when it doesn't exist.
+func (ds *DownloadServer) ociInfo(w http.ResponseWriter, r *http.Request, artifact string) error {
+	ctx := r.Context()
+	if ds.OCITimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ds.OCITimeout)
+		defer cancel()
+	}
+	object := ds.ociObjectName(artifact)
+	head, exists, err := ds.headObject(ctx, object)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded && r.Context().Err() == nil {
+			return errOCITimeout
+		}
+		return err
+	}
+	if !exists {
+		return errNoObject
+	}
+	header := head.header
+	if header == nil {
+		header = http.Header{}
+	}
+	info := &artifactInfo{
+		Artifact:     artifact,
+		Backend:      "oci",
+		Size:         head.size,
+		LastModified: head.lastModified.UTC(),
+		ETag:         header.Get("ETag"),
+		StorageTier:  header.Get("storage-tier"),
+	}
+	filename := object[strings.LastIndex(object, "/")+1:]
+	if ds.FilenameMetadataKey != "" {
+		if name := path.Base(header.Get("opc-meta-" + ds.FilenameMetadataKey)); name != "." && name != "/" {
+			filename = name
+		}
+	}
+	if info.Filename, err = ds.downloadFilename(filename); err != nil {
+		return err
+	}
+	if info.ContentType = ds.pinnedContentType(info.Filename); info.ContentType == "" {
+		info.ContentType = "binary/octet-stream"
+	}
+	if md5 := objectMD5(header); md5 != "" {
+		info.Digest = "md5=" + md5
+	}
+	return sendInfo(w, info)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// testInfo decodes the answer to an info=1 request.
+func testInfo(t *testing.T, rec *httptest.ResponseRecorder) artifactInfo {
+	var info artifactInfo
+	if rec.Code != 200 {
+		t.Fatalf("info=1 = %d %s", rec.Code, rec.Body.String())
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestLocalInfo(t *testing.T) {
+	dir := testStore(t, map[string]string{"a/f.txt": "hello", "sub/g.txt": "sub"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.contentTypeOf, _ = newContentTypes([]string{"txt=text/plain"})
+
+	info := testInfo(t, testDownload("GET", "info=1&a=a/f.txt&s="+dir))
+	sum := sha256.Sum256([]byte("hello"))
+	want := artifactInfo{
+		Artifact:    "a/f.txt",
+		Backend:     "local",
+		Filename:    "f.txt",
+		Size:        5,
+		ContentType: "text/plain",
+		Digest:      "sha-256=" + base64.StdEncoding.EncodeToString(sum[:]),
+	}
+	if info.LastModified.IsZero() || time.Since(info.LastModified) > time.Hour {
+		t.Errorf("lastModified = %s", info.LastModified)
+	}
+	info.LastModified = time.Time{}
+	if info != want {
+		t.Errorf("info = %+v, want %+v", info, want)
+	}
+
+	for _, artifact := range []string{"missing.txt", "sub"} {
+		if rec := testDownload("GET", "info=1&a="+artifact+"&s="+dir); rec.Code != 404 {
+			t.Errorf("info=1 for %s = %d, want 404", artifact, rec.Code)
+		}
+	}
+	if rec := testDownload("GET", "info=1&exists=1&a=a/f.txt&s="+dir); rec.Code != 400 {
+		t.Errorf("info=1 with exists=1 = %d, want 400", rec.Code)
+	}
+}
+
+func TestOCIInfo(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.bin", []byte("hello"))
+	f.server()
+
+	info := testInfo(t, testDownload("GET", "info=1&t=ten&a=a/f.bin"))
+	sum := md5.Sum([]byte("hello"))
+	want := artifactInfo{
+		Artifact:     "a/f.bin",
+		Backend:      "oci",
+		Filename:     "f.bin",
+		Size:         5,
+		ContentType:  "binary/octet-stream",
+		LastModified: time.Unix(1500000000, 0).UTC(),
+		Digest:       "md5=" + base64.StdEncoding.EncodeToString(sum[:]),
+		ETag:         `"v1"`,
+	}
+	if info != want {
+		t.Errorf("info = %+v, want %+v", info, want)
+	}
+	if n := f.count(&f.pars); n != 0 {
+		t.Errorf("%d PARs created for info=1", n)
+	}
+	if rec := testDownload("GET", "info=1&t=ten&a=a/missing.bin"); rec.Code != 404 {
+		t.Errorf("info=1 for a missing object = %d, want 404", rec.Code)
+	}
+}
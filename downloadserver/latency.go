@@ -0,0 +1,172 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Download latency. Every download is logged once its response is done with its
+ * time to first byte, taken just before the first successful write to the
+ * response, next to its total duration. The first covers the backend (PAR
+ * creation and the OCI connection, or opening the local file) and the second
+ * the transfer too, which tells slow backends from slow transfers. Both are
+ * kept per backend as histograms for /metrics. Successful downloads taking
+ * longer than SlowDownloadThreshold are logged at Warn instead of Info, with
+ * the artifact size too, so that the slow tail stands out in the logs.
+ */
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histograms.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// histogram counts observations into buckets, per label value.
+type histogram struct {
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+// histogramSeries holds the cumulative bucket counts of one label value, with the
+// +Inf bucket last.
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, series: make(map[string]*histogramSeries)}
+}
+
+// observe records a value for label. A nil histogram records nothing.
+func (h *histogram) observe(label string, value float64) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[label]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[label] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.counts[len(h.buckets)]++
+	s.sum += value
+}
+
+// write writes the histogram in the Prometheus text format, with the label
+// values under labelName.
+func (h *histogram) write(w io.Writer, name string, help string, labelName string) {
+	if h == nil {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	labels := make([]string, 0, len(h.series))
+	for label := range h.series {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		s := h.series[label]
+		l := labelEscaper.Replace(label)
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s=\"%s\",le=\"%g\"} %d\n", name, labelName, l, bound, s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s=\"%s\",le=\"+Inf\"} %d\n", name, labelName, l, s.counts[len(h.buckets)])
+		fmt.Fprintf(w, "%s_sum{%s=\"%s\"} %g\n", name, labelName, l, s.sum)
+		fmt.Fprintf(w, "%s_count{%s=\"%s\"} %d\n", name, labelName, l, s.counts[len(h.buckets)])
+	}
+}
+
+// downloadDone logs a download whose response was written through w by
+// backend, records its latency and emits its event.
+func (ds *DownloadServer) downloadDone(r *http.Request, req *DownloadRequest, w *statusWriter, backend string, started time.Time, aborted bool) {
+	duration := time.Since(started)
+	status := w.status
+	if status == 0 && !aborted {
+		status = http.StatusOK
+	}
+	fields := Fields{
+		"artifact":   req.Artifacts[0],
+		"backend":    backend,
+		"client":     ds.clientAddr(r),
+		"status":     status,
+		"bytes":      w.written,
+		"durationMs": milliseconds(duration),
+	}
+	if !w.firstByte.IsZero() {
+		fields["ttfbMs"] = milliseconds(w.firstByte.Sub(started))
+	}
+	if aborted {
+		fields["aborted"] = true
+	}
+	if duration > 0 {
+		fields["bytesPerSec"] = int64(float64(w.written) / duration.Seconds())
+	}
+	if ds.SlowDownloadThreshold > 0 && duration > ds.SlowDownloadThreshold && status >= 200 && status < 300 {
+		fields["thresholdMs"] = milliseconds(ds.SlowDownloadThreshold)
+		if size := artifactSize(w.Header()); size >= 0 {
+			fields["size"] = size
+		}
+		ds.logger().Warn("Slow download served", fields)
+	} else {
+		ds.logger().Info("Download served", fields)
+	}
+
+	// Refusals answer without touching a backend and would hide its latency.
+	if status >= 200 && status < 300 {
+		if !w.firstByte.IsZero() {
+			ds.ttfb.observe(backend, w.firstByte.Sub(started).Seconds())
+		}
+		ds.durations.observe(backend, duration.Seconds())
+		ds.throughput.observe(w.written, duration)
+	}
+
+	if ds.events != nil {
+		event := downloadEvent(ds.clientAddr(r), req, w, started)
+		event.Status = status
+		event.Aborted = aborted
+		ds.events.emit(event)
+	}
+}
+
+// artifactSize returns the size of the artifact sent with header, the total of
+// a Content-Range or else the Content-Length, and -1 when neither is known.
+func artifactSize(header http.Header) int64 {
+	if cr := header.Get("Content-Range"); cr != "" {
+		if i := strings.LastIndex(cr, "/"); i >= 0 {
+			if size, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+				return size
+			}
+		}
+	}
+	if size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil {
+		return size
+	}
+	return -1
+}
+
+// milliseconds returns d in milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// writeLatencyMetrics writes the latency histograms in the Prometheus text format.
+func (ds *DownloadServer) writeLatencyMetrics(w io.Writer) {
+	ds.ttfb.write(w, "runner_download_ttfb_seconds", "Time from the start of a download to its first byte.", "backend")
+	ds.durations.write(w, "runner_download_duration_seconds", "Total time taken by a download.", "backend")
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArtifactSize(t *testing.T) {
+	for _, tc := range []struct {
+		contentRange, contentLength string
+		want                        int64
+	}{
+		{"bytes 0-1/10", "2", 10},
+		{"", "5", 5},
+		{"bytes 0-1/*", "2", 2},
+		{"", "", -1},
+	} {
+		header := http.Header{}
+		if tc.contentRange != "" {
+			header.Set("Content-Range", tc.contentRange)
+		}
+		if tc.contentLength != "" {
+			header.Set("Content-Length", tc.contentLength)
+		}
+		if got := artifactSize(header); got != tc.want {
+			t.Errorf("artifactSize(%q, %q) = %d, want %d", tc.contentRange, tc.contentLength, got, tc.want)
+		}
+	}
+}
+
+func TestSlowDownloadLog(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.txt": "hello"})
+	defer os.RemoveAll(dir)
+	logger := &testLogger{}
+	ds := localServer()
+	ds.Logger = logger
+
+	ds.SlowDownloadThreshold = time.Hour
+	testDownload("GET", "a=f.txt&s="+dir)
+	if len(logger.find("Download served")) != 1 || len(logger.find("Slow download served")) != 0 {
+		t.Error("download within the threshold not logged as served")
+	}
+
+	ds.SlowDownloadThreshold = time.Nanosecond
+	testDownload("GET", "a=f.txt&s="+dir)
+	slow := logger.find("Slow download served")
+	if len(slow) != 1 || slow[0].level != "warn" {
+		t.Fatalf("slow download logged as %+v", slow)
+	}
+	if slow[0].fields["size"] != int64(5) || slow[0].fields["thresholdMs"] == nil {
+		t.Errorf("slow download fields = %v", slow[0].fields)
+	}
+
+	// Failed downloads aren't slow ones.
+	testDownload("GET", "a=missing.txt&s="+dir)
+	if len(logger.find("Slow download served")) != 1 {
+		t.Error("failed download logged as slow")
+	}
+}
+
+func TestStatusWriterFirstByte(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &statusWriter{ResponseWriter: rec}
+	w.Header().Set("X-Test", "1")
+	if !w.firstByte.IsZero() {
+		t.Error("first byte taken before anything was written")
+	}
+	before := time.Now()
+	w.Write([]byte("abc"))
+	first := w.firstByte
+	if first.Before(before) || w.status != 200 || w.written != 3 {
+		t.Errorf("after the first write: firstByte %v, status %d, written %d", first, w.status, w.written)
+	}
+	w.Write([]byte("de"))
+	if w.firstByte != first || w.written != 5 {
+		t.Error("later write moved the first byte")
+	}
+
+	// A response without a body has its first byte with its header.
+	w = &statusWriter{ResponseWriter: httptest.NewRecorder()}
+	w.WriteHeader(204)
+	if w.firstByte.IsZero() || w.status != 204 {
+		t.Errorf("header only: firstByte %v, status %d", w.firstByte, w.status)
+	}
+}
+
+func TestTimeToFirstByte(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	logger := &testLogger{}
+	ds := f.server()
+	ds.Logger = logger
+	ds.ttfb = newHistogram(latencyBuckets)
+	ds.durations = newHistogram(latencyBuckets)
+	f.parDelay = 30 * time.Millisecond
+
+	if rec := testDownload("GET", "t=ten&a=a/f.txt"); rec.Code != 200 {
+		t.Fatalf("download = %d", rec.Code)
+	}
+	served := logger.find("Download served")
+	if len(served) != 1 {
+		t.Fatalf("%d downloads logged", len(served))
+	}
+	// The backend's latency is before the first byte, and both are in the
+	// duration.
+	ttfb, _ := served[0].fields["ttfbMs"].(float64)
+	duration, _ := served[0].fields["durationMs"].(float64)
+	if ttfb < 30 || duration < ttfb {
+		t.Errorf("ttfbMs %v, durationMs %v, want ttfbMs >= 30 and <= durationMs", ttfb, duration)
+	}
+
+	// Refusals aren't observed.
+	testDownload("GET", "t=other&a=a/f.txt")
+	var metrics bytes.Buffer
+	ds.writeLatencyMetrics(&metrics)
+	for _, line := range []string{
+		`runner_download_ttfb_seconds_bucket{backend="oci",le="0.025"} 0`,
+		`runner_download_ttfb_seconds_bucket{backend="oci",le="+Inf"} 1`,
+		`runner_download_ttfb_seconds_count{backend="oci"} 1`,
+		`runner_download_duration_seconds_count{backend="oci"} 1`,
+	} {
+		if !strings.Contains(metrics.String(), line+"\n") {
+			t.Errorf("metrics lack %s:\n%s", line, metrics.String())
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{0.1, 1})
+	h.observe("local", 0.05)
+	h.observe("local", 0.5)
+	h.observe("local", 5)
+	h.observe(`o"ci`, 1)
+	var b bytes.Buffer
+	h.write(&b, "m", "Help.", "backend")
+	want := `# HELP m Help.
+# TYPE m histogram
+m_bucket{backend="local",le="0.1"} 1
+m_bucket{backend="local",le="1"} 2
+m_bucket{backend="local",le="+Inf"} 3
+m_sum{backend="local"} 5.55
+m_count{backend="local"} 3
+m_bucket{backend="o\"ci",le="0.1"} 0
+m_bucket{backend="o\"ci",le="1"} 1
+m_bucket{backend="o\"ci",le="+Inf"} 1
+m_sum{backend="o\"ci"} 1
+m_count{backend="o\"ci"} 1
+`
+	if b.String() != want {
+		t.Errorf("histogram =\n%s\nwant\n%s", b.String(), want)
+	}
+
+	var none *histogram
+	none.observe("local", 1)
+	b.Reset()
+	none.write(&b, "m", "Help.", "backend")
+	if b.Len() != 0 {
+		t.Error("nil histogram written")
+	}
+}
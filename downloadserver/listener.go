@@ -0,0 +1,172 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listen opens the listener for the service, a Unix domain socket when one is
+// configured and otherwise a TCP listener on addr with the configured keep-alive.
+// The minimum read rate and the connection limit apply to either.
+func (ds *DownloadServer) listen(addr string) (net.Listener, error) {
+	var listener net.Listener
+	if ds.SocketPath != "" {
+		ln, err := listenUnix(strings.TrimPrefix(ds.SocketPath, "unix:"))
+		if err != nil {
+			return nil, err
+		}
+		listener = ln
+	} else {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		listener = tcpListener{ln.(*net.TCPListener), ds.TCPKeepAlive, ds.TCPReadBuffer, ds.TCPWriteBuffer, !ds.TCPDelay}
+	}
+	if ds.SlowReadRate > 0 {
+		listener = ds.newSlowReadListener(listener)
+	}
+	if ds.MaxConnections > 0 {
+		listener = newLimitListener(listener, ds.MaxConnections)
+	}
+	return listener, nil
+}
+
+// listenUnix listens on the Unix domain socket at path. A socket file left behind
+// by an instance that did not shut down cleanly is removed first. The socket file
+// is removed again when the listener is closed.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// tcpListener enables TCP keep-alive on accepted connections so that dead peers
+// are detected and their connections reaped, and sets their socket buffer sizes
+// and TCP_NODELAY.
+type tcpListener struct {
+	*net.TCPListener
+	keepAlive   time.Duration
+	readBuffer  int
+	writeBuffer int
+	noDelay     bool
+}
+
+func (l tcpListener) Accept() (net.Conn, error) {
+	c, err := l.AcceptTCP()
+	if err != nil {
+		return nil, err
+	}
+	if l.keepAlive > 0 {
+		c.SetKeepAlive(true)
+		c.SetKeepAlivePeriod(l.keepAlive)
+	}
+	setSocketBuffers(c, l.readBuffer, l.writeBuffer)
+	c.SetNoDelay(l.noDelay)
+	return c, nil
+}
+
+// setSocketBuffers sets the kernel buffer sizes of a TCP connection, leaving the
+// system defaults for sizes of zero.
+func setSocketBuffers(c *net.TCPConn, readBuffer int, writeBuffer int) {
+	if readBuffer > 0 {
+		c.SetReadBuffer(readBuffer)
+	}
+	if writeBuffer > 0 {
+		c.SetWriteBuffer(writeBuffer)
+	}
+}
+
+// newUpstreamClient returns the HTTP client for the GETs from OCI, which dials
+// connections with the configured socket buffer sizes. Its settings otherwise
+// follow http.DefaultTransport.
+func (ds *DownloadServer) newUpstreamClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	dial := func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		c, err := dialer.DialContext(ctx, network, addr)
+		if tc, ok := c.(*net.TCPConn); ok {
+			setSocketBuffers(tc, ds.TCPReadBuffer, ds.TCPWriteBuffer)
+		}
+		return c, err
+	}
+	return &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dial,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}}
+}
+
+// upstreamClient returns the HTTP client for the GETs from OCI.
+func (ds *DownloadServer) upstreamClient() *http.Client {
+	if ds.upstream == nil {
+		return http.DefaultClient
+	}
+	return ds.upstream
+}
+
+// limitListener caps the number of simultaneously open connections. Accept
+// blocks once the limit is reached until one of the open connections is closed.
+type limitListener struct {
+	net.Listener
+	sem       chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newLimitListener(l net.Listener, n int) *limitListener {
+	return &limitListener{
+		Listener: l,
+		sem:      make(chan struct{}, n),
+		done:     make(chan struct{}),
+	}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.sem <- struct{}{}:
+	case <-l.done:
+		return nil, errListenerClosed
+	}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.sem }}, nil
+}
+
+func (l *limitListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+	return err
+}
+
+// limitConn returns its slot to the limitListener when closed.
+type limitConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
+
+// errListenerClosed is returned by Accept after the listener has been closed.
+var errListenerClosed = errors.New("listener closed")
@@ -0,0 +1,103 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// acceptedSocket accepts a connection on a listener from ds.listen and returns
+// the value of a socket option of the accepted side.
+func acceptedSocket(t *testing.T, ds *DownloadServer, level int, opt int) int {
+	ln, err := ds.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	return socketOption(t, c, level, opt)
+}
+
+// socketOption returns the value of a socket option of the TCP connection c.
+func socketOption(t *testing.T, c net.Conn, level int, opt int) int {
+	raw, err := c.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value int
+	var sockErr error
+	raw.Control(func(fd uintptr) {
+		value, sockErr = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+	if sockErr != nil {
+		t.Fatal(sockErr)
+	}
+	return value
+}
+
+// checkBuffer checks a socket buffer size read back from the kernel, which
+// doubles the requested size for its bookkeeping.
+func checkBuffer(t *testing.T, what string, got int, want int) {
+	if got < want || got > 2*want {
+		t.Errorf("%s = %d, want %d", what, got, want)
+	}
+}
+
+func TestTCPNoDelay(t *testing.T) {
+	ds := &DownloadServer{}
+	if v := acceptedSocket(t, ds, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); v == 0 {
+		t.Error("TCP_NODELAY not set on accepted connections")
+	}
+	ds.TCPDelay = true
+	if v := acceptedSocket(t, ds, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); v != 0 {
+		t.Error("TCP_NODELAY set with TCPDelay")
+	}
+}
+
+func TestTCPKeepAlive(t *testing.T) {
+	ds := &DownloadServer{TCPKeepAlive: 42 * time.Second}
+	if v := acceptedSocket(t, ds, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE); v != 42 {
+		t.Errorf("TCP_KEEPIDLE = %d, want 42", v)
+	}
+}
+
+func TestListenerSocketBuffers(t *testing.T) {
+	// Sizes well apart from the system defaults, above the kernel minimums.
+	ds := &DownloadServer{TCPReadBuffer: 6000, TCPWriteBuffer: 7000}
+	checkBuffer(t, "SO_RCVBUF", acceptedSocket(t, ds, syscall.SOL_SOCKET, syscall.SO_RCVBUF), 6000)
+	checkBuffer(t, "SO_SNDBUF", acceptedSocket(t, ds, syscall.SOL_SOCKET, syscall.SO_SNDBUF), 7000)
+}
+
+func TestUpstreamSocketBuffers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	ds := &DownloadServer{TCPReadBuffer: 6000, TCPWriteBuffer: 7000}
+	var conn net.Conn
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { conn = info.Conn }}
+	r, _ := http.NewRequest("GET", srv.URL, nil)
+	resp, err := ds.newUpstreamClient().Do(r.WithContext(httptrace.WithClientTrace(r.Context(), trace)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if conn == nil {
+		t.Fatal("no connection traced")
+	}
+	checkBuffer(t, "SO_RCVBUF", socketOption(t, conn, syscall.SOL_SOCKET, syscall.SO_RCVBUF), 6000)
+	checkBuffer(t, "SO_SNDBUF", socketOption(t, conn, syscall.SOL_SOCKET, syscall.SO_SNDBUF), 7000)
+}
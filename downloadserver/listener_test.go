@@ -0,0 +1,235 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUnixSocketListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rd.sock")
+
+	// A socket left behind by an earlier instance is replaced.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	if _, err := os.Lstat(path); err != nil {
+		t.Fatalf("stale socket file missing: %v", err)
+	}
+
+	ds := &DownloadServer{SocketPath: "unix:" + path}
+	ln, err := ds.listen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("over the socket"))
+	}))
+	client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return net.Dial("unix", path)
+	}}}
+	resp, err := client.Get("http://unix/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "over the socket" {
+		t.Errorf("body = %q", body)
+	}
+
+	ln.Close()
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file left after close: %v", err)
+	}
+}
+
+func TestRegularFileNotRemovedForSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "not-a-socket")
+	if err := ioutil.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ln, err := listenUnix(path); err == nil {
+		ln.Close()
+		t.Error("listened over a regular file")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("regular file removed: %v", err)
+	}
+}
+
+func TestConnectionLimit(t *testing.T) {
+	ds := &DownloadServer{MaxConnections: 1}
+	ln, err := ds.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- c
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+	first := <-accepted
+	select {
+	case <-accepted:
+		t.Fatal("second connection accepted past MaxConnections")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// Closing a connection frees its slot.
+	first.Close()
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("second connection not accepted once the first closed")
+	}
+
+	// A closed listener stops waiting for a slot.
+	ln.Close()
+	if _, ok := <-accepted; ok {
+		t.Error("connection accepted after close")
+	}
+}
+
+// delayListener hands out connections whose writes reach the peer only after
+// latency, simulating a long link. At most window writes are in flight, so a
+// writer is held back as by a full send window.
+type delayListener struct {
+	net.Listener
+	latency time.Duration
+	window  int
+}
+
+func (l delayListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	d := &delayConn{Conn: c, latency: l.latency, queue: make(chan delayedWrite, l.window), drained: make(chan struct{})}
+	go d.deliver()
+	return d, nil
+}
+
+type delayedWrite struct {
+	data []byte
+	at   time.Time
+}
+
+type delayConn struct {
+	net.Conn
+	latency time.Duration
+	mu      sync.Mutex
+	closed  bool
+	queue   chan delayedWrite
+	drained chan struct{}
+}
+
+func (c *delayConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, errListenerClosed
+	}
+	c.queue <- delayedWrite{append([]byte(nil), p...), time.Now().Add(c.latency)}
+	return len(p), nil
+}
+
+func (c *delayConn) deliver() {
+	defer close(c.drained)
+	for w := range c.queue {
+		time.Sleep(time.Until(w.at))
+		c.Conn.Write(w.data)
+	}
+}
+
+// Close delivers the writes still in flight before closing the connection.
+func (c *delayConn) Close() error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+	c.mu.Unlock()
+	<-c.drained
+	return c.Conn.Close()
+}
+
+// BenchmarkHighLatencyDownload measures the throughput of a local download to a
+// client 20ms away, with the default socket buffers and with larger ones.
+func BenchmarkHighLatencyDownload(b *testing.B) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1<<18)
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "large.bin"), content, 0644); err != nil {
+		b.Fatal(err)
+	}
+	for _, size := range []int{0, 4 << 20} {
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			ds := localServer()
+			ds.TCPReadBuffer, ds.TCPWriteBuffer = size, size
+			ln, err := ds.listen("127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			srv := &http.Server{Handler: http.HandlerFunc(download)}
+			go srv.Serve(delayListener{ln, 20 * time.Millisecond, 64})
+			defer srv.Close()
+			url := "http://" + ln.Addr().String() + downloadPath + "?a=large.bin&s=" + dir
+
+			b.SetBytes(int64(len(content)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := http.Get(url)
+				if err != nil {
+					b.Fatal(err)
+				}
+				n, err := io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+				if err != nil || n != int64(len(content)) {
+					b.Fatalf("read %d of %d bytes: %v", n, len(content), err)
+				}
+			}
+		})
+	}
+}
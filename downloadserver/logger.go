@@ -0,0 +1,86 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"github.com/wercker/pkg/log"
+)
+
+// Fields are the structured values attached to a log message.
+type Fields map[string]interface{}
+
+// Logger is the logging interface used throughout the download server. Embedders
+// can supply their own implementation to route the server's logging into the
+// host application's logger; the default writes to github.com/wercker/pkg/log.
+// Implementations are called on the request path and must not block; a panic in
+// one is contained and never fails a download.
+type Logger interface {
+	Debug(msg string, fields Fields)
+	Info(msg string, fields Fields)
+	Warn(msg string, fields Fields)
+	Error(msg string, fields Fields)
+}
+
+// NewWerckerLogger returns a Logger backed by github.com/wercker/pkg/log.
+func NewWerckerLogger() Logger {
+	return werckerLogger{}
+}
+
+type werckerLogger struct{}
+
+func (werckerLogger) Debug(msg string, fields Fields) {
+	log.WithFields(log.Fields(fields)).Debug(msg)
+}
+
+func (werckerLogger) Info(msg string, fields Fields) {
+	log.WithFields(log.Fields(fields)).Info(msg)
+}
+
+func (werckerLogger) Warn(msg string, fields Fields) {
+	log.WithFields(log.Fields(fields)).Warn(msg)
+}
+
+func (werckerLogger) Error(msg string, fields Fields) {
+	log.WithFields(log.Fields(fields)).Error(msg)
+}
+
+// logger returns the configured Logger, falling back to the wercker logger.
+func (ds *DownloadServer) logger() Logger {
+	if ds.Logger == nil {
+		return werckerLogger{}
+	}
+	return safeLogger{ds.Logger}
+}
+
+// safeLogger contains panics from a supplied Logger so that a broken logging
+// backend can't take down the download it was logging for. The message is
+// written to the wercker logger instead.
+type safeLogger struct {
+	Logger
+}
+
+func (l safeLogger) Debug(msg string, fields Fields) {
+	defer l.contain(werckerLogger.Debug, msg, fields)
+	l.Logger.Debug(msg, fields)
+}
+
+func (l safeLogger) Info(msg string, fields Fields) {
+	defer l.contain(werckerLogger.Info, msg, fields)
+	l.Logger.Info(msg, fields)
+}
+
+func (l safeLogger) Warn(msg string, fields Fields) {
+	defer l.contain(werckerLogger.Warn, msg, fields)
+	l.Logger.Warn(msg, fields)
+}
+
+func (l safeLogger) Error(msg string, fields Fields) {
+	defer l.contain(werckerLogger.Error, msg, fields)
+	l.Logger.Error(msg, fields)
+}
+
+func (safeLogger) contain(fallback func(werckerLogger, string, Fields), msg string, fields Fields) {
+	if r := recover(); r != nil {
+		fallback(werckerLogger{}, msg, fields)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logEntry is a message logged to a testLogger.
+type logEntry struct {
+	level  string
+	msg    string
+	fields Fields
+}
+
+// testLogger records the messages logged.
+type testLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *testLogger) log(level string, msg string, fields Fields) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, logEntry{level, msg, fields})
+}
+
+func (l *testLogger) Debug(msg string, fields Fields) { l.log("debug", msg, fields) }
+func (l *testLogger) Info(msg string, fields Fields)  { l.log("info", msg, fields) }
+func (l *testLogger) Warn(msg string, fields Fields)  { l.log("warn", msg, fields) }
+func (l *testLogger) Error(msg string, fields Fields) { l.log("error", msg, fields) }
+
+// find returns the entries logged with msg.
+func (l *testLogger) find(msg string) []logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var found []logEntry
+	for _, e := range l.entries {
+		if e.msg == msg {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// failingLogger is a logging backend that fails every call.
+type failingLogger struct{}
+
+func (failingLogger) Debug(msg string, fields Fields) { panic("exporter unavailable") }
+func (failingLogger) Info(msg string, fields Fields)  { panic("exporter unavailable") }
+func (failingLogger) Warn(msg string, fields Fields)  { panic("exporter unavailable") }
+func (failingLogger) Error(msg string, fields Fields) { panic("exporter unavailable") }
+
+func TestFailingLoggerDoesNotFailDownloads(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.txt": "hello"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.Logger = failingLogger{}
+	ds.Debug = true
+
+	if rec := testDownload("GET", "a=f.txt&s="+dir); rec.Code != 200 || rec.Body.String() != "hello" {
+		t.Errorf("download with a failing logger = %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := testDownload("GET", "a=missing&s="+dir); rec.Code < 400 {
+		t.Errorf("missing artifact with a failing logger = %d, want an error", rec.Code)
+	}
+}
+
+func TestFailingEventWebhookDoesNotFailDownloads(t *testing.T) {
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer webhook.Close()
+	dir := testStore(t, map[string]string{"f.txt": "hello"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	logger := &testLogger{}
+	ds.Logger = logger
+	ds.events = newEventEmitter(ds, webhook.URL, 1)
+
+	if rec := testDownload("GET", "a=f.txt&s="+dir); rec.Code != 200 || rec.Body.String() != "hello" {
+		t.Errorf("download with a failing webhook = %d %q", rec.Code, rec.Body.String())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ds.events.close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s := ds.events.report(); s.Failed != 1 || s.Sent != 0 {
+		t.Errorf("event stats = %+v, want one failed", s)
+	}
+	if len(logger.find("Failed to post download event")) != 1 {
+		t.Error("failed event not logged")
+	}
+}
+
+func TestCustomLogger(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.txt": "hello"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	logger := &testLogger{}
+	ds.Logger = logger
+
+	if rec := testDownload("GET", "a=f.txt&s="+dir); rec.Code != 200 {
+		t.Fatalf("download = %d", rec.Code)
+	}
+	served := logger.find("Download served")
+	if len(served) != 1 || served[0].level != "info" || served[0].fields["artifact"] != "f.txt" || served[0].fields["backend"] != "local" {
+		t.Errorf("download logged as %+v", served)
+	}
+	if rec := testDownload("GET", "a=../f.txt&s="+dir); rec.Code != 403 {
+		t.Fatalf("download outside the storepath = %d", rec.Code)
+	}
+	if refused := logger.find("Refused local artifact outside the storepath"); len(refused) != 1 || refused[0].level != "warn" {
+		t.Errorf("refusal logged as %+v", refused)
+	}
+}
+
+func TestSafeLogger(t *testing.T) {
+	l := safeLogger{failingLogger{}}
+	for level, log := range map[string]func(string, Fields){"debug": l.Debug, "info": l.Info, "warn": l.Warn, "error": l.Error} {
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					t.Errorf("%s: panic %v escaped", level, p)
+				}
+			}()
+			log("message", Fields{"key": "value"})
+		}()
+	}
+	// The configured Logger is wrapped, the default one isn't.
+	ds := &DownloadServer{}
+	if _, ok := ds.logger().(werckerLogger); !ok {
+		t.Errorf("default logger = %T", ds.logger())
+	}
+	ds.Logger = &testLogger{}
+	if _, ok := ds.logger().(safeLogger); !ok {
+		t.Errorf("configured logger = %T, want it contained", ds.logger())
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * Maintenance mode. While it is on, downloads are refused with 503 Service
+ * Unavailable and a Retry-After instead of failing against a backend that is
+ * being worked on, while /healthz keeps reporting the process alive so that it
+ * isn't restarted. The mode starts as configured by Maintenance and can be
+ * switched at run time with SetMaintenance, which the server command wires to
+ * SIGUSR1, or through the /maintenance endpoint when MaintenanceToken is set.
+ */
+
+// DefaultMaintenanceRetry is the Retry-After sent during maintenance when
+// MaintenanceRetry isn't set.
+const DefaultMaintenanceRetry = time.Minute
+
+// maintenanceMessage is the error sent for downloads during maintenance.
+const maintenanceMessage = "the download service is down for maintenance, try again later"
+
+// SetMaintenance switches maintenance mode on or off.
+func (ds *DownloadServer) SetMaintenance(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	if atomic.SwapInt32(&ds.maintenance, v) != v {
+		ds.logger().Warn("Maintenance mode changed", Fields{"maintenance": on})
+	}
+}
+
+// InMaintenance reports whether maintenance mode is on.
+func (ds *DownloadServer) InMaintenance() bool {
+	return atomic.LoadInt32(&ds.maintenance) == 1
+}
+
+// maintenanceError writes the response refusing a download during maintenance.
+func (ds *DownloadServer) maintenanceError(w http.ResponseWriter, r *http.Request) {
+	wait := ds.MaintenanceRetry
+	if wait <= 0 {
+		wait = DefaultMaintenanceRetry
+	}
+	w.Header().Set("Retry-After", retryAfter(wait))
+	httpError(w, r, maintenanceMessage, http.StatusServiceUnavailable)
+}
+
+// healthStatus is the JSON document served by /healthz and /maintenance.
+type healthStatus struct {
+	Status      string `json:"status"`
+	Maintenance bool   `json:"maintenance"`
+}
+
+func writeHealthStatus(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(healthStatus{Status: "alive", Maintenance: downloadServer.InMaintenance()})
+}
+
+// Healthz handler. Reports that the server is alive, also during maintenance.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		httpError(w, r, "protocol error", http.StatusMethodNotAllowed)
+		return
+	}
+	writeHealthStatus(w)
+}
+
+// Maintenance handler. GET reports the maintenance mode and POST with on=true or
+// on=false switches it. Requests must present MaintenanceToken as a bearer token.
+func maintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		w.Header().Set("Allow", "GET, POST")
+		httpError(w, r, "protocol error", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(downloadServer.MaintenanceToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		httpError(w, r, "maintenance requires authorization", http.StatusUnauthorized)
+		return
+	}
+	if r.Method == "POST" {
+		on, err := strconv.ParseBool(r.URL.Query().Get("on"))
+		if err != nil {
+			httpError(w, r, "on= must be true or false", http.StatusBadRequest)
+			return
+		}
+		downloadServer.SetMaintenance(on)
+	}
+	writeHealthStatus(w)
+}
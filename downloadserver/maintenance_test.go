@@ -0,0 +1,132 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testHealthStatus returns the status code and health status answered by handler.
+func testHealthStatus(t *testing.T, handler func(w *httptest.ResponseRecorder)) (int, healthStatus) {
+	rec := httptest.NewRecorder()
+	handler(rec)
+	var status healthStatus
+	if rec.Code == 200 {
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, status
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.txt": "hello"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.MaintenanceRetry = 2 * time.Minute
+
+	ds.SetMaintenance(true)
+	rec := testDownload("GET", "a=f.txt&s="+dir)
+	if rec.Code != 503 || rec.Header().Get("Retry-After") != "120" {
+		t.Errorf("download during maintenance = %d Retry-After %q, want 503", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	code, status := testHealthStatus(t, func(w *httptest.ResponseRecorder) { healthz(w, httptest.NewRequest("GET", "/healthz", nil)) })
+	if code != 200 || status.Status != "alive" || !status.Maintenance {
+		t.Errorf("healthz during maintenance = %d %+v", code, status)
+	}
+
+	ds.SetMaintenance(false)
+	if rec := testDownload("GET", "a=f.txt&s="+dir); rec.Code != 200 {
+		t.Errorf("download after maintenance = %d", rec.Code)
+	}
+	if _, status := testHealthStatus(t, func(w *httptest.ResponseRecorder) { healthz(w, httptest.NewRequest("GET", "/healthz", nil)) }); status.Maintenance {
+		t.Error("healthz reports maintenance after it ended")
+	}
+}
+
+func TestMaintenanceEndpoint(t *testing.T) {
+	ds := localServer()
+	ds.MaintenanceToken = "secret"
+	request := func(method, query, token string) func(w *httptest.ResponseRecorder) {
+		return func(w *httptest.ResponseRecorder) {
+			r := httptest.NewRequest(method, "/maintenance"+query, nil)
+			if token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+			maintenance(w, r)
+		}
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		if code, _ := testHealthStatus(t, request("POST", "?on=true", token)); code != 401 {
+			t.Errorf("POST with token %q = %d, want 401", token, code)
+		}
+	}
+	// The token is only taken with the Bearer scheme.
+	bare := func(w *httptest.ResponseRecorder) {
+		r := httptest.NewRequest("POST", "/maintenance?on=true", nil)
+		r.Header.Set("Authorization", "secret")
+		maintenance(w, r)
+	}
+	if code, _ := testHealthStatus(t, bare); code != 401 {
+		t.Errorf("POST with a bare token = %d, want 401", code)
+	}
+	if ds.InMaintenance() {
+		t.Fatal("maintenance switched on without authorization")
+	}
+	if code, status := testHealthStatus(t, request("POST", "?on=true", "secret")); code != 200 || !status.Maintenance || !ds.InMaintenance() {
+		t.Errorf("POST on=true = %d %+v", code, status)
+	}
+	if code, status := testHealthStatus(t, request("GET", "", "secret")); code != 200 || !status.Maintenance {
+		t.Errorf("GET = %d %+v", code, status)
+	}
+	if code, _ := testHealthStatus(t, request("POST", "?on=maybe", "secret")); code != 400 {
+		t.Errorf("POST on=maybe = %d, want 400", code)
+	}
+	if code, _ := testHealthStatus(t, request("POST", "?on=false", "secret")); code != 200 || ds.InMaintenance() {
+		t.Errorf("POST on=false = %d, maintenance %v", code, ds.InMaintenance())
+	}
+	if code, _ := testHealthStatus(t, request("DELETE", "", "secret")); code != 405 {
+		t.Errorf("DELETE = %d, want 405", code)
+	}
+}
+
+func TestMaintenanceToggleUnderLoad(t *testing.T) {
+	// Maintenance mode is the only setting changed while the server runs, so
+	// switching it while downloads are served must be race free (go test -race).
+	dir := testStore(t, map[string]string{"f.txt": "hello"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if rec := testDownload("GET", "a=f.txt&s="+dir); rec.Code != 200 && rec.Code != 503 {
+					t.Errorf("download while switching maintenance = %d", rec.Code)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		ds.SetMaintenance(i%2 == 0)
+		ds.statistics()
+	}
+	close(stop)
+	wg.Wait()
+	ds.SetMaintenance(false)
+}
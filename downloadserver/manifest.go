@@ -0,0 +1,206 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+ * Archive manifests. manifest=1 answers a download of a tar, gzipped tar or zip
+ * artifact with a JSON listing of its entries instead of the archive itself,
+ * so clients can pick the entry= to extract. Entry names are normalized the same
+ * way as for entry=. Tar archives are read as a stream up to the last header; zip
+ * archives keep their directory at the end and need random access, which OCI
+ * objects only get by being read into memory.
+ */
+
+// manifestMaxEntries bounds the number of entries listed; longer listings are
+// cut short and flagged as truncated.
+const manifestMaxEntries = 10000
+
+// manifestMaxZipSize is the largest OCI zip object read into memory to list it.
+const manifestMaxZipSize = 64 << 20
+
+// archiveManifest is the JSON listing returned for manifest=1.
+type archiveManifest struct {
+	Artifact  string          `json:"artifact"`
+	Format    string          `json:"format"`
+	Entries   []manifestEntry `json:"entries"`
+	Truncated bool            `json:"truncated,omitempty"`
+}
+
+type manifestEntry struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"` // file, dir, symlink or other
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"` // permission bits in octal
+	ModTime time.Time `json:"modtime"`
+}
+
+// archiveFormat returns the manifest format of the artifact name, "" when it
+// isn't a supported archive.
+func archiveFormat(name string) string {
+	if isTar, gzipped := isTarArtifact(name); isTar {
+		if gzipped {
+			return "tar.gz"
+		}
+		return "tar"
+	}
+	if strings.HasSuffix(name, ".zip") {
+		return "zip"
+	}
+	return ""
+}
+
+func newManifestEntry(name string, info os.FileInfo) manifestEntry {
+	entry := manifestEntry{
+		Name:    cleanEntryName(name),
+		Type:    "other",
+		Size:    info.Size(),
+		Mode:    fmt.Sprintf("%04o", info.Mode().Perm()),
+		ModTime: info.ModTime().UTC(),
+	}
+	switch mode := info.Mode(); {
+	case mode.IsRegular():
+		entry.Type = "file"
+	case mode.IsDir():
+		entry.Type = "dir"
+		entry.Size = 0
+	case mode&os.ModeSymlink != 0:
+		entry.Type = "symlink"
+	}
+	return entry
+}
+
+// add appends entry to the manifest, reporting false once it is full.
+func (m *archiveManifest) add(entry manifestEntry) bool {
+	if len(m.Entries) >= manifestMaxEntries {
+		m.Truncated = true
+		return false
+	}
+	m.Entries = append(m.Entries, entry)
+	return true
+}
+
+// tarManifest lists the tar archive read from body.
+func tarManifest(m *archiveManifest, body io.Reader) error {
+	if m.Format == "tar.gz" {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return badRequest("artifact is not a gzip compressed archive")
+		}
+		body = zr
+	}
+	tr := tar.NewReader(body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return badRequest("artifact is not a valid archive: " + err.Error())
+		}
+		if !m.add(newManifestEntry(hdr.Name, hdr.FileInfo())) {
+			return nil
+		}
+	}
+}
+
+// zipManifest lists the zip archive of size bytes read through at.
+func zipManifest(m *archiveManifest, at io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(at, size)
+	if err != nil {
+		return badRequest("artifact is not a valid archive: " + err.Error())
+	}
+	for _, f := range zr.File {
+		if !m.add(newManifestEntry(f.Name, f.FileInfo())) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// sendManifest writes the manifest as the JSON response.
+func sendManifest(w http.ResponseWriter, m *archiveManifest) error {
+	if m.Entries == nil {
+		m.Entries = []manifestEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	return json.NewEncoder(w).Encode(m)
+}
+
+// localManifest answers manifest=1 for a local artifact.
+func (ds *DownloadServer) localManifest(w http.ResponseWriter, artifact string, storepath string) error {
+	m := &archiveManifest{Artifact: artifact, Format: archiveFormat(artifact)}
+	if m.Format == "" {
+		return badRequest("artifact is not a recognized archive")
+	}
+	artifactPath, err := ds.localPath(storepath, artifact)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(artifactPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if m.Format == "zip" {
+		stat, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		err = zipManifest(m, f, stat.Size())
+	} else {
+		err = tarManifest(m, f)
+	}
+	if err != nil {
+		return err
+	}
+	return sendManifest(w, m)
+}
+
+// ociManifest answers manifest=1 for an OCI artifact.
+func (ds *DownloadServer) ociManifest(w http.ResponseWriter, r *http.Request, artifact string) error {
+	object := ds.ociObjectName(artifact)
+	m := &archiveManifest{Artifact: artifact, Format: archiveFormat(object)}
+	if m.Format == "" {
+		return badRequest("artifact is not a recognized archive")
+	}
+	stream, err := ds.fetchOCIObject(r.Context(), object, nil)
+	if err != nil {
+		return err
+	}
+	defer stream.Body.Close()
+	if m.Format == "zip" {
+		if stream.ContentLength > manifestMaxZipSize {
+			return &statusError{http.StatusRequestEntityTooLarge, "zip archive is too large to list"}
+		}
+		content, err := ioutil.ReadAll(io.LimitReader(stream.Body, manifestMaxZipSize+1))
+		if err != nil {
+			return err
+		}
+		if len(content) > manifestMaxZipSize {
+			return &statusError{http.StatusRequestEntityTooLarge, "zip archive is too large to list"}
+		}
+		err = zipManifest(m, bytes.NewReader(content), int64(len(content)))
+	} else {
+		err = tarManifest(m, stream.Body)
+	}
+	if err != nil {
+		return err
+	}
+	return sendManifest(w, m)
+}
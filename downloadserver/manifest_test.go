@@ -0,0 +1,126 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// testZip returns a zip archive of the name, content pairs of entries.
+func testZip(t *testing.T, entries ...string) string {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i+1 < len(entries); i += 2 {
+		w, err := zw.Create(entries[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(entries[i+1]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// manifestOf decodes the manifest=1 response rec.
+func manifestOf(t *testing.T, rec *httptest.ResponseRecorder) archiveManifest {
+	var m archiveManifest
+	if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil {
+		t.Fatalf("manifest %q: %v", rec.Body.String(), err)
+	}
+	return m
+}
+
+// entryList summarizes the entries of m as name:type:size.
+func entryList(m archiveManifest) string {
+	var list []string
+	for _, e := range m.Entries {
+		list = append(list, fmt.Sprintf("%s:%s:%d", e.Name, e.Type, e.Size))
+	}
+	return fmt.Sprint(list)
+}
+
+func TestArchiveFormat(t *testing.T) {
+	for name, want := range map[string]string{
+		"a.tar": "tar", "a.tar.gz": "tar.gz", "a.tgz": "tar.gz", "a.zip": "zip", "a.gz": "", "a.txt": "",
+	} {
+		if got := archiveFormat(name); got != want {
+			t.Errorf("archiveFormat(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestLocalManifest(t *testing.T) {
+	archive := testTar(t, "bin/", "", "./bin/tool", "binary", "README", "read me")
+	dir := testStore(t, map[string]string{
+		"build.tar": archive,
+		"build.tgz": gzipped(t, archive),
+		"build.zip": testZip(t, "docs/index.html", "<html>"),
+		"bad.tar":   "not a tar archive at all, just some text that is long enough",
+		"build.txt": "text",
+	})
+	defer os.RemoveAll(dir)
+	localServer()
+
+	for artifact, want := range map[string]string{
+		"build.tar": "[bin:dir:0 bin/tool:file:6 README:file:7]",
+		"build.tgz": "[bin:dir:0 bin/tool:file:6 README:file:7]",
+		"build.zip": "[docs/index.html:file:6]",
+	} {
+		rec := testDownload("GET", "a="+artifact+"&manifest=1&s="+dir)
+		if rec.Code != 200 || rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s: manifest = %d %s", artifact, rec.Code, rec.Body.String())
+			continue
+		}
+		m := manifestOf(t, rec)
+		if got := entryList(m); got != want || m.Artifact != artifact || m.Format != archiveFormat(artifact) || m.Truncated {
+			t.Errorf("%s: manifest %+v, entries %s, want %s", artifact, m, got, want)
+		}
+	}
+	for _, artifact := range []string{"bad.tar", "build.txt"} {
+		if rec := testDownload("GET", "a="+artifact+"&manifest=1&s="+dir); rec.Code != 400 {
+			t.Errorf("%s: manifest = %d, want 400", artifact, rec.Code)
+		}
+	}
+}
+
+func TestOCIManifest(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/build.tgz", []byte(gzipped(t, testTar(t, "bin/tool", "binary"))))
+	f.put("a/build.zip", []byte(testZip(t, "README", "read me")))
+	f.server()
+
+	for artifact, want := range map[string]string{
+		"a/build.tgz": "[bin/tool:file:6]",
+		"a/build.zip": "[README:file:7]",
+	} {
+		rec := testDownload("GET", "t=ten&a="+artifact+"&manifest=1")
+		if rec.Code != 200 {
+			t.Errorf("%s: manifest = %d %s", artifact, rec.Code, rec.Body.String())
+			continue
+		}
+		if got := entryList(manifestOf(t, rec)); got != want {
+			t.Errorf("%s: entries %s, want %s", artifact, got, want)
+		}
+	}
+}
+
+func TestManifestTruncated(t *testing.T) {
+	m := &archiveManifest{}
+	for i := 0; i < manifestMaxEntries; i++ {
+		if !m.add(manifestEntry{Name: "f"}) {
+			t.Fatalf("manifest full after %d entries", i)
+		}
+	}
+	if m.add(manifestEntry{Name: "one too many"}) || !m.Truncated || len(m.Entries) != manifestMaxEntries {
+		t.Errorf("manifest past the limit: %d entries, truncated %v", len(m.Entries), m.Truncated)
+	}
+}
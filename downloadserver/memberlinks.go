@@ -0,0 +1,191 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	ocistorage "github.com/oracle/oci-go-sdk/objectstorage"
+)
+
+/*
+ * Member links. Instead of having an archive assembled, mode=urls returns a PAR
+ * for every a= artifact, or with prefix=1 for every object whose name starts
+ * with the a= prefix, so the client can download the members directly from OCI
+ * and in parallel. The PARs are created like those of mode=url, by a bounded
+ * pool of ArchiveWorkers, and live for RedirectPARTTL or until the sweeper
+ * deletes them after RedirectPARGrace.
+ */
+
+// maxMemberLinks bounds the number of PARs created for a single mode=urls request.
+const maxMemberLinks = 1000
+
+// memberLink is an entry of the JSON array returned for mode=urls.
+type memberLink struct {
+	Name    string    `json:"name"`
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+	object  string
+}
+
+// sendMemberLinks answers a mode=urls request with a PAR for each member. With
+// prefix=1 the members are the objects under the a= prefix that allowed, which
+// applies the deny list, the identity prefixes and the Authorizer to each
+// listed name, accepts.
+func (ds *DownloadServer) sendMemberLinks(w http.ResponseWriter, r *http.Request, req *DownloadRequest, allowed func(name string) bool) error {
+	var links []*memberLink
+	if req.Prefix {
+		prefix := ds.ociObjectName(req.Artifacts[0])
+		// Cleaning the name for the object prefix drops a trailing "/", which
+		// would widen the listing to sibling names.
+		if strings.HasSuffix(req.Artifacts[0], "/") && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		objects, err := ds.listObjects(r.Context(), prefix, maxMemberLinks)
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			name := strings.TrimPrefix(object, ds.ObjectPrefix)
+			if allowed(name) {
+				links = append(links, &memberLink{Name: name, object: object})
+			}
+		}
+	} else {
+		if len(req.Artifacts) > maxMemberLinks {
+			return badRequest(fmt.Sprintf("mode=urls is limited to %d artifacts", maxMemberLinks))
+		}
+		for _, artifact := range req.Artifacts {
+			links = append(links, &memberLink{Name: artifact, object: ds.ociObjectName(artifact)})
+		}
+	}
+
+	ttl := ds.RedirectPARTTL
+	if ttl <= 0 {
+		ttl = DefaultRedirectPARTTL
+	}
+	if err := ds.createMemberLinks(r.Context(), links, ttl); err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	if links == nil {
+		links = []*memberLink{}
+	}
+	return json.NewEncoder(w).Encode(links)
+}
+
+// createMemberLinks creates the PARs of links concurrently, failing on the first
+// member whose PAR can't be created.
+func (ds *DownloadServer) createMemberLinks(ctx context.Context, links []*memberLink, ttl time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	workers := ds.ArchiveWorkers
+	if workers < 1 {
+		workers = DefaultArchiveWorkers
+	}
+	slots := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var failure error
+	for _, link := range links {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(link *memberLink) {
+			defer func() { <-slots; wg.Done() }()
+			if err := ds.createMemberLink(ctx, link, ttl); err != nil {
+				once.Do(func() { failure = err; cancel() })
+			}
+		}(link)
+	}
+	wg.Wait()
+	if failure != nil {
+		return failure
+	}
+	return ctx.Err()
+}
+
+// createMemberLink creates the PAR of a single member within OCITimeout.
+func (ds *DownloadServer) createMemberLink(ctx context.Context, link *memberLink, ttl time.Duration) error {
+	parent := ctx
+	if ds.OCITimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ds.OCITimeout)
+		defer cancel()
+	}
+	url, err := ds.redirectLink(ctx, link.object, ttl)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+			return errOCITimeout
+		}
+		return err
+	}
+	link.URL = url
+	link.Expires = ds.redirectExpiry(ttl)
+	return nil
+}
+
+// listObjects returns the names of the objects starting with prefix, failing
+// over between the regions. More than max objects is a 400 Bad Request.
+func (ds *DownloadServer) listObjects(ctx context.Context, prefix string, max int) ([]string, error) {
+	var err error
+	for _, region := range ds.regionOrder() {
+		var client ocistorage.ObjectStorageClient
+		if client, err = ds.objectStorageClient(region); err != nil {
+			return nil, err
+		}
+		var names []string
+		if names, err = listRegionObjects(ctx, client, &ds.Namespace, &ds.BucketName, prefix, max); err == nil {
+			return names, nil
+		}
+		if _, tooMany := err.(*statusError); tooMany || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// listRegionObjects lists the objects starting with prefix in a bucket, a page at
+// a time.
+func listRegionObjects(ctx context.Context, client ocistorage.ObjectStorageClient, namespace *string, bucket *string, prefix string, max int) ([]string, error) {
+	var names []string
+	var start *string
+	for {
+		list, err := client.ListObjects(ctx, ocistorage.ListObjectsRequest{
+			NamespaceName: namespace,
+			BucketName:    bucket,
+			Prefix:        &prefix,
+			Start:         start,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range list.Objects {
+			// Names ending with "/" are folder markers rather than members.
+			if object.Name == nil || strings.HasSuffix(*object.Name, "/") {
+				continue
+			}
+			if len(names) == max {
+				return nil, badRequest(fmt.Sprintf("more than %d objects match the prefix", max))
+			}
+			names = append(names, *object.Name)
+		}
+		if list.NextStartWith == nil || *list.NextStartWith == "" {
+			return names, nil
+		}
+		start = list.NextStartWith
+	}
+}
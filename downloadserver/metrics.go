@@ -0,0 +1,113 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+/*
+ * Metrics. The /metrics endpoint serves the server statistics in the Prometheus
+ * text exposition format, for scraping alongside the JSON /stats.
+ */
+
+// metricsContentType is the content type of the Prometheus text format.
+const metricsContentType = "text/plain; version=0.0.4"
+
+// labelEscaper escapes label values for the text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Metrics handler. Reports the current server statistics for Prometheus.
+func metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		httpError(w, r, "protocol error", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", metricsContentType)
+	writeMetrics(w, downloadServer.statistics())
+	downloadServer.writeLatencyMetrics(w)
+}
+
+// writeMetrics writes s in the Prometheus text format.
+func writeMetrics(w io.Writer, s *serverStats) {
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	metric("runner_download_par_creations_total", "counter", "PAR creations made for downloads.")
+	fmt.Fprintf(w, "runner_download_par_creations_total %d\n", s.PARCoalescing.Calls)
+	metric("runner_download_par_coalesced_total", "counter", "Downloads that shared a concurrent download's PAR creation.")
+	fmt.Fprintf(w, "runner_download_par_coalesced_total %d\n", s.PARCoalescing.Coalesced)
+	metric("runner_download_par_max_shared", "gauge", "Largest number of downloads that shared a single PAR creation.")
+	fmt.Fprintf(w, "runner_download_par_max_shared %d\n", s.PARCoalescing.MaxShared)
+
+	if s.Downloads != nil {
+		metric("runner_download_active", "gauge", "Downloads in progress.")
+		fmt.Fprintf(w, "runner_download_active %d\n", s.Downloads.Active)
+		metric("runner_download_queued", "gauge", "Downloads waiting in the queue for a slot.")
+		fmt.Fprintf(w, "runner_download_queued %d\n", s.Downloads.Queued)
+		metric("runner_download_queue_rejected_total", "counter", "Downloads refused because the queue was full.")
+		fmt.Fprintf(w, "runner_download_queue_rejected_total %d\n", s.Downloads.Rejected)
+		metric("runner_download_queue_timeouts_total", "counter", "Downloads refused after waiting in the queue for too long.")
+		fmt.Fprintf(w, "runner_download_queue_timeouts_total %d\n", s.Downloads.TimedOut)
+	}
+
+	if s.Events != nil {
+		metric("runner_download_events_sent_total", "counter", "Download events posted to the webhook.")
+		fmt.Fprintf(w, "runner_download_events_sent_total %d\n", s.Events.Sent)
+		metric("runner_download_events_failed_total", "counter", "Download events the webhook failed to accept.")
+		fmt.Fprintf(w, "runner_download_events_failed_total %d\n", s.Events.Failed)
+		metric("runner_download_events_dropped_total", "counter", "Download events dropped because the buffer was full.")
+		fmt.Fprintf(w, "runner_download_events_dropped_total %d\n", s.Events.Dropped)
+	}
+
+	if s.Throughput != nil {
+		metric("runner_download_throughput_bytes_per_second", "gauge", "Estimated percentiles of the recent download throughput.")
+		fmt.Fprintf(w, "runner_download_throughput_bytes_per_second{quantile=\"0.5\"} %g\n", s.Throughput.P50)
+		fmt.Fprintf(w, "runner_download_throughput_bytes_per_second{quantile=\"0.95\"} %g\n", s.Throughput.P95)
+		fmt.Fprintf(w, "runner_download_throughput_bytes_per_second{quantile=\"0.99\"} %g\n", s.Throughput.P99)
+	}
+
+	if s.Regions != nil {
+		regions := make([]string, 0, len(s.Regions))
+		for region := range s.Regions {
+			regions = append(regions, region)
+		}
+		sort.Strings(regions)
+		metric("runner_download_region_healthy", "gauge", "Whether the region is tried ahead of the unhealthy ones.")
+		for _, region := range regions {
+			healthy := 0
+			if s.Regions[region].Healthy {
+				healthy = 1
+			}
+			fmt.Fprintf(w, "runner_download_region_healthy{region=\"%s\"} %d\n", labelEscaper.Replace(region), healthy)
+		}
+		metric("runner_download_region_consecutive_failures", "gauge", "Failures of the region since its last success.")
+		for _, region := range regions {
+			fmt.Fprintf(w, "runner_download_region_consecutive_failures{region=\"%s\"} %d\n", labelEscaper.Replace(region), s.Regions[region].ConsecutiveFailures)
+		}
+		metric("runner_download_region_failures_total", "counter", "OCI fetches that failed in the region.")
+		for _, region := range regions {
+			fmt.Fprintf(w, "runner_download_region_failures_total{region=\"%s\"} %d\n", labelEscaper.Replace(region), s.Regions[region].Failures)
+		}
+		metric("runner_download_region_successes_total", "counter", "OCI fetches that succeeded in the region.")
+		for _, region := range regions {
+			fmt.Fprintf(w, "runner_download_region_successes_total{region=\"%s\"} %d\n", labelEscaper.Replace(region), s.Regions[region].Successes)
+		}
+	}
+
+	metric("runner_download_tenancy_bytes", "gauge", "Bytes served per tenancy in the current quota window.")
+	tenancies := make([]string, 0, len(s.TenancyUsage))
+	for tenancy := range s.TenancyUsage {
+		tenancies = append(tenancies, tenancy)
+	}
+	sort.Strings(tenancies)
+	for _, tenancy := range tenancies {
+		fmt.Fprintf(w, "runner_download_tenancy_bytes{tenancy=\"%s\"} %d\n", labelEscaper.Replace(tenancy), s.TenancyUsage[tenancy])
+	}
+}
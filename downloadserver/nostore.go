@@ -0,0 +1,43 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+/*
+ * Client cache bypass. A download sent with Cache-Control: no-store skips every
+ * server side cache: a new PAR is created even when a cached one would do, and
+ * the digest, sniffed content type and decompressed size of local files are
+ * computed afresh. The fresh values replace the cached ones, so later requests
+ * benefit too. The PAR cache is bypassed through the request context since it
+ * is consulted deep in the OCI fetch.
+ */
+
+// requestsNoStore reports whether the request's Cache-Control has no-store.
+func requestsNoStore(r *http.Request) bool {
+	for _, value := range r.Header["Cache-Control"] {
+		for _, directive := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-store") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type noStoreKey struct{}
+
+// withNoStore returns ctx marked to bypass the server side caches.
+func withNoStore(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noStoreKey{}, true)
+}
+
+// noStore reports whether ctx bypasses the server side caches.
+func noStore(ctx context.Context) bool {
+	bypass, _ := ctx.Value(noStoreKey{}).(bool)
+	return bypass
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestsNoStore(t *testing.T) {
+	for _, tc := range []struct {
+		values []string
+		want   bool
+	}{
+		{nil, false},
+		{[]string{"no-store"}, true},
+		{[]string{"max-age=0, No-Store"}, true},
+		{[]string{"no-cache", "no-store"}, true},
+		{[]string{"no-cache"}, false},
+		{[]string{"no-storex"}, false},
+	} {
+		r := httptest.NewRequest("GET", downloadPath, nil)
+		for _, value := range tc.values {
+			r.Header.Add("Cache-Control", value)
+		}
+		if got := requestsNoStore(r); got != tc.want {
+			t.Errorf("requestsNoStore(%q) = %v, want %v", tc.values, got, tc.want)
+		}
+	}
+}
+
+func TestNoStoreBypassesPARCache(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	ds := f.server()
+	ds.parCache = newPARCache()
+
+	testDownload("GET", "t=ten&a=a/f.txt")
+	if rec := testDownload("GET", "t=ten&a=a/f.txt", "Cache-Control", "no-store"); rec.Code != 200 || rec.Body.String() != "hello" {
+		t.Fatalf("no-store download = %d %q", rec.Code, rec.Body.String())
+	}
+	if n := f.count(&f.pars); n != 2 {
+		t.Errorf("%d PARs created, want a new one for no-store", n)
+	}
+	// Later downloads use the cache again.
+	testDownload("GET", "t=ten&a=a/f.txt")
+	if n := f.count(&f.pars); n != 2 {
+		t.Errorf("%d PARs created, want a cached PAR reused", n)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+/*
+ * Object name encoding. A PAR's access URI ends with the name of its object,
+ * which has to be percent-encoded to survive as a URL path: a raw # or ? would
+ * end the path, and a + is read as a space by some proxies. The name is
+ * encoded as selected by ObjectNameEncoding before the PAR URL is used or
+ * handed out. The SDK calls encode object names themselves.
+ */
+
+// How object names are encoded in PAR URLs.
+const (
+	// ObjectNamePath percent-encodes every byte of the name other than the
+	// RFC 3986 unreserved characters, keeping "/" between name segments.
+	ObjectNamePath = "path"
+	// ObjectNameStrict is ObjectNamePath with "/" encoded as %2F too.
+	ObjectNameStrict = "strict"
+	// ObjectNameNone uses the access URI exactly as OCI returned it.
+	ObjectNameNone = "none"
+)
+
+// encodeObjectName percent-encodes object with the ObjectNameEncoding scheme.
+func encodeObjectName(object string, scheme string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(object); i++ {
+		c := object[i]
+		if unreservedByte(c) || (c == '/' && scheme != ObjectNameStrict) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// unreservedByte reports whether c is an RFC 3986 unreserved character.
+func unreservedByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// parURL returns the URL of the PAR for object with access URI accessURI on
+// host, with the object name encoded as selected by ObjectNameEncoding. An
+// access URI that doesn't end with the bucket's object path is used as is.
+func (ds *DownloadServer) parURL(host string, accessURI string, object string) string {
+	if ds.ObjectNameEncoding != ObjectNameNone {
+		marker := "/n/" + ds.Namespace + "/b/" + ds.BucketName + "/o/"
+		if i := strings.Index(accessURI, marker); i >= 0 {
+			accessURI = accessURI[:i+len(marker)] + encodeObjectName(object, ds.ObjectNameEncoding)
+		}
+	}
+	return fmt.Sprintf("https://%s%s", host, accessURI)
+}
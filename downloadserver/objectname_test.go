@@ -0,0 +1,52 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"testing"
+)
+
+func TestEncodeObjectName(t *testing.T) {
+	for _, tc := range []struct {
+		object, path, strict string
+	}{
+		{"builds/app-1.0_x~y.tar", "builds/app-1.0_x~y.tar", "builds%2Fapp-1.0_x~y.tar"},
+		{"a b/c#d?e+f.txt", "a%20b/c%23d%3Fe%2Bf.txt", "a%20b%2Fc%23d%3Fe%2Bf.txt"},
+		{"ü/100%", "%C3%BC/100%25", "%C3%BC%2F100%25"},
+	} {
+		if got := encodeObjectName(tc.object, ObjectNamePath); got != tc.path {
+			t.Errorf("path encoding of %q = %q, want %q", tc.object, got, tc.path)
+		}
+		if got := encodeObjectName(tc.object, ObjectNameStrict); got != tc.strict {
+			t.Errorf("strict encoding of %q = %q, want %q", tc.object, got, tc.strict)
+		}
+	}
+}
+
+func TestPARURL(t *testing.T) {
+	ds := &DownloadServer{Namespace: "ns", BucketName: "bk", ObjectNameEncoding: ObjectNamePath}
+	const accessURI = "/p/tok/n/ns/b/bk/o/a b#1.txt"
+	if got := ds.parURL("host", accessURI, "a b#1.txt"); got != "https://host/p/tok/n/ns/b/bk/o/a%20b%231.txt" {
+		t.Errorf("parURL = %q", got)
+	}
+	if got := ds.parURL("host", "/p/tok/elsewhere", "a b#1.txt"); got != "https://host/p/tok/elsewhere" {
+		t.Errorf("parURL of an unexpected access URI = %q", got)
+	}
+	ds.ObjectNameEncoding = ObjectNameNone
+	if got := ds.parURL("host", accessURI, "a b#1.txt"); got != "https://host"+accessURI {
+		t.Errorf("parURL without encoding = %q", got)
+	}
+}
+
+func TestDownloadEncodedObjectName(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	const object = "builds/release #2/app+tools?.tar"
+	f.put(object, []byte("tools"))
+	f.server().ObjectNameEncoding = ObjectNamePath
+
+	rec := testDownload("GET", "t=ten&a=builds%2Frelease+%232%2Fapp%2Btools%3F.tar")
+	if rec.Code != 200 || rec.Body.String() != "tools" {
+		t.Errorf("download of %q = %d %q", object, rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// errOCITimeout is returned when the PAR creation and the GET from the PAR didn't
+// complete within the OCITimeout budget.
+var errOCITimeout = errors.New("timed out fetching artifact from OCI")
+
+// errPARRefused is returned when OCI refuses the GET of an object with a new
+// PAR too.
+var errPARRefused = &statusError{http.StatusForbidden, "OCI refused access to the artifact"}
+
+// ociObjectName strips off environment specific prefixes from an artifact. OCI
+// objects are stored without these. The name is then placed under ObjectPrefix,
+// cleaned first so that it can't climb out of the prefix.
+func (ds *DownloadServer) ociObjectName(artifact string) string {
+	prefixStaging := "wercker-development/"
+	if strings.HasPrefix(artifact, prefixStaging) {
+		artifact = artifact[len(prefixStaging):]
+	}
+	prefixProduction := "wercker-production/"
+	if strings.HasPrefix(artifact, prefixProduction) {
+		artifact = artifact[len(prefixProduction):]
+	}
+	if ds.ObjectPrefix != "" {
+		artifact = ds.ObjectPrefix + strings.TrimPrefix(path.Clean("/"+artifact), "/")
+	}
+	return artifact
+}
+
+// fetchOCIObject creates a PAR for the object and issues the GET for it, failing
+// over to the next region when one fails. A single budget, OCITimeout, covers
+// creating the PARs and the GETs up to the response headers. The body of the
+// returned response stays readable until ctx is done; streaming it is bounded
+// by MaxDownloadDuration instead. With OCIParallelParts set the object is
+// fetched as parallel ranges when OCI supports it. A non-nil rng fetches only
+// that range of the object.
+func (ds *DownloadServer) fetchOCIObject(ctx context.Context, object string, rng *byteRange) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	timedOut := make(chan struct{})
+	var budget *time.Timer
+	if ds.OCITimeout > 0 {
+		budget = time.AfterFunc(ds.OCITimeout, func() {
+			close(timedOut)
+			cancel()
+		})
+	}
+	fail := func(err error) (*http.Response, error) {
+		cancel()
+		select {
+		case <-timedOut:
+			return nil, errOCITimeout
+		default:
+			return nil, err
+		}
+	}
+
+	var artifactUrl string
+	var stream *http.Response
+	var err error
+	regions := ds.regionOrder()
+	for i, region := range regions {
+		artifactUrl, stream, err = ds.fetchFromRegion(ctx, region, object, rng)
+		if err == nil {
+			if ds.regions != nil {
+				ds.regions.succeeded(region)
+			}
+			break
+		}
+		// Failures that aren't down to the region, such as a missing object,
+		// end the download.
+		_, limited := err.(*rateLimitedError)
+		_, unsatisfiable := err.(*rangeError)
+		_, refused := err.(*statusError)
+		if limited || unsatisfiable || refused || ctx.Err() != nil {
+			return fail(err)
+		}
+		if ds.regions != nil {
+			ds.regions.failed(region)
+		}
+		if i < len(regions)-1 {
+			ds.logger().Warn("OCI region failed, trying next region", Fields{"region": region, "object": object, "error": err.Error()})
+		}
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if budget != nil && !budget.Stop() {
+		stream.Body.Close()
+		return fail(errOCITimeout)
+	}
+	if stream.StatusCode == http.StatusPartialContent && rng == nil {
+		assembled, err := ds.assembleParts(ctx, cancel, artifactUrl, stream)
+		if err != nil {
+			stream.Body.Close()
+			return fail(err)
+		}
+		return assembled, nil
+	}
+	stream.Body = &cancelOnClose{stream.Body, cancel}
+	return stream, nil
+}
+
+// fetchFromRegion gets the PAR for object in region and issues the GET for it,
+// returning the PAR URL and the response once its headers are in. Objects below
+// DirectFetchThreshold, ranges with DirectRanges and objects encrypted with an
+// SSE-C key are fetched without a PAR.
+func (ds *DownloadServer) fetchFromRegion(ctx context.Context, region string, object string, rng *byteRange) (string, *http.Response, error) {
+	// The HEAD of a direct fetch has already seen whether the object is
+	// archived.
+	headed := false
+	if ds.DirectFetchThreshold > 0 || (rng != nil && ds.DirectRanges) || ds.sseKey != nil {
+		stream, ok, err := ds.fetchDirect(ctx, region, object, rng)
+		if err != nil || ok {
+			return "", stream, err
+		}
+		headed = true
+	}
+
+	parallel := ds.OCIParallelParts > 1 && rng == nil
+	var artifactUrl string
+	var stream *http.Response
+	for attempt := 0; ; attempt++ {
+		var err error
+		artifactUrl, stream, err = ds.getFromPAR(ctx, region, object, rng, parallel)
+		if err != nil {
+			return "", nil, err
+		}
+		// A PAR that expired, or was deleted, between being handed out and the
+		// GET is refused with a 403 or 404. OCI answers a missing object the
+		// same way, so a new PAR is tried only a limited number of times.
+		if (stream.StatusCode == http.StatusForbidden || stream.StatusCode == http.StatusNotFound) && attempt < parRetries {
+			stream.Body.Close()
+			ds.logger().Warn("PAR refused by OCI, retrying with a new PAR", Fields{"object": object, "region": region, "status": stream.Status, "parTTL": parTTL.String()})
+			ds.dropObjectPAR(region, object)
+			continue
+		}
+		break
+	}
+	// An empty object has no first part to fetch, and is fetched whole.
+	if parallel && stream.StatusCode == http.StatusRequestedRangeNotSatisfiable && stream.Header.Get("Content-Range") == "bytes */0" {
+		stream.Body.Close()
+		parallel = false
+		var err error
+		if artifactUrl, stream, err = ds.getFromPAR(ctx, region, object, nil, false); err != nil {
+			return "", nil, err
+		}
+	}
+	// A plain 200 to the ranged request means ranges aren't supported and the
+	// whole object is streamed as one.
+	if stream.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		stream.Body.Close()
+		return "", nil, unsatisfiedRange(stream)
+	}
+	if stream.StatusCode != http.StatusOK && !((parallel || rng != nil) && stream.StatusCode == http.StatusPartialContent) {
+		stream.Body.Close()
+		// An archived object can't be read until it has been restored.
+		if !headed {
+			if err := ds.archivedObject(ctx, region, object); err != nil {
+				return "", nil, err
+			}
+		}
+		// The object is missing, or refused, even with a new PAR: the region
+		// itself is working.
+		if stream.StatusCode == http.StatusNotFound {
+			return "", nil, errNoObject
+		}
+		if stream.StatusCode == http.StatusForbidden {
+			return "", nil, errPARRefused
+		}
+		return "", nil, fmt.Errorf("OCI download failed: %s", stream.Status)
+	}
+	return artifactUrl, stream, nil
+}
+
+// parRetries is the number of times a download retries with a new PAR when OCI
+// refuses the one it got.
+const parRetries = 1
+
+// getFromPAR gets the PAR for object in region and issues the GET for it.
+func (ds *DownloadServer) getFromPAR(ctx context.Context, region string, object string, rng *byteRange, parallel bool) (string, *http.Response, error) {
+	timing := timingFrom(ctx)
+	started := time.Now()
+	artifactUrl, err := ds.objectPAR(ctx, region, object)
+	timing.since("par", "PAR creation", started)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Issue the GET using the preauthenticated URL
+	request, err := http.NewRequest("GET", artifactUrl, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	if parallel {
+		first, _ := partRange(0, ds.partSize(), ds.partSize())
+		request.Header.Set("Range", first)
+	} else if rng != nil {
+		request.Header.Set("Range", rng.header())
+	}
+	started = time.Now()
+	stream, err := ds.upstreamClient().Do(request.WithContext(ctx))
+	timing.since("ttfb", "OCI first byte", started)
+	if err != nil {
+		return "", nil, err
+	}
+	return artifactUrl, stream, nil
+}
+
+// cancelOnClose releases the request context of a response once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMissingObjectKeepsRegionHealthy(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	ds := f.server()
+	ds.regions = newRegionHealth([]string{ds.Region})
+
+	for _, threshold := range []int64{0, 1 << 20} {
+		ds.DirectFetchThreshold = threshold
+		rec := httptest.NewRecorder()
+		download(rec, httptest.NewRequest("GET", downloadPath+"?t=ten&a=missing.bin", nil))
+		if rec.Code != 404 {
+			t.Errorf("threshold %d: download of a missing object = %d, want 404", threshold, rec.Code)
+		}
+	}
+	// Through a PAR the missing object is tried again with a new one.
+	if n := f.count(&f.pars); n != 2 {
+		t.Errorf("%d PARs created, want 2", n)
+	}
+	if s := ds.regions.report()[ds.Region]; s.Failures != 0 || !s.Healthy {
+		t.Errorf("region after a missing object = %+v", s)
+	}
+
+	f.mu.Lock()
+	f.status = 500
+	f.mu.Unlock()
+	ds.DirectFetchThreshold = 0
+	rec := httptest.NewRecorder()
+	download(rec, httptest.NewRequest("GET", downloadPath+"?t=ten&a=missing.bin", nil))
+	if s := ds.regions.report()[ds.Region]; s.Failures != 1 {
+		t.Errorf("region after a failing backend = %+v", s)
+	}
+}
+
+func TestRegionMetrics(t *testing.T) {
+	h := newRegionHealth([]string{"us-phoenix-1", "us-ashburn-1"})
+	for i := 0; i < regionFailureThreshold; i++ {
+		h.failed("us-ashburn-1")
+	}
+	h.succeeded("us-phoenix-1")
+	var buf bytes.Buffer
+	writeMetrics(&buf, &serverStats{Regions: h.report()})
+	for _, line := range []string{
+		`runner_download_region_healthy{region="us-ashburn-1"} 0`,
+		`runner_download_region_healthy{region="us-phoenix-1"} 1`,
+		`runner_download_region_consecutive_failures{region="us-ashburn-1"} 3`,
+		`runner_download_region_failures_total{region="us-ashburn-1"} 3`,
+		`runner_download_region_successes_total{region="us-phoenix-1"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("metrics lack %s:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestOCITimeout(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	ds := f.server()
+	ds.OCITimeout = 50 * time.Millisecond
+	f.mu.Lock()
+	f.parDelay = 300 * time.Millisecond
+	f.mu.Unlock()
+
+	started := time.Now()
+	rec := testDownload("GET", "t=ten&a=a/f.txt")
+	if rec.Code != 504 {
+		t.Errorf("download past OCITimeout = %d, want 504", rec.Code)
+	}
+	if elapsed := time.Since(started); elapsed > 250*time.Millisecond {
+		t.Errorf("download took %s past a %s OCITimeout", elapsed, ds.OCITimeout)
+	}
+
+	// The budget only covers the fetch up to the response headers.
+	f.mu.Lock()
+	f.parDelay = 0
+	f.mu.Unlock()
+	ds.OCITimeout = time.Second
+	if rec := testDownload("GET", "t=ten&a=a/f.txt"); rec.Code != 200 || rec.Body.String() != "hello" {
+		t.Errorf("download within OCITimeout = %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestStalePARRetry(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	ds := f.server()
+	ds.parCache = newPARCache()
+	if rec := testDownload("GET", "t=ten&a=a/f.txt"); rec.Code != 200 {
+		t.Fatalf("first download = %d", rec.Code)
+	}
+
+	// The cached PAR is refused once, as after it was deleted.
+	f.parStatus = http.StatusForbidden
+	f.onParGet = func(r *http.Request) {
+		f.mu.Lock()
+		f.parStatus = 0
+		f.mu.Unlock()
+	}
+	if rec := testDownload("GET", "t=ten&a=a/f.txt"); rec.Code != 200 || rec.Body.String() != "hello" {
+		t.Fatalf("download with a stale PAR = %d %q", rec.Code, rec.Body.String())
+	}
+	if n := f.count(&f.pars); n != 2 {
+		t.Errorf("%d PARs created, want a new one for the stale PAR", n)
+	}
+	f.onParGet = nil
+	testDownload("GET", "t=ten&a=a/f.txt")
+	if n := f.count(&f.pars); n != 2 {
+		t.Errorf("%d PARs created, want the new PAR cached", n)
+	}
+
+	// A PAR refused every time is retried once only.
+	f.parStatus = http.StatusNotFound
+	gets := f.count(&f.parGets)
+	if rec := testDownload("GET", "t=ten&a=a/f.txt"); rec.Code != 404 {
+		t.Errorf("download refused twice = %d, want 404", rec.Code)
+	}
+	if n := f.count(&f.parGets) - gets; n != 1+parRetries {
+		t.Errorf("%d PAR GETs, want %d", n, 1+parRetries)
+	}
+}
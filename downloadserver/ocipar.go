@@ -3,26 +3,28 @@
 package downloadserver
 
 import (
-	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	ocicommon "github.com/oracle/oci-go-sdk/common"
 	ocistorage "github.com/oracle/oci-go-sdk/objectstorage"
-	"github.com/wercker/pkg/log"
 	"golang.org/x/net/context"
 )
 
+// parTTL is the lifetime of the PARs created for downloads.
+const parTTL = 2 * time.Minute
+
 // CreateOCIPAR creates a pre-authenticated URL for a download artifact from
 // OCI Object Storage. This handler will also delete expired PARs as a
-// housekeeping function.
-func (ds *DownloadServer) CreateOCIPAR(parname string, artifact string) (string, error) {
-	ctx := context.Background()
-	// Create the configuration
-	configProvider := ocicommon.NewRawConfigurationProvider(ds.Tenancy,
-		ds.User, ds.Region, ds.Fingerprint, ds.Privatekey, &ds.Passphrase)
+// housekeeping function. The OCI calls are bound to ctx.
+func (ds *DownloadServer) CreateOCIPAR(ctx context.Context, parname string, artifact string) (string, error) {
+	return ds.createRegionPAR(ctx, ds.Region, parname, artifact, parTTL)
+}
 
-	// Create the object storage client
-	client, err := ocistorage.NewObjectStorageClientWithConfigurationProvider(configProvider)
+// createRegionPAR creates the PAR for artifact in the bucket in region, valid for ttl.
+func (ds *DownloadServer) createRegionPAR(ctx context.Context, region string, parname string, artifact string, ttl time.Duration) (string, error) {
+	client, err := ds.objectStorageClient(region)
 	if err != nil {
 		return "", err
 	}
@@ -51,9 +53,9 @@ func (ds *DownloadServer) CreateOCIPAR(parname string, artifact string) (string,
 		}
 	}
 
-	// Specify 5 minutes to live
+	// Specify the time to live
 	expires := ocicommon.SDKTime{
-		time.Now().Add(time.Minute * 2),
+		Time: time.Now().Add(ttl),
 	}
 
 	// Setup the creation details
@@ -73,9 +75,35 @@ func (ds *DownloadServer) CreateOCIPAR(parname string, artifact string) (string,
 	if err != nil {
 		return "", err
 	}
-	par := fmt.Sprintf("https://%s%s", client.BaseClient.Host, *response.AccessUri)
-	if ds.Debug {
-		log.Debugln(fmt.Sprintf("OCI PAR is %s", par))
-	}
+	par := ds.parURL(client.BaseClient.Host, *response.AccessUri, artifact)
+	// The PAR URL grants access to the object and is kept out of the log.
+	ds.debug(ctx, "OCI PAR created", Fields{"parName": parname, "object": artifact, "region": region})
 	return par, nil
 }
+
+// objectStorageClient creates an object storage client for region using the
+// server's credentials. OCIEndpoint, when set, is used for the primary region.
+func (ds *DownloadServer) objectStorageClient(region string) (ocistorage.ObjectStorageClient, error) {
+	// Create the configuration
+	configProvider := ocicommon.NewRawConfigurationProvider(ds.Tenancy,
+		ds.User, region, ds.Fingerprint, ds.Privatekey, &ds.Passphrase)
+
+	// Create the object storage client
+	client, err := ocistorage.NewObjectStorageClientWithConfigurationProvider(configProvider)
+	if err != nil {
+		return client, err
+	}
+	if ds.OCIEndpoint != "" && region == ds.Region {
+		client.Host = strings.TrimPrefix(ds.OCIEndpoint, "https://")
+	}
+	// The SDK's own client keeps its timeout but dials with the socket buffer
+	// sizes of the PAR downloads.
+	if sdkClient, ok := client.HTTPClient.(*http.Client); ok && ds.upstream != nil {
+		sdkClient.Transport = ds.upstream.Transport
+	}
+	if ds.sseKey != nil {
+		client.Interceptor = ds.sseKey.intercept
+	}
+	client.HTTPClient = &authWatcher{ds: ds, next: &archivalStateKeeper{next: client.HTTPClient}}
+	return client, nil
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ * Proxy offloading. Behind nginx, Apache or lighttpd the bytes of a download
+ * don't have to pass through this server: with Offload set, a plain download is
+ * answered with an empty response carrying an internal redirect that the proxy
+ * follows itself. X-Accel-Redirect (nginx) points local files at
+ * OffloadLocalPrefix followed by their absolute path, and OCI objects at
+ * OffloadOCIPrefix followed by the host and path of a PAR for them. X-Sendfile
+ * (Apache, lighttpd) carries the absolute path of local files and leaves OCI
+ * downloads streamed. Downloads that are transformed on the way, or that need
+ * the server to see the bytes, are always streamed.
+ */
+
+// Proxy internal redirect headers selected with Offload.
+const (
+	OffloadAccelRedirect = "x-accel-redirect"
+	OffloadSendfile      = "x-sendfile"
+)
+
+// Default internal locations for X-Accel-Redirect offloading.
+const (
+	DefaultOffloadLocalPrefix = "/internal/local"
+	DefaultOffloadOCIPrefix   = "/internal/oci"
+)
+
+// offloadable reports whether a download with opts can be left to the proxy.
+func (ds *DownloadServer) offloadable(opts transferOptions) bool {
+	if ds.Offload == "" || opts.digest != "" || opts.trailers || opts.entry != "" || opts.entries != nil || opts.follow || opts.encrypt || opts.resume != "" {
+		return false
+	}
+	// The proxy serves a Range header itself but knows nothing of offset=.
+	return opts.byteRange == nil || !opts.byteRange.query
+}
+
+// offloadLocal answers the download of the local file at artifactPath with an
+// internal redirect to it. It returns false when the file has to be streamed.
+func (ds *DownloadServer) offloadLocal(w http.ResponseWriter, artifactPath string, filename string, stat os.FileInfo, opts transferOptions) (bool, error) {
+	if !ds.offloadable(opts) || (ds.GzipPassthrough && strings.HasSuffix(filename, ".gz")) {
+		return false, nil
+	}
+	artifactPath, err := filepath.Abs(artifactPath)
+	if err != nil {
+		return false, nil
+	}
+	var header, location string
+	switch ds.Offload {
+	case OffloadSendfile:
+		header, location = "X-Sendfile", artifactPath
+	case OffloadAccelRedirect:
+		if ds.OffloadLocalPrefix == "" {
+			return false, nil
+		}
+		header = "X-Accel-Redirect"
+		location = strings.TrimSuffix(ds.OffloadLocalPrefix, "/") + encodeObjectName(artifactPath, ObjectNamePath)
+	default:
+		return false, nil
+	}
+	if filename, err = ds.downloadFilename(filename); err != nil {
+		return true, err
+	}
+	w.Header().Set("Last-Modified", stat.ModTime().UTC().Format(http.TimeFormat))
+	ds.sendOffload(w, header, location, filename)
+	if ds.Debug {
+		ds.logger().Debug("Local download offloaded to the proxy", Fields{"path": artifactPath, header: location})
+	}
+	return true, nil
+}
+
+// offloadOCI answers the download of object with an internal redirect to a PAR
+// for it. It returns false when the object has to be streamed.
+func (ds *DownloadServer) offloadOCI(ctx context.Context, w http.ResponseWriter, object string, opts transferOptions) (bool, error) {
+	if !ds.offloadable(opts) || ds.Offload != OffloadAccelRedirect || ds.OffloadOCIPrefix == "" || ds.sseKey != nil {
+		return false, nil
+	}
+	filename, err := ds.downloadFilename(object[strings.LastIndex(object, "/")+1:])
+	if err != nil {
+		return true, err
+	}
+	if ds.OCITimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ds.OCITimeout)
+		defer cancel()
+	}
+	var url string
+	for _, region := range ds.regionOrder() {
+		if url, err = ds.objectPAR(ctx, region, object); err == nil {
+			break
+		}
+		if _, limited := err.(*rateLimitedError); limited || ctx.Err() != nil {
+			break
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return true, errOCITimeout
+	}
+	if err != nil {
+		return true, err
+	}
+	location := strings.TrimSuffix(ds.OffloadOCIPrefix, "/") + "/" + strings.TrimPrefix(url, "https://")
+	ds.sendOffload(w, "X-Accel-Redirect", location, filename)
+	ds.debug(ctx, "OCI download offloaded to the proxy", Fields{"artifact": object})
+	return true, nil
+}
+
+// sendOffload writes the empty response redirecting the proxy to location with
+// header.
+func (ds *DownloadServer) sendOffload(w http.ResponseWriter, header string, location string, filename string) {
+	w.Header().Set("Content-Disposition", ds.contentDisposition(filename))
+	if pinned := ds.pinnedContentType(filename); pinned != "" {
+		w.Header().Set("Content-Type", pinned)
+	} else {
+		w.Header().Set("Content-Type", "binary/octet-stream")
+	}
+	w.Header().Set(header, location)
+	w.WriteHeader(http.StatusOK)
+}
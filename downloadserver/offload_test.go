@@ -0,0 +1,114 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOffloadable(t *testing.T) {
+	ds := &DownloadServer{}
+	if ds.offloadable(transferOptions{}) {
+		t.Error("offloadable without Offload")
+	}
+	ds.Offload = OffloadSendfile
+	if !ds.offloadable(transferOptions{}) || !ds.offloadable(transferOptions{byteRange: &byteRange{first: 2, last: -1}}) {
+		t.Error("plain download or Range header not offloadable")
+	}
+	// The options that need the server to see the bytes.
+	for name, opts := range map[string]transferOptions{
+		"digest":   {digest: "abc"},
+		"trailers": {trailers: true},
+		"entry":    {entry: "f"},
+		"entries":  {entries: []string{"*"}},
+		"follow":   {follow: true},
+		"encrypt":  {encrypt: true},
+		"resume":   {resume: "r"},
+		"offset":   {byteRange: &byteRange{first: 2, last: -1, query: true}},
+	} {
+		if ds.offloadable(opts) {
+			t.Errorf("%s offloadable", name)
+		}
+	}
+}
+
+func TestOffloadLocal(t *testing.T) {
+	dir := testStore(t, map[string]string{"sub/f.bin": "hello", "log.txt.gz": "gz"})
+	defer os.RemoveAll(dir)
+	abs, err := filepath.Abs(filepath.Join(dir, "sub/f.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := localServer()
+
+	ds.Offload = OffloadSendfile
+	rec := testDownload("GET", "a=sub/f.bin&s="+dir)
+	if rec.Code != 200 || rec.Header().Get("X-Sendfile") != abs || rec.Body.Len() != 0 {
+		t.Errorf("X-Sendfile = %d %q %q, want %s without a body", rec.Code, rec.Header().Get("X-Sendfile"), rec.Body.String(), abs)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "f.bin") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	if rec.Header().Get("Last-Modified") == "" {
+		t.Error("no Last-Modified for the proxy's conditional requests")
+	}
+	// The proxy serves Range headers itself.
+	if rec := testDownload("GET", "a=sub/f.bin&s="+dir, "Range", "bytes=1-2"); rec.Header().Get("X-Sendfile") != abs {
+		t.Error("download with a Range header streamed")
+	}
+	for _, query := range []string{"a=sub/f.bin&offset=1&s=" + dir, "a=sub/f.bin&trailers=1&s=" + dir} {
+		if rec := testDownload("GET", query); rec.Header().Get("X-Sendfile") != "" || !strings.Contains(rec.Body.String(), "ello") {
+			t.Errorf("%s: offloaded or not streamed, body %q", query, rec.Body.String())
+		}
+	}
+
+	ds.Offload = OffloadAccelRedirect
+	if rec := testDownload("GET", "a=sub/f.bin&s="+dir); rec.Header().Get("X-Accel-Redirect") != "" || rec.Body.String() != "hello" {
+		t.Error("X-Accel-Redirect without OffloadLocalPrefix not streamed")
+	}
+	ds.OffloadLocalPrefix = DefaultOffloadLocalPrefix + "/"
+	if rec := testDownload("GET", "a=sub/f.bin&s="+dir); rec.Header().Get("X-Accel-Redirect") != DefaultOffloadLocalPrefix+abs {
+		t.Errorf("X-Accel-Redirect = %q, want %s", rec.Header().Get("X-Accel-Redirect"), DefaultOffloadLocalPrefix+abs)
+	}
+
+	// Content-Encoding: gzip is set by the server, not by the proxy.
+	ds.GzipPassthrough = true
+	if rec := testDownload("GET", "a=log.txt.gz&s="+dir); rec.Header().Get("X-Accel-Redirect") != "" {
+		t.Error("passed through .gz offloaded")
+	}
+}
+
+func TestOffloadOCI(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.bin", []byte("from oci"))
+	ds := f.server()
+
+	// X-Sendfile has no way to reach a PAR.
+	ds.Offload = OffloadSendfile
+	if rec := testDownload("GET", "t=ten&a=a/f.bin"); rec.Header().Get("X-Sendfile") != "" || rec.Body.String() != "from oci" {
+		t.Error("OCI download offloaded with X-Sendfile")
+	}
+
+	ds.Offload = OffloadAccelRedirect
+	ds.OffloadOCIPrefix = DefaultOffloadOCIPrefix
+	pars := len(f.parIDs())
+	rec := testDownload("GET", "t=ten&a=a/f.bin")
+	host := strings.TrimPrefix(f.URL, "https://")
+	location := rec.Header().Get("X-Accel-Redirect")
+	if rec.Code != 200 || !strings.HasPrefix(location, DefaultOffloadOCIPrefix+"/"+host+"/p/") || rec.Body.Len() != 0 {
+		t.Errorf("X-Accel-Redirect = %d %q %q", rec.Code, location, rec.Body.String())
+	}
+	if n := len(f.parIDs()) - pars; n != 1 {
+		t.Errorf("%d PARs created for the redirect, want 1", n)
+	}
+
+	// The object is streamed for the server to compute its trailers.
+	rec = testDownload("GET", "t=ten&a=a/f.bin&trailers=1")
+	if rec.Header().Get("X-Accel-Redirect") != "" || rec.Body.String() != "from oci" {
+		t.Errorf("download with trailers=1 = %q %q", rec.Header().Get("X-Accel-Redirect"), rec.Body.String())
+	}
+}
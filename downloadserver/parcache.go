@@ -0,0 +1,147 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// parMinRemaining is how much of a cached PAR's lifetime must be left for it to
+// be reused, leaving time for the GET from it to start.
+const parMinRemaining = 30 * time.Second
+
+// parCache holds the PARs created for objects so they can be reused by later
+// downloads of the same object while they are still valid.
+type parCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedPAR
+}
+
+type cachedPAR struct {
+	url     string
+	expires time.Time
+}
+
+func newPARCache() *parCache {
+	return &parCache{entries: make(map[string]cachedPAR)}
+}
+
+// get returns a cached PAR for object that is valid for at least parMinRemaining.
+func (c *parCache) get(object string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[object]
+	if !ok || time.Until(entry.expires) < parMinRemaining {
+		return "", false
+	}
+	return entry.url, true
+}
+
+// put caches the PAR for object, dropping any entries that are no longer usable.
+func (c *parCache) put(object string, url string, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if time.Until(entry.expires) < parMinRemaining {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[object] = cachedPAR{url: url, expires: expires}
+}
+
+// drop removes the cached PAR for object.
+func (c *parCache) drop(object string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, object)
+}
+
+// objectPAR returns a PAR URL for object in region, reusing a cached PAR when the
+// cache is enabled and the request doesn't bypass it. Concurrent requests for the
+// same object share a single PAR creation. New PARs are subject to the PAR
+// creation rate limit.
+func (ds *DownloadServer) objectPAR(ctx context.Context, region string, object string) (string, error) {
+	key := region + "/" + object
+	if ds.parCache != nil && !noStore(ctx) {
+		if url, ok := ds.parCache.get(key); ok {
+			return url, nil
+		}
+	}
+	for {
+		url, err, shared := ds.parFlight.do(key, func() (string, error) {
+			url, err := ds.newObjectPAR(ctx, region, object, key)
+			if err != nil && ctx.Err() != nil {
+				return "", errPARAbandoned
+			}
+			return url, err
+		})
+		// The shared call ran with the context of the request that started it;
+		// if that request went away this one tries again with its own.
+		if shared && err == errPARAbandoned && ctx.Err() == nil {
+			continue
+		}
+		return url, err
+	}
+}
+
+// dropObjectPAR forgets the cached PAR for object in region, so the next
+// objectPAR creates a new one.
+func (ds *DownloadServer) dropObjectPAR(region string, object string) {
+	if ds.parCache != nil {
+		ds.parCache.drop(region + "/" + object)
+	}
+}
+
+// errPARAbandoned is the result of a coalesced PAR creation whose request went
+// away before it completed.
+var errPARAbandoned = errors.New("PAR creation abandoned")
+
+// newObjectPAR creates a PAR for object in region and caches it under key.
+func (ds *DownloadServer) newObjectPAR(ctx context.Context, region string, object string, key string) (string, error) {
+	if ds.parLimiter != nil {
+		if err := ds.parLimiter.wait(ctx, ds.PARRateWait); err != nil {
+			return "", err
+		}
+	}
+
+	expires := time.Now().Add(parTTL)
+	url, err := ds.createRegionPAR(ctx, region, newPARName(), object, parTTL)
+	if err != nil {
+		return "", err
+	}
+	if ds.parCache != nil {
+		ds.parCache.put(key, url, expires)
+	}
+	return url, nil
+}
+
+// redirectPARPrefix starts the names of the PARs handed out in redirect mode,
+// which the sweeper deletes once they are older than RedirectPARGrace.
+const redirectPARPrefix = "download-redirect-"
+
+// newPARName returns a unique name for a download PAR.
+func newPARName() string {
+	return uniquePARName("download-")
+}
+
+// newRedirectPARName returns a unique name for a PAR handed out in redirect mode.
+func newRedirectPARName() string {
+	return uniquePARName(redirectPARPrefix)
+}
+
+// uniquePARName returns prefix followed by a random suffix.
+func uniquePARName(prefix string) string {
+	parname := prefix + "parname"
+	// Create the derived value.
+	byt := make([]byte, 16)
+	_, err := rand.Read(byt)
+	if err == nil {
+		parname = fmt.Sprintf("%s%X-%X-%X-%X-%X", prefix, byt[0:4], byt[4:6], byt[6:8], byt[8:10], byt[10:])
+	}
+	return parname
+}
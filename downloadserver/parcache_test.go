@@ -0,0 +1,44 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPARCache(t *testing.T) {
+	c := newPARCache()
+	c.put("r/valid", "https://par/valid", time.Now().Add(time.Hour))
+	c.put("r/expiring", "https://par/expiring", time.Now().Add(parMinRemaining/2))
+	if url, ok := c.get("r/valid"); !ok || url != "https://par/valid" {
+		t.Errorf("valid PAR = %q %v", url, ok)
+	}
+	if _, ok := c.get("r/expiring"); ok {
+		t.Error("PAR about to expire reused")
+	}
+	c.drop("r/valid")
+	if _, ok := c.get("r/valid"); ok {
+		t.Error("dropped PAR reused")
+	}
+}
+
+func TestPARCacheReuse(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	ds := f.server()
+	ds.parCache = newPARCache()
+
+	for i := 0; i < 3; i++ {
+		if rec := testDownload("GET", "t=ten&a=a/f.txt"); rec.Code != 200 || rec.Body.String() != "hello" {
+			t.Fatalf("download %d = %d %q", i, rec.Code, rec.Body.String())
+		}
+	}
+	if n := f.count(&f.pars); n != 1 {
+		t.Errorf("%d PARs created with the cache, want 1", n)
+	}
+	if n := f.count(&f.parGets); n != 3 {
+		t.Errorf("%d GETs through the PAR, want 3", n)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	ocicommon "github.com/oracle/oci-go-sdk/common"
+	ocistorage "github.com/oracle/oci-go-sdk/objectstorage"
+)
+
+/*
+ * Split artifacts. Very large artifacts are sometimes stored as numbered OCI
+ * objects, artifact.part0, artifact.part1 and so on. parts=N streams the N parts
+ * of the a= artifact concatenated in order as a single download, and parts=auto
+ * takes parts until the next one is missing. The size of every part is looked
+ * up before the response starts, so that the combined Content-Length is known
+ * and a missing part fails the download before anything is sent.
+ */
+
+// partsAuto is the DownloadRequest.Parts value of parts=auto.
+const partsAuto = -1
+
+// maxParts bounds the number of parts of a split artifact.
+const maxParts = 10000
+
+// parsePartCount decodes the parts= parameter.
+func parsePartCount(value string) (int, error) {
+	if value == "auto" {
+		return partsAuto, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > maxParts {
+		return 0, badRequest(fmt.Sprintf("parts= must be auto or a count from 1 to %d", maxParts))
+	}
+	return n, nil
+}
+
+// partName is the object name of part i of object.
+func partName(object string, i int) string {
+	return fmt.Sprintf("%s.part%d", object, i)
+}
+
+// objectPart is a part of a split artifact as found by headObject.
+type objectPart struct {
+	name         string
+	size         int64
+	lastModified time.Time
+	// header is the HEAD response header, nil when it isn't available.
+	header http.Header
+}
+
+// headObject looks up the size of object, failing over between the regions like
+// a download does. ok is false when the object doesn't exist.
+func (ds *DownloadServer) headObject(ctx context.Context, object string) (*objectPart, bool, error) {
+	var err error
+	for _, region := range ds.regionOrder() {
+		var client ocistorage.ObjectStorageClient
+		if client, err = ds.objectStorageClient(region); err != nil {
+			return nil, false, err
+		}
+		var head ocistorage.HeadObjectResponse
+		head, err = client.HeadObject(ctx, ocistorage.HeadObjectRequest{
+			NamespaceName: &ds.Namespace,
+			BucketName:    &ds.BucketName,
+			ObjectName:    &object,
+		})
+		if se, isService := ocicommon.IsServiceError(err); isService && se.GetHTTPStatusCode() == http.StatusNotFound {
+			return nil, false, nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		if err := ds.archivalError(ctx, client, region, object, archivalState(head.RawResponse)); err != nil {
+			return nil, false, err
+		}
+		if head.ContentLength == nil {
+			return nil, false, fmt.Errorf("unknown size for part %s", object)
+		}
+		part := &objectPart{name: object, size: *head.ContentLength}
+		if head.RawResponse != nil {
+			part.lastModified, _ = http.ParseTime(head.RawResponse.Header.Get("Last-Modified"))
+			part.header = head.RawResponse.Header
+		}
+		return part, true, nil
+	}
+	return nil, false, err
+}
+
+// objectParts looks up the parts of the split artifact object: count of them, or
+// with partsAuto as many as there are in sequence.
+func (ds *DownloadServer) objectParts(ctx context.Context, object string, count int) ([]*objectPart, error) {
+	var parts []*objectPart
+	for i := 0; count == partsAuto || i < count; i++ {
+		if i >= maxParts {
+			return nil, badRequest(fmt.Sprintf("artifact has more than %d parts", maxParts))
+		}
+		part, ok, err := ds.headObject(ctx, partName(object, i))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			if count == partsAuto && i > 0 {
+				break
+			}
+			return nil, &statusError{http.StatusNotFound, fmt.Sprintf("missing part %s", partName(object, i))}
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// streamOCIParts streams the parts of a split OCI artifact as a single download.
+func (ds *DownloadServer) streamOCIParts(w http.ResponseWriter, r *http.Request, artifact string, count int, opts transferOptions) error {
+	object := ds.ociObjectName(artifact)
+	parts, err := ds.objectParts(r.Context(), object, count)
+	if err != nil {
+		return err
+	}
+	var size int64
+	var modTime time.Time
+	for _, part := range parts {
+		size += part.size
+		if part.lastModified.After(modTime) {
+			modTime = part.lastModified
+		}
+	}
+	body := &partsReader{ds: ds, ctx: r.Context(), parts: parts}
+	defer body.Close()
+	a := &artifactStream{
+		name:     object,
+		filename: object[strings.LastIndex(object, "/")+1:],
+		body:     body,
+		size:     size,
+	}
+	if !modTime.IsZero() {
+		a.lastModified = modTime.UTC().Format(http.TimeFormat)
+	}
+	if a.filename, err = ds.downloadFilename(a.filename); err != nil {
+		return err
+	}
+	nbytes, err := ds.sendArtifact(w, a, opts)
+	if err != nil {
+		return err
+	}
+	ds.debug(r.Context(), "OCI split download complete", Fields{"artifact": object, "parts": len(parts), "bytes": nbytes})
+	return nil
+}
+
+// partsReader reads the parts of a split artifact in order, fetching each one
+// when the previous one is done. A part that doesn't have the size it was
+// looked up with fails the read, so the parts can't be silently misaligned.
+type partsReader struct {
+	ds      *DownloadServer
+	ctx     context.Context
+	parts   []*objectPart
+	next    int
+	current io.ReadCloser
+	read    int64
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	for {
+		if p.current == nil {
+			if p.next >= len(p.parts) {
+				return 0, io.EOF
+			}
+			stream, err := p.ds.fetchOCIObject(p.ctx, p.parts[p.next].name, nil)
+			if err != nil {
+				return 0, err
+			}
+			p.current = stream.Body
+			p.read = 0
+		}
+		n, err := p.current.Read(b)
+		p.read += int64(n)
+		part := p.parts[p.next]
+		if p.read > part.size || (err == io.EOF && p.read != part.size) {
+			return n, fmt.Errorf("part %s changed size during the download", part.name)
+		}
+		if err == io.EOF {
+			p.current.Close()
+			p.current = nil
+			p.next++
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (p *partsReader) Close() error {
+	if p.current != nil {
+		p.current.Close()
+		p.current = nil
+	}
+	return nil
+}
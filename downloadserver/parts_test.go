@@ -0,0 +1,88 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestOCIParts(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	// Full parts and a short last one, so that a misplaced boundary shows.
+	f.put("big.iso.part0", []byte("0123456789"))
+	f.put("big.iso.part1", []byte("abcdefghij"))
+	f.put("big.iso.part2", []byte("XYZ"))
+	f.server()
+	whole := "0123456789abcdefghijXYZ"
+
+	for _, parts := range []string{"3", "auto"} {
+		rec := testDownload("GET", "t=ten&a=big.iso&parts="+parts)
+		if rec.Code != 200 || rec.Body.String() != whole {
+			t.Errorf("parts=%s: %d %q, want %q", parts, rec.Code, rec.Body.String(), whole)
+		}
+		if rec.Header().Get("Content-Length") != strconv.Itoa(len(whole)) {
+			t.Errorf("parts=%s: Content-Length = %q", parts, rec.Header().Get("Content-Length"))
+		}
+		if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "big.iso") || strings.Contains(cd, "part") {
+			t.Errorf("parts=%s: Content-Disposition = %q", parts, cd)
+		}
+	}
+
+	// Fewer parts than stored is a prefix of the artifact.
+	if rec := testDownload("GET", "t=ten&a=big.iso&parts=2"); rec.Body.String() != "0123456789abcdefghij" {
+		t.Errorf("parts=2: %q", rec.Body.String())
+	}
+	// More parts than stored fails before anything is sent.
+	rec := testDownload("GET", "t=ten&a=big.iso&parts=4")
+	if rec.Code != 404 || !strings.Contains(rec.Body.String(), "missing part big.iso.part3") {
+		t.Errorf("parts=4: %d %q, want 404", rec.Code, rec.Body.String())
+	}
+	if rec := testDownload("GET", "t=ten&a=none.iso&parts=auto"); rec.Code != 404 {
+		t.Errorf("parts=auto without parts = %d, want 404", rec.Code)
+	}
+}
+
+func TestPartsReader(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a.part0", []byte("first"))
+	f.put("a.part1", []byte("second"))
+	ds := f.server()
+	parts, err := ds.objectParts(context.Background(), "a", partsAuto)
+	if err != nil || len(parts) != 2 {
+		t.Fatalf("parts = %v, %v", parts, err)
+	}
+
+	// Reads across the part boundary, one byte at a time.
+	body, err := ioutil.ReadAll(iotest.OneByteReader(&partsReader{ds: ds, ctx: context.Background(), parts: parts}))
+	if err != nil || string(body) != "firstsecond" {
+		t.Errorf("read %q, %v", body, err)
+	}
+
+	// A part replaced with one of another size since it was looked up.
+	f.put("a.part0", []byte("longer first"))
+	if _, err := ioutil.ReadAll(&partsReader{ds: ds, ctx: context.Background(), parts: parts}); err == nil || !strings.Contains(err.Error(), "changed size") {
+		t.Errorf("grown part: %v", err)
+	}
+	f.put("a.part0", []byte("1st"))
+	if _, err := ioutil.ReadAll(&partsReader{ds: ds, ctx: context.Background(), parts: parts}); err == nil || !strings.Contains(err.Error(), "changed size") {
+		t.Errorf("shrunk part: %v", err)
+	}
+}
+
+func TestParsePartCount(t *testing.T) {
+	for _, value := range []string{"0", "-1", "10001", "many", ""} {
+		if _, err := parsePartCount(value); err == nil {
+			t.Errorf("parts=%s accepted", value)
+		}
+	}
+	if n, err := parsePartCount("auto"); err != nil || n != partsAuto {
+		t.Errorf("parts=auto = %d, %v", n, err)
+	}
+}
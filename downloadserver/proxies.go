@@ -0,0 +1,97 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+/*
+ * Trusted proxies. Behind a load balancer every request comes from the balancer
+ * and the client address is only known from X-Forwarded-For or X-Real-IP, which
+ * any client can also send. The headers are therefore only believed when the
+ * connection comes from one of the TrustedProxies. X-Forwarded-For is read from
+ * the right, skipping the trusted proxies that appended to it, and the first
+ * address that isn't a trusted proxy is the client; anything to the left of
+ * that was supplied by the client and is ignored. The client address is what
+ * the per client download limit, the logs and the download events go by.
+ */
+
+// trustedProxies are the compiled TrustedProxies networks.
+type trustedProxies []*net.IPNet
+
+// newTrustedProxies compiles the CIDR or single address entries. No entries
+// trust no proxy.
+func newTrustedProxies(entries []string) (trustedProxies, error) {
+	var proxies trustedProxies
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %s", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %s", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// trusted reports whether the IP address ip is a trusted proxy.
+func (p trustedProxies) trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range p {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address of the client of r: the connection's remote
+// address unless that is a trusted proxy, and then the address the proxies
+// forwarded.
+func (ds *DownloadServer) clientAddr(r *http.Request) string {
+	peer := clientIP(r.RemoteAddr)
+	if !ds.proxies.trusted(peer) {
+		return r.RemoteAddr
+	}
+	var hops []string
+	for _, value := range r.Header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	if len(hops) > 0 {
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			client = hop
+			if !ds.proxies.trusted(hop) {
+				break
+			}
+		}
+		return client
+	}
+	if real := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(real) != nil {
+		return real
+	}
+	return peer
+}
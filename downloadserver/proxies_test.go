@@ -0,0 +1,66 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewTrustedProxies(t *testing.T) {
+	proxies, err := newTrustedProxies([]string{"10.0.0.0/8", " 192.0.2.1 ", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"10.1.2.3":    true,
+		"192.0.2.1":   true,
+		"192.0.2.2":   false,
+		"2001:db8::1": true,
+		"2001:db8::2": false,
+		"not an ip":   false,
+	} {
+		if got := proxies.trusted(ip); got != want {
+			t.Errorf("trusted(%s) = %v, want %v", ip, got, want)
+		}
+	}
+	for _, entry := range []string{"10.0.0.0/33", "proxy.local"} {
+		if _, err := newTrustedProxies([]string{entry}); err == nil {
+			t.Errorf("trusted proxy %q accepted", entry)
+		}
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	ds := &DownloadServer{}
+	ds.proxies, _ = newTrustedProxies([]string{"10.0.0.0/8"})
+	for _, tc := range []struct {
+		remote    string
+		forwarded []string
+		realIP    string
+		want      string
+	}{
+		// Headers from untrusted peers are ignored.
+		{"203.0.113.9:1234", []string{"198.51.100.1"}, "198.51.100.2", "203.0.113.9:1234"},
+		{"10.0.0.1:1234", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		// The trusted proxies appending to X-Forwarded-For are skipped, and
+		// whatever the client put in front is ignored.
+		{"10.0.0.1:1234", []string{"1.2.3.4, 198.51.100.1, 10.0.0.2"}, "", "198.51.100.1"},
+		{"10.0.0.1:1234", []string{"1.2.3.4", "198.51.100.1, 10.0.0.2"}, "", "198.51.100.1"},
+		{"10.0.0.1:1234", []string{"garbage, 10.0.0.2"}, "", "10.0.0.2"},
+		{"10.0.0.1:1234", nil, "198.51.100.2", "198.51.100.2"},
+		{"10.0.0.1:1234", nil, "garbage", "10.0.0.1"},
+	} {
+		r := httptest.NewRequest("GET", downloadPath, nil)
+		r.RemoteAddr = tc.remote
+		for _, value := range tc.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if tc.realIP != "" {
+			r.Header.Set("X-Real-IP", tc.realIP)
+		}
+		if got := ds.clientAddr(r); got != tc.want {
+			t.Errorf("clientAddr from %s %q %q = %q, want %q", tc.remote, tc.forwarded, tc.realIP, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultQuotaWindow is the window over which tenancy byte quotas are counted
+// when QuotaWindow isn't set.
+const DefaultQuotaWindow = time.Hour
+
+// quotaBuckets is the number of buckets a quota window is counted in. Usage
+// leaves the window a bucket at a time.
+const quotaBuckets = 12
+
+// tenancyQuotas tracks the bytes served per tenancy over a sliding window and
+// enforces the configured per tenancy limits. A limit for the tenancy "*" applies
+// to every tenancy without a limit of its own. Tenancies without a limit are
+// unlimited but their usage is still tracked. The bytes are counted as they are
+// written, so that downloads in progress count against the quota of the
+// downloads that start after them. Tenancies whose usage has all left the
+// window are forgotten, so that any number of t= values seen over time can't
+// grow the tracked usage beyond the tenancies of the last window.
+type tenancyQuotas struct {
+	mu     sync.Mutex
+	limits map[string]int64
+	window time.Duration
+	start  time.Time
+	used   map[string]*tenancyUsage
+	// bucket is the number of the current bucket, counted from start.
+	bucket int64
+}
+
+// tenancyUsage is the bytes served to a tenancy in each bucket of the window.
+type tenancyUsage struct {
+	counts [quotaBuckets]int64
+	total  int64
+}
+
+func newTenancyQuotas(limits map[string]int64, window time.Duration) *tenancyQuotas {
+	if window <= 0 {
+		window = DefaultQuotaWindow
+	}
+	return &tenancyQuotas{
+		limits: limits,
+		window: window,
+		start:  time.Now(),
+		used:   make(map[string]*tenancyUsage),
+	}
+}
+
+// span is the length of a bucket.
+func (q *tenancyQuotas) span() time.Duration {
+	if span := q.window / quotaBuckets; span > 0 {
+		return span
+	}
+	return 1
+}
+
+// roll moves the window on to the bucket of now, dropping the usage of the
+// buckets that left it and the tenancies left without any. Must hold mu.
+func (q *tenancyQuotas) roll(now time.Time) {
+	bucket := int64(now.Sub(q.start) / q.span())
+	if bucket <= q.bucket {
+		return
+	}
+	for tenancy, u := range q.used {
+		if bucket-q.bucket >= quotaBuckets {
+			delete(q.used, tenancy)
+			continue
+		}
+		for b := q.bucket + 1; b <= bucket; b++ {
+			u.total -= u.counts[b%quotaBuckets]
+			u.counts[b%quotaBuckets] = 0
+		}
+		if u.total == 0 {
+			delete(q.used, tenancy)
+		}
+	}
+	q.bucket = bucket
+}
+
+// allow reports whether tenancy is still within its quota. When it isn't, the
+// time until enough of its usage has left the window is returned.
+func (q *tenancyQuotas) allow(tenancy string) (bool, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	q.roll(now)
+	limit, ok := q.limits[tenancy]
+	if !ok {
+		limit, ok = q.limits["*"]
+	}
+	u := q.used[tenancy]
+	if !ok || u == nil || u.total < limit {
+		return true, 0
+	}
+	// The oldest buckets leave the window first.
+	excess := u.total - limit
+	for b := q.bucket - quotaBuckets + 1; b <= q.bucket; b++ {
+		if b < 0 {
+			continue
+		}
+		excess -= u.counts[b%quotaBuckets]
+		if excess < 0 {
+			return false, q.start.Add(time.Duration(b+quotaBuckets) * q.span()).Sub(now)
+		}
+	}
+	return false, q.window
+}
+
+// add records n bytes served to tenancy.
+func (q *tenancyQuotas) add(tenancy string, n int64) {
+	if n <= 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(time.Now())
+	u := q.used[tenancy]
+	if u == nil {
+		u = &tenancyUsage{}
+		q.used[tenancy] = u
+	}
+	u.counts[q.bucket%quotaBuckets] += n
+	u.total += n
+}
+
+// quotaWriter counts the bytes of the response body written through it against
+// the quota of tenancy.
+type quotaWriter struct {
+	http.ResponseWriter
+	quotas  *tenancyQuotas
+	tenancy string
+}
+
+func (c *quotaWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.quotas.add(c.tenancy, int64(n))
+	return n, err
+}
+
+// Flush passes flushes through to the underlying writer when it supports them.
+func (c *quotaWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// usage returns a copy of the bytes served per tenancy in the current window.
+func (q *tenancyQuotas) usage() map[string]int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(time.Now())
+	usage := make(map[string]int64, len(q.used))
+	for tenancy, u := range q.used {
+		usage[tenancy] = u.total
+	}
+	return usage
+}
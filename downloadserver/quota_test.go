@@ -0,0 +1,123 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTenancyQuotas(t *testing.T) {
+	q := newTenancyQuotas(map[string]int64{"ten": 10, "*": 100}, time.Hour)
+	if ok, _ := q.allow("ten"); !ok {
+		t.Fatal("unused quota refused")
+	}
+	// A download in progress counts before it is done.
+	w := &quotaWriter{ResponseWriter: httptest.NewRecorder(), quotas: q, tenancy: "ten"}
+	w.Write(make([]byte, 10))
+	if ok, reset := q.allow("ten"); ok || reset <= 0 || reset > time.Hour {
+		t.Errorf("allow with the quota used = %v %s", ok, reset)
+	}
+	// Other tenancies fall under "*".
+	q.add("other", 99)
+	if ok, _ := q.allow("other"); !ok {
+		t.Error("tenancy under the * quota refused")
+	}
+	q.add("other", 1)
+	if ok, _ := q.allow("other"); ok {
+		t.Error("tenancy over the * quota allowed")
+	}
+	if usage := q.usage(); usage["ten"] != 10 || usage["other"] != 100 {
+		t.Errorf("usage = %v", usage)
+	}
+}
+
+// age moves the quota window of q on by d.
+func (q *tenancyQuotas) age(d time.Duration) {
+	q.mu.Lock()
+	q.start = q.start.Add(-d)
+	q.mu.Unlock()
+}
+
+func TestQuotaSlidingWindow(t *testing.T) {
+	q := newTenancyQuotas(map[string]int64{"ten": 10}, 12*time.Minute)
+	q.add("ten", 4)
+	q.age(6 * time.Minute)
+	q.add("ten", 6)
+	// Usage leaves the window a bucket at a time, the oldest first, instead of
+	// all of it at once at the end of a fixed window.
+	ok, reset := q.allow("ten")
+	if ok || reset <= 5*time.Minute || reset > 6*time.Minute {
+		t.Errorf("allow with the quota used = %v %s, want refused for the 6m until the first bytes leave", ok, reset)
+	}
+	q.age(6 * time.Minute)
+	if ok, _ := q.allow("ten"); !ok {
+		t.Error("quota refused once the first bytes left the window")
+	}
+	if usage := q.usage(); usage["ten"] != 6 {
+		t.Errorf("usage = %v, want the 6 bytes still in the window", usage)
+	}
+	q.age(6 * time.Minute)
+	if usage := q.usage(); len(usage) != 0 {
+		t.Errorf("usage = %v, want nothing left in the window", usage)
+	}
+}
+
+func TestQuotaEviction(t *testing.T) {
+	q := newTenancyQuotas(nil, time.Hour)
+	for i := 0; i < 1000; i++ {
+		q.add(fmt.Sprintf("random-%d", i), 1)
+	}
+	q.add("ten", 0)
+	if n := len(q.usage()); n != 1000 {
+		t.Fatalf("%d tenancies tracked, want 1000", n)
+	}
+	// Tenancies are forgotten once their usage has left the window.
+	q.age(time.Hour)
+	q.add("ten", 1)
+	q.mu.Lock()
+	n := len(q.used)
+	q.mu.Unlock()
+	if n != 1 {
+		t.Errorf("%d tenancies tracked after the window, want only the current one", n)
+	}
+}
+
+func TestLocalDownloadQuota(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quota")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "f.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ds := &DownloadServer{Tenancy: "ten"}
+	ds.quotas = newTenancyQuotas(map[string]int64{"ten": 5}, time.Hour)
+	downloadServer = ds
+
+	rec := httptest.NewRecorder()
+	download(rec, httptest.NewRequest("GET", downloadPath+"?t=ten&a=f.txt&s="+dir, nil))
+	if rec.Code != 200 || rec.Body.String() != "hello" {
+		t.Fatalf("download = %d %q", rec.Code, rec.Body.String())
+	}
+	if used := ds.quotas.usage()["ten"]; used != 5 {
+		t.Errorf("local download counted %d bytes, want 5", used)
+	}
+	rec = httptest.NewRecorder()
+	download(rec, httptest.NewRequest("GET", downloadPath+"?t=ten&a=f.txt&s="+dir, nil))
+	if rec.Code != 429 || !retryAfterWithin(rec, 3600) {
+		t.Errorf("download over the quota = %d Retry-After %q, want 429 within the hour", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	// Downloads without a tenancy have no quota.
+	rec = httptest.NewRecorder()
+	download(rec, httptest.NewRequest("GET", downloadPath+"?a=f.txt&s="+dir, nil))
+	if rec.Code != 200 {
+		t.Errorf("download without t= = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Parallel ranged fetches. To speed up large OCI downloads the object can be
+ * fetched from its PAR as consecutive byte ranges with several ranges in flight
+ * at once. The parts are reassembled in order so the client sees an ordinary,
+ * contiguous 200 response; this is purely a server side throughput optimization
+ * and unrelated to any Range the client sends. The parts are fetched with
+ * If-Match on the ETag of the first one, so that an object overwritten during
+ * the download fails it instead of stitching two versions together.
+ */
+
+// errObjectChanged fails a download whose object changed between its parts.
+var errObjectChanged = errors.New("OCI object changed during the download")
+
+// DefaultOCIPartSize is the size of the parts an object is fetched in when
+// parallel fetching is enabled and OCIPartSize isn't set.
+const DefaultOCIPartSize = 8 << 20
+
+// partRange returns the Range header value for part i of an object of total bytes.
+func partRange(i int, partSize int64, total int64) (string, int64) {
+	start := int64(i) * partSize
+	end := start + partSize
+	if end > total {
+		end = total
+	}
+	return fmt.Sprintf("bytes=%d-%d", start, end-1), end - start
+}
+
+// contentRangeTotal returns the complete length from a Content-Range header such
+// as "bytes 0-1023/4096".
+func contentRangeTotal(contentRange string) (int64, error) {
+	index := strings.LastIndex(contentRange, "/")
+	if !strings.HasPrefix(contentRange, "bytes ") || index < 0 {
+		return 0, fmt.Errorf("unexpected Content-Range: %s", contentRange)
+	}
+	return strconv.ParseInt(contentRange[index+1:], 10, 64)
+}
+
+// assembleParts turns the response to a GET for the first part of the object at
+// url into a response for the whole object, fetching the remaining parts
+// concurrently as the body is read.
+func (ds *DownloadServer) assembleParts(ctx context.Context, cancel context.CancelFunc, url string, first *http.Response) (*http.Response, error) {
+	partSize := ds.partSize()
+	total, err := contentRangeTotal(first.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, err
+	}
+	first.StatusCode = http.StatusOK
+	first.Status = "200 OK"
+	first.ContentLength = total
+	first.Header.Del("Content-Range")
+	first.Header.Set("Content-Length", strconv.FormatInt(total, 10))
+
+	n := int((total + partSize - 1) / partSize)
+	if n <= 1 {
+		first.Body = &cancelOnClose{first.Body, cancel}
+		return first, nil
+	}
+
+	body := &partsBody{
+		current: first.Body,
+		first:   first.Body,
+		parts:   make([]chan partResult, n),
+		slots:   make(chan struct{}, ds.OCIParallelParts),
+		cancel:  cancel,
+	}
+	for i := range body.parts {
+		body.parts[i] = make(chan partResult, 1)
+	}
+	body.next = 1
+	client := ds.upstreamClient()
+	etag := first.Header.Get("ETag")
+	go func() {
+		for i := 1; i < n; i++ {
+			select {
+			case body.slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(i int) {
+				data, err := fetchPart(ctx, client, url, etag, i, partSize, total)
+				body.parts[i] <- partResult{data, err}
+			}(i)
+		}
+	}()
+	first.Body = body
+	return first, nil
+}
+
+// fetchPart fetches part i of the object at url with client. With an etag the
+// part must come from that version of the object.
+func fetchPart(ctx context.Context, client *http.Client, url string, etag string, i int, partSize int64, total int64) ([]byte, error) {
+	rng, length := partRange(i, partSize, total)
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Range", rng)
+	if etag != "" {
+		request.Header.Set("If-Match", etag)
+	}
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusPreconditionFailed || (etag != "" && response.Header.Get("ETag") != "" && response.Header.Get("ETag") != etag) {
+		return nil, errObjectChanged
+	}
+	if response.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("OCI ranged download failed: %s", response.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, length))
+	if err == nil && int64(len(data)) != length {
+		err = io.ErrUnexpectedEOF
+	}
+	return data, err
+}
+
+type partResult struct {
+	data []byte
+	err  error
+}
+
+// partsBody reads the parts of an object in order while the following parts are
+// being fetched. A slot is held by each part from when its fetch starts until it
+// has been read, bounding both the concurrent requests and the buffered data.
+type partsBody struct {
+	current io.Reader
+	first   io.ReadCloser
+	parts   []chan partResult
+	next    int
+	slots   chan struct{}
+	cancel  context.CancelFunc
+}
+
+func (b *partsBody) Read(p []byte) (int, error) {
+	for {
+		if b.current == nil {
+			if b.next >= len(b.parts) {
+				return 0, io.EOF
+			}
+			res := <-b.parts[b.next]
+			b.next++
+			<-b.slots
+			if res.err != nil {
+				return 0, res.err
+			}
+			b.current = bytes.NewReader(res.data)
+		}
+		n, err := b.current.Read(p)
+		if err == io.EOF {
+			b.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (b *partsBody) Close() error {
+	b.cancel()
+	return b.first.Close()
+}
+
+// partSize returns the configured part size for parallel fetches.
+func (ds *DownloadServer) partSize() int64 {
+	if ds.OCIPartSize > 0 {
+		return ds.OCIPartSize
+	}
+	return DefaultOCIPartSize
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestPartRange(t *testing.T) {
+	for _, tc := range []struct {
+		i      int
+		total  int64
+		rng    string
+		length int64
+	}{
+		{0, 10, "bytes=0-3", 4},
+		{1, 10, "bytes=4-7", 4},
+		{2, 10, "bytes=8-9", 2},
+	} {
+		if rng, length := partRange(tc.i, 4, tc.total); rng != tc.rng || length != tc.length {
+			t.Errorf("partRange(%d, 4, %d) = %s %d, want %s %d", tc.i, tc.total, rng, length, tc.rng, tc.length)
+		}
+	}
+	if total, err := contentRangeTotal("bytes 0-3/10"); err != nil || total != 10 {
+		t.Errorf("contentRangeTotal = %d %v", total, err)
+	}
+	if _, err := contentRangeTotal("items 0-3/10"); err == nil {
+		t.Error("unexpected Content-Range accepted")
+	}
+}
+
+func TestParallelParts(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	content := []byte("0123456789abcdefghij")
+	f.put("big.bin", content)
+	ds := f.server()
+	ds.OCIParallelParts = 2
+	ds.OCIPartSize = 6
+
+	var mu sync.Mutex
+	var ifMatch []string
+	f.onParGet = func(r *http.Request) {
+		mu.Lock()
+		ifMatch = append(ifMatch, r.Header.Get("If-Match"))
+		mu.Unlock()
+	}
+	rec := httptest.NewRecorder()
+	download(rec, httptest.NewRequest("GET", downloadPath+"?t=ten&a=big.bin", nil))
+	if rec.Code != 200 || !bytes.Equal(rec.Body.Bytes(), content) {
+		t.Fatalf("download = %d %q", rec.Code, rec.Body.String())
+	}
+	if n := f.count(&f.parGets); n != 4 {
+		t.Errorf("%d PAR GETs, want 4", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i, etag := range ifMatch {
+		if want := map[bool]string{true: "", false: `"v1"`}[i == 0]; etag != want {
+			t.Errorf("GET %d If-Match = %q, want %q", i, etag, want)
+		}
+	}
+}
+
+func TestParallelPartsObjectChanged(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	content := []byte("0123456789abcdefghij")
+	f.put("big.bin", content)
+	ds := f.server()
+	ds.OCIParallelParts = 2
+	ds.OCIPartSize = 6
+
+	// The object is overwritten once its first part has been fetched.
+	var once sync.Once
+	f.onParGet = func(r *http.Request) {
+		if r.Header.Get("If-Match") != "" {
+			once.Do(func() { f.put("big.bin", []byte("ABCDEFGHIJKLMNOPQRST")) })
+		}
+	}
+	rec := httptest.NewRecorder()
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("download of a changed object = %q, panic %v, want it aborted", rec.Body.String(), p)
+		}
+	}()
+	download(rec, httptest.NewRequest("GET", downloadPath+"?t=ten&a=big.bin", nil))
+}
+
+func TestParallelPartsEmptyObject(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("empty.bin", []byte{})
+	ds := f.server()
+	ds.OCIParallelParts = 2
+	ds.OCIPartSize = 6
+
+	rec := httptest.NewRecorder()
+	download(rec, httptest.NewRequest("GET", downloadPath+"?t=ten&a=empty.bin", nil))
+	if rec.Code != 200 || rec.Body.Len() != 0 {
+		t.Errorf("download of an empty object = %d %q, want an empty 200", rec.Code, rec.Body.String())
+	}
+	// The ranged GET is answered 416 and repeated without a range.
+	if len(f.ranges) != 2 || f.ranges[0] == "" || f.ranges[1] != "" {
+		t.Errorf("PAR GET ranges = %q", f.ranges)
+	}
+}
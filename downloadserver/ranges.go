@@ -0,0 +1,260 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Byte ranges. A download can be limited to a single byte range with a Range
+ * header or, for clients that can't set headers, with the offset= and length=
+ * query parameters. Either way the response is a 206 Partial Content with a
+ * Content-Range, or a 416 when the range lies outside the artifact. Multiple
+ * ranges in one request aren't supported; such a Range header is ignored and
+ * the whole artifact sent, as HTTP allows. Downloads only advertise
+ * Accept-Ranges: bytes when a range of them would be served, and otherwise
+ * Accept-Ranges: none.
+ */
+
+// backendRanges reports whether the OCI response stream comes from a backend
+// that serves byte ranges: it advertised them or sent one.
+func backendRanges(stream *http.Response) bool {
+	if stream.Header.Get("Content-Range") != "" {
+		return true
+	}
+	for _, unit := range strings.Split(stream.Header.Get("Accept-Ranges"), ",") {
+		if strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+			return true
+		}
+	}
+	return false
+}
+
+// byteRange is a requested range of bytes. first is -1 for a suffix range of the
+// last last bytes; last is -1 for a range running to the end.
+type byteRange struct {
+	first, last int64
+	// query is set when the range came from offset= and length=, which must
+	// be honoured rather than ignored when the range can't be served.
+	query bool
+}
+
+// parseRange returns the range requested by the Range header or the offset= and
+// length= parameters, nil when none is. Asking for a range in both ways is a
+// conflict and rejected.
+func parseRange(header string, offset string, length string) (*byteRange, error) {
+	if header != "" && (offset != "" || length != "") {
+		return nil, badRequest("offset= and length= can't be combined with a Range header")
+	}
+	if offset != "" || length != "" {
+		rng := &byteRange{first: 0, last: -1, query: true}
+		if offset != "" {
+			n, err := strconv.ParseInt(offset, 10, 64)
+			if err != nil || n < 0 {
+				return nil, badRequest("invalid offset=")
+			}
+			rng.first = n
+		}
+		if length != "" {
+			n, err := strconv.ParseInt(length, 10, 64)
+			if err != nil || n < 1 {
+				return nil, badRequest("invalid length=")
+			}
+			rng.last = rng.first + n - 1
+		}
+		return rng, nil
+	}
+	if header == "" {
+		return nil, nil
+	}
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+	dash := strings.Index(spec, "-")
+	if dash < 0 {
+		return nil, nil
+	}
+	first, last := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+	rng := &byteRange{first: -1, last: -1}
+	var err error
+	if first != "" {
+		if rng.first, err = strconv.ParseInt(first, 10, 64); err != nil || rng.first < 0 {
+			return nil, nil
+		}
+	}
+	if last != "" {
+		if rng.last, err = strconv.ParseInt(last, 10, 64); err != nil || rng.last < 0 {
+			return nil, nil
+		}
+	}
+	if first == "" && last == "" || rng.first >= 0 && rng.last >= 0 && rng.last < rng.first {
+		return nil, nil
+	}
+	return rng, nil
+}
+
+// header returns the range as a Range header value.
+func (rng *byteRange) header() string {
+	switch {
+	case rng.first < 0:
+		return fmt.Sprintf("bytes=-%d", rng.last)
+	case rng.last < 0:
+		return fmt.Sprintf("bytes=%d-", rng.first)
+	}
+	return fmt.Sprintf("bytes=%d-%d", rng.first, rng.last)
+}
+
+// resolve returns the offset and length of the range within an artifact of size
+// bytes. ok is false when the range can't be satisfied.
+func (rng *byteRange) resolve(size int64) (start int64, length int64, ok bool) {
+	if rng.first < 0 {
+		if rng.last == 0 || size == 0 {
+			return 0, 0, false
+		}
+		start = size - rng.last
+		if start < 0 {
+			start = 0
+		}
+		return start, size - start, true
+	}
+	if rng.first >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if rng.last >= 0 && rng.last < end {
+		end = rng.last
+	}
+	return rng.first, end - rng.first + 1, true
+}
+
+// rangeError is returned when the requested range lies outside the artifact,
+// whose size is given when known (-1 otherwise).
+type rangeError struct {
+	size int64
+}
+
+func (e *rangeError) Error() string {
+	return "requested range not satisfiable"
+}
+
+// unsatisfiedRange returns the rangeError for an OCI 416 response, taking the
+// object's size from its Content-Range.
+func unsatisfiedRange(resp *http.Response) error {
+	size := int64(-1)
+	if cr := resp.Header.Get("Content-Range"); strings.HasPrefix(cr, "bytes */") {
+		if n, err := strconv.ParseInt(strings.TrimPrefix(cr, "bytes */"), 10, 64); err == nil {
+			size = n
+		}
+	}
+	return &rangeError{size: size}
+}
+
+// selectRange limits a local artifact opened as f to the requested range.
+func selectRange(a *artifactStream, f io.ReadSeeker, rng *byteRange) error {
+	start, length, ok := rng.resolve(a.size)
+	if !ok {
+		return &rangeError{size: a.size}
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	a.contentRange = fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, a.size)
+	a.body = io.LimitReader(f, length)
+	a.size = length
+	a.digest = ""
+	return nil
+}
+
+// decompressedSizeCacheSize bounds the number of decompressed sizes kept.
+const decompressedSizeCacheSize = 1024
+
+// decompressedSizeCache holds the decompressed sizes of local gzip files keyed by
+// path, valid while the size and modification time of the file are unchanged.
+type decompressedSizeCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedSize
+}
+
+type cachedSize struct {
+	size    int64
+	modTime time.Time
+	value   int64
+}
+
+func newDecompressedSizeCache() *decompressedSizeCache {
+	return &decompressedSizeCache{entries: make(map[string]cachedSize)}
+}
+
+// fileSize returns the decompressed size of the open gzip file f at path. fresh
+// measures it even when it is cached.
+func (c *decompressedSizeCache) fileSize(path string, f *os.File, stat os.FileInfo, fresh bool) (int64, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && !fresh && entry.size == stat.Size() && entry.modTime.Equal(stat.ModTime()) {
+		return entry.value, nil
+	}
+
+	zr, err := gzip.NewReader(io.NewSectionReader(f, 0, stat.Size()))
+	if err != nil {
+		return 0, err
+	}
+	value, err := io.Copy(ioutil.Discard, zr)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	if len(c.entries) >= decompressedSizeCacheSize {
+		c.entries = make(map[string]cachedSize)
+	}
+	c.entries[path] = cachedSize{size: stat.Size(), modTime: stat.ModTime(), value: value}
+	c.mu.Unlock()
+	return value, nil
+}
+
+// selectDecompressedRange limits a local gzip artifact that is sent decompressed
+// to the requested range of the decompressed content. Neither the decompressed
+// size nor where a byte of it lies in the file is known without decompressing,
+// so the file is decompressed once to measure it (cached while the file is
+// unchanged) and everything before the range is decompressed and discarded: a
+// range near the end of a large file costs about as much CPU as the whole file.
+// A range covering all of the content is sent as a plain download.
+func (ds *DownloadServer) selectDecompressedRange(a *artifactStream, f *os.File, stat os.FileInfo, rng *byteRange, fresh bool) error {
+	size, err := ds.gunzipSizes.fileSize(a.name, f, stat, fresh)
+	if err != nil {
+		return err
+	}
+	start, length, ok := rng.resolve(size)
+	if !ok {
+		return &rangeError{size: size}
+	}
+	a.size = length
+	if length == size {
+		return nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(ioutil.Discard, zr, start); err != nil {
+		return err
+	}
+	a.contentRange = fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size)
+	a.body = io.LimitReader(zr, length)
+	return nil
+}
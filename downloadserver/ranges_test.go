@@ -0,0 +1,171 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	for _, tc := range []struct {
+		header, offset, length string
+		want                   *byteRange
+	}{
+		{"", "", "", nil},
+		{"bytes=0-9", "", "", &byteRange{first: 0, last: 9}},
+		{"bytes=5-", "", "", &byteRange{first: 5, last: -1}},
+		{"bytes=-3", "", "", &byteRange{first: -1, last: 3}},
+		{"bytes=0-1,4-5", "", "", nil},
+		{"bytes=9-0", "", "", nil},
+		{"items=0-9", "", "", nil},
+		{"", "4", "", &byteRange{first: 4, last: -1, query: true}},
+		{"", "", "3", &byteRange{first: 0, last: 2, query: true}},
+		{"", "4", "3", &byteRange{first: 4, last: 6, query: true}},
+	} {
+		got, err := parseRange(tc.header, tc.offset, tc.length)
+		if err != nil || (got == nil) != (tc.want == nil) || got != nil && *got != *tc.want {
+			t.Errorf("parseRange(%q, %q, %q) = %+v %v, want %+v", tc.header, tc.offset, tc.length, got, err, tc.want)
+		}
+	}
+	for _, bad := range [][3]string{{"bytes=0-9", "0", ""}, {"", "-1", ""}, {"", "x", ""}, {"", "0", "0"}, {"", "", "-5"}} {
+		if _, err := parseRange(bad[0], bad[1], bad[2]); err == nil {
+			t.Errorf("parseRange(%q, %q, %q) accepted", bad[0], bad[1], bad[2])
+		}
+	}
+}
+
+func TestResolveRange(t *testing.T) {
+	for _, tc := range []struct {
+		rng           byteRange
+		start, length int64
+		ok            bool
+	}{
+		{byteRange{first: 0, last: 3}, 0, 4, true},
+		{byteRange{first: 8, last: 20}, 8, 2, true},
+		{byteRange{first: 4, last: -1}, 4, 6, true},
+		{byteRange{first: -1, last: 3}, 7, 3, true},
+		{byteRange{first: -1, last: 30}, 0, 10, true},
+		{byteRange{first: 10, last: -1}, 0, 0, false},
+		{byteRange{first: -1, last: 0}, 0, 0, false},
+	} {
+		start, length, ok := tc.rng.resolve(10)
+		if start != tc.start || length != tc.length || ok != tc.ok {
+			t.Errorf("%+v.resolve(10) = %d %d %v, want %d %d %v", tc.rng, start, length, ok, tc.start, tc.length, tc.ok)
+		}
+	}
+}
+
+func TestQueryRangeDownload(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.txt": "0123456789"})
+	defer os.RemoveAll(dir)
+	localServer()
+
+	rec := testDownload("GET", "a=f.txt&offset=2&length=3&s="+dir)
+	if rec.Code != 206 || rec.Body.String() != "234" || rec.Header().Get("Content-Range") != "bytes 2-4/10" {
+		t.Errorf("local offset=2&length=3 = %d %q %q", rec.Code, rec.Body.String(), rec.Header().Get("Content-Range"))
+	}
+	rec = testDownload("GET", "a=f.txt&offset=10&s="+dir)
+	if rec.Code != 416 || rec.Header().Get("Content-Range") != "bytes */10" {
+		t.Errorf("local offset=10 = %d %q, want 416", rec.Code, rec.Header().Get("Content-Range"))
+	}
+	rec = testDownload("GET", "a=f.txt&offset=0&s="+dir, "Range", "bytes=0-1")
+	if rec.Code != 400 {
+		t.Errorf("offset= with a Range header = %d, want 400", rec.Code)
+	}
+
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("f.txt", []byte("0123456789"))
+	f.server()
+	rec = testDownload("GET", "t=ten&a=f.txt&offset=7")
+	if rec.Code != 206 || rec.Body.String() != "789" || rec.Header().Get("Content-Range") != "bytes 7-9/10" {
+		t.Errorf("OCI offset=7 = %d %q %q", rec.Code, rec.Body.String(), rec.Header().Get("Content-Range"))
+	}
+	if last := f.ranges[len(f.ranges)-1]; last != "bytes=7-" {
+		t.Errorf("OCI Range = %q, want bytes=7-", last)
+	}
+}
+
+func TestDecompressedRange(t *testing.T) {
+	dir := testStore(t, map[string]string{"log.txt.gz": gzipped(t, "0123456789")})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.GzipPassthrough = true
+	ds.gunzipSizes = newDecompressedSizeCache()
+
+	rec := testDownload("GET", "a=log.txt.gz&s="+dir, "Accept-Encoding", "identity", "Range", "bytes=3-5")
+	if rec.Code != 206 || rec.Body.String() != "345" || rec.Header().Get("Content-Range") != "bytes 3-5/10" {
+		t.Errorf("decompressed range = %d %q %q", rec.Code, rec.Body.String(), rec.Header().Get("Content-Range"))
+	}
+	if rec.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("Accept-Ranges = %q", rec.Header().Get("Accept-Ranges"))
+	}
+	rec = testDownload("GET", "a=log.txt.gz&s="+dir, "Accept-Encoding", "identity", "Range", "bytes=-2")
+	if rec.Code != 206 || rec.Body.String() != "89" {
+		t.Errorf("decompressed suffix range = %d %q", rec.Code, rec.Body.String())
+	}
+	rec = testDownload("GET", "a=log.txt.gz&s="+dir, "Accept-Encoding", "identity", "Range", "bytes=10-")
+	if rec.Code != 416 || rec.Header().Get("Content-Range") != "bytes */10" {
+		t.Errorf("range past the decompressed content = %d %q", rec.Code, rec.Header().Get("Content-Range"))
+	}
+
+	// The cached size is measured again once the file changes.
+	path := filepath.Join(dir, "log.txt.gz")
+	if err := ioutil.WriteFile(path, []byte(gzipped(t, "0123456789abcdef")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec = testDownload("GET", "a=log.txt.gz&s="+dir, "Accept-Encoding", "identity", "Range", "bytes=-3")
+	if rec.Code != 206 || rec.Body.String() != "def" || rec.Header().Get("Content-Range") != "bytes 13-15/16" {
+		t.Errorf("range of the rewritten file = %d %q %q", rec.Code, rec.Body.String(), rec.Header().Get("Content-Range"))
+	}
+}
+
+func TestBackendRanges(t *testing.T) {
+	for _, tc := range []struct {
+		header http.Header
+		want   bool
+	}{
+		{http.Header{"Accept-Ranges": {"bytes"}}, true},
+		{http.Header{"Accept-Ranges": {"none, Bytes"}}, true},
+		{http.Header{"Content-Range": {"bytes 0-3/10"}}, true},
+		{http.Header{"Accept-Ranges": {"none"}}, false},
+		{http.Header{}, false},
+	} {
+		if got := backendRanges(&http.Response{Header: tc.header}); got != tc.want {
+			t.Errorf("backendRanges(%v) = %v, want %v", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestAcceptRanges(t *testing.T) {
+	const digest = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	dir := testStore(t, map[string]string{"f.txt": "hello", "cas/" + digest: "hello"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.CASLayout = "cas/{digest}"
+	ds.FollowIdleTimeout = 10 * time.Millisecond
+	ds.EncryptionKey = testKEK
+	for query, want := range map[string]string{
+		"a=f.txt":              "bytes",
+		"h=sha256&a=" + digest: "none",
+		"a=f.txt&follow=1":     "none",
+		"a=f.txt&encrypt=1":    "none",
+	} {
+		if got := testDownload("GET", query+"&s="+dir).Header().Get("Accept-Ranges"); got != want {
+			t.Errorf("%s: Accept-Ranges = %q, want %q", query, got, want)
+		}
+	}
+
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	f.server()
+	if got := testDownload("GET", "t=ten&a=a/f.txt").Header().Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("OCI Accept-Ranges = %q, want bytes", got)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket allowing rate events per second with bursts of
+// up to burst events.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token, possibly one that only becomes available in the future,
+// and returns how long the caller has to wait before using it. When the wait
+// would be longer than maxWait no token is taken and false is returned along with
+// the time until a token is available.
+func (l *rateLimiter) reserve(maxWait time.Duration) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	var delay time.Duration
+	if l.tokens < 1 {
+		delay = time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		if delay > maxWait {
+			return delay, false
+		}
+	}
+	l.tokens--
+	return delay, true
+}
+
+// wait blocks until the caller may proceed, waiting at most maxWait. A
+// *rateLimitedError is returned when the wait would be longer.
+func (l *rateLimiter) wait(ctx context.Context, maxWait time.Duration) error {
+	delay, ok := l.reserve(maxWait)
+	if !ok {
+		return &rateLimitedError{retryAfter: delay}
+	}
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitedError is returned when a rate limit has been exceeded.
+type rateLimitedError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitedError) Error() string {
+	return "too many artifact requests, try again later"
+}
+
+// retryAfter formats d as a Retry-After value in whole seconds, rounding up so a
+// client never retries too early.
+func retryAfter(d time.Duration) string {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
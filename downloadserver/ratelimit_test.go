@@ -0,0 +1,77 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// retryAfterWithin reports whether rec tells the client to retry after between
+// one and max seconds.
+func retryAfterWithin(rec *httptest.ResponseRecorder, max int) bool {
+	seconds, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	return err == nil && seconds >= 1 && seconds <= max
+}
+
+func TestRetryAfter(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		0:                       "1",
+		100 * time.Millisecond:  "1",
+		1500 * time.Millisecond: "2",
+		3 * time.Second:         "3",
+	} {
+		if got := retryAfter(d); got != want {
+			t.Errorf("retryAfter(%s) = %s, want %s", d, got, want)
+		}
+	}
+}
+
+func TestRateLimitedResponse(t *testing.T) {
+	localServer()
+	rec := httptest.NewRecorder()
+	downloadError(rec, httptest.NewRequest("GET", downloadPath, nil), &rateLimitedError{retryAfter: 2 * time.Second})
+	if rec.Code != 503 || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("rate limited download = %d Retry-After %q, want 503 and 2", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(1, 2)
+	for i := 0; i < 2; i++ {
+		if delay, ok := l.reserve(0); !ok || delay != 0 {
+			t.Fatalf("burst reservation %d = %s %v", i, delay, ok)
+		}
+	}
+	delay, ok := l.reserve(0)
+	if ok || delay <= 0 || delay > time.Second {
+		t.Errorf("reservation past the burst = %s %v, want refused with a wait of up to 1s", delay, ok)
+	}
+	// A caller willing to wait gets a token from the future.
+	if delay, ok := l.reserve(2 * time.Second); !ok || delay <= 0 {
+		t.Errorf("waiting reservation = %s %v", delay, ok)
+	}
+}
+
+func TestPARRateLimit(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/one", []byte("1"))
+	f.put("a/two", []byte("2"))
+	ds := f.server()
+	ds.parLimiter = newRateLimiter(0.01, 1)
+
+	if rec := testDownload("GET", "t=ten&a=a/one"); rec.Code != 200 {
+		t.Fatalf("first download = %d", rec.Code)
+	}
+	rec := testDownload("GET", "t=ten&a=a/two")
+	// The next PAR is allowed in 100s.
+	if rec.Code != 503 || !retryAfterWithin(rec, 100) {
+		t.Errorf("download past the PAR rate limit = %d Retry-After %q, want 503 within 100s", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if n := f.count(&f.pars); n != 1 {
+		t.Errorf("%d PARs created, want 1", n)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ocistorage "github.com/oracle/oci-go-sdk/objectstorage"
+)
+
+/*
+ * Readiness. /healthz only says the process is alive; /readyz says whether the
+ * instance should get traffic. It answers 503 until a backend check, a HEAD of
+ * the bucket, has succeeded, and again after ReadinessFailures checks in a row
+ * have failed, so that a load balancer doesn't route downloads to an instance
+ * that can't reach OCI. The checks run every ReadinessInterval from startup.
+ * Without a bucket configured there is no backend to wait for and the server is
+ * ready from the start. On the way out, Close first spends LameDuckPeriod not
+ * ready while still serving, so that the load balancer has stopped sending
+ * requests by the time the listener is closed.
+ */
+
+// DefaultReadinessInterval is how often the backend is checked when
+// ReadinessInterval isn't set.
+const DefaultReadinessInterval = 10 * time.Second
+
+// DefaultReadinessFailures is the number of consecutive failed backend checks
+// making the server not ready when ReadinessFailures isn't set.
+const DefaultReadinessFailures = 3
+
+// readinessTimeout bounds a single backend check.
+const readinessTimeout = 10 * time.Second
+
+// readinessGate tracks whether the backend checks allow traffic.
+type readinessGate struct {
+	limit    int
+	mu       sync.Mutex
+	ready    bool
+	failures int
+}
+
+func newReadinessGate(limit int) *readinessGate {
+	if limit < 1 {
+		limit = DefaultReadinessFailures
+	}
+	return &readinessGate{limit: limit}
+}
+
+// succeeded records a successful backend check, reporting whether it made the
+// server ready.
+func (g *readinessGate) succeeded() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.failures = 0
+	changed := !g.ready
+	g.ready = true
+	return changed
+}
+
+// failed records a failed backend check, reporting whether it made the server
+// not ready.
+func (g *readinessGate) failed() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.failures++
+	if g.ready && g.failures >= g.limit {
+		g.ready = false
+		return true
+	}
+	return false
+}
+
+// readiness is the JSON document served by /readyz.
+type readiness struct {
+	Status      string `json:"status"`
+	Maintenance bool   `json:"maintenance"`
+	// Failures is the number of consecutive failed backend checks, whose
+	// errors are logged rather than served.
+	Failures int `json:"failures"`
+}
+
+// report returns the readiness state.
+func (g *readinessGate) report() (bool, readiness) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	r := readiness{Status: "ready", Failures: g.failures}
+	if !g.ready {
+		r.Status = "not ready"
+	}
+	return g.ready, r
+}
+
+// checkBackend heads the bucket, failing over between the regions.
+func (ds *DownloadServer) checkBackend(ctx context.Context) error {
+	var err error
+	for _, region := range ds.regionOrder() {
+		var client ocistorage.ObjectStorageClient
+		if client, err = ds.objectStorageClient(region); err != nil {
+			continue
+		}
+		if _, err = client.HeadBucket(ctx, ocistorage.HeadBucketRequest{
+			NamespaceName: &ds.Namespace,
+			BucketName:    &ds.BucketName,
+		}); err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// watchReadiness checks the backend at once and then every ReadinessInterval
+// until stop is closed, logging every change of readiness.
+func (ds *DownloadServer) watchReadiness(stop <-chan struct{}) {
+	interval := ds.ReadinessInterval
+	if interval <= 0 {
+		interval = DefaultReadinessInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), readinessTimeout)
+		err := ds.checkBackend(ctx)
+		cancel()
+		if err == nil {
+			if ds.readiness.succeeded() {
+				ds.logger().Info("Backend check succeeded, server is ready", nil)
+			}
+		} else if ds.readiness.failed() {
+			ds.logger().Error("Backend checks failing, server is not ready", Fields{"failures": ds.readiness.limit, "error": err.Error()})
+		} else {
+			ds.logger().Warn("Backend check failed", Fields{"error": err.Error()})
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// lameDuckWait fails /readyz for LameDuckPeriod when the server is serving. Only
+// the first call waits.
+func (ds *DownloadServer) lameDuckWait() {
+	ds.mu.Lock()
+	serving := ds.server != nil
+	ds.mu.Unlock()
+	if ds.LameDuckPeriod <= 0 || !serving || !atomic.CompareAndSwapInt32(&ds.lameDuck, 0, 1) {
+		return
+	}
+	ds.logger().Info("Lame-duck period started, reporting not ready before shutdown", Fields{"period": ds.LameDuckPeriod.String()})
+	time.Sleep(ds.LameDuckPeriod)
+}
+
+// Readyz handler. Answers 200 while the backend checks allow traffic and 503
+// Service Unavailable otherwise, while OCI rejects the credentials and during
+// the lame-duck period.
+func readyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		httpError(w, r, "protocol error", http.StatusMethodNotAllowed)
+		return
+	}
+	ready, state := true, readiness{Status: "ready"}
+	if downloadServer.readiness != nil {
+		ready, state = downloadServer.readiness.report()
+	}
+	if downloadServer.creds.invalid() {
+		ready, state.Status = false, "backend authentication failed"
+	}
+	if atomic.LoadInt32(&downloadServer.lameDuck) == 1 {
+		ready, state.Status = false, "shutting down"
+	}
+	state.Maintenance = downloadServer.InMaintenance()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(state)
+}
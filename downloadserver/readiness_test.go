@@ -0,0 +1,228 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testReadyz calls the readyz handler and decodes its document.
+func testReadyz(t *testing.T) (int, readiness) {
+	rec := httptest.NewRecorder()
+	readyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+	var state readiness
+	if err := json.NewDecoder(rec.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, state
+}
+
+// waitReadyz polls /readyz until it answers want.
+func waitReadyz(t *testing.T, want int) readiness {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code, state := testReadyz(t)
+		if code == want {
+			return state
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("readyz = %d %+v, want %d", code, state, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReadinessGate(t *testing.T) {
+	g := newReadinessGate(2)
+	if ready, _ := g.report(); ready {
+		t.Error("gate ready before any check")
+	}
+	if g.failed() {
+		t.Error("failure before readiness changed the state")
+	}
+	if !g.succeeded() || g.succeeded() {
+		t.Error("only the first success should make the gate ready")
+	}
+	if g.failed() {
+		t.Error("one failure of two made the gate not ready")
+	}
+	if !g.failed() {
+		t.Error("two failures in a row didn't make the gate not ready")
+	}
+	if ready, state := g.report(); ready || state.Status != "not ready" || state.Failures != 2 {
+		t.Errorf("report = %v %+v", ready, state)
+	}
+	if newReadinessGate(0).limit != DefaultReadinessFailures {
+		t.Error("limit doesn't default to DefaultReadinessFailures")
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	localServer()
+	if code, state := testReadyz(t); code != 200 || state.Status != "ready" {
+		t.Errorf("readyz without a bucket = %d %+v", code, state)
+	}
+
+	f := newFakeOCI(t)
+	defer f.Close()
+	ds := f.server()
+	ds.ReadinessInterval = 5 * time.Millisecond
+	ds.readiness = newReadinessGate(2)
+	if code, _ := testReadyz(t); code != 503 {
+		t.Errorf("readyz before a backend check = %d, want 503", code)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go ds.watchReadiness(stop)
+	waitReadyz(t, 200)
+
+	f.mu.Lock()
+	f.status = 500
+	f.mu.Unlock()
+	if state := waitReadyz(t, 503); state.Status != "not ready" || state.Failures < 2 {
+		t.Errorf("readyz with failing checks = %+v", state)
+	}
+	f.mu.Lock()
+	f.status = 0
+	f.mu.Unlock()
+	waitReadyz(t, 200)
+}
+
+func TestLameDuckPeriod(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.txt": "hello"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.LameDuckPeriod = 100 * time.Millisecond
+
+	// A server that isn't serving stops at once.
+	start := time.Now()
+	ds.Close()
+	if elapsed := time.Since(start); elapsed >= ds.LameDuckPeriod {
+		t.Errorf("Close without serving took %s", elapsed)
+	}
+
+	// A download started before Close completes while new connections are
+	// refused.
+	ds = localServer()
+	ds.LameDuckPeriod = 100 * time.Millisecond
+	started, release := make(chan struct{}), make(chan struct{})
+	addr := slowServer(t, ds, started, release)
+	slow := make(chan string, 1)
+	go func() {
+		resp, err := (&http.Client{Transport: &http.Transport{}}).Get("http://" + addr + downloadPath + "?a=f.txt&s=" + dir)
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		slow <- fmt.Sprintf("%d %s", resp.StatusCode, body)
+	}()
+	<-started
+	closed := make(chan time.Duration, 1)
+	start = time.Now()
+	go func() {
+		ds.Close()
+		closed <- time.Since(start)
+	}()
+	if state := waitReadyz(t, 503); state.Status != "shutting down" {
+		t.Errorf("readyz during the lame-duck period = %+v", state)
+	}
+	if rec := testDownload("GET", "a=f.txt&s="+dir); rec.Code != 200 {
+		t.Errorf("download during the lame-duck period = %d", rec.Code)
+	}
+	waitRefused(t, addr)
+	select {
+	case <-closed:
+		t.Fatal("Close returned with a download in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if got := <-slow; got != "200 hello" {
+		t.Errorf("download in flight = %s, want it completed", got)
+	}
+	if elapsed := <-closed; elapsed < ds.LameDuckPeriod {
+		t.Errorf("Close took %s, want the %s lame-duck period", elapsed, ds.LameDuckPeriod)
+	}
+	// Only the first Close waits.
+	start = time.Now()
+	ds.Close()
+	if elapsed := time.Since(start); elapsed >= ds.LameDuckPeriod {
+		t.Errorf("second Close took %s", elapsed)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.txt": "hello"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.DrainTimeout = 50 * time.Millisecond
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	addr := slowServer(t, ds, started, release)
+	failed := make(chan error, 1)
+	go func() {
+		resp, err := (&http.Client{Transport: &http.Transport{}}).Get("http://" + addr + downloadPath + "?a=f.txt&s=" + dir)
+		if err == nil {
+			resp.Body.Close()
+		}
+		failed <- err
+	}()
+	<-started
+
+	// A download still running after the drain timeout is cut.
+	start := time.Now()
+	ds.Close()
+	if elapsed := time.Since(start); elapsed < ds.DrainTimeout || elapsed > 5*time.Second {
+		t.Errorf("Close took %s with a %s drain timeout", elapsed, ds.DrainTimeout)
+	}
+	if err := <-failed; err == nil {
+		t.Error("download cut by the drain timeout succeeded")
+	}
+}
+
+// slowServer serves ds on a local port, returning its address. Each request is
+// held until release is closed, after closing started for the first one.
+func slowServer(t *testing.T, ds *DownloadServer, started chan struct{}, release chan struct{}) string {
+	ln, err := ds.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var once sync.Once
+	server := ds.httpServer("")
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(started) })
+		<-release
+		download(w, r)
+	})
+	ds.mu.Lock()
+	ds.server = server
+	ds.mu.Unlock()
+	go server.Serve(ln)
+	return ln.Addr().String()
+}
+
+// waitRefused waits until connections to addr are refused.
+func waitRefused(t *testing.T, addr string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			return
+		}
+		c.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("connections still accepted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+/*
+ * Redirect mode. With mode=redirect an OCI download is answered with a 302 to a
+ * PAR for the object, and with mode=url the PAR is returned in a JSON document,
+ * so the client downloads directly from OCI instead of through this server. The
+ * client has to get to the PAR first, so these PARs live for RedirectPARTTL
+ * rather than the short lifetime of the PARs used for proxied downloads. They
+ * can't be deleted after use; expired PARs are removed by the housekeeping done
+ * whenever a PAR is created. With RedirectPARGrace set the sweeper deletes them
+ * once the grace window has passed instead, which lets RedirectPARTTL be
+ * generous for slow clients without the PARs outliving their use by as much.
+ * The grace window is counted from the creation of the PAR, which concurrent
+ * requests for the same object may share.
+ */
+
+// DefaultRedirectPARTTL is the lifetime of the PARs handed out in redirect mode
+// when RedirectPARTTL isn't set.
+const DefaultRedirectPARTTL = 15 * time.Minute
+
+// Download modes selected with mode=.
+const (
+	modeRedirect = "redirect"
+	modeURL      = "url"
+	modeURLs     = "urls"
+)
+
+// parLink is the JSON document returned for mode=url.
+type parLink struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// redirectOCIArtifact creates a PAR for artifact and sends the client to it.
+func (ds *DownloadServer) redirectOCIArtifact(w http.ResponseWriter, r *http.Request, artifact string, mode string) error {
+	object := ds.ociObjectName(artifact)
+	ttl := ds.RedirectPARTTL
+	if ttl <= 0 {
+		ttl = DefaultRedirectPARTTL
+	}
+	ctx := r.Context()
+	if ds.OCITimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ds.OCITimeout)
+		defer cancel()
+	}
+
+	url, err := ds.redirectLink(ctx, object, ttl)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded && r.Context().Err() == nil {
+			return errOCITimeout
+		}
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if mode == modeURL {
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(parLink{URL: url, Expires: ds.redirectExpiry(ttl)})
+	}
+	http.Redirect(w, r, url, http.StatusFound)
+	return nil
+}
+
+// redirectExpiry returns when a PAR handed out now with ttl stops being usable,
+// the earlier of its expiry and the end of its grace window.
+func (ds *DownloadServer) redirectExpiry(ttl time.Duration) time.Time {
+	if ds.RedirectPARGrace > 0 && ds.RedirectPARGrace < ttl {
+		ttl = ds.RedirectPARGrace
+	}
+	return time.Now().Add(ttl).UTC()
+}
+
+// redirectLink creates a PAR for object valid for ttl, failing over between the
+// regions.
+func (ds *DownloadServer) redirectLink(ctx context.Context, object string, ttl time.Duration) (string, error) {
+	var url string
+	var err error
+	for _, region := range ds.regionOrder() {
+		if url, err = ds.redirectPAR(ctx, region, object, ttl); err == nil {
+			if ds.regions != nil {
+				ds.regions.succeeded(region)
+			}
+			return url, nil
+		}
+		if _, limited := err.(*rateLimitedError); limited || ctx.Err() != nil {
+			break
+		}
+		if ds.regions != nil {
+			ds.regions.failed(region)
+		}
+	}
+	return "", err
+}
+
+// redirectPAR creates a PAR for object in region valid for ttl, subject to the
+// PAR creation rate limit. Concurrent requests for the same object share a
+// single PAR creation.
+func (ds *DownloadServer) redirectPAR(ctx context.Context, region string, object string, ttl time.Duration) (string, error) {
+	key := "redirect/" + region + "/" + object
+	for {
+		url, err, shared := ds.parFlight.do(key, func() (string, error) {
+			if ds.parLimiter != nil {
+				if err := ds.parLimiter.wait(ctx, ds.PARRateWait); err != nil {
+					return "", err
+				}
+			}
+			url, err := ds.createRegionPAR(ctx, region, newRedirectPARName(), object, ttl)
+			if err != nil && ctx.Err() != nil {
+				return "", errPARAbandoned
+			}
+			return url, err
+		})
+		if shared && err == errPARAbandoned && ctx.Err() == nil {
+			continue
+		}
+		return url, err
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedirectMode(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	ds := f.server()
+	ds.RedirectPARTTL = 5 * time.Minute
+
+	rec := testDownload("GET", "t=ten&a=a/f.txt&mode=redirect")
+	location := rec.Header().Get("Location")
+	if rec.Code != 302 || !strings.HasPrefix(location, f.URL+"/p/") || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("mode=redirect = %d Location %q", rec.Code, location)
+	}
+	// The client downloads from OCI itself.
+	resp, err := f.Client().Get(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(content) != "hello" {
+		t.Errorf("download from the PAR = %q", content)
+	}
+	f.mu.Lock()
+	expires := f.parList[0].Expires
+	f.mu.Unlock()
+	if until := time.Until(expires); until < 4*time.Minute || until > 5*time.Minute {
+		t.Errorf("redirect PAR expires in %s, want RedirectPARTTL", until)
+	}
+	if n := f.count(&f.parGets); n != 1 {
+		t.Errorf("%d PAR GETs, want only the client's", n)
+	}
+}
+
+func TestURLMode(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	ds := f.server()
+	ds.RedirectPARTTL = 5 * time.Minute
+
+	rec := testDownload("GET", "t=ten&a=a/f.txt&mode=url")
+	var link parLink
+	if err := json.Unmarshal(rec.Body.Bytes(), &link); err != nil || rec.Code != 200 {
+		t.Fatalf("mode=url = %d %q", rec.Code, rec.Body.String())
+	}
+	if !strings.HasPrefix(link.URL, f.URL+"/p/") || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("mode=url link = %+v", link)
+	}
+	if until := time.Until(link.Expires); until < 4*time.Minute || until > 5*time.Minute {
+		t.Errorf("link expires in %s, want RedirectPARTTL", until)
+	}
+}
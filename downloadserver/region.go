@@ -0,0 +1,135 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+/*
+ * Region failover. Deployments that replicate the bucket across regions can
+ * list several regions. Each OCI download tries the regions in order, skipping
+ * ahead past regions that have failed repeatedly in the recent past, and fails
+ * over to the next region when PAR creation or the GET from the PAR fails.
+ */
+
+// regionFailureThreshold is the number of consecutive failures after which a
+// region is considered unhealthy.
+const regionFailureThreshold = 3
+
+// regionRetryAfter is how long an unhealthy region is passed over before it is
+// tried first again.
+const regionRetryAfter = 30 * time.Second
+
+// regionStatus is the health of a region as reported by the stats endpoint.
+type regionStatus struct {
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Failures            int64     `json:"failures"`
+	Successes           int64     `json:"successes"`
+	LastFailure         time.Time `json:"lastFailure,omitempty"`
+}
+
+// regionHealth tracks recent failures of the configured regions.
+type regionHealth struct {
+	mu      sync.Mutex
+	regions []string
+	status  map[string]*regionStatus
+}
+
+func newRegionHealth(regions []string) *regionHealth {
+	h := &regionHealth{regions: regions, status: make(map[string]*regionStatus)}
+	for _, region := range regions {
+		h.status[region] = &regionStatus{}
+	}
+	return h
+}
+
+// healthy reports whether s should be tried ahead of the other regions.
+func (s *regionStatus) healthy() bool {
+	return s.ConsecutiveFailures < regionFailureThreshold || time.Since(s.LastFailure) >= regionRetryAfter
+}
+
+// order returns the regions to try: the healthy ones in configured order followed
+// by the unhealthy ones as a last resort.
+func (h *regionHealth) order() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var healthy, unhealthy []string
+	for _, region := range h.regions {
+		if h.status[region].healthy() {
+			healthy = append(healthy, region)
+		} else {
+			unhealthy = append(unhealthy, region)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+func (h *regionHealth) succeeded(region string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.status[region]
+	s.ConsecutiveFailures = 0
+	s.Successes++
+}
+
+func (h *regionHealth) failed(region string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.status[region]
+	s.ConsecutiveFailures++
+	s.Failures++
+	s.LastFailure = time.Now()
+}
+
+// report returns a snapshot of the health of every region.
+func (h *regionHealth) report() map[string]regionStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	report := make(map[string]regionStatus, len(h.status))
+	for region, s := range h.status {
+		r := *s
+		r.Healthy = s.healthy()
+		report[region] = r
+	}
+	return report
+}
+
+// regionOrder returns the regions to try for an OCI download. Without a list of
+// regions the download only uses Region.
+func (ds *DownloadServer) regionOrder() []string {
+	if ds.regions == nil {
+		return []string{ds.Region}
+	}
+	return ds.regions.order()
+}
+
+// regionPattern matches OCI region identifiers, such as us-phoenix-1, and the
+// three letter region keys, such as phx, that the OCI SDK also accepts.
+var regionPattern = regexp.MustCompile(`^([a-z]+(-[a-z]+)+-[0-9]+|[a-z]{3})$`)
+
+// validateRegions checks the configured regions at startup, since a missing or
+// malformed region only shows up later as an obscure DNS error for a malformed
+// endpoint. OCIEndpoint replaces the endpoint of the primary region, so its name
+// isn't checked then. A server without a tenancy only serves local files and
+// needs no region.
+func (ds *DownloadServer) validateRegions() error {
+	if ds.OCIEndpoint == "" {
+		if ds.Region == "" && ds.Tenancy != "" {
+			return fmt.Errorf("no OCI region: set WERCKER_OCI_REGION or an OCI endpoint")
+		}
+		if ds.Region != "" && !regionPattern.MatchString(ds.Region) {
+			return fmt.Errorf("invalid OCI region: %q", ds.Region)
+		}
+	}
+	for _, region := range ds.Regions {
+		if region != ds.Region && !regionPattern.MatchString(region) {
+			return fmt.Errorf("invalid OCI region: %q", region)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestValidateRegions(t *testing.T) {
+	for _, tc := range []struct {
+		ds *DownloadServer
+		ok bool
+	}{
+		{&DownloadServer{Tenancy: "ten", Region: "us-phoenix-1"}, true},
+		{&DownloadServer{Tenancy: "ten", Region: "phx"}, true},
+		{&DownloadServer{Tenancy: "ten", Region: "ap-chiyoda-1"}, true},
+		{&DownloadServer{Tenancy: "ten"}, false},
+		{&DownloadServer{Tenancy: "ten", Region: "US-Phoenix-1"}, false},
+		{&DownloadServer{Tenancy: "ten", Region: "us-phoenix"}, false},
+		{&DownloadServer{Tenancy: "ten", Region: "https://objectstorage.us-phoenix-1.oraclecloud.com"}, false},
+		// Local only servers need no region.
+		{&DownloadServer{}, true},
+		// The endpoint stands in for the primary region.
+		{&DownloadServer{Tenancy: "ten", OCIEndpoint: "https://localhost:8443"}, true},
+		{&DownloadServer{Tenancy: "ten", Region: "local", OCIEndpoint: "https://localhost:8443"}, true},
+		{&DownloadServer{Tenancy: "ten", Region: "us-phoenix-1", Regions: []string{"us-phoenix-1", "us-ashburn-1"}}, true},
+		{&DownloadServer{Tenancy: "ten", Region: "us-phoenix-1", Regions: []string{"us-phoenix-1", "ashburn"}}, false},
+		{&DownloadServer{Tenancy: "ten", Region: "local", OCIEndpoint: "https://localhost:8443", Regions: []string{"local", "bogus region"}}, false},
+	} {
+		if err := tc.ds.validateRegions(); (err == nil) != tc.ok {
+			t.Errorf("validateRegions(region %q, regions %q, endpoint %q) = %v", tc.ds.Region, tc.ds.Regions, tc.ds.OCIEndpoint, err)
+		}
+	}
+}
+
+func TestRegionOrder(t *testing.T) {
+	ds := &DownloadServer{Region: "us-phoenix-1"}
+	if order := ds.regionOrder(); fmt.Sprint(order) != "[us-phoenix-1]" {
+		t.Errorf("order without regions = %v", order)
+	}
+
+	ds.regions = newRegionHealth([]string{"us-phoenix-1", "us-ashburn-1", "eu-frankfurt-1"})
+	for i := 0; i < regionFailureThreshold; i++ {
+		ds.regions.failed("us-phoenix-1")
+	}
+	if order := ds.regionOrder(); fmt.Sprint(order) != "[us-ashburn-1 eu-frankfurt-1 us-phoenix-1]" {
+		t.Errorf("order with us-phoenix-1 unhealthy = %v", order)
+	}
+	ds.regions.succeeded("us-phoenix-1")
+	if order := ds.regionOrder(); fmt.Sprint(order) != "[us-phoenix-1 us-ashburn-1 eu-frankfurt-1]" {
+		t.Errorf("order after a success = %v", order)
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DownloadRequest is a download request decoded from its query parameters.
+type DownloadRequest struct {
+	// Artifacts are the a= artifacts. In content addressed mode they have
+	// been mapped from the digest to the storage location.
+	Artifacts []string
+	// StorePath is the s= local storepath, empty for OCI downloads.
+	StorePath string
+	// Tenancy and Namespace are the t= and n= OCI specifiers.
+	Tenancy   string
+	Namespace string
+	// Digest is the expected sha256 of a content addressed (h=sha256) download.
+	Digest string
+	// Archive is the archive= format several artifacts are bundled in.
+	Archive string
+	// Entry is the entry= to extract from a tar artifact.
+	Entry string
+	// Entries are the entries= patterns selecting the entries of a tar
+	// artifact sent as a new tar.
+	Entries []string
+	// Manifest (manifest=1) lists the entries of an archive artifact instead
+	// of downloading it.
+	Manifest bool
+	// Chunks (chunks=1) returns the SHA-256 of each chunk of the artifact
+	// instead of downloading it.
+	Chunks bool
+	// Exists (exists=1) only reports whether the artifact exists.
+	Exists bool
+	// Info (info=1) describes the artifact as JSON instead of downloading it.
+	Info bool
+	// Parts is the number of parts (parts=N) of an artifact split across
+	// OCI objects, partsAuto for parts=auto and zero for a whole artifact.
+	Parts int
+	// Mode is the mode= in which a PAR is handed out instead of streaming.
+	Mode string
+	// Prefix (prefix=1) makes mode=urls list the objects under the a= prefix
+	// instead of taking the a= artifacts as the members.
+	Prefix bool
+	// Fallback is set for fallback=local with both a storepath and a tenancy:
+	// OCI is tried first and the local copy served when that fails.
+	Fallback bool
+	// Trailers, Follow and Encrypt are set by trailers=1, follow=1 and
+	// encrypt=1.
+	Trailers bool
+	Follow   bool
+	Encrypt  bool
+	// AcceptGzip is set when the client accepts a gzip encoded response, as
+	// told by encoding= or else by Accept-Encoding.
+	AcceptGzip bool
+	// Resume is the resume= of a resumable local download, resumeStart to
+	// start a session or the token of the session to carry on with.
+	Resume string
+	// NoStore is set by Cache-Control: no-store, bypassing the server side
+	// caches.
+	NoStore bool
+	// Range is the byte range requested with a Range header or offset= and
+	// length=, nil for the whole artifact.
+	Range *byteRange
+	// ClientName is the common name of the verified TLS client certificate,
+	// when clients authenticate with one.
+	ClientName string
+}
+
+// Local reports whether the artifacts are served from the local storepath
+// without trying OCI first.
+func (req *DownloadRequest) Local() bool {
+	return req.StorePath != "" && !req.Fallback
+}
+
+// transferOptions returns the options controlling how the artifact is sent.
+func (req *DownloadRequest) transferOptions() transferOptions {
+	return transferOptions{
+		digest:     req.Digest,
+		trailers:   req.Trailers,
+		entry:      req.Entry,
+		entries:    req.Entries,
+		follow:     req.Follow,
+		encrypt:    req.Encrypt,
+		acceptGzip: req.AcceptGzip,
+		noStore:    req.NoStore,
+		resume:     req.Resume,
+		byteRange:  req.Range,
+	}
+}
+
+// badRequest is a request validation error, answered with 400 Bad Request.
+func badRequest(msg string) error {
+	return &statusError{http.StatusBadRequest, msg}
+}
+
+// DefaultMaxQueryLength is the --max-query-length default, in bytes.
+const DefaultMaxQueryLength = 8192
+
+// checkQueryLength refuses a query string longer than MaxQueryLength with 414
+// URI Too Long.
+func (ds *DownloadServer) checkQueryLength(r *http.Request) error {
+	if ds.MaxQueryLength > 0 && len(r.URL.RawQuery) > ds.MaxQueryLength {
+		return &statusError{http.StatusRequestURITooLong, "query string too long"}
+	}
+	return nil
+}
+
+// parseDownloadRequest decodes and validates the query parameters of a download
+// request. Any problem with them is returned as a 400 Bad Request error.
+func (ds *DownloadServer) parseDownloadRequest(r *http.Request) (*DownloadRequest, error) {
+	parms, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+	req := &DownloadRequest{
+		Artifacts:  parms["a"],
+		StorePath:  parms.Get("s"),
+		Tenancy:    parms.Get("t"),
+		Namespace:  parms.Get("n"),
+		Archive:    parms.Get("archive"),
+		Entry:      parms.Get("entry"),
+		Mode:       parms.Get("mode"),
+		Trailers:   parms.Get("trailers") == "1",
+		Follow:     parms.Get("follow") == "1",
+		Encrypt:    parms.Get("encrypt") == "1",
+		Manifest:   parms.Get("manifest") == "1",
+		Prefix:     parms.Get("prefix") == "1",
+		Chunks:     parms.Get("chunks") == "1",
+		Resume:     parms.Get("resume"),
+		Exists:     parms.Get("exists") == "1",
+		Info:       parms.Get("info") == "1",
+		AcceptGzip: acceptsEncoding(r, "gzip"),
+		NoStore:    requestsNoStore(r),
+		ClientName: clientName(r),
+	}
+	// Without a= the artifact can be laid out by the artifact template.
+	if len(req.Artifacts) == 0 && ds.template != nil {
+		artifact, err := ds.template.expand(parms)
+		if err != nil {
+			return nil, err
+		}
+		req.Artifacts = []string{artifact}
+	}
+	if len(req.Artifacts) < 1 || req.Artifacts[0] == "" {
+		return nil, badRequest("missing artifact a=")
+	}
+	// The artifact is served from the storepath or from the tenancy's bucket.
+	if req.StorePath == "" && req.Tenancy == "" {
+		return nil, badRequest("missing OCI specifier t= or storepath s=")
+	}
+	// encoding= stands in for an Accept-Encoding that proxies strip or mangle.
+	if encoding := parms["encoding"]; len(encoding) > 0 {
+		switch encoding[0] {
+		case "gzip":
+			req.AcceptGzip = true
+		case "none":
+			req.AcceptGzip = false
+		default:
+			return nil, badRequest("unsupported encoding=")
+		}
+	}
+	if entries := parms.Get("entries"); entries != "" {
+		if req.Entries, err = parseEntryPatterns(entries); err != nil {
+			return nil, err
+		}
+	}
+	if parts := parms.Get("parts"); parts != "" {
+		if req.Parts, err = parsePartCount(parts); err != nil {
+			return nil, err
+		}
+	}
+
+	// In content addressed mode the artifact is a digest that is mapped to a storage
+	// location and verified while streaming.
+	if hash := parms["h"]; len(hash) > 0 {
+		if hash[0] != "sha256" {
+			return nil, badRequest("unsupported hash h=")
+		}
+		req.Digest = req.Artifacts[0]
+		if !validSHA256(req.Digest) {
+			return nil, badRequest("artifact a= is not a sha256 digest")
+		}
+		req.Artifacts = []string{ds.casObjectName(req.Digest)}
+	}
+
+	if req.Range, err = parseRange(r.Header.Get("Range"), parms.Get("offset"), parms.Get("length")); err != nil {
+		return nil, err
+	}
+	// A range the download can't be limited to is ignored when it came from
+	// the Range header.
+	if req.Range != nil && !req.Range.query && len(conflictingOptions(req, optionRange)) > 0 {
+		req.Range = nil
+	}
+
+	// archive=tar bundles every a= artifact into a single tar download.
+	if req.Archive != "" && req.Archive != "tar" {
+		return nil, badRequest("unsupported archive format")
+	}
+	if req.Encrypt && len(ds.EncryptionKey) == 0 {
+		return nil, badRequest("encrypt=1 is not available for this download")
+	}
+	if err := checkOptionCombinations(req); err != nil {
+		return nil, err
+	}
+
+	// fallback=local serves the local copy when the OCI download fails, so it
+	// needs both of them.
+	if fallback := parms["fallback"]; len(fallback) > 0 {
+		if fallback[0] != "local" {
+			return nil, badRequest("unsupported fallback=")
+		}
+		if req.StorePath == "" || req.Tenancy == "" {
+			return nil, badRequest("fallback=local requires both t= and s=")
+		}
+		req.Fallback = true
+	}
+
+	if req.Parts != 0 && req.StorePath != "" {
+		return nil, badRequest("parts= is only supported for OCI downloads")
+	}
+	if req.Follow && !req.Local() {
+		return nil, badRequest("follow=1 is only supported for local downloads")
+	}
+	if req.Resume != "" && !req.Local() {
+		return nil, badRequest("resume= is only supported for local downloads")
+	}
+
+	// mode=redirect and mode=url hand the client a PAR instead of streaming, and
+	// mode=urls one for each member.
+	if req.Mode != "" && req.Mode != modeRedirect && req.Mode != modeURL && req.Mode != modeURLs {
+		return nil, badRequest("unsupported mode")
+	}
+	if req.Mode != "" && req.Local() {
+		return nil, badRequest("mode= is only supported for OCI downloads")
+	}
+	if req.Mode != "" && ds.sseKey != nil {
+		return nil, badRequest("mode= is not available for objects encrypted with a customer key")
+	}
+	if req.Prefix && (req.Mode != modeURLs || len(req.Artifacts) > 1 || req.Digest != "") {
+		return nil, badRequest("prefix=1 requires mode=urls and a single a= prefix")
+	}
+	return req, nil
+}
+
+// requestOption is a download option that some of the others exclude.
+type requestOption struct {
+	name string
+	set  func(req *DownloadRequest) bool
+}
+
+// optionRange names a byte range, which the Range header gives as well as
+// offset= and length=.
+const optionRange = "offset= and length="
+
+// requestOptions are the options that exclude one another, in the order they
+// are reported in.
+var requestOptions = []requestOption{
+	{"h=", func(req *DownloadRequest) bool { return req.Digest != "" }},
+	{"archive=", func(req *DownloadRequest) bool { return req.Archive != "" }},
+	{"entry=", func(req *DownloadRequest) bool { return req.Entry != "" }},
+	{"entries=", func(req *DownloadRequest) bool { return req.Entries != nil }},
+	{"manifest=1", func(req *DownloadRequest) bool { return req.Manifest }},
+	{"chunks=1", func(req *DownloadRequest) bool { return req.Chunks }},
+	{"exists=1", func(req *DownloadRequest) bool { return req.Exists }},
+	{"info=1", func(req *DownloadRequest) bool { return req.Info }},
+	{"mode=", func(req *DownloadRequest) bool { return req.Mode != "" }},
+	{"parts=", func(req *DownloadRequest) bool { return req.Parts != 0 }},
+	{"resume=", func(req *DownloadRequest) bool { return req.Resume != "" }},
+	{"follow=1", func(req *DownloadRequest) bool { return req.Follow }},
+	{"encrypt=1", func(req *DownloadRequest) bool { return req.Encrypt }},
+	{optionRange, func(req *DownloadRequest) bool { return req.Range != nil }},
+}
+
+// excludedOptions lists the options that can't be combined with each option.
+// A pair is listed once, under the option that comes first in requestOptions;
+// the exclusion holds both ways.
+var excludedOptions = map[string][]string{
+	"h=":         {"archive=", "entry=", "entries=", "chunks=1", "parts=", "resume=", "follow=1", optionRange},
+	"archive=":   {"entry=", "entries=", "manifest=1", "chunks=1", "exists=1", "info=1", "mode=", "parts=", "resume=", "follow=1", "encrypt=1", optionRange},
+	"entry=":     {"entries=", "manifest=1", "chunks=1", "exists=1", "info=1", "mode=", "parts=", "resume=", "follow=1", optionRange},
+	"entries=":   {"manifest=1", "chunks=1", "exists=1", "info=1", "mode=", "parts=", "resume=", "follow=1", optionRange},
+	"manifest=1": {"chunks=1", "exists=1", "info=1", "mode=", "parts=", "resume=", "follow=1", "encrypt=1", optionRange},
+	"chunks=1":   {"exists=1", "info=1", "mode=", "parts=", "resume=", "follow=1", "encrypt=1", optionRange},
+	"exists=1":   {"info=1", "mode=", "parts=", "resume=", "follow=1", "encrypt=1", optionRange},
+	"info=1":     {"mode=", "parts=", "resume=", "follow=1", "encrypt=1", optionRange},
+	"mode=":      {"parts=", "encrypt=1"},
+	"parts=":     {optionRange},
+	"resume=":    {"follow=1", "encrypt=1", optionRange},
+	"follow=1":   {optionRange},
+	"encrypt=1":  {optionRange},
+}
+
+// excludes reports whether options a and b can't be combined.
+func excludes(a, b string) bool {
+	for _, pair := range [][2]string{{a, b}, {b, a}} {
+		for _, name := range excludedOptions[pair[0]] {
+			if name == pair[1] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// conflictingOptions returns the options set in req that can't be combined
+// with the option name.
+func conflictingOptions(req *DownloadRequest, name string) []string {
+	var conflicts []string
+	for _, option := range requestOptions {
+		if option.name != name && option.set(req) && excludes(name, option.name) {
+			conflicts = append(conflicts, option.name)
+		}
+	}
+	return conflicts
+}
+
+// checkOptionCombinations refuses a request combining options that exclude one
+// another, naming every option the first of them can't be combined with.
+func checkOptionCombinations(req *DownloadRequest) error {
+	for _, option := range requestOptions {
+		if !option.set(req) || len(conflictingOptions(req, option.name)) == 0 {
+			continue
+		}
+		var excluded []string
+		for _, other := range requestOptions {
+			if other.name != option.name && excludes(option.name, other.name) {
+				excluded = append(excluded, other.name)
+			}
+		}
+		list := strings.Join(excluded[:len(excluded)-1], ", ")
+		if len(excluded) > 1 {
+			list += " or "
+		}
+		list += excluded[len(excluded)-1]
+		return badRequest(option.name + " can't be combined with " + list)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testOptions set each of the options that exclude one another.
+var testOptions = map[string]func(req *DownloadRequest){
+	"h=":         func(req *DownloadRequest) { req.Digest = strings.Repeat("a", 64) },
+	"archive=":   func(req *DownloadRequest) { req.Archive = "tar" },
+	"entry=":     func(req *DownloadRequest) { req.Entry = "x" },
+	"entries=":   func(req *DownloadRequest) { req.Entries = []string{"x"} },
+	"manifest=1": func(req *DownloadRequest) { req.Manifest = true },
+	"chunks=1":   func(req *DownloadRequest) { req.Chunks = true },
+	"exists=1":   func(req *DownloadRequest) { req.Exists = true },
+	"info=1":     func(req *DownloadRequest) { req.Info = true },
+	"mode=":      func(req *DownloadRequest) { req.Mode = modeURL },
+	"parts=":     func(req *DownloadRequest) { req.Parts = 2 },
+	"resume=":    func(req *DownloadRequest) { req.Resume = "1" },
+	"follow=1":   func(req *DownloadRequest) { req.Follow = true },
+	"encrypt=1":  func(req *DownloadRequest) { req.Encrypt = true },
+	optionRange:  func(req *DownloadRequest) { req.Range = &byteRange{query: true} },
+}
+
+// testCompatible are the pairs of options that can be combined. Every other
+// pair is refused.
+var testCompatible = map[[2]string]bool{
+	{"h=", "manifest=1"}:      true,
+	{"h=", "exists=1"}:        true,
+	{"h=", "info=1"}:          true,
+	{"h=", "mode="}:           true,
+	{"h=", "encrypt=1"}:       true,
+	{"entry=", "encrypt=1"}:   true,
+	{"entries=", "encrypt=1"}: true,
+	{"mode=", "resume="}:      true,
+	{"mode=", "follow=1"}:     true,
+	{"mode=", optionRange}:    true,
+	{"parts=", "resume="}:     true,
+	{"parts=", "follow=1"}:    true,
+	{"parts=", "encrypt=1"}:   true,
+	{"follow=1", "encrypt=1"}: true,
+}
+
+func TestOptionCombinations(t *testing.T) {
+	if len(testOptions) != len(requestOptions) {
+		t.Fatalf("%d options tested, %d in requestOptions", len(testOptions), len(requestOptions))
+	}
+	for i, a := range requestOptions {
+		for _, b := range requestOptions[i+1:] {
+			req := &DownloadRequest{}
+			testOptions[a.name](req)
+			testOptions[b.name](req)
+			err := checkOptionCombinations(req)
+			if compatible := testCompatible[[2]string{a.name, b.name}]; compatible != (err == nil) {
+				t.Errorf("%s with %s: err = %v, compatible %v", a.name, b.name, err, compatible)
+			}
+		}
+	}
+}
+
+func TestOptionCombinationMessage(t *testing.T) {
+	req := &DownloadRequest{Manifest: true, Mode: modeURL}
+	err := checkOptionCombinations(req)
+	want := "manifest=1 can't be combined with archive=, entry=, entries=, chunks=1, exists=1, info=1, mode=, parts=, resume=, follow=1, encrypt=1 or offset= and length="
+	if se, ok := err.(*statusError); !ok || se.code != 400 || se.msg != want {
+		t.Errorf("err = %v, want 400 %s", err, want)
+	}
+}
+
+func TestParseDownloadRequestRange(t *testing.T) {
+	ds := &DownloadServer{}
+	// A Range header the download can't be limited to is ignored.
+	r := httptest.NewRequest("GET", downloadPath+"?a=f.tar&chunks=1&s=/store", nil)
+	r.Header.Set("Range", "bytes=0-9")
+	req, err := ds.parseDownloadRequest(r)
+	if err != nil || req.Range != nil {
+		t.Errorf("chunks=1 with a Range header = %+v %v, want the range dropped", req, err)
+	}
+	// offset= and length= are refused instead.
+	r = httptest.NewRequest("GET", downloadPath+"?a=f.tar&chunks=1&offset=0&length=10&s=/store", nil)
+	if _, err := ds.parseDownloadRequest(r); err == nil {
+		t.Error("chunks=1 with offset= and length= accepted")
+	}
+	r = httptest.NewRequest("GET", downloadPath+"?a=f.tar&offset=0&length=10&s=/store", nil)
+	if req, err := ds.parseDownloadRequest(r); err != nil || req.Range == nil {
+		t.Errorf("plain range = %+v %v", req, err)
+	}
+}
+
+func TestParseDownloadRequestLocality(t *testing.T) {
+	ds := &DownloadServer{}
+	for query, ok := range map[string]bool{
+		"a=f&t=ten&parts=2":                 true,
+		"a=f&s=/store&parts=2":              false,
+		"a=f&s=/store&follow=1":             true,
+		"a=f&t=ten&follow=1":                false,
+		"a=f&s=/store&resume=1":             true,
+		"a=f&t=ten&resume=1":                false,
+		"a=f&t=ten&mode=url":                true,
+		"a=f&s=/store&mode=url":             false,
+		"a=f&t=ten&encrypt=1":               false,
+		"a=f&t=ten&archive=zip":             false,
+		"a=f&t=ten&mode=urls":               true,
+		"a=f&t=ten&prefix=1":                false,
+		"a=f&t=ten&encoding=br":             false,
+		"a=f&t=ten&encoding=gzip":           true,
+		"a=f":                               false,
+		"a=f&t=ten":                         true,
+		"a=f&s=/store":                      true,
+		"a=f&t=ten&s=/store&fallback=local": true,
+		"a=f&t=ten&fallback=local":          false,
+		"a=f&s=/store&fallback=local":       false,
+		"a=f&t=ten&s=/store&fallback=oci":   false,
+	} {
+		_, err := ds.parseDownloadRequest(httptest.NewRequest("GET", downloadPath+"?"+query, nil))
+		if ok != (err == nil) {
+			t.Errorf("%s: err = %v, want ok %v", query, err, ok)
+		}
+	}
+}
+
+func TestMaxQueryLength(t *testing.T) {
+	ds := localServer()
+	long := "a=" + strings.Repeat("x", 100) + "&s=/nonexistent"
+	if rec := testDownload("GET", long); rec.Code == 414 {
+		t.Error("query refused without MaxQueryLength")
+	}
+	ds.MaxQueryLength = 100
+	if rec := testDownload("GET", long); rec.Code != 414 {
+		t.Errorf("long query = %d, want 414", rec.Code)
+	}
+	if rec := testDownload("GET", "a=f.txt&s=/nonexistent"); rec.Code == 414 {
+		t.Error("short query refused")
+	}
+}
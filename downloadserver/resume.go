@@ -0,0 +1,192 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+ * Resumable sessions. Clients that can't keep track of how much of a download
+ * they have ask for resume=1 on a plain local download. The response carries
+ * a session token in X-Resume-Token and the server counts the bytes it writes
+ * for the session. After an interruption the client repeats the request with
+ * resume=<token> and the download carries on, as a 206 Partial Content, from
+ * where the server got to. The bytes handed to the connection aren't all
+ * received when it breaks, as megabytes can sit in socket buffers, so the
+ * download carries on ResumeMargin before the count and the client writes the
+ * body at the start of its Content-Range, overwriting what it already had.
+ * Sessions are kept in memory and forgotten ResumeSessionTTL after their last
+ * use, and a session is abandoned when the artifact changes. A newer request
+ * for a session takes it over from an older one still running.
+ */
+
+// DefaultResumeSessionTTL is how long an unused resume session is kept when
+// ResumeSessionTTL isn't set.
+const DefaultResumeSessionTTL = time.Hour
+
+// DefaultResumeMargin is how far before the bytes written a session carries on
+// when ResumeMargin isn't set.
+const DefaultResumeMargin = 16 << 20
+
+// maxResumeSessions bounds the number of resume sessions kept in memory.
+const maxResumeSessions = 10000
+
+// headerResumeToken carries the token of the resume session of a download.
+const headerResumeToken = "X-Resume-Token"
+
+// resumeStart is the resume= value starting a new session.
+const resumeStart = "1"
+
+var errTooManyResumes = &statusError{http.StatusServiceUnavailable, "too many resume sessions"}
+
+// resumeSession is the progress of a resumable download.
+type resumeSession struct {
+	token     string
+	storepath string
+	artifact  string
+	size      int64
+	modTime   time.Time
+	// offset is the end of the bytes of the artifact written for the session.
+	offset int64
+	// gen counts the requests for the session; only the latest one advances it.
+	gen     int
+	expires time.Time
+}
+
+// resumeSessions keeps the resume sessions by token.
+type resumeSessions struct {
+	ttl      time.Duration
+	margin   int64
+	mu       sync.Mutex
+	sessions map[string]*resumeSession
+}
+
+func newResumeSessions(ttl time.Duration, margin int64) *resumeSessions {
+	if ttl <= 0 {
+		ttl = DefaultResumeSessionTTL
+	}
+	if margin < 0 {
+		margin = DefaultResumeMargin
+	}
+	return &resumeSessions{ttl: ttl, margin: margin, sessions: make(map[string]*resumeSession)}
+}
+
+// open returns the session of a download of artifact from storepath, whose file
+// is described by stat, together with the request generation and the offset to
+// carry on from, margin before the end of the bytes written so far. A new
+// session is started for resume=1.
+func (s *resumeSessions) open(token string, storepath string, artifact string, stat os.FileInfo) (*resumeSession, int, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if token == resumeStart {
+		for t, session := range s.sessions {
+			if now.After(session.expires) {
+				delete(s.sessions, t)
+			}
+		}
+		if len(s.sessions) >= maxResumeSessions {
+			return nil, 0, 0, errTooManyResumes
+		}
+		byt := make([]byte, 16)
+		if _, err := rand.Read(byt); err != nil {
+			return nil, 0, 0, err
+		}
+		session := &resumeSession{
+			token:     hex.EncodeToString(byt),
+			storepath: storepath,
+			artifact:  artifact,
+			size:      stat.Size(),
+			modTime:   stat.ModTime(),
+			expires:   now.Add(s.ttl),
+		}
+		s.sessions[session.token] = session
+		return session, 0, 0, nil
+	}
+	session := s.sessions[token]
+	if session == nil || now.After(session.expires) {
+		delete(s.sessions, token)
+		return nil, 0, 0, &statusError{http.StatusGone, "unknown or expired resume session"}
+	}
+	if session.storepath != storepath || session.artifact != artifact {
+		return nil, 0, 0, badRequest("resume session is for another download")
+	}
+	if session.size != stat.Size() || !session.modTime.Equal(stat.ModTime()) {
+		delete(s.sessions, token)
+		return nil, 0, 0, &statusError{http.StatusPreconditionFailed, "artifact changed since the resume session started"}
+	}
+	session.gen++
+	session.expires = now.Add(s.ttl)
+	session.offset -= s.margin
+	if session.offset < 0 {
+		session.offset = 0
+	}
+	return session, session.gen, session.offset, nil
+}
+
+// retryAfter returns the time until the first of the sessions expires.
+func (s *resumeSessions) retryAfter() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var first time.Time
+	for _, session := range s.sessions {
+		if first.IsZero() || session.expires.Before(first) {
+			first = session.expires
+		}
+	}
+	return time.Until(first)
+}
+
+// advance counts n more bytes written for generation gen of session.
+func (s *resumeSessions) advance(session *resumeSession, gen int, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session.gen == gen {
+		session.offset += n
+		session.expires = time.Now().Add(s.ttl)
+	}
+}
+
+// resumeWriter counts the bytes of the artifact written for a resume session.
+type resumeWriter struct {
+	io.Writer
+	sessions *resumeSessions
+	session  *resumeSession
+	gen      int
+}
+
+func (w *resumeWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if n > 0 {
+		w.sessions.advance(w.session, w.gen, int64(n))
+	}
+	return n, err
+}
+
+// resumeDownload opens the resume session of a local download and limits opts
+// to the rest of the artifact.
+func (ds *DownloadServer) resumeDownload(w http.ResponseWriter, storepath string, artifact string, stat os.FileInfo, opts *transferOptions) error {
+	if ds.resumes == nil {
+		return badRequest("resume= is not available")
+	}
+	session, gen, offset, err := ds.resumes.open(opts.resume, storepath, artifact, stat)
+	if err == errTooManyResumes {
+		w.Header().Set("Retry-After", retryAfter(ds.resumes.retryAfter()))
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set(headerResumeToken, session.token)
+	opts.progress = &resumeWriter{sessions: ds.resumes, session: session, gen: gen}
+	if offset > 0 {
+		opts.byteRange = &byteRange{first: offset, last: -1}
+	}
+	return nil
+}
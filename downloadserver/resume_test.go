@@ -0,0 +1,147 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// resumeSessionOf returns the session of token in ds.
+func resumeSessionOf(ds *DownloadServer, token string) *resumeSession {
+	ds.resumes.mu.Lock()
+	defer ds.resumes.mu.Unlock()
+	return ds.resumes.sessions[token]
+}
+
+func TestResumeDownload(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.bin": "0123456789", "g.bin": "other"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	if rec := testDownload("GET", "resume=1&a=f.bin&s="+dir); rec.Code != 400 {
+		t.Errorf("resume=1 without sessions = %d, want 400", rec.Code)
+	}
+	ds.resumes = newResumeSessions(time.Hour, 2)
+
+	rec := testDownload("GET", "resume=1&a=f.bin&s="+dir)
+	token := rec.Header().Get(headerResumeToken)
+	if rec.Code != 200 || rec.Body.String() != "0123456789" || len(token) != 32 {
+		t.Fatalf("resume=1 = %d %q, token %q", rec.Code, rec.Body.String(), token)
+	}
+	session := resumeSessionOf(ds, token)
+	if session == nil || session.offset != 10 {
+		t.Fatalf("session = %+v, want the whole artifact counted", session)
+	}
+
+	// The connection broke after 6 bytes; the download carries on the margin
+	// before them.
+	ds.resumes.mu.Lock()
+	session.offset = 6
+	ds.resumes.mu.Unlock()
+	rec = testDownload("GET", "resume="+token+"&a=f.bin&s="+dir)
+	if rec.Code != 206 || rec.Body.String() != "456789" || rec.Header().Get("Content-Range") != "bytes 4-9/10" {
+		t.Errorf("resumed = %d %q %q", rec.Code, rec.Body.String(), rec.Header().Get("Content-Range"))
+	}
+	if rec.Header().Get(headerResumeToken) != token {
+		t.Errorf("resumed token = %q", rec.Header().Get(headerResumeToken))
+	}
+	if session.offset != 10 || session.gen != 1 {
+		t.Errorf("session after the resume: offset %d, gen %d", session.offset, session.gen)
+	}
+
+	// The token only resumes its own download.
+	if rec := testDownload("GET", "resume="+token+"&a=g.bin&s="+dir); rec.Code != 400 {
+		t.Errorf("token for another artifact = %d, want 400", rec.Code)
+	}
+	tampered := token[:31] + "x"
+	if rec := testDownload("GET", "resume="+tampered+"&a=f.bin&s="+dir); rec.Code != 410 {
+		t.Errorf("unknown token = %d, want 410", rec.Code)
+	}
+	if rec := testDownload("GET", "resume="+token+"&t=ten&a=f.bin"); rec.Code != 400 {
+		t.Errorf("resume= for OCI = %d, want 400", rec.Code)
+	}
+
+	ds.resumes.mu.Lock()
+	session.expires = time.Now().Add(-time.Second)
+	ds.resumes.mu.Unlock()
+	if rec := testDownload("GET", "resume="+token+"&a=f.bin&s="+dir); rec.Code != 410 {
+		t.Errorf("expired token = %d, want 410", rec.Code)
+	}
+	if resumeSessionOf(ds, token) != nil {
+		t.Error("expired session kept")
+	}
+}
+
+func TestResumeArtifactChanged(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.bin": "0123456789"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.resumes = newResumeSessions(time.Hour, 0)
+	token := testDownload("GET", "resume=1&a=f.bin&s="+dir).Header().Get(headerResumeToken)
+
+	// Same size, another modification time.
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(dir+"/f.bin", later, later); err != nil {
+		t.Fatal(err)
+	}
+	if rec := testDownload("GET", "resume="+token+"&a=f.bin&s="+dir); rec.Code != 412 {
+		t.Errorf("resume of a changed artifact = %d, want 412", rec.Code)
+	}
+	// The session is abandoned.
+	if rec := testDownload("GET", "resume="+token+"&a=f.bin&s="+dir); rec.Code != 410 {
+		t.Errorf("resume of an abandoned session = %d, want 410", rec.Code)
+	}
+
+	token = testDownload("GET", "resume=1&a=f.bin&s="+dir).Header().Get(headerResumeToken)
+	if err := ioutil.WriteFile(dir+"/f.bin", []byte("0123"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if rec := testDownload("GET", "resume="+token+"&a=f.bin&s="+dir); rec.Code != 412 {
+		t.Errorf("resume of a truncated artifact = %d, want 412", rec.Code)
+	}
+}
+
+func TestResumeTakeOver(t *testing.T) {
+	s := newResumeSessions(time.Hour, 0)
+	dir := testStore(t, map[string]string{"f.bin": "0123456789"})
+	defer os.RemoveAll(dir)
+	stat, err := os.Stat(dir + "/f.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, gen, _, err := s.open(resumeStart, dir, "f.bin", stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.advance(session, gen, 4)
+	_, newer, offset, err := s.open(session.token, dir, "f.bin", stat)
+	if err != nil || offset != 4 {
+		t.Fatalf("resumed at %d, %v", offset, err)
+	}
+	// The older request still running no longer moves the session.
+	s.advance(session, gen, 3)
+	s.advance(session, newer, 2)
+	if session.offset != 6 {
+		t.Errorf("offset = %d, want 6", session.offset)
+	}
+}
+
+func TestTooManyResumeSessions(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.bin": "0123456789"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.resumes = newResumeSessions(time.Hour, 0)
+	expires := time.Now().Add(30 * time.Second)
+	for i := 0; i < maxResumeSessions; i++ {
+		token := fmt.Sprint(i)
+		ds.resumes.sessions[token] = &resumeSession{token: token, expires: expires.Add(time.Duration(i) * time.Second)}
+	}
+
+	rec := testDownload("GET", "resume=1&a=f.bin&s="+dir)
+	if rec.Code != 503 || !retryAfterWithin(rec, 30) {
+		t.Errorf("resume=1 with all sessions taken = %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
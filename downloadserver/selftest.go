@@ -0,0 +1,189 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	ocicommon "github.com/oracle/oci-go-sdk/common"
+	ocistorage "github.com/oracle/oci-go-sdk/objectstorage"
+)
+
+/*
+ * Self test. The /selftest endpoint exercises the whole OCI path: it uploads a
+ * small object with random content, creates a PAR for it, downloads it through
+ * the PAR and checks the content, then deletes the PAR and the object. As it
+ * writes to the bucket it is only available when SelftestToken is set and the
+ * request presents it as a bearer token.
+ */
+
+// selftestPrefix is the object name prefix of the self test objects, keeping them
+// apart from real artifacts.
+const selftestPrefix = "runner-download-selftest/"
+
+// selftestTimeout bounds a whole self test run.
+const selftestTimeout = 30 * time.Second
+
+// selftestStep is the outcome of one step of a self test.
+type selftestStep struct {
+	Name       string `json:"name"`
+	Passed     bool   `json:"passed"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// selftestResult is the JSON document served by the self test endpoint.
+type selftestResult struct {
+	Passed bool           `json:"passed"`
+	Region string         `json:"region"`
+	Object string         `json:"object"`
+	Steps  []selftestStep `json:"steps"`
+}
+
+// Selftest handler. Runs a self test and reports the result as JSON, with 503
+// Service Unavailable when it failed.
+func selftest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		w.Header().Set("Allow", "GET, POST")
+		httpError(w, r, "protocol error", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(downloadServer.SelftestToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		httpError(w, r, "self test requires authorization", http.StatusUnauthorized)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), selftestTimeout)
+	defer cancel()
+	result := downloadServer.runSelftest(ctx)
+	if !result.Passed {
+		downloadServer.logger().Error("Self test failed", Fields{"region": result.Region, "object": result.Object})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !result.Passed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// bearerToken returns the token of the Authorization header of r, or "" when it
+// doesn't use the Bearer scheme.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return auth[len("Bearer "):]
+}
+
+// runSelftest round trips a small object through the bucket in the first region
+// to be tried for downloads. Whatever was created is cleaned up even when an
+// earlier step fails.
+func (ds *DownloadServer) runSelftest(ctx context.Context) *selftestResult {
+	content := make([]byte, 64)
+	rand.Read(content)
+	result := &selftestResult{
+		Passed: true,
+		Region: ds.regionOrder()[0],
+		Object: fmt.Sprintf("%s%X", selftestPrefix, content[:8]),
+	}
+	step := func(name string, fn func() error) bool {
+		start := time.Now()
+		err := fn()
+		s := selftestStep{Name: name, Passed: err == nil, DurationMs: int64(time.Since(start) / time.Millisecond)}
+		if err != nil {
+			s.Error = err.Error()
+			result.Passed = false
+		}
+		result.Steps = append(result.Steps, s)
+		return err == nil
+	}
+
+	client, err := ds.objectStorageClient(result.Region)
+	if !step("client", func() error { return err }) {
+		return result
+	}
+
+	if !step("put object", func() error {
+		length := int64(len(content))
+		_, err := client.PutObject(ctx, ocistorage.PutObjectRequest{
+			NamespaceName: &ds.Namespace,
+			BucketName:    &ds.BucketName,
+			ObjectName:    &result.Object,
+			ContentLength: &length,
+			PutObjectBody: ioutil.NopCloser(bytes.NewReader(content)),
+		})
+		return err
+	}) {
+		return result
+	}
+	defer step("delete object", func() error {
+		_, err := client.DeleteObject(ctx, ocistorage.DeleteObjectRequest{
+			NamespaceName: &ds.Namespace,
+			BucketName:    &ds.BucketName,
+			ObjectName:    &result.Object,
+		})
+		return err
+	})
+
+	var par ocistorage.CreatePreauthenticatedRequestResponse
+	if !step("create par", func() error {
+		parname := "selftest-" + result.Object[len(selftestPrefix):]
+		par, err = client.CreatePreauthenticatedRequest(ctx, ocistorage.CreatePreauthenticatedRequestRequest{
+			NamespaceName: &ds.Namespace,
+			BucketName:    &ds.BucketName,
+			CreatePreauthenticatedRequestDetails: ocistorage.CreatePreauthenticatedRequestDetails{
+				Name:        &parname,
+				ObjectName:  &result.Object,
+				TimeExpires: &ocicommon.SDKTime{Time: time.Now().Add(parTTL)},
+				AccessType:  "ObjectRead",
+			},
+		})
+		return err
+	}) {
+		return result
+	}
+	defer step("delete par", func() error {
+		_, err := client.DeletePreauthenticatedRequest(ctx, ocistorage.DeletePreauthenticatedRequestRequest{
+			NamespaceName: &ds.Namespace,
+			BucketName:    &ds.BucketName,
+			ParId:         par.Id,
+		})
+		return err
+	})
+
+	step("download", func() error {
+		url := ds.parURL(client.BaseClient.Host, *par.AccessUri, result.Object)
+		request, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		response, err := ds.upstreamClient().Do(request.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return fmt.Errorf("download failed: %s", response.Status)
+		}
+		data, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(data, content) {
+			return fmt.Errorf("downloaded content does not match uploaded content")
+		}
+		return nil
+	})
+	return result
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testSelftest runs the self test endpoint with the Authorization header auth.
+func testSelftest(auth string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/selftest", nil)
+	if auth != "" {
+		r.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	selftest(rec, r)
+	return rec
+}
+
+func TestSelftest(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	ds := f.server()
+	ds.SelftestToken = "secret"
+
+	for _, auth := range []string{"", "Bearer wrong", "secret"} {
+		if rec := testSelftest(auth); rec.Code != 401 || rec.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("self test with Authorization %q = %d, want 401", auth, rec.Code)
+		}
+	}
+	if n := f.count(&f.pars); n != 0 {
+		t.Errorf("unauthorized self tests created %d PARs", n)
+	}
+
+	rec := testSelftest("Bearer secret")
+	var result selftestResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != 200 || !result.Passed || !strings.HasPrefix(result.Object, selftestPrefix) || result.Region != "us-phoenix-1" {
+		t.Errorf("self test = %d %+v", rec.Code, result)
+	}
+	var steps []string
+	for _, s := range result.Steps {
+		steps = append(steps, s.Name)
+	}
+	if got := strings.Join(steps, ","); got != "client,put object,create par,download,delete par,delete object" {
+		t.Errorf("steps = %s", got)
+	}
+	// Everything the self test created is cleaned up.
+	f.mu.Lock()
+	objects, pars := len(f.objects), len(f.parList)
+	f.mu.Unlock()
+	if objects != 0 || pars != 0 {
+		t.Errorf("%d objects and %d PARs left behind", objects, pars)
+	}
+}
+
+func TestSelftestFailure(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	ds := f.server()
+	ds.SelftestToken = "secret"
+	f.parStatus = 500
+
+	rec := testSelftest("Bearer secret")
+	var result selftestResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != 503 || result.Passed {
+		t.Errorf("failing self test = %d %+v, want 503", rec.Code, result)
+	}
+	for _, s := range result.Steps {
+		if s.Passed != (s.Name != "download") {
+			t.Errorf("step %+v", s)
+		}
+	}
+	// The cleanup runs after a failed step too.
+	f.mu.Lock()
+	objects, pars := len(f.objects), len(f.parList)
+	f.mu.Unlock()
+	if objects != 0 || pars != 0 {
+		t.Errorf("%d objects and %d PARs left behind", objects, pars)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// DefaultShutdownTimeout is how long the shutdown hooks get to run in total.
+const DefaultShutdownTimeout = 5 * time.Second
+
+// DefaultDrainTimeout is how long Close waits for the downloads in flight when
+// DrainTimeout isn't set.
+const DefaultDrainTimeout = 30 * time.Second
+
+// ShutdownHook is called when the server shuts down, typically to flush
+// buffered metrics or trace spans. It should return once ctx is done.
+type ShutdownHook func(ctx context.Context) error
+
+type namedHook struct {
+	name string
+	hook ShutdownHook
+}
+
+// OnShutdown registers hook to be run by Close, after the server has stopped
+// accepting requests. Hooks run in the order they were registered and share a
+// single ShutdownTimeout; each runs at most once.
+func (ds *DownloadServer) OnShutdown(name string, hook ShutdownHook) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.shutdownHooks = append(ds.shutdownHooks, namedHook{name: name, hook: hook})
+}
+
+// runShutdownHooks runs the registered hooks, giving up on any still running
+// once the timeout has passed so that a stuck exporter can't hold up the exit.
+func (ds *DownloadServer) runShutdownHooks() {
+	ds.mu.Lock()
+	hooks := ds.shutdownHooks
+	ds.shutdownHooks = nil
+	ds.mu.Unlock()
+	if len(hooks) == 0 {
+		return
+	}
+
+	timeout := ds.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, h := range hooks {
+		done := make(chan error, 1)
+		go func(hook ShutdownHook) {
+			done <- hook(ctx)
+		}(h.hook)
+		select {
+		case err := <-done:
+			if err != nil {
+				ds.logger().Warn("Shutdown hook failed", Fields{"hook": h.name, "error": err.Error()})
+			}
+		case <-ctx.Done():
+			ds.logger().Warn("Shutdown hooks timed out", Fields{"hook": h.name, "timeout": timeout.String()})
+			return
+		}
+	}
+}
+
+// drain closes the listener of server and waits for its downloads in flight to
+// finish, closing the connections still open once DrainTimeout has passed.
+func (ds *DownloadServer) drain(server *http.Server) error {
+	timeout := ds.DrainTimeout
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err == context.DeadlineExceeded {
+		ds.logger().Warn("Downloads still running after the drain timeout were cut", Fields{"timeout": timeout.String()})
+		return server.Close()
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShutdownHooks(t *testing.T) {
+	logger := &testLogger{}
+	ds := &DownloadServer{Logger: logger}
+	var ran []string
+	ds.OnShutdown("first", func(ctx context.Context) error {
+		ran = append(ran, "first")
+		return errors.New("flush failed")
+	})
+	ds.OnShutdown("second", func(ctx context.Context) error {
+		ran = append(ran, "second")
+		return nil
+	})
+	if err := ds.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 2 || ran[0] != "first" || ran[1] != "second" {
+		t.Errorf("hooks ran %q, want first then second", ran)
+	}
+	if failed := logger.find("Shutdown hook failed"); len(failed) != 1 || failed[0].fields["hook"] != "first" {
+		t.Errorf("failure logs = %+v", failed)
+	}
+
+	// Each hook runs once.
+	ds.Close()
+	if len(ran) != 2 {
+		t.Errorf("hooks ran again: %q", ran)
+	}
+}
+
+func TestShutdownHooksTimeout(t *testing.T) {
+	logger := &testLogger{}
+	ds := &DownloadServer{Logger: logger, ShutdownTimeout: 50 * time.Millisecond}
+	stuck := make(chan struct{})
+	defer close(stuck)
+	later := false
+	ds.OnShutdown("stuck", func(ctx context.Context) error {
+		<-stuck
+		return nil
+	})
+	ds.OnShutdown("later", func(ctx context.Context) error {
+		later = true
+		return nil
+	})
+
+	start := time.Now()
+	ds.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close took %s with a stuck hook", elapsed)
+	}
+	if later {
+		t.Error("hook after the timeout ran")
+	}
+	if timedOut := logger.find("Shutdown hooks timed out"); len(timedOut) != 1 || timedOut[0].fields["hook"] != "stuck" {
+		t.Errorf("timeout logs = %+v", timedOut)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"fmt"
+	"sync"
+)
+
+// flightGroup coalesces concurrent calls for the same key so that only one of
+// them does the work, the others waiting for and sharing its result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+	stats coalescingStats
+}
+
+type flightCall struct {
+	done    chan struct{}
+	val     string
+	err     error
+	waiters int
+}
+
+// coalescingStats counts how effective coalescing is.
+type coalescingStats struct {
+	// Calls is the number of calls that did the work.
+	Calls int64 `json:"calls"`
+	// Coalesced is the number of callers that shared another call's result.
+	Coalesced int64 `json:"coalesced"`
+	// MaxShared is the largest number of callers that shared a single call,
+	// the call itself included.
+	MaxShared int `json:"maxShared"`
+}
+
+// do calls fn for key unless a call for key is already in flight, in which case
+// it waits for that call's result. shared reports whether the result came from
+// another caller's call. A panic in fn carries on in the caller that made the
+// call, while the callers waiting on it get an error.
+func (g *flightGroup) do(key string, fn func() (string, error)) (val string, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		c.waiters++
+		g.stats.Coalesced++
+		if c.waiters+1 > g.stats.MaxShared {
+			g.stats.MaxShared = c.waiters + 1
+		}
+		g.mu.Unlock()
+		<-c.done
+		return c.val, c.err, true
+	}
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.stats.Calls++
+	if g.stats.MaxShared == 0 {
+		g.stats.MaxShared = 1
+	}
+	g.mu.Unlock()
+
+	completed := false
+	defer func() {
+		if completed {
+			g.finish(key, c)
+			return
+		}
+		p := recover()
+		c.err = fmt.Errorf("call for %s panicked: %v", key, p)
+		g.finish(key, c)
+		if p != nil {
+			panic(p)
+		}
+	}()
+	c.val, c.err = fn()
+	completed = true
+	return c.val, c.err, false
+}
+
+// finish ends call c for key, releasing its waiters.
+func (g *flightGroup) finish(key string, c *flightCall) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(c.done)
+}
+
+// report returns the coalescing counters.
+func (g *flightGroup) report() coalescingStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stats
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupCoalesces(t *testing.T) {
+	var g flightGroup
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "par", nil
+	}
+
+	const callers = 10
+	var wg sync.WaitGroup
+	results := make(chan string, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err, _ := g.do("key", fn)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- val
+		}()
+	}
+	// Let every caller join the call in flight before it completes.
+	for deadline := time.Now().Add(5 * time.Second); g.report().Coalesced < callers-1 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("fn called %d times, want 1", n)
+	}
+	for val := range results {
+		if val != "par" {
+			t.Errorf("result %q", val)
+		}
+	}
+	if s := g.report(); s.Calls != 1 || s.Coalesced != callers-1 || s.MaxShared != callers {
+		t.Errorf("stats = %+v", s)
+	}
+	// The key is free for a new call once the call is done.
+	if _, _, shared := g.do("key", func() (string, error) { return "", nil }); shared {
+		t.Error("call after completion shared the old result")
+	}
+}
+
+func TestFlightGroupPanic(t *testing.T) {
+	var g flightGroup
+	started := make(chan struct{})
+	waited := make(chan error)
+	go func() {
+		<-started
+		_, err, shared := g.do("key", func() (string, error) { return "", nil })
+		if !shared {
+			t.Error("waiter didn't join the call")
+		}
+		waited <- err
+	}()
+
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Errorf("panic = %v, want boom", p)
+			}
+		}()
+		g.do("key", func() (string, error) {
+			close(started)
+			for g.report().Coalesced == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			panic("boom")
+		})
+	}()
+
+	select {
+	case err := <-waited:
+		if err == nil {
+			t.Error("waiter got no error for the panicked call")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiter still blocked after the call panicked")
+	}
+	if _, err, shared := g.do("key", func() (string, error) { return "ok", nil }); err != nil || shared {
+		t.Errorf("call after the panic = %v %v", err, shared)
+	}
+}
+
+func TestConcurrentDownloadsShareAPAR(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	ds := f.server()
+	f.parDelay = 50 * time.Millisecond
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ds.objectPAR(context.Background(), ds.Region, "a/f.txt"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := f.count(&f.pars); n != 1 {
+		t.Errorf("%d PARs created for concurrent downloads, want 1", n)
+	}
+}
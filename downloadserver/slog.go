@@ -0,0 +1,42 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+//go:build go1.21
+// +build go1.21
+
+package downloadserver
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+)
+
+// NewSlogLogger returns a Logger backed by logger, so the server's output goes
+// through the same slog handler as the rest of the embedding service. Fields
+// become slog attributes.
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return slogLogger{logger}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogLogger) Debug(msg string, fields Fields) { l.log(slog.LevelDebug, msg, fields) }
+func (l slogLogger) Info(msg string, fields Fields)  { l.log(slog.LevelInfo, msg, fields) }
+func (l slogLogger) Warn(msg string, fields Fields)  { l.log(slog.LevelWarn, msg, fields) }
+func (l slogLogger) Error(msg string, fields Fields) { l.log(slog.LevelError, msg, fields) }
+
+func (l slogLogger) log(level slog.Level, msg string, fields Fields) {
+	// Sort the keys so attributes come out in a stable order.
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]slog.Attr, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, slog.Any(k, fields[k]))
+	}
+	l.logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
@@ -0,0 +1,28 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+//go:build go1.21
+// +build go1.21
+
+package downloadserver
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	logger.Debug("dropped", nil)
+	logger.Warn("Download served", Fields{"status": 200, "artifact": "a/b.tar"})
+
+	out := buf.String()
+	if strings.Contains(out, "dropped") {
+		t.Errorf("debug message logged at info level: %s", out)
+	}
+	if !strings.Contains(out, `level=WARN msg="Download served" artifact=a/b.tar status=200`) {
+		t.Errorf("log = %s, want the fields as sorted attributes", out)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+ * Slow-read protection. A client reading a byte now and then holds its
+ * connection and download open for as long as MaxDownloadDuration allows. With
+ * SlowReadRate set, the writes to every client connection have to keep up with
+ * that many bytes per second averaged over SlowReadWindow: each write is given
+ * a deadline at which the bytes so far would be sent at the floor rate, plus
+ * SlowReadGrace so that brief stalls are tolerated. A client that falls further
+ * behind has its write time out, which aborts the download and closes the
+ * connection. Time the server spends not writing, waiting on OCI or for the
+ * next request, doesn't count against the client, and a client reading faster
+ * than the floor builds up at most SlowReadWindow of credit, which is what the
+ * rate is averaged over.
+ */
+
+// DefaultSlowReadWindow is the window the minimum read rate is averaged over
+// when SlowReadWindow isn't set.
+const DefaultSlowReadWindow = 10 * time.Second
+
+// DefaultSlowReadGrace is how far behind the minimum read rate a client may
+// fall when SlowReadGrace isn't set.
+const DefaultSlowReadGrace = 10 * time.Second
+
+// slowReadListener enforces the minimum read rate on accepted connections,
+// calling slow for each connection it gives up on.
+type slowReadListener struct {
+	net.Listener
+	rate   int64
+	window time.Duration
+	grace  time.Duration
+	slow   func(net.Conn)
+}
+
+func (l *slowReadListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &slowReadConn{Conn: c, listener: l}, nil
+}
+
+// newSlowReadListener wraps l to enforce SlowReadRate, logging the clients that
+// are disconnected for reading too slowly.
+func (ds *DownloadServer) newSlowReadListener(l net.Listener) *slowReadListener {
+	window, grace := ds.SlowReadWindow, ds.SlowReadGrace
+	if window <= 0 {
+		window = DefaultSlowReadWindow
+	}
+	if grace <= 0 {
+		grace = DefaultSlowReadGrace
+	}
+	return &slowReadListener{Listener: l, rate: ds.SlowReadRate, window: window, grace: grace, slow: func(c net.Conn) {
+		ds.logger().Warn("Client reading below the minimum rate, aborting", Fields{"client": c.RemoteAddr().String(), "minBytesPerSec": ds.SlowReadRate})
+	}}
+}
+
+// slowReadConn is a connection whose writes have to keep up with the minimum
+// read rate.
+type slowReadConn struct {
+	net.Conn
+	listener *slowReadListener
+	mu       sync.Mutex
+	// deadline is the write deadline set by the HTTP server, which the writes
+	// never extend.
+	deadline time.Time
+	// due is when the bytes written so far would have been sent at the floor
+	// rate.
+	due time.Time
+}
+
+func (c *slowReadConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *slowReadConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *slowReadConn) Write(p []byte) (int, error) {
+	l := c.listener
+	c.mu.Lock()
+	now := time.Now()
+	// Time not spent writing isn't held against the client, and being ahead
+	// of the floor counts for at most a window.
+	if c.due.Before(now) {
+		c.due = now
+	} else if c.due.After(now.Add(l.window)) {
+		c.due = now.Add(l.window)
+	}
+	c.due = c.due.Add(time.Duration(float64(len(p)) / float64(l.rate) * float64(time.Second)))
+	limit := c.due.Add(l.grace)
+	serverDeadline := c.deadline
+	enforced := serverDeadline.IsZero() || limit.Before(serverDeadline)
+	if enforced {
+		c.Conn.SetWriteDeadline(limit)
+	}
+	c.mu.Unlock()
+
+	n, err := c.Conn.Write(p)
+
+	c.mu.Lock()
+	if enforced && c.deadline.Equal(serverDeadline) {
+		c.Conn.SetWriteDeadline(serverDeadline)
+	}
+	c.mu.Unlock()
+	if ne, ok := err.(net.Error); ok && ne.Timeout() && enforced && l.slow != nil {
+		l.slow(c.Conn)
+	}
+	return n, err
+}
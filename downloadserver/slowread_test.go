@@ -0,0 +1,136 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// deadlineConn records the write deadlines set on it.
+type deadlineConn struct {
+	net.Conn
+	deadlines []time.Time
+	err       error
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	c.deadlines = append(c.deadlines, t)
+	return nil
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	return len(p), nil
+}
+
+// timeoutError is a net.Error for a write that timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestSlowReadDeadlines(t *testing.T) {
+	l := &slowReadListener{rate: 1000, window: time.Second, grace: 100 * time.Millisecond}
+	raw := &deadlineConn{}
+	c := &slowReadConn{Conn: raw, listener: l}
+
+	start := time.Now()
+	c.Write(make([]byte, 500))
+	if len(raw.deadlines) != 2 || !raw.deadlines[1].IsZero() {
+		t.Fatalf("deadlines = %v, want one for the write and the server's restored", raw.deadlines)
+	}
+	if d := raw.deadlines[0].Sub(start); d < 600*time.Millisecond || d > 700*time.Millisecond {
+		t.Errorf("write deadline in %s, want 500ms at the floor rate plus the grace", d)
+	}
+
+	// Being ahead of the floor builds up at most a window of credit.
+	for i := 0; i < 10; i++ {
+		c.Write(make([]byte, 500))
+	}
+	if d := raw.deadlines[len(raw.deadlines)-2].Sub(time.Now()); d > 1700*time.Millisecond {
+		t.Errorf("write deadline in %s, want at most the window ahead", d)
+	}
+
+	// A server deadline sooner than the floor is left alone.
+	raw.deadlines = nil
+	serverDeadline := time.Now().Add(time.Millisecond)
+	c.SetWriteDeadline(serverDeadline)
+	c.Write(make([]byte, 500))
+	if len(raw.deadlines) != 1 || !raw.deadlines[0].Equal(serverDeadline) {
+		t.Errorf("deadlines = %v, want only the server's", raw.deadlines)
+	}
+}
+
+func TestSlowReadTimeout(t *testing.T) {
+	var slow []net.Conn
+	l := &slowReadListener{rate: 1000, window: time.Second, grace: time.Second, slow: func(c net.Conn) { slow = append(slow, c) }}
+	raw := &deadlineConn{err: timeoutError{}}
+	c := &slowReadConn{Conn: raw, listener: l}
+	if _, err := c.Write([]byte("x")); err == nil {
+		t.Fatal("write didn't fail")
+	}
+	if len(slow) != 1 || slow[0] != raw {
+		t.Errorf("slow called for %v", slow)
+	}
+
+	// A timeout of the server's own deadline isn't the client's fault.
+	slow = nil
+	c.SetWriteDeadline(time.Now())
+	c.Write([]byte("x"))
+	if len(slow) != 0 {
+		t.Error("slow called for the server's deadline")
+	}
+}
+
+func TestSlowReadListener(t *testing.T) {
+	logger := &testLogger{}
+	ds := &DownloadServer{
+		Logger:         logger,
+		SlowReadRate:   1 << 20,
+		SlowReadWindow: 50 * time.Millisecond,
+		SlowReadGrace:  50 * time.Millisecond,
+	}
+	ln, err := ds.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The client never reads, so the writes stall once the buffers are full.
+	chunk := make([]byte, 32<<10)
+	done := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := c.Write(chunk); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-done:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Errorf("write error = %v, want a timeout", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("writes to a client that doesn't read didn't time out")
+	}
+	if len(logger.find("Client reading below the minimum rate, aborting")) != 1 {
+		t.Error("slow client not logged")
+	}
+}
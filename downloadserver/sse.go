@@ -0,0 +1,65 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+/*
+ * Customer-provided encryption keys (SSE-C). Objects OCI stores encrypted with
+ * a key of the customer's can only be read by presenting the key with every
+ * request. SSECustomerKeys gives the AES-256 key of each bucket, and the key of
+ * BucketName is added to the object GETs, HEADs and PUTs of the OCI client,
+ * which decrypts the objects for the download. PARs can't carry the key, so
+ * such objects are always read directly with the OCI client and mode= isn't
+ * available for them, nor offloading to a proxy. The key is never logged.
+ */
+
+// SSE-C request headers.
+const (
+	headerSSEAlgorithm = "opc-sse-customer-algorithm"
+	headerSSEKey       = "opc-sse-customer-key"
+	headerSSEKeySHA256 = "opc-sse-customer-key-sha256"
+)
+
+// sseCustomerKey is the SSE-C key of the bucket, encoded for the headers.
+type sseCustomerKey struct {
+	key    string
+	sha256 string
+}
+
+// newSSECustomerKey returns the key of bucket in keys, nil when it has none.
+func newSSECustomerKey(keys map[string][]byte, bucket string) (*sseCustomerKey, error) {
+	key, ok := keys[bucket]
+	if !ok {
+		return nil, nil
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid SSE-C key for bucket %s: must be 32 bytes", bucket)
+	}
+	sum := sha256.Sum256(key)
+	return &sseCustomerKey{
+		key:    base64.StdEncoding.EncodeToString(key),
+		sha256: base64.StdEncoding.EncodeToString(sum[:]),
+	}, nil
+}
+
+// intercept adds the key to the requests of the OCI client that read or write
+// objects. It is a nil-safe common.RequestInterceptor.
+func (k *sseCustomerKey) intercept(r *http.Request) error {
+	if k == nil || !strings.Contains(r.URL.Path, "/o/") {
+		return nil
+	}
+	switch r.Method {
+	case "GET", "HEAD", "PUT":
+		r.Header.Set(headerSSEAlgorithm, "AES256")
+		r.Header.Set(headerSSEKey, k.key)
+		r.Header.Set(headerSSEKeySHA256, k.sha256)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewSSECustomerKey(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	keys := map[string][]byte{"bk": key, "short": key[:16]}
+	if k, err := newSSECustomerKey(keys, "other"); k != nil || err != nil {
+		t.Errorf("key of a bucket without one = %v %v", k, err)
+	}
+	if _, err := newSSECustomerKey(keys, "short"); err == nil {
+		t.Error("16 byte key accepted")
+	}
+	k, err := newSSECustomerKey(keys, "bk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		method, path string
+		keyed        bool
+	}{
+		{"GET", "/n/ns/b/bk/o/a/f.txt", true},
+		{"HEAD", "/n/ns/b/bk/o/a/f.txt", true},
+		{"DELETE", "/n/ns/b/bk/o/a/f.txt", false},
+		{"POST", "/n/ns/b/bk/p/", false},
+		{"HEAD", "/n/ns/b/bk/", false},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		k.intercept(r)
+		if got := r.Header.Get(headerSSEKey) == base64.StdEncoding.EncodeToString(key); got != tc.keyed {
+			t.Errorf("%s %s keyed = %v, want %v", tc.method, tc.path, got, tc.keyed)
+		}
+		if tc.keyed && (r.Header.Get(headerSSEAlgorithm) != "AES256" || r.Header.Get(headerSSEKeySHA256) == "") {
+			t.Errorf("%s %s headers = %v", tc.method, tc.path, r.Header)
+		}
+	}
+	if err := (*sseCustomerKey)(nil).intercept(httptest.NewRequest("GET", "/n/ns/b/bk/o/f", nil)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSSECustomerKeyDownload(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	ds := f.server()
+	key := bytes.Repeat([]byte{7}, 32)
+	ds.sseKey, _ = newSSECustomerKey(map[string][]byte{"bk": key}, "bk")
+
+	// Objects are read directly, with the key, whatever their size.
+	if rec := testDownload("GET", "t=ten&a=a/f.txt"); rec.Code != 200 || rec.Body.String() != "hello" {
+		t.Fatalf("download = %d %q", rec.Code, rec.Body.String())
+	}
+	if pars := f.count(&f.pars); pars != 0 {
+		t.Errorf("%d PARs created for an SSE-C object", pars)
+	}
+	f.mu.Lock()
+	sseKeys := f.sseKeys
+	f.mu.Unlock()
+	if len(sseKeys) != 2 {
+		t.Fatalf("object requests = %d, want a HEAD and a GET", len(sseKeys))
+	}
+	for _, sent := range sseKeys {
+		if sent != base64.StdEncoding.EncodeToString(key) {
+			t.Errorf("object request sent key %q", sent)
+		}
+	}
+
+	if rec := testDownload("GET", "t=ten&a=a/f.txt&mode=url"); rec.Code != 400 {
+		t.Errorf("mode=url with an SSE-C key = %d, want 400", rec.Code)
+	}
+}
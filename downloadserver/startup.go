@@ -0,0 +1,53 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import "strings"
+
+// logConfig logs the effective configuration of the server at startup. Secrets
+// (private key, passphrase, fingerprint, self test token and encryption key) are
+// never logged, only whether they have been set.
+func (ds *DownloadServer) logConfig(addr string) {
+	listen := addr
+	if ds.SocketPath != "" {
+		listen = ds.SocketPath
+	}
+	ds.logger().Info("Artifact download server configuration", Fields{
+		"listen":              listen,
+		"https":               ds.CertPemFile != "" && ds.KeyPemFile != "",
+		"clientCAFile":        ds.ClientCAFile,
+		"tenancy":             ds.Tenancy,
+		"user":                ds.User,
+		"region":              ds.Region,
+		"regions":             strings.Join(ds.Regions, ","),
+		"ociEndpoint":         ds.OCIEndpoint,
+		"namespace":           ds.Namespace,
+		"bucket":              ds.BucketName,
+		"objectPrefix":        ds.ObjectPrefix,
+		"privateKey":          redact(ds.Privatekey),
+		"passphrase":          redact(ds.Passphrase),
+		"fingerprint":         redact(ds.Fingerprint),
+		"selftestToken":       redact(ds.SelftestToken),
+		"debugToken":          redact(ds.DebugToken),
+		"maintenanceToken":    redact(ds.MaintenanceToken),
+		"maintenance":         ds.Maintenance,
+		"encryptionKey":       redact(string(ds.EncryptionKey)),
+		"tokenKey":            redact(string(ds.TokenKey)),
+		"sseCustomerKey":      ds.sseKey != nil,
+		"maxDownloadDuration": ds.MaxDownloadDuration.String(),
+		"idleTimeout":         ds.IdleTimeout.String(),
+		"maxConnections":      ds.MaxConnections,
+		"maxDownloads":        ds.MaxDownloads,
+		"downloadQueue":       ds.DownloadQueue,
+		"offload":             ds.Offload,
+		"debug":               ds.Debug,
+	})
+}
+
+// redact hides a secret value, reporting only whether it is set.
+func redact(secret string) string {
+	if secret == "" {
+		return "<not set>"
+	}
+	return "<redacted>"
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// serverStats is the JSON document served by the stats endpoint.
+type serverStats struct {
+	// TenancyUsage is the number of bytes served per tenancy in the current
+	// quota window.
+	TenancyUsage map[string]int64 `json:"tenancyUsage"`
+	// Regions is the health of each region when several are configured.
+	Regions map[string]regionStatus `json:"regions,omitempty"`
+	// PARCoalescing counts the PAR creations made and the downloads that
+	// shared one instead of creating their own.
+	PARCoalescing coalescingStats `json:"parCoalescing"`
+	// Downloads reports the downloads in progress and queued when MaxDownloads
+	// is set.
+	Downloads *downloadStats `json:"downloads,omitempty"`
+	// Events counts the download events posted to the webhook when one is
+	// configured.
+	Events *eventStats `json:"events,omitempty"`
+	// Throughput estimates the percentiles of the recent download throughput
+	// in bytes per second.
+	Throughput *throughputStats `json:"throughput,omitempty"`
+}
+
+// Stats handler. Reports the current server statistics as JSON.
+func stats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		httpError(w, r, "protocol error", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(downloadServer.statistics())
+}
+
+// statistics gathers the current server statistics.
+func (ds *DownloadServer) statistics() *serverStats {
+	s := &serverStats{
+		TenancyUsage:  ds.quotas.usage(),
+		PARCoalescing: ds.parFlight.report(),
+	}
+	if ds.regions != nil {
+		s.Regions = ds.regions.report()
+	}
+	if ds.downloads != nil {
+		s.Downloads = ds.downloads.report()
+	}
+	if ds.events != nil {
+		s.Events = ds.events.report()
+	}
+	if ds.throughput != nil {
+		s.Throughput = ds.throughput.report()
+	}
+	return s
+}
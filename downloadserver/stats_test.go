@@ -0,0 +1,71 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoalescingStats(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	f.server()
+	f.parDelay = 50 * time.Millisecond
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testDownload("GET", "t=ten&a=a/f.txt")
+		}()
+	}
+	wg.Wait()
+
+	rec := httptest.NewRecorder()
+	stats(rec, httptest.NewRequest("GET", "/stats", nil))
+	var s serverStats
+	if err := json.NewDecoder(rec.Body).Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	if c := s.PARCoalescing; c.Calls != 1 || c.Coalesced != 3 || c.MaxShared != 4 {
+		t.Errorf("stats parCoalescing = %+v, want 1 call shared by 4", c)
+	}
+	if s.TenancyUsage["ten"] != 20 {
+		t.Errorf("stats tenancyUsage = %v", s.TenancyUsage)
+	}
+
+	rec = httptest.NewRecorder()
+	metrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != metricsContentType {
+		t.Errorf("metrics Content-Type = %q", ct)
+	}
+	for _, line := range []string{
+		"# TYPE runner_download_par_creations_total counter",
+		"runner_download_par_creations_total 1",
+		"runner_download_par_coalesced_total 3",
+		"runner_download_par_max_shared 4",
+		`runner_download_tenancy_bytes{tenancy="ten"} 20`,
+	} {
+		if !strings.Contains(rec.Body.String(), line+"\n") {
+			t.Errorf("metrics lack %s:\n%s", line, rec.Body.String())
+		}
+	}
+
+	for _, handler := range []func(w *httptest.ResponseRecorder){
+		func(w *httptest.ResponseRecorder) { stats(w, httptest.NewRequest("POST", "/stats", nil)) },
+		func(w *httptest.ResponseRecorder) { metrics(w, httptest.NewRequest("POST", "/metrics", nil)) },
+	} {
+		rec := httptest.NewRecorder()
+		handler(rec)
+		if rec.Code != 405 || rec.Header().Get("Allow") != "GET" {
+			t.Errorf("POST = %d Allow %q, want 405", rec.Code, rec.Header().Get("Allow"))
+		}
+	}
+}
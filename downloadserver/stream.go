@@ -0,0 +1,321 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// artifactStream is an opened artifact, from either backend, ready to be sent to
+// the client.
+type artifactStream struct {
+	name         string // local path or object name, used for logging
+	filename     string // file name presented to the client
+	body         io.Reader
+	size         int64 // -1 when unknown
+	lastModified string
+	contentType  string // defaults to binary/octet-stream
+	storedType   string // content type recorded by the backend, if any
+	encoding     string // Content-Encoding of body, if any
+	digest       string // Digest header value, if known up front
+	contentMD5   string // Content-MD5 of the stored object, if known
+	contentRange string // Content-Range of a partial (206) response
+	ranges       bool   // whether a Range request for the artifact is honored
+	// changed, when set, reports whether the artifact was modified while it
+	// was being streamed.
+	changed func() bool
+}
+
+// transferOptions are the per request options controlling how an artifact is sent.
+type transferOptions struct {
+	// digest is the expected sha256 of the content, verified while streaming.
+	digest string
+	// trailers sends the transfer metadata (bytes, sha256 and duration) as HTTP
+	// trailers once the body has been written.
+	trailers bool
+	// entry is the name of a single file to send from within a tar archive.
+	entry string
+	// entries are the patterns of the entries of a tar archive sent as a new tar.
+	entries []string
+	// follow keeps streaming content appended to a local file while it grows.
+	follow bool
+	// encrypt sends the content encrypted with a per download data key.
+	encrypt bool
+	// acceptGzip is set when the client accepts a gzip encoded response.
+	acceptGzip bool
+	// noStore recomputes the cached digest, content type and decompressed size
+	// of a local file.
+	noStore bool
+	// resume is the resume= of a resumable local download.
+	resume string
+	// progress counts the bytes written for the resume session.
+	progress *resumeWriter
+	// byteRange limits the download to a range of the artifact.
+	byteRange *byteRange
+	// timing collects the Server-Timing of the download when enabled.
+	timing *serverTiming
+	// deadline is the request context when the client gave a deadline.
+	deadline context.Context
+	// head sends only the headers of the download, for a HEAD request.
+	head bool
+}
+
+// Names of the trailers sent when transfer metadata is requested with trailers=1.
+const (
+	trailerBytes    = "X-Bytes-Transferred"
+	trailerSHA256   = "X-Content-SHA256"
+	trailerDuration = "X-Duration-Ms"
+	trailerChanged  = "X-Artifact-Changed"
+)
+
+// MaxWriteChunkSize bounds WriteChunkSize, as every download holds a chunk in
+// memory.
+const MaxWriteChunkSize = 64 << 20
+
+// chunkWriter gathers the response body into chunks of size bytes, each written
+// and flushed to the client at once, so that the flushes come at a steady
+// cadence whatever the reads of the artifact return.
+type chunkWriter struct {
+	http.ResponseWriter
+	buf []byte
+}
+
+func newChunkWriter(w http.ResponseWriter, size int) *chunkWriter {
+	return &chunkWriter{ResponseWriter: w, buf: make([]byte, 0, size)}
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(c.buf[len(c.buf):cap(c.buf)], p)
+		c.buf = c.buf[:len(c.buf)+n]
+		p = p[n:]
+		written += n
+		if len(c.buf) == cap(c.buf) {
+			if err := c.flushChunk(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flushChunk writes the gathered bytes, if any, and flushes them to the client.
+func (c *chunkWriter) flushChunk() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	_, err := c.ResponseWriter.Write(c.buf)
+	c.buf = c.buf[:0]
+	if err != nil {
+		return err
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// statusWriter records the status, the number of body bytes and the time to
+// first byte of a response. The first byte is taken to be sent just before the
+// first successful write, of the body or of the header alone.
+type statusWriter struct {
+	http.ResponseWriter
+	status    int
+	written   int64
+	firstByte time.Time
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+		s.firstByte = time.Now()
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusWriter) Write(p []byte) (int, error) {
+	started := time.Now()
+	n, err := s.ResponseWriter.Write(p)
+	if s.status == 0 && err == nil {
+		s.status = http.StatusOK
+		s.firstByte = started
+	}
+	s.written += int64(n)
+	return n, err
+}
+
+// Flush passes flushes through to the underlying writer when it supports them.
+func (s *statusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// errResponseCommitted is returned when a download failed after its status and
+// part of its body were sent, so no error response can be written any more.
+var errResponseCommitted = errors.New("response already committed")
+
+// sendArtifact writes the download response headers for a and streams its content,
+// returning the number of bytes written. A failure once the response is committed
+// is logged and returned as errResponseCommitted.
+func (ds *DownloadServer) sendArtifact(w http.ResponseWriter, a *artifactStream, opts transferOptions) (int64, error) {
+	start := time.Now()
+	w.Header().Set("Content-Disposition", ds.contentDisposition(a.filename))
+	if pinned := ds.pinnedContentType(a.filename); pinned != "" {
+		a.contentType = pinned
+	}
+	if a.contentType != "" {
+		w.Header().Set("Content-Type", a.contentType)
+	} else {
+		w.Header().Set("Content-Type", "binary/octet-stream")
+	}
+	if a.encoding != "" {
+		w.Header().Set("Content-Encoding", a.encoding)
+	}
+	compress := ds.compressible(a, opts)
+	if compress {
+		w.Header().Add("Vary", "Accept-Encoding")
+		compress = opts.acceptGzip
+	}
+	if compress {
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	// Ranges are only advertised when a Range request would get one, not the
+	// whole artifact instead.
+	if a.ranges && opts.digest == "" && !opts.encrypt && !opts.follow {
+		w.Header().Set("Accept-Ranges", "bytes")
+	} else {
+		w.Header().Set("Accept-Ranges", "none")
+	}
+	if a.lastModified != "" {
+		w.Header().Set("Last-Modified", a.lastModified)
+	}
+	// The digest of the content doesn't hold for an encrypted, compressed or
+	// partial body.
+	digestHeader := ds.DigestHeader && !opts.encrypt && !compress && a.contentRange == ""
+	if digestHeader && a.digest != "" {
+		w.Header().Set("Digest", a.digest)
+	}
+	digestTrailer := digestHeader && a.digest == ""
+	if a.contentMD5 != "" && !opts.encrypt && !compress && a.contentRange == "" {
+		w.Header().Set("Content-MD5", a.contentMD5)
+	}
+
+	// The chunks are of the bytes sent, after any encryption or compression.
+	out := w
+	var chunks *chunkWriter
+	if ds.WriteChunkSize > 0 {
+		chunks = newChunkWriter(w, ds.WriteChunkSize)
+		out = chunks
+	}
+	var dst io.Writer = out
+	var closer io.Closer
+	size := a.size
+	if opts.encrypt {
+		enc, err := ds.newEncryptingWriter(out)
+		if err != nil {
+			return 0, err
+		}
+		dst, closer = enc, enc
+		size = encryptedSize(a.size)
+	} else if compress {
+		zw := gzip.NewWriter(out)
+		dst, closer = zw, zw
+		size = -1
+	}
+	if opts.progress != nil {
+		opts.progress.Writer = dst
+		dst = opts.progress
+	}
+
+	body := a.body
+	if opts.deadline != nil {
+		body = &deadlineReader{body, opts.deadline}
+	}
+	var sum hash.Hash
+	if opts.trailers {
+		// Trailers are only delivered with a chunked response, so the length is
+		// left out and the content hashed as it goes past.
+		trailers := fmt.Sprintf("%s, %s, %s", trailerBytes, trailerSHA256, trailerDuration)
+		if a.changed != nil {
+			trailers += ", " + trailerChanged
+		}
+		if digestTrailer {
+			trailers += ", Digest"
+		}
+		w.Header().Set("Trailer", trailers)
+		sum = sha256.New()
+		body = io.TeeReader(body, sum)
+	} else if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	if opts.timing != nil && ds.ServerTiming {
+		w.Header().Set("Server-Timing", opts.timing.header())
+	}
+	if a.size == 0 && ds.sendEmpty(w, a, opts) {
+		return 0, nil
+	}
+	if a.contentRange != "" {
+		w.Header().Set("Content-Range", a.contentRange)
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	// A HEAD is answered with the headers alone, the body is never read.
+	if opts.head {
+		if a.contentRange == "" {
+			w.WriteHeader(http.StatusOK)
+		}
+		return 0, nil
+	}
+
+	nbytes, err := copyVerified(dst, body, opts.digest)
+	if err == nil && closer != nil {
+		err = closer.Close()
+	}
+	if err == nil && chunks != nil {
+		err = chunks.flushChunk()
+	}
+	if err == errDigestMismatch {
+		ds.logger().Error("Download aborted", Fields{"artifact": a.name, "error": err.Error()})
+		panic(http.ErrAbortHandler)
+	}
+	if err != nil && (nbytes > 0 || a.contentRange != "") {
+		ds.logger().Info("Partial download, response already committed", Fields{"artifact": a.name, "bytes": nbytes, "error": err.Error()})
+		return nbytes, errResponseCommitted
+	}
+	if err != nil {
+		return nbytes, err
+	}
+
+	// The download can't be taken back at this point but it can be flagged so
+	// the client knows to retry.
+	changed := a.changed != nil && a.changed()
+	if changed {
+		ds.logger().Warn("Artifact changed during download, content may be inconsistent", Fields{"artifact": a.name})
+	}
+
+	if opts.trailers {
+		if a.changed != nil {
+			w.Header().Set(trailerChanged, strconv.FormatBool(changed))
+		}
+		w.Header().Set(trailerBytes, strconv.FormatInt(nbytes, 10))
+		w.Header().Set(trailerSHA256, hex.EncodeToString(sum.Sum(nil)))
+		if digestTrailer {
+			w.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum.Sum(nil)))
+		}
+		w.Header().Set(trailerDuration, strconv.FormatInt(int64(time.Since(start)/time.Millisecond), 10))
+	}
+	return nbytes, nil
+}
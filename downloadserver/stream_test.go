@@ -0,0 +1,209 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// failingReader returns err once its content has been read.
+type failingReader struct {
+	content io.Reader
+	err     error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.content.Read(p)
+	if err == io.EOF {
+		return n, f.err
+	}
+	return n, err
+}
+
+func TestSendArtifactFailure(t *testing.T) {
+	logger := &testLogger{}
+	ds := &DownloadServer{Logger: logger}
+	broken := errors.New("connection reset")
+
+	// Nothing sent yet: the error can still be answered.
+	a := &artifactStream{name: "f.txt", filename: "f.txt", size: 5, body: &failingReader{strings.NewReader(""), broken}}
+	if _, err := ds.sendArtifact(httptest.NewRecorder(), a, transferOptions{}); err != broken {
+		t.Errorf("failure before the body = %v, want %v", err, broken)
+	}
+
+	a = &artifactStream{name: "f.txt", filename: "f.txt", size: 5, body: &failingReader{strings.NewReader("hel"), broken}}
+	n, err := ds.sendArtifact(httptest.NewRecorder(), a, transferOptions{})
+	if err != errResponseCommitted || n != 3 {
+		t.Errorf("failure part way = %d %v, want 3 %v", n, err, errResponseCommitted)
+	}
+	if logged := logger.find("Partial download, response already committed"); len(logged) != 1 || logged[0].fields["bytes"] != int64(3) {
+		t.Errorf("partial download logs = %+v", logged)
+	}
+}
+
+func TestPartialDownloadAborted(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	content := bytes.Repeat([]byte("x"), 64<<10)
+	f.put("big.bin", content)
+	f.server()
+	f.cutAfter = 1000
+
+	rec := httptest.NewRecorder()
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("cut download = %d, panic %v, want it aborted", rec.Code, p)
+		}
+		if rec.Body.Len() == 0 || rec.Body.Len() >= len(content) {
+			t.Errorf("cut download sent %d bytes", rec.Body.Len())
+		}
+	}()
+	download(rec, httptest.NewRequest("GET", downloadPath+"?t=ten&a=big.bin", nil))
+}
+
+// chunkRecorder records the writes and flushes reaching the client.
+type chunkRecorder struct {
+	*httptest.ResponseRecorder
+	writes  []string
+	flushes int
+}
+
+func (c *chunkRecorder) Write(p []byte) (int, error) {
+	c.writes = append(c.writes, string(p))
+	return c.ResponseRecorder.Write(p)
+}
+
+func (c *chunkRecorder) Flush() {
+	c.flushes++
+	c.ResponseRecorder.Flush()
+}
+
+func TestChunkWriter(t *testing.T) {
+	rec := &chunkRecorder{ResponseRecorder: httptest.NewRecorder()}
+	c := newChunkWriter(rec, 4)
+	for _, p := range []string{"ab", "cdefghi", "j"} {
+		if n, err := c.Write([]byte(p)); n != len(p) || err != nil {
+			t.Errorf("Write(%q) = %d %v", p, n, err)
+		}
+	}
+	if err := c.flushChunk(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(rec.writes, "|"); got != "abcd|efgh|ij" || rec.flushes != 3 {
+		t.Errorf("writes %q with %d flushes, want abcd|efgh|ij with 3", got, rec.flushes)
+	}
+	// Nothing gathered is nothing to write.
+	c.flushChunk()
+	if len(rec.writes) != 3 {
+		t.Errorf("empty chunk written: %q", rec.writes)
+	}
+}
+
+func TestWriteChunkSize(t *testing.T) {
+	ds := &DownloadServer{WriteChunkSize: 4}
+	rec := &chunkRecorder{ResponseRecorder: httptest.NewRecorder()}
+	a := &artifactStream{name: "f.txt", filename: "f.txt", size: 10, body: strings.NewReader("abcdefghij")}
+	if n, err := ds.sendArtifact(rec, a, transferOptions{}); n != 10 || err != nil {
+		t.Fatalf("sendArtifact = %d %v", n, err)
+	}
+	if got := strings.Join(rec.writes, "|"); got != "abcd|efgh|ij" || rec.Body.String() != "abcdefghij" {
+		t.Errorf("writes %q, body %q", got, rec.Body.String())
+	}
+}
+
+func TestTrailers(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.txt": "hello"})
+	defer os.RemoveAll(dir)
+	localServer()
+	server := httptest.NewServer(http.HandlerFunc(download))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + downloadPath + "?a=f.txt&trailers=1&s=" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "hello" {
+		t.Fatalf("download with trailers=1 = %q, %v", body, err)
+	}
+	// Trailers are only delivered with a chunked response.
+	if resp.ContentLength != -1 {
+		t.Errorf("Content-Length = %d, want none", resp.ContentLength)
+	}
+	if got := resp.Trailer.Get(trailerBytes); got != "5" {
+		t.Errorf("%s = %q, want 5", trailerBytes, got)
+	}
+	if got := resp.Trailer.Get(trailerSHA256); got != sha256Hex("hello") {
+		t.Errorf("%s = %q, want the content's sha256", trailerSHA256, got)
+	}
+	if _, err := strconv.Atoi(resp.Trailer.Get(trailerDuration)); err != nil {
+		t.Errorf("%s = %q, want milliseconds", trailerDuration, resp.Trailer.Get(trailerDuration))
+	}
+
+	// Without trailers=1 the length is sent and there are no trailers.
+	resp, err = http.Get(server.URL + downloadPath + "?a=f.txt&s=" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.ContentLength != 5 || len(resp.Trailer) != 0 {
+		t.Errorf("download without trailers=1 = Content-Length %d trailers %v", resp.ContentLength, resp.Trailer)
+	}
+}
+
+func TestDetectChanges(t *testing.T) {
+	const size = 8 << 20
+	dir := testStore(t, map[string]string{"f.txt": "hello", "big.bin": string(make([]byte, size))})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.DetectChanges = true
+	logger := &testLogger{}
+	ds.Logger = logger
+	server := httptest.NewServer(http.HandlerFunc(download))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + downloadPath + "?a=f.txt&trailers=1&s=" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if got := resp.Trailer.Get(trailerChanged); got != "false" {
+		t.Errorf("%s of an unchanged artifact = %q, want false", trailerChanged, got)
+	}
+
+	// The artifact grows while the client is still reading it.
+	resp, err = http.Get(server.URL + downloadPath + "?a=big.bin&trailers=1&s=" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(resp.Body, make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "big.bin"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("more"))
+	f.Close()
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if got := resp.Trailer.Get(trailerChanged); got != "true" {
+		t.Errorf("%s of a changed artifact = %q, want true", trailerChanged, got)
+	}
+	if len(logger.find("Artifact changed during download, content may be inconsistent")) != 1 {
+		t.Error("changed artifact not logged")
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	ocistorage "github.com/oracle/oci-go-sdk/objectstorage"
+)
+
+// sweepTimeout bounds a single sweep of a region.
+const sweepTimeout = 5 * time.Minute
+
+// sweepPARs periodically deletes the download PARs that have expired, or are
+// older than PARMaxAge when set, until stop is closed. PARs handed out in
+// redirect mode are also deleted once older than RedirectPARGrace when set.
+// PARs handed out in redirect mode and PARs left behind by failed downloads are
+// otherwise only removed when the next PAR is created.
+func (ds *DownloadServer) sweepPARs(stop <-chan struct{}) {
+	ticker := time.NewTicker(ds.PARSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		regions := []string{ds.Region}
+		if len(ds.Regions) > 0 {
+			regions = ds.Regions
+		}
+		for _, region := range regions {
+			ctx, cancel := context.WithTimeout(context.Background(), sweepTimeout)
+			deleted, err := ds.sweepRegion(ctx, region)
+			cancel()
+			if err != nil {
+				ds.logger().Warn("PAR sweep failed", Fields{"region": region, "deleted": deleted, "error": err.Error()})
+				continue
+			}
+			ds.logger().Info("PAR sweep complete", Fields{"region": region, "deleted": deleted})
+		}
+	}
+}
+
+// sweepRegion deletes the stale download PARs of the bucket in region, returning
+// how many were deleted.
+func (ds *DownloadServer) sweepRegion(ctx context.Context, region string) (int, error) {
+	client, err := ds.objectStorageClient(region)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	deleted := 0
+	var page *string
+	for {
+		list, err := client.ListPreauthenticatedRequests(ctx, ocistorage.ListPreauthenticatedRequestsRequest{
+			NamespaceName: &ds.Namespace,
+			BucketName:    &ds.BucketName,
+			Page:          page,
+		})
+		if err != nil {
+			return deleted, err
+		}
+		for _, item := range list.Items {
+			if item.Name == nil || !strings.HasPrefix(*item.Name, "download-") {
+				continue
+			}
+			stale := item.TimeExpires != nil && item.TimeExpires.Before(now)
+			if ds.PARMaxAge > 0 && item.TimeCreated != nil && now.Sub(item.TimeCreated.Time) > ds.PARMaxAge {
+				stale = true
+			}
+			if ds.RedirectPARGrace > 0 && strings.HasPrefix(*item.Name, redirectPARPrefix) && item.TimeCreated != nil && now.Sub(item.TimeCreated.Time) > ds.RedirectPARGrace {
+				stale = true
+			}
+			if !stale {
+				continue
+			}
+			_, err := client.DeletePreauthenticatedRequest(ctx, ocistorage.DeletePreauthenticatedRequestRequest{
+				NamespaceName: &ds.Namespace,
+				BucketName:    &ds.BucketName,
+				ParId:         item.Id,
+			})
+			if err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+		if list.OpcNextPage == nil {
+			return deleted, nil
+		}
+		page = list.OpcNextPage
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSweepRegion(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	ds := f.server()
+	ds.RedirectPARGrace = time.Minute
+	now := time.Now().UTC()
+	for _, par := range []fakePAR{
+		{ID: "expired", Name: "download-1", Created: now.Add(-time.Hour), Expires: now.Add(-time.Minute)},
+		{ID: "live", Name: "download-2", Created: now.Add(-time.Hour), Expires: now.Add(time.Minute)},
+		{ID: "redirect-old", Name: redirectPARPrefix + "1", Created: now.Add(-2 * time.Minute), Expires: now.Add(time.Hour)},
+		{ID: "redirect-new", Name: redirectPARPrefix + "2", Created: now, Expires: now.Add(time.Hour)},
+		{ID: "foreign", Name: "backup", Created: now.Add(-time.Hour), Expires: now.Add(-time.Minute)},
+	} {
+		par := par
+		f.parList = append(f.parList, &par)
+	}
+
+	deleted, err := ds.sweepRegion(context.Background(), ds.Region)
+	if err != nil || deleted != 2 {
+		t.Errorf("sweep = %d %v, want 2 deleted", deleted, err)
+	}
+	if left := f.parIDs(); fmt.Sprint(left) != "[foreign live redirect-new]" {
+		t.Errorf("PARs left = %v", left)
+	}
+
+	// Without a grace window redirect PARs stay until they expire, and
+	// PARMaxAge ages out download PARs that are still valid.
+	ds.RedirectPARGrace = 0
+	ds.PARMaxAge = 30 * time.Minute
+	if deleted, err := ds.sweepRegion(context.Background(), ds.Region); err != nil || deleted != 1 {
+		t.Errorf("sweep with PARMaxAge = %d %v, want 1 deleted", deleted, err)
+	}
+	if left := f.parIDs(); fmt.Sprint(left) != "[foreign redirect-new]" {
+		t.Errorf("PARs left = %v", left)
+	}
+}
+
+func TestRedirectPARGrace(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	ds := f.server()
+	ds.RedirectPARTTL = time.Hour
+	ds.RedirectPARGrace = 2 * time.Minute
+
+	rec := testDownload("GET", "t=ten&a=a/f.txt&mode=url")
+	if rec.Code != 200 {
+		t.Fatalf("mode=url = %d %s", rec.Code, rec.Body.String())
+	}
+	var link parLink
+	if err := json.NewDecoder(rec.Body).Decode(&link); err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(link.Expires); until > 2*time.Minute || until < time.Minute {
+		t.Errorf("link expires in %s, want the 2m grace window", until)
+	}
+	if len(f.parList) != 1 || time.Until(f.parList[0].Expires) < 59*time.Minute {
+		t.Errorf("redirect PAR = %+v, want it to live for RedirectPARTTL", f.parList)
+	}
+	if name := f.parList[0].Name; len(name) <= len(redirectPARPrefix) || name[:len(redirectPARPrefix)] != redirectPARPrefix {
+		t.Errorf("redirect PAR name = %q", name)
+	}
+}
+
+func TestSweepPARs(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	ds := f.server()
+	ds.PARSweepInterval = 10 * time.Millisecond
+	logger := &testLogger{}
+	ds.Logger = logger
+	now := time.Now().UTC()
+	f.parList = append(f.parList, &fakePAR{ID: "expired", Name: "download-1", Created: now.Add(-time.Hour), Expires: now.Add(-time.Minute)})
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		ds.sweepPARs(stop)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.parIDs()) != 0 || len(logger.find("PAR sweep complete")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expired PAR not swept, left %v", f.parIDs())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A failing sweep is logged and the next one is tried all the same.
+	f.mu.Lock()
+	f.status = 500
+	f.mu.Unlock()
+	for len(logger.find("PAR sweep failed")) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("failing sweeps not retried")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sweeper didn't stop")
+	}
+}
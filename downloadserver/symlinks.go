@@ -0,0 +1,81 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ * Symbolic links in local storepaths. Opening a local artifact follows any
+ * symlink on its path, so a link inside the storepath could serve any file the
+ * server can read. LocalSymlinks decides what happens instead: SymlinksRefuse,
+ * the default, refuses artifacts with a symlink anywhere on their path below
+ * the storepath, SymlinksContain resolves the links and refuses artifacts whose
+ * target lies outside the storepath, and SymlinksFollow follows them as before.
+ * Unless following, artifacts whose path leads out of the storepath with ".."
+ * are refused too. The storepath itself may be a symlink.
+ */
+
+// Symlink policies selected with LocalSymlinks.
+const (
+	SymlinksRefuse  = "refuse"
+	SymlinksContain = "contain"
+	SymlinksFollow  = "follow"
+)
+
+// errOutsideStore refuses a local artifact that the symlink policy doesn't
+// allow to be served.
+var errOutsideStore = &statusError{http.StatusForbidden, "artifact is not available for download"}
+
+// checkSymlinks checks the local artifactPath below storepath against the
+// symlink policy. Paths that don't exist are left for opening them to fail.
+func (ds *DownloadServer) checkSymlinks(storepath string, artifactPath string) error {
+	if ds.LocalSymlinks == SymlinksFollow {
+		return nil
+	}
+	root := filepath.Clean(storepath)
+	rel, err := filepath.Rel(root, filepath.Clean(artifactPath))
+	if err != nil || !withinStore(rel) {
+		ds.logger().Warn("Refused local artifact outside the storepath", Fields{"storepath": storepath, "path": artifactPath})
+		return errOutsideStore
+	}
+
+	if ds.LocalSymlinks == SymlinksContain {
+		resolvedRoot, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return nil
+		}
+		resolved, err := filepath.EvalSymlinks(artifactPath)
+		if err != nil {
+			return nil
+		}
+		if rel, err = filepath.Rel(resolvedRoot, resolved); err != nil || !withinStore(rel) {
+			ds.logger().Warn("Refused local artifact linked outside the storepath", Fields{"storepath": storepath, "path": artifactPath, "target": resolved})
+			return errOutsideStore
+		}
+		return nil
+	}
+
+	dir := root
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, name)
+		fi, err := os.Lstat(dir)
+		if err != nil {
+			return nil
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			ds.logger().Warn("Refused symlinked local artifact", Fields{"storepath": storepath, "path": artifactPath, "link": dir})
+			return errOutsideStore
+		}
+	}
+	return nil
+}
+
+// withinStore reports whether a path relative to the storepath stays below it.
+func withinStore(rel string) bool {
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
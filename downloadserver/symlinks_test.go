@@ -0,0 +1,61 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalSymlinks(t *testing.T) {
+	outside := testStore(t, map[string]string{"secret.txt": "secret"})
+	defer os.RemoveAll(outside)
+	dir := testStore(t, map[string]string{"f.txt": "hello", "sub/g.txt": "sub"})
+	defer os.RemoveAll(dir)
+	for link, target := range map[string]string{
+		"inside.txt": filepath.Join(dir, "f.txt"),
+		"linked":     filepath.Join(dir, "sub"),
+		"escape.txt": filepath.Join(outside, "secret.txt"),
+		"escape":     outside,
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A storepath that is a symlink itself is allowed.
+	storeLink := filepath.Join(outside, "store")
+	if err := os.Symlink(dir, storeLink); err != nil {
+		t.Fatal(err)
+	}
+	ds := localServer()
+
+	for _, tc := range []struct {
+		policy, artifact string
+		want             int
+	}{
+		{SymlinksRefuse, "f.txt", 200},
+		{SymlinksRefuse, "inside.txt", 403},
+		{SymlinksRefuse, "linked/g.txt", 403},
+		{SymlinksRefuse, "escape.txt", 403},
+		{SymlinksContain, "inside.txt", 200},
+		{SymlinksContain, "linked/g.txt", 200},
+		{SymlinksContain, "escape.txt", 403},
+		{SymlinksContain, "escape/secret.txt", 403},
+		{SymlinksFollow, "escape.txt", 200},
+		{SymlinksRefuse, "../" + filepath.Base(outside) + "/secret.txt", 403},
+		{SymlinksContain, "../" + filepath.Base(outside) + "/secret.txt", 403},
+	} {
+		ds.LocalSymlinks = tc.policy
+		if rec := testDownload("GET", "a="+tc.artifact+"&s="+dir); rec.Code != tc.want {
+			t.Errorf("%s %s = %d, want %d", tc.policy, tc.artifact, rec.Code, tc.want)
+		}
+	}
+
+	ds.LocalSymlinks = ""
+	rec := testDownload("GET", "a=f.txt&s="+storeLink)
+	if body, _ := ioutil.ReadAll(rec.Body); rec.Code != 200 || string(body) != "hello" {
+		t.Errorf("symlinked storepath = %d %q", rec.Code, body)
+	}
+}
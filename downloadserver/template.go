@@ -0,0 +1,104 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+/*
+ * Artifact templates. Rather than have clients put together storage keys, an
+ * ArtifactTemplate such as {tenant}/{pipeline}/{artifact} lays out where
+ * artifacts are stored and a request without a= gives the variables as query
+ * parameters of the same name, tenant=, pipeline= and artifact=. Each value is
+ * checked against templateValue before it is interpolated, which keeps path
+ * separators, ".." and other surprises out of the key. a= itself is still
+ * accepted, for download tokens and content addressed requests.
+ */
+
+// templateValue is the pattern every template variable value must match.
+var templateValue = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,254}$`)
+
+// templateName is the pattern of the variable names in a template.
+var templateName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// downloadParams are the query parameters of a download, which template
+// variables can't be named after.
+var downloadParams = []string{
+	"a", "s", "t", "n", "h", "archive", "entry", "entries", "mode", "trailers", "follow", "encrypt",
+	"manifest", "prefix", "chunks", "resume", "exists", "info", "parts", "offset",
+	"length", "fallback", "encoding",
+}
+
+// artifactTemplate is a compiled ArtifactTemplate: literal text alternating
+// with variable names.
+type artifactTemplate struct {
+	literals []string
+	names    []string
+}
+
+// newArtifactTemplate compiles template. A nil template is returned for an
+// empty one.
+func newArtifactTemplate(template string) (*artifactTemplate, error) {
+	if template == "" {
+		return nil, nil
+	}
+	t := &artifactTemplate{}
+	rest := template
+	for {
+		open := strings.Index(rest, "{")
+		if open < 0 {
+			if strings.Contains(rest, "}") {
+				return nil, fmt.Errorf("invalid artifact template %s: unbalanced }", template)
+			}
+			t.literals = append(t.literals, rest)
+			break
+		}
+		end := strings.Index(rest[open:], "}")
+		if end < 0 || strings.Contains(rest[:open], "}") {
+			return nil, fmt.Errorf("invalid artifact template %s: unbalanced braces", template)
+		}
+		name := rest[open+1 : open+end]
+		if !templateName.MatchString(name) {
+			return nil, fmt.Errorf("invalid artifact template %s: bad variable name {%s}", template, name)
+		}
+		for _, param := range downloadParams {
+			if name == param {
+				return nil, fmt.Errorf("invalid artifact template %s: {%s} is a download parameter", template, name)
+			}
+		}
+		t.literals = append(t.literals, rest[:open])
+		t.names = append(t.names, name)
+		rest = rest[open+end+1:]
+	}
+	if len(t.names) == 0 {
+		return nil, fmt.Errorf("invalid artifact template %s: no variables", template)
+	}
+	return t, nil
+}
+
+// expand interpolates the variables given in parms into the artifact name. A
+// missing, repeated or unsafe value is a 400 Bad Request.
+func (t *artifactTemplate) expand(parms url.Values) (string, error) {
+	var b bytes.Buffer
+	for i, name := range t.names {
+		values := parms[name]
+		if len(values) == 0 {
+			return "", badRequest(fmt.Sprintf("missing artifact a= or template variable %s=", name))
+		}
+		if len(values) > 1 {
+			return "", badRequest(fmt.Sprintf("template variable %s= given more than once", name))
+		}
+		if !templateValue.MatchString(values[0]) {
+			return "", badRequest(fmt.Sprintf("invalid template variable %s=", name))
+		}
+		b.WriteString(t.literals[i])
+		b.WriteString(values[0])
+	}
+	b.WriteString(t.literals[len(t.names)])
+	return b.String(), nil
+}
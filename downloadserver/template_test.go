@@ -0,0 +1,73 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestNewArtifactTemplate(t *testing.T) {
+	if tmpl, err := newArtifactTemplate(""); tmpl != nil || err != nil {
+		t.Errorf("empty template = %v %v", tmpl, err)
+	}
+	for _, template := range []string{
+		"builds/artifact",
+		"{tenant}/{artifact",
+		"{tenant}}/{artifact}",
+		"tenant}/{artifact}",
+		"{Tenant}/{artifact}",
+		"{tenant}/{a}",
+		"{}/{artifact}",
+	} {
+		if _, err := newArtifactTemplate(template); err == nil {
+			t.Errorf("template %q accepted", template)
+		}
+	}
+}
+
+func TestArtifactTemplateExpand(t *testing.T) {
+	tmpl, err := newArtifactTemplate("builds/{tenant}/{pipeline}-{artifact}.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		query, want string
+		ok          bool
+	}{
+		{"tenant=acme&pipeline=ci&artifact=app-1.0", "builds/acme/ci-app-1.0.tar", true},
+		{"tenant=acme&pipeline=ci", "", false},
+		{"tenant=acme&tenant=other&pipeline=ci&artifact=app", "", false},
+		{"tenant=..&pipeline=ci&artifact=app", "", false},
+		{"tenant=acme&pipeline=ci%2F..&artifact=app", "", false},
+		{"tenant=&pipeline=ci&artifact=app", "", false},
+	} {
+		parms, _ := url.ParseQuery(tc.query)
+		got, err := tmpl.expand(parms)
+		if tc.ok && (err != nil || got != tc.want) {
+			t.Errorf("expand(%s) = %q %v, want %q", tc.query, got, err, tc.want)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("expand(%s) = %q, want an error", tc.query, got)
+		}
+	}
+}
+
+func TestTemplateDownload(t *testing.T) {
+	dir := testStore(t, map[string]string{"acme/ci/app.txt": "app", "plain.txt": "plain"})
+	defer os.RemoveAll(dir)
+	ds := localServer()
+	ds.template, _ = newArtifactTemplate("{tenant}/{pipeline}/{artifact}.txt")
+
+	if rec := testDownload("GET", "tenant=acme&pipeline=ci&artifact=app&s="+dir); rec.Code != 200 || rec.Body.String() != "app" {
+		t.Errorf("template download = %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := testDownload("GET", "tenant=acme&artifact=app&s="+dir); rec.Code != 400 {
+		t.Errorf("download missing a variable = %d, want 400", rec.Code)
+	}
+	// a= is still accepted.
+	if rec := testDownload("GET", "a=plain.txt&s="+dir); rec.Code != 200 || rec.Body.String() != "plain" {
+		t.Errorf("a= download with a template = %d %q", rec.Code, rec.Body.String())
+	}
+}
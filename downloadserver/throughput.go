@@ -0,0 +1,122 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+/*
+ * Download throughput. The throughput of every successful download, its bytes
+ * over its duration, is counted into logarithmic buckets whose counts decay
+ * exponentially with ThroughputHalfLife, so that the percentiles reported by
+ * /stats and /metrics follow the recent load without keeping the samples. The
+ * buckets are a quarter of a doubling wide, which puts the estimates within
+ * about 10% of the true percentiles. Downloads smaller than
+ * minThroughputBytes are left out since their time is mostly latency.
+ */
+
+// DefaultThroughputHalfLife is the half-life of the throughput samples when
+// ThroughputHalfLife isn't set.
+const DefaultThroughputHalfLife = 5 * time.Minute
+
+// minThroughputBytes is the smallest download whose throughput is counted.
+const minThroughputBytes = 64 << 10
+
+// The buckets cover 1KiB/s to 16GiB/s, four per doubling.
+const (
+	throughputMin       = 1 << 10
+	throughputPerDouble = 4
+	throughputBuckets   = 24 * throughputPerDouble
+)
+
+// throughputQuantiles are the percentiles reported.
+var throughputQuantiles = []float64{0.5, 0.95, 0.99}
+
+// throughputEstimator keeps the exponentially decaying distribution of the
+// download throughput.
+type throughputEstimator struct {
+	halfLife time.Duration
+	mu       sync.Mutex
+	counts   [throughputBuckets]float64
+	updated  time.Time
+}
+
+// throughputStats reports the throughput percentiles in bytes per second.
+type throughputStats struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	// Samples is the decayed number of downloads the percentiles are based on.
+	Samples float64 `json:"samples"`
+}
+
+func newThroughputEstimator(halfLife time.Duration) *throughputEstimator {
+	if halfLife <= 0 {
+		halfLife = DefaultThroughputHalfLife
+	}
+	return &throughputEstimator{halfLife: halfLife, updated: time.Now()}
+}
+
+// decay ages the counts to now. The caller holds the lock.
+func (e *throughputEstimator) decay(now time.Time) {
+	elapsed := now.Sub(e.updated)
+	if elapsed <= 0 {
+		return
+	}
+	factor := math.Exp2(-float64(elapsed) / float64(e.halfLife))
+	for i := range e.counts {
+		e.counts[i] *= factor
+	}
+	e.updated = now
+}
+
+// observe counts a download of bytes that took d. A nil estimator counts nothing.
+func (e *throughputEstimator) observe(bytes int64, d time.Duration) {
+	if e == nil || bytes < minThroughputBytes || d <= 0 {
+		return
+	}
+	rate := float64(bytes) / d.Seconds()
+	i := int(math.Floor(math.Log2(rate/throughputMin) * throughputPerDouble))
+	if i < 0 {
+		i = 0
+	} else if i >= throughputBuckets {
+		i = throughputBuckets - 1
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.decay(time.Now())
+	e.counts[i]++
+}
+
+// report returns the current percentile estimates, each the geometric middle of
+// the bucket it falls in.
+func (e *throughputEstimator) report() *throughputStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.decay(time.Now())
+	var total float64
+	for _, c := range e.counts {
+		total += c
+	}
+	s := &throughputStats{Samples: total}
+	if total == 0 {
+		return s
+	}
+	values := make([]float64, len(throughputQuantiles))
+	for q, quantile := range throughputQuantiles {
+		var cumulative float64
+		for i, c := range e.counts {
+			cumulative += c
+			// The last bucket with samples catches the rounding of the sums.
+			if cumulative >= quantile*total || cumulative >= total {
+				values[q] = throughputMin * math.Exp2((float64(i)+0.5)/throughputPerDouble)
+				break
+			}
+		}
+	}
+	s.P50, s.P95, s.P99 = values[0], values[1], values[2]
+	return s
+}
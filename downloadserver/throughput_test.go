@@ -0,0 +1,84 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// within reports whether got is within 10% of want.
+func within(got float64, want float64) bool {
+	return math.Abs(got-want) <= 0.1*want
+}
+
+func TestThroughputPercentiles(t *testing.T) {
+	e := newThroughputEstimator(time.Hour)
+	if s := e.report(); s.Samples != 0 || s.P50 != 0 {
+		t.Errorf("empty estimator = %+v", s)
+	}
+	// 90 downloads at 1MB/s and 10 at 100MB/s.
+	for i := 0; i < 90; i++ {
+		e.observe(1e6, time.Second)
+	}
+	for i := 0; i < 10; i++ {
+		e.observe(1e8, time.Second)
+	}
+	s := e.report()
+	if !within(s.Samples, 100) {
+		t.Errorf("samples = %g, want 100", s.Samples)
+	}
+	if !within(s.P50, 1e6) || !within(s.P95, 1e8) || !within(s.P99, 1e8) {
+		t.Errorf("percentiles = %+v, want p50 1e6 and p95, p99 1e8", s)
+	}
+
+	// Small downloads are mostly latency and aren't counted, nor are the
+	// downloads of an estimator that isn't configured.
+	e.observe(minThroughputBytes-1, time.Millisecond)
+	e.observe(1e6, 0)
+	if s := e.report(); !within(s.Samples, 100) {
+		t.Errorf("samples = %g after small downloads", s.Samples)
+	}
+	var none *throughputEstimator
+	none.observe(1e6, time.Second)
+}
+
+func TestThroughputRange(t *testing.T) {
+	e := newThroughputEstimator(time.Hour)
+	// Rates outside the buckets go to the first and the last one.
+	e.observe(minThroughputBytes, 24*time.Hour)
+	if s := e.report(); s.P99 > 2*throughputMin {
+		t.Errorf("slowest rate reported as %g", s.P99)
+	}
+	e = newThroughputEstimator(time.Hour)
+	e.observe(1<<40, time.Millisecond)
+	if s := e.report(); s.P50 < 14<<30 {
+		t.Errorf("fastest rate reported as %g", s.P50)
+	}
+}
+
+func TestThroughputDecay(t *testing.T) {
+	e := newThroughputEstimator(time.Minute)
+	for i := 0; i < 8; i++ {
+		e.observe(1e6, time.Second)
+	}
+	// Samples lose half their weight each half-life, so a change of the load
+	// moves the percentiles.
+	e.mu.Lock()
+	e.updated = e.updated.Add(-2 * time.Minute)
+	e.mu.Unlock()
+	if s := e.report(); !within(s.Samples, 2) {
+		t.Errorf("samples = %g after two half-lives, want 2", s.Samples)
+	}
+	for i := 0; i < 8; i++ {
+		e.observe(1e8, time.Second)
+	}
+	if s := e.report(); !within(s.P50, 1e8) {
+		t.Errorf("p50 = %g after the load changed, want 1e8", s.P50)
+	}
+
+	if e := newThroughputEstimator(0); e.halfLife != DefaultThroughputHalfLife {
+		t.Errorf("default half-life = %s", e.halfLife)
+	}
+}
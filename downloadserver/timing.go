@@ -0,0 +1,85 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+ * Server-Timing. With ServerTiming set downloads carry a Server-Timing header
+ * breaking down the time spent before the first byte was sent: creating the PAR
+ * and waiting for OCI for OCI objects, opening and stat'ing local files, and
+ * the total. The timings are collected on the request context since they are
+ * taken deep in the OCI fetch.
+ */
+
+// serverTiming collects the timings of a single download.
+type serverTiming struct {
+	start   time.Time
+	mu      sync.Mutex
+	metrics []timingMetric
+}
+
+type timingMetric struct {
+	name string
+	desc string
+	dur  time.Duration
+}
+
+type serverTimingKey struct{}
+
+func newServerTiming() *serverTiming {
+	return &serverTiming{start: time.Now()}
+}
+
+// withServerTiming returns ctx carrying t.
+func withServerTiming(ctx context.Context, t *serverTiming) context.Context {
+	return context.WithValue(ctx, serverTimingKey{}, t)
+}
+
+// timingFrom returns the timings carried by ctx, nil when there are none.
+func timingFrom(ctx context.Context) *serverTiming {
+	t, _ := ctx.Value(serverTimingKey{}).(*serverTiming)
+	return t
+}
+
+// since records the time since start under name. Repeated steps, such as
+// fetches retried in another region, add up. A nil t records nothing.
+func (t *serverTiming) since(name string, desc string, start time.Time) {
+	if t == nil {
+		return
+	}
+	d := time.Since(start)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.metrics {
+		if t.metrics[i].name == name {
+			t.metrics[i].dur += d
+			return
+		}
+	}
+	t.metrics = append(t.metrics, timingMetric{name: name, desc: desc, dur: d})
+}
+
+// header returns the Server-Timing header value, ending with the total time
+// since the download started.
+func (t *serverTiming) header() string {
+	total := time.Since(t.start)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var buf bytes.Buffer
+	for _, m := range t.metrics {
+		fmt.Fprintf(&buf, "%s;dur=%.3f", m.name, float64(m.dur)/float64(time.Millisecond))
+		if m.desc != "" {
+			fmt.Fprintf(&buf, ";desc=%q", m.desc)
+		}
+		buf.WriteString(", ")
+	}
+	fmt.Fprintf(&buf, "total;dur=%.3f", float64(total)/float64(time.Millisecond))
+	return buf.String()
+}
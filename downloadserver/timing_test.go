@@ -0,0 +1,71 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerTimingHeader(t *testing.T) {
+	timing := newServerTiming()
+	started := time.Now().Add(-2 * time.Millisecond)
+	timing.since("par", "PAR creation", started)
+	// Repeated steps add up.
+	timing.since("par", "PAR creation", started)
+	header := timing.header()
+	parts := strings.Split(header, ", ")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "par;dur=") || !strings.HasSuffix(parts[0], `;desc="PAR creation"`) || !strings.HasPrefix(parts[1], "total;dur=") {
+		t.Fatalf("Server-Timing = %q", header)
+	}
+	if timing.metrics[0].dur < 4*time.Millisecond {
+		t.Errorf("repeated step took %s, want both counted", timing.metrics[0].dur)
+	}
+	// The total isn't one of the steps, which the debug log reports on their
+	// own once the download is done.
+	if len(timing.metrics) != 1 {
+		t.Errorf("steps after the header = %+v", timing.metrics)
+	}
+	if fields := timing.fields(); len(fields) != 2 || fields["parMs"] == nil || fields["totalMs"] == nil {
+		t.Errorf("fields = %v", fields)
+	}
+
+	// Downloads without timings record nothing.
+	var none *serverTiming
+	none.since("par", "", started)
+	if timingFrom(context.Background()) != nil {
+		t.Error("timings found on a plain context")
+	}
+	if timingFrom(withServerTiming(context.Background(), timing)) != timing {
+		t.Error("timings not carried by the context")
+	}
+}
+
+func TestServerTimingDownload(t *testing.T) {
+	f := newFakeOCI(t)
+	defer f.Close()
+	f.put("a/f.txt", []byte("hello"))
+	dir := testStore(t, map[string]string{"f.txt": "hello"})
+	defer os.RemoveAll(dir)
+	ds := f.server()
+
+	if rec := testDownload("GET", "t=ten&a=a/f.txt"); rec.Header().Get("Server-Timing") != "" {
+		t.Errorf("Server-Timing = %q without ServerTiming", rec.Header().Get("Server-Timing"))
+	}
+	ds.ServerTiming = true
+	for query, steps := range map[string][]string{
+		"t=ten&a=a/f.txt":  {"par;", "ttfb;", "total;"},
+		"a=f.txt&s=" + dir: {"open;", "stat;", "total;"},
+	} {
+		rec := testDownload("GET", query)
+		header := rec.Header().Get("Server-Timing")
+		for _, step := range steps {
+			if !strings.Contains(header, step) {
+				t.Errorf("%s: Server-Timing %q without %s", query, header, step)
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+ * Download tokens. A token signed with TokenKey names the artifact, where it is
+ * stored and when the token expires, and is presented in the path
+ * (downloadPath/<token>) so the link works as a plain <a href>. The token is an
+ * unpadded base64url JSON payload and an HMAC-SHA256 of it, joined by a dot.
+ * Requests may still add download options such as entry= in the query, but not
+ * the parameters the token fixes, nor mode=urls or prefix=1, which would widen
+ * the token to other objects.
+ */
+
+// downloadPath is the path of the download endpoint.
+const downloadPath = "/api/v3/operator/artifact/download"
+
+// DownloadToken is the content of a signed download token.
+type DownloadToken struct {
+	Artifact  string    `json:"a"`
+	StorePath string    `json:"s,omitempty"`
+	Tenancy   string    `json:"t,omitempty"`
+	Namespace string    `json:"n,omitempty"`
+	Expires   time.Time `json:"exp"`
+}
+
+// tokenParams are the query parameters set by a token, which the request can't
+// override.
+var tokenParams = []string{"a", "s", "t", "n", "h"}
+
+var errInvalidToken = &statusError{http.StatusForbidden, "invalid download token"}
+
+// SignDownloadToken returns the token for t signed with key.
+func SignDownloadToken(key []byte, t DownloadToken) (string, error) {
+	if len(key) == 0 {
+		return "", errors.New("no token key")
+	}
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + tokenSignature(key, encoded), nil
+}
+
+func tokenSignature(key []byte, encoded string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyDownloadToken checks the signature and expiry of token.
+func verifyDownloadToken(key []byte, token string, now time.Time) (*DownloadToken, error) {
+	dot := strings.IndexByte(token, '.')
+	if dot < 0 {
+		return nil, errInvalidToken
+	}
+	encoded, signature := token[:dot], token[dot+1:]
+	if !hmac.Equal([]byte(signature), []byte(tokenSignature(key, encoded))) {
+		return nil, errInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	t := &DownloadToken{}
+	if err := json.Unmarshal(payload, t); err != nil || t.Artifact == "" {
+		return nil, errInvalidToken
+	}
+	if !now.Before(t.Expires) {
+		return nil, &statusError{http.StatusForbidden, "download token expired"}
+	}
+	return t, nil
+}
+
+// applyDownloadToken verifies the token in the path of r and turns it into the
+// query parameters of an ordinary download request.
+func (ds *DownloadServer) applyDownloadToken(r *http.Request, token string) error {
+	t, err := verifyDownloadToken(ds.TokenKey, token, time.Now())
+	if err != nil {
+		return err
+	}
+	parms, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	for _, name := range tokenParams {
+		if _, ok := parms[name]; ok {
+			return badRequest(name + "= can't be given with a download token")
+		}
+	}
+	if _, ok := parms["prefix"]; ok || parms.Get("mode") == modeURLs {
+		return badRequest("mode=urls and prefix= can't be given with a download token")
+	}
+	parms.Set("a", t.Artifact)
+	for name, value := range map[string]string{"s": t.StorePath, "t": t.Tenancy, "n": t.Namespace} {
+		if value != "" {
+			parms.Set(name, value)
+		}
+	}
+	r.URL.RawQuery = parms.Encode()
+	return nil
+}
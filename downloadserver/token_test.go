@@ -0,0 +1,143 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package downloadserver
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testTokenKey = []byte("0123456789abcdef0123456789abcdef")
+
+func signTestToken(t *testing.T, tok DownloadToken) string {
+	signed, err := SignDownloadToken(testTokenKey, tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerifyDownloadToken(t *testing.T) {
+	now := time.Now()
+	signed := signTestToken(t, DownloadToken{Artifact: "a/b.tar", Tenancy: "ten", Expires: now.Add(time.Minute)})
+	tok, err := verifyDownloadToken(testTokenKey, signed, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Artifact != "a/b.tar" || tok.Tenancy != "ten" {
+		t.Errorf("token = %+v", tok)
+	}
+	if _, err := verifyDownloadToken(testTokenKey, signed, now.Add(2*time.Minute)); err == nil {
+		t.Error("expired token accepted")
+	}
+	if _, err := verifyDownloadToken([]byte("another key"), signed, now); err != errInvalidToken {
+		t.Errorf("token signed with another key: %v", err)
+	}
+	tampered := signTestToken(t, DownloadToken{Artifact: "other", Expires: now.Add(time.Minute)})
+	tampered = tampered[:strings.IndexByte(tampered, '.')] + signed[strings.IndexByte(signed, '.'):]
+	if _, err := verifyDownloadToken(testTokenKey, tampered, now); err != errInvalidToken {
+		t.Errorf("tampered token: %v", err)
+	}
+}
+
+func TestMalformedDownloadToken(t *testing.T) {
+	now := time.Now()
+	noArtifact := signTestToken(t, DownloadToken{Tenancy: "ten", Expires: now.Add(time.Minute)})
+	encoded := base64.RawURLEncoding.EncodeToString([]byte("not json"))
+	for _, token := range []string{
+		"",
+		"nodot",
+		"!!!." + tokenSignature(testTokenKey, "!!!"),
+		encoded + "." + tokenSignature(testTokenKey, encoded),
+		noArtifact,
+	} {
+		if _, err := verifyDownloadToken(testTokenKey, token, now); err != errInvalidToken {
+			t.Errorf("%q: err = %v, want %v", token, err, errInvalidToken)
+		}
+	}
+}
+
+func TestApplyDownloadToken(t *testing.T) {
+	ds := &DownloadServer{TokenKey: testTokenKey}
+	signed := signTestToken(t, DownloadToken{Artifact: "builds/app", Tenancy: "ten", Expires: time.Now().Add(time.Minute)})
+
+	r := httptest.NewRequest("GET", downloadPath+"/"+signed+"?entry=x", nil)
+	if err := ds.applyDownloadToken(r, signed); err != nil {
+		t.Fatal(err)
+	}
+	parms, _ := url.ParseQuery(r.URL.RawQuery)
+	if parms.Get("a") != "builds/app" || parms.Get("t") != "ten" || parms.Get("entry") != "x" {
+		t.Errorf("query = %s", r.URL.RawQuery)
+	}
+
+	// The token fixes its artifact and must not be widened to other objects.
+	for _, query := range []string{"a=other", "t=other", "mode=urls", "mode=urls&prefix=1", "prefix=1"} {
+		r := httptest.NewRequest("GET", downloadPath+"/"+signed+"?"+query, nil)
+		err := ds.applyDownloadToken(r, signed)
+		if se, ok := err.(*statusError); !ok || se.code != 400 {
+			t.Errorf("%s: err = %v, want 400", query, err)
+		}
+	}
+}
+
+func TestAuthorizeMember(t *testing.T) {
+	ds := &DownloadServer{Authorizer: AuthorizerFunc(func(ctx context.Context, req *DownloadRequest) error {
+		if len(req.Artifacts) != 1 || req.Prefix {
+			return errors.New("not a single member")
+		}
+		if strings.HasPrefix(req.Artifacts[0], "builds/secret") {
+			return errors.New("denied")
+		}
+		return nil
+	})}
+	req := &DownloadRequest{Artifacts: []string{"builds/"}, Tenancy: "ten", Mode: modeURLs, Prefix: true}
+	if !ds.authorizeMember(context.Background(), req, "builds/app") {
+		t.Error("allowed member refused")
+	}
+	if ds.authorizeMember(context.Background(), req, "builds/secret.key") {
+		t.Error("refused member allowed")
+	}
+	if !req.Prefix || req.Artifacts[0] != "builds/" {
+		t.Error("request changed")
+	}
+}
+
+func TestDownloadWithToken(t *testing.T) {
+	dir := testStore(t, map[string]string{"f.txt": "hello", "other.txt": "other"})
+	defer os.RemoveAll(dir)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		download(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+	ds := localServer()
+	signed := signTestToken(t, DownloadToken{Artifact: "f.txt", StorePath: dir, Expires: time.Now().Add(time.Minute)})
+
+	// Without a TokenKey there are no tokens, only an unknown path.
+	if rec := get(downloadPath + "/" + signed); rec.Code != 404 {
+		t.Errorf("token without a TokenKey = %d, want 404", rec.Code)
+	}
+
+	ds.TokenKey = testTokenKey
+	if rec := get(downloadPath + "/" + signed); rec.Code != 200 || rec.Body.String() != "hello" {
+		t.Errorf("token download = %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := get(downloadPath + "/" + signed + "?a=other.txt"); rec.Code != 400 {
+		t.Errorf("token with another artifact = %d, want 400", rec.Code)
+	}
+	expired := signTestToken(t, DownloadToken{Artifact: "f.txt", StorePath: dir, Expires: time.Now().Add(-time.Second)})
+	if rec := get(downloadPath + "/" + expired); rec.Code != 403 || !strings.Contains(rec.Body.String(), "expired") {
+		t.Errorf("expired token = %d %q, want 403", rec.Code, rec.Body.String())
+	}
+	forged, _ := SignDownloadToken([]byte("another key"), DownloadToken{Artifact: "f.txt", StorePath: dir, Expires: time.Now().Add(time.Minute)})
+	if rec := get(downloadPath + "/" + forged); rec.Code != 403 {
+		t.Errorf("token signed with another key = %d, want 403", rec.Code)
+	}
+}
@@ -29,6 +29,7 @@ func main() {
 	}
 	app.Commands = []cli.Command{
 		serverCommand,
+		tokenCommand,
 	}
 	app.Run(os.Args)
 }
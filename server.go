@@ -3,11 +3,18 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/wercker/pkg/log"
 	"github.com/wercker/runner-download/downloadserver"
@@ -18,7 +25,7 @@ var serverCommand = cli.Command{
 	Name:   "server",
 	Usage:  "start artifact download server",
 	Action: serverAction,
-	Flags:  append(serverFlags),
+	Flags:  serverFlags,
 }
 
 var serverFlags = []cli.Flag{
@@ -38,6 +45,483 @@ var serverFlags = []cli.Flag{
 		Usage:  "Key PEM file for HTTPS",
 		EnvVar: "KEY_PEM_FILE",
 	},
+	cli.StringFlag{
+		Name:   "client-ca-file",
+		Usage:  "CA bundle PEM file for verifying client certificates, requires HTTPS clients to authenticate",
+		EnvVar: "CLIENT_CA_FILE",
+	},
+	cli.DurationFlag{
+		Name:   "max-download-duration",
+		Usage:  "maximum total duration of a single download, 0 for no limit",
+		EnvVar: "MAX_DOWNLOAD_DURATION",
+	},
+	cli.DurationFlag{
+		Name:   "idle-timeout",
+		Value:  downloadserver.DefaultIdleTimeout,
+		Usage:  "close keep-alive connections that have been idle between requests for this long",
+		EnvVar: "IDLE_TIMEOUT",
+	},
+	cli.Int64Flag{
+		Name:   "slow-read-rate",
+		Usage:  "minimum rate in bytes per second at which clients must read downloads, 0 for no minimum",
+		EnvVar: "SLOW_READ_RATE",
+	},
+	cli.DurationFlag{
+		Name:   "slow-read-window",
+		Value:  downloadserver.DefaultSlowReadWindow,
+		Usage:  "window over which the minimum read rate is averaged",
+		EnvVar: "SLOW_READ_WINDOW",
+	},
+	cli.DurationFlag{
+		Name:   "slow-read-grace",
+		Value:  downloadserver.DefaultSlowReadGrace,
+		Usage:  "how far behind the minimum read rate a client may fall before it is disconnected",
+		EnvVar: "SLOW_READ_GRACE",
+	},
+	cli.StringFlag{
+		Name:   "artifact-template",
+		Usage:  "layout of the artifact of requests without a=, such as {tenant}/{pipeline}/{artifact}, filled in from query parameters",
+		EnvVar: "ARTIFACT_TEMPLATE",
+	},
+	cli.StringFlag{
+		Name:   "cas-layout",
+		Value:  downloadserver.DefaultCASLayout,
+		Usage:  "storage layout for content addressed (h=sha256) downloads",
+		EnvVar: "CAS_LAYOUT",
+	},
+	cli.DurationFlag{
+		Name:   "tcp-keepalive",
+		Value:  3 * time.Minute,
+		Usage:  "TCP keep-alive period for client connections, 0 to disable",
+		EnvVar: "TCP_KEEPALIVE",
+	},
+	cli.IntFlag{
+		Name:   "tcp-read-buffer",
+		Usage:  "socket receive buffer size in bytes for client and OCI connections, 0 for the system default",
+		EnvVar: "TCP_READ_BUFFER",
+	},
+	cli.IntFlag{
+		Name:   "tcp-write-buffer",
+		Usage:  "socket send buffer size in bytes for client and OCI connections, 0 for the system default",
+		EnvVar: "TCP_WRITE_BUFFER",
+	},
+	cli.BoolTFlag{
+		Name:   "tcp-nodelay",
+		Usage:  "set TCP_NODELAY on client connections, false to enable Nagle's algorithm",
+		EnvVar: "TCP_NODELAY",
+	},
+	cli.IntFlag{
+		Name:   "max-connections",
+		Usage:  "maximum number of simultaneous client connections, 0 for no limit",
+		EnvVar: "MAX_CONNECTIONS",
+	},
+	cli.IntFlag{
+		Name:   "max-downloads",
+		Usage:  "maximum number of simultaneous downloads across all clients, 0 for no limit",
+		EnvVar: "MAX_DOWNLOADS",
+	},
+	cli.IntFlag{
+		Name:   "download-queue",
+		Usage:  "number of downloads beyond max-downloads that wait for a slot, 0 to refuse them at once",
+		EnvVar: "DOWNLOAD_QUEUE",
+	},
+	cli.DurationFlag{
+		Name:   "download-queue-wait",
+		Value:  downloadserver.DefaultDownloadQueueWait,
+		Usage:  "maximum time a queued download waits for a slot",
+		EnvVar: "DOWNLOAD_QUEUE_WAIT",
+	},
+	cli.DurationFlag{
+		Name:   "throughput-half-life",
+		Value:  downloadserver.DefaultThroughputHalfLife,
+		Usage:  "half-life of the download throughput samples behind the reported percentiles",
+		EnvVar: "THROUGHPUT_HALF_LIFE",
+	},
+	cli.DurationFlag{
+		Name:   "slow-download-threshold",
+		Usage:  "log successful downloads taking longer than this at warn level, 0 to disable",
+		EnvVar: "SLOW_DOWNLOAD_THRESHOLD",
+	},
+	cli.DurationFlag{
+		Name:   "resume-session-ttl",
+		Value:  downloadserver.DefaultResumeSessionTTL,
+		Usage:  "how long the session of a resumable download (resume=) is kept after its last use",
+		EnvVar: "RESUME_SESSION_TTL",
+	},
+	cli.Int64Flag{
+		Name:   "resume-margin",
+		Value:  downloadserver.DefaultResumeMargin,
+		Usage:  "bytes before the end of those written that a resumed download (resume=) carries on from",
+		EnvVar: "RESUME_MARGIN",
+	},
+	cli.StringFlag{
+		Name:   "deadline-header",
+		Value:  downloadserver.DefaultDeadlineHeader,
+		Usage:  "request header giving a deadline (RFC 3339 time or duration) for the download, empty to ignore",
+		EnvVar: "DEADLINE_HEADER",
+	},
+	cli.IntFlag{
+		Name:   "max-client-downloads",
+		Usage:  "maximum number of simultaneous downloads from a single client IP address, 0 for no limit",
+		EnvVar: "MAX_CLIENT_DOWNLOADS",
+	},
+	cli.IntFlag{
+		Name:   "max-query-length",
+		Value:  downloadserver.DefaultMaxQueryLength,
+		Usage:  "maximum length in bytes of the query string of a download, 0 for no limit",
+		EnvVar: "MAX_QUERY_LENGTH",
+	},
+	cli.StringFlag{
+		Name:   "socket",
+		Usage:  "listen on this Unix domain socket (unix:<path>) instead of a TCP port",
+		EnvVar: "SOCKET_PATH",
+	},
+	cli.BoolFlag{
+		Name:   "detect-changes",
+		Usage:  "flag local artifacts that change while being downloaded",
+		EnvVar: "DETECT_CHANGES",
+	},
+	cli.BoolFlag{
+		Name:   "server-timing",
+		Usage:  "send a Server-Timing header with the backend timings of each download",
+		EnvVar: "SERVER_TIMING",
+	},
+	cli.BoolFlag{
+		Name:   "detect-content-type",
+		Usage:  "send local artifacts with their detected content type rather than binary/octet-stream",
+		EnvVar: "DETECT_CONTENT_TYPE",
+	},
+	cli.DurationFlag{
+		Name:   "oci-timeout",
+		Value:  time.Minute,
+		Usage:  "total time allowed to create the PAR and start the OCI download, 0 for no limit",
+		EnvVar: "OCI_TIMEOUT",
+	},
+	cli.IntFlag{
+		Name:   "archive-workers",
+		Value:  downloadserver.DefaultArchiveWorkers,
+		Usage:  "number of artifacts fetched concurrently when assembling an archive",
+		EnvVar: "ARCHIVE_WORKERS",
+	},
+	cli.StringFlag{
+		Name:   "tenancy-quotas",
+		Usage:  "per tenancy byte quotas as tenancy=bytes,... (use * for all other tenancies)",
+		EnvVar: "TENANCY_QUOTAS",
+	},
+	cli.DurationFlag{
+		Name:   "quota-window",
+		Value:  downloadserver.DefaultQuotaWindow,
+		Usage:  "window over which tenancy byte quotas are counted",
+		EnvVar: "QUOTA_WINDOW",
+	},
+	cli.BoolFlag{
+		Name:   "gzip-passthrough",
+		Usage:  "serve local .gz artifacts with Content-Encoding: gzip to clients that accept it",
+		EnvVar: "GZIP_PASSTHROUGH",
+	},
+	cli.BoolFlag{
+		Name:   "par-cache",
+		Usage:  "reuse a valid PAR for repeated downloads of the same object",
+		EnvVar: "PAR_CACHE",
+	},
+	cli.Float64Flag{
+		Name:   "par-rate-limit",
+		Usage:  "maximum number of PARs created per second, 0 for no limit",
+		EnvVar: "PAR_RATE_LIMIT",
+	},
+	cli.IntFlag{
+		Name:   "par-rate-burst",
+		Value:  1,
+		Usage:  "number of PARs that may be created at once before the rate limit applies",
+		EnvVar: "PAR_RATE_BURST",
+	},
+	cli.DurationFlag{
+		Name:   "par-rate-wait",
+		Usage:  "how long a download may wait for the PAR rate limit, 0 to fail immediately",
+		EnvVar: "PAR_RATE_WAIT",
+	},
+	cli.DurationFlag{
+		Name:   "shutdown-timeout",
+		Value:  downloadserver.DefaultShutdownTimeout,
+		Usage:  "maximum time spent running shutdown hooks, such as flushing metrics, on exit",
+		EnvVar: "SHUTDOWN_TIMEOUT",
+	},
+	cli.DurationFlag{
+		Name:   "lame-duck-period",
+		Usage:  "time spent failing /readyz while still serving before shutting down, 0 to shut down at once",
+		EnvVar: "LAME_DUCK_PERIOD",
+	},
+	cli.DurationFlag{
+		Name:   "drain-timeout",
+		Value:  downloadserver.DefaultDrainTimeout,
+		Usage:  "maximum time downloads in flight get to finish on shutdown before they are cut",
+		EnvVar: "DRAIN_TIMEOUT",
+	},
+	cli.DurationFlag{
+		Name:   "follow-idle-timeout",
+		Value:  downloadserver.DefaultFollowIdleTimeout,
+		Usage:  "end a follow=1 download once the file hasn't grown for this long",
+		EnvVar: "FOLLOW_IDLE_TIMEOUT",
+	},
+	cli.BoolFlag{
+		Name:   "digest-header",
+		Usage:  "send the RFC 3230 Digest of the artifact content",
+		EnvVar: "DIGEST_HEADER",
+	},
+	cli.StringFlag{
+		Name:   "oci-endpoint",
+		Usage:  "Object Storage host used for the primary region instead of the one derived from WERCKER_OCI_REGION",
+		EnvVar: "OCI_ENDPOINT",
+	},
+	cli.StringFlag{
+		Name:   "oci-regions",
+		Usage:  "comma separated OCI regions the bucket is replicated to, in order of preference",
+		EnvVar: "OCI_REGIONS",
+	},
+	cli.DurationFlag{
+		Name:   "readiness-interval",
+		Value:  downloadserver.DefaultReadinessInterval,
+		Usage:  "how often the backend is checked for /readyz",
+		EnvVar: "READINESS_INTERVAL",
+	},
+	cli.IntFlag{
+		Name:   "readiness-failures",
+		Value:  downloadserver.DefaultReadinessFailures,
+		Usage:  "consecutive failed backend checks after which /readyz reports not ready",
+		EnvVar: "READINESS_FAILURES",
+	},
+	cli.DurationFlag{
+		Name:   "par-sweep-interval",
+		Usage:  "how often expired download PARs are swept from the bucket, 0 to disable",
+		EnvVar: "PAR_SWEEP_INTERVAL",
+	},
+	cli.DurationFlag{
+		Name:   "par-max-age",
+		Usage:  "have the sweeper also delete download PARs older than this, 0 for expired PARs only",
+		EnvVar: "PAR_MAX_AGE",
+	},
+	cli.DurationFlag{
+		Name:   "redirect-par-ttl",
+		Value:  downloadserver.DefaultRedirectPARTTL,
+		Usage:  "lifetime of the PARs handed to clients with mode=redirect or mode=url",
+		EnvVar: "REDIRECT_PAR_TTL",
+	},
+	cli.DurationFlag{
+		Name:   "redirect-par-grace",
+		Usage:  "have the sweeper delete the PARs handed out in redirect mode once older than this, 0 to keep them until they expire",
+		EnvVar: "REDIRECT_PAR_GRACE",
+	},
+	cli.StringFlag{
+		Name:   "object-prefix",
+		Usage:  "prefix prepended to artifact names to form OCI object names, such as prod/",
+		EnvVar: "OBJECT_PREFIX",
+	},
+	cli.StringFlag{
+		Name:   "object-name-encoding",
+		Value:  downloadserver.ObjectNamePath,
+		Usage:  "encoding of object names in PAR URLs: path, strict (also encoding /) or none",
+		EnvVar: "OBJECT_NAME_ENCODING",
+	},
+	cli.StringFlag{
+		Name:   "filename-metadata-key",
+		Usage:  "OCI object metadata key holding the download filename, used instead of the object name when present",
+		EnvVar: "FILENAME_METADATA_KEY",
+	},
+	cli.StringSliceFlag{
+		Name:   "deny-pattern",
+		Usage:  "never serve artifacts matching this glob pattern, or regular expression prefixed with re:, may be repeated",
+		EnvVar: "DENY_PATTERNS",
+	},
+	cli.StringSliceFlag{
+		Name:   "identity-prefix",
+		Usage:  "identity=prefix, allows the caller identity to download artifacts under the path prefix only, may be repeated",
+		EnvVar: "IDENTITY_PREFIXES",
+	},
+	cli.StringSliceFlag{
+		Name:   "trusted-proxy",
+		Usage:  "CIDR or address of a proxy whose X-Forwarded-For and X-Real-IP headers are trusted, may be repeated",
+		EnvVar: "TRUSTED_PROXIES",
+	},
+	cli.StringSliceFlag{
+		Name:   "content-type",
+		Usage:  "ext=type, sends artifacts with this extension as this content type, may be repeated",
+		EnvVar: "CONTENT_TYPES",
+	},
+	cli.StringSliceFlag{
+		Name:   "response-header",
+		Usage:  "\"Name: value\" header added to every download response, may be repeated",
+		EnvVar: "RESPONSE_HEADERS",
+	},
+	cli.StringFlag{
+		Name:   "identity-header",
+		Usage:  "request header identifying callers without a client certificate, for use behind a trusted proxy",
+		EnvVar: "IDENTITY_HEADER",
+	},
+	cli.StringFlag{
+		Name:   "event-webhook",
+		Usage:  "URL a JSON event is posted to for every download",
+		EnvVar: "EVENT_WEBHOOK",
+	},
+	cli.StringFlag{
+		Name:   "audit-log",
+		Usage:  "file every refused download is recorded in as a line of JSON, - for standard output",
+		EnvVar: "AUDIT_LOG",
+	},
+	cli.IntFlag{
+		Name:   "event-buffer",
+		Value:  downloadserver.DefaultEventBuffer,
+		Usage:  "number of download events buffered while the webhook is slow, after which they are dropped",
+		EnvVar: "EVENT_BUFFER",
+	},
+	cli.StringFlag{
+		Name:   "encryption-key-file",
+		Usage:  "file holding the hex encoded AES-256 key encryption key that enables encrypt=1 downloads",
+		EnvVar: "ENCRYPTION_KEY_FILE",
+	},
+	cli.StringSliceFlag{
+		Name:   "sse-customer-key-file",
+		Usage:  "bucket=file, reads the objects of bucket with the base64 encoded AES-256 SSE-C key in file, may be repeated",
+		EnvVar: "SSE_CUSTOMER_KEY_FILES",
+	},
+	tokenKeyFlag,
+	cli.BoolFlag{
+		Name:   "compress",
+		Usage:  "gzip compress responses for clients that accept it",
+		EnvVar: "COMPRESS",
+	},
+	cli.Int64Flag{
+		Name:   "compress-min-size",
+		Value:  downloadserver.DefaultCompressMinSize,
+		Usage:  "size in bytes below which responses aren't compressed",
+		EnvVar: "COMPRESS_MIN_SIZE",
+	},
+	cli.IntFlag{
+		Name:   "max-filename-length",
+		Usage:  "maximum length in bytes of download filenames, 0 for no limit",
+		EnvVar: "MAX_FILENAME_LENGTH",
+	},
+	cli.StringFlag{
+		Name:   "overlong-filenames",
+		Value:  downloadserver.FilenameTruncate,
+		Usage:  "truncate (keeping the extension) or reject download filenames over the maximum length",
+		EnvVar: "OVERLONG_FILENAMES",
+	},
+	cli.StringFlag{
+		Name:   "empty-artifacts",
+		Value:  downloadserver.EmptyOK,
+		Usage:  "response to zero-byte artifacts: ok (200 with an empty body) or no-content (204)",
+		EnvVar: "EMPTY_ARTIFACTS",
+	},
+	cli.BoolFlag{
+		Name:   "case-insensitive",
+		Usage:  "look up local artifacts ignoring case when there is no exact match",
+		EnvVar: "CASE_INSENSITIVE",
+	},
+	cli.StringSliceFlag{
+		Name:   "directory-default",
+		Usage:  "name of the file served for a local download of a directory, may be repeated to try several in order",
+		EnvVar: "DIRECTORY_DEFAULTS",
+	},
+	cli.StringFlag{
+		Name:   "local-symlinks",
+		Value:  downloadserver.SymlinksRefuse,
+		Usage:  "symlinks on the path of local artifacts: refuse, contain (targets within the storepath) or follow",
+		EnvVar: "LOCAL_SYMLINKS",
+	},
+	cli.StringFlag{
+		Name:   "content-disposition",
+		Value:  downloadserver.DispositionBoth,
+		Usage:  "filename encoding: both (ASCII filename and RFC 6266 filename*) or legacy (ASCII filename only)",
+		EnvVar: "CONTENT_DISPOSITION",
+	},
+	cli.BoolFlag{
+		Name:   "maintenance",
+		Usage:  "start in maintenance mode, refusing downloads with 503; SIGUSR1 toggles the mode",
+		EnvVar: "MAINTENANCE",
+	},
+	cli.DurationFlag{
+		Name:   "maintenance-retry",
+		Value:  downloadserver.DefaultMaintenanceRetry,
+		Usage:  "Retry-After sent with downloads refused during maintenance",
+		EnvVar: "MAINTENANCE_RETRY",
+	},
+	cli.StringFlag{
+		Name:   "maintenance-token",
+		Usage:  "bearer token enabling the /maintenance endpoint, which switches maintenance mode",
+		EnvVar: "MAINTENANCE_TOKEN",
+	},
+	cli.BoolFlag{
+		Name:   "restore-archived",
+		Usage:  "request the restore of archived OCI objects when they are downloaded",
+		EnvVar: "RESTORE_ARCHIVED",
+	},
+	cli.IntFlag{
+		Name:   "restore-hours",
+		Value:  downloadserver.DefaultRestoreHours,
+		Usage:  "hours a restored OCI object stays readable",
+		EnvVar: "RESTORE_HOURS",
+	},
+	cli.StringFlag{
+		Name:   "selftest-token",
+		Usage:  "bearer token enabling the /selftest endpoint, which writes a test object to the bucket",
+		EnvVar: "SELFTEST_TOKEN",
+	},
+	cli.StringFlag{
+		Name:   "debug-token",
+		Usage:  "token that downloads present in an X-Debug header to have just their handling logged in detail",
+		EnvVar: "DEBUG_TOKEN",
+	},
+	cli.StringFlag{
+		Name:   "offload",
+		Usage:  "answer plain downloads with an internal redirect for a fronting proxy: x-accel-redirect (nginx) or x-sendfile",
+		EnvVar: "OFFLOAD",
+	},
+	cli.StringFlag{
+		Name:   "offload-local-prefix",
+		Value:  downloadserver.DefaultOffloadLocalPrefix,
+		Usage:  "internal proxy location serving local files by absolute path with x-accel-redirect, empty to stream them",
+		EnvVar: "OFFLOAD_LOCAL_PREFIX",
+	},
+	cli.StringFlag{
+		Name:   "offload-oci-prefix",
+		Value:  downloadserver.DefaultOffloadOCIPrefix,
+		Usage:  "internal proxy location fetching OCI PARs by host and path with x-accel-redirect, empty to stream them",
+		EnvVar: "OFFLOAD_OCI_PREFIX",
+	},
+	cli.Int64Flag{
+		Name:   "hash-chunk-size",
+		Value:  downloadserver.DefaultHashChunkSize,
+		Usage:  "size in bytes of the chunks whose SHA-256 is listed for chunks=1",
+		EnvVar: "HASH_CHUNK_SIZE",
+	},
+	cli.IntFlag{
+		Name:   "write-chunk-size",
+		Usage:  "size in bytes of the chunks downloads are written and flushed to the client in, 0 to write them as they are read",
+		EnvVar: "WRITE_CHUNK_SIZE",
+	},
+	// Setting a threshold costs every OCI download a HEAD of the object to learn
+	// its size, an extra round trip before the PAR of the larger objects.
+	cli.Int64Flag{
+		Name:   "direct-fetch-threshold",
+		Usage:  "read OCI objects smaller than this many bytes directly instead of through a PAR, 0 to always use a PAR",
+		EnvVar: "DIRECT_FETCH_THRESHOLD",
+	},
+	cli.BoolFlag{
+		Name:   "direct-ranges",
+		Usage:  "read byte ranges of OCI objects directly instead of through a PAR, whatever the object size",
+		EnvVar: "DIRECT_RANGES",
+	},
+	cli.IntFlag{
+		Name:   "oci-parallel-parts",
+		Usage:  "number of byte ranges of an OCI object fetched concurrently, 0 for a single stream",
+		EnvVar: "OCI_PARALLEL_PARTS",
+	},
+	cli.Int64Flag{
+		Name:   "oci-part-size",
+		Value:  downloadserver.DefaultOCIPartSize,
+		Usage:  "size in bytes of the ranges fetched in parallel from OCI",
+		EnvVar: "OCI_PART_SIZE",
+	},
 }
 
 var serverAction = func(c *cli.Context) error {
@@ -47,29 +531,133 @@ var serverAction = func(c *cli.Context) error {
 		return err
 	}
 
+	// Note: DownloadServer structure is populated with OCI credentials taken from the
+	// environment. If these are coming from somewhere else then tjhey needc to be supplied
+	// after the structure is returned.
+	ds := downloadserver.NewDownloadServer()
+
 	msg := "Interrupted artifact download server and terminated"
 	signalChannel := make(chan os.Signal, 2)
 	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		sig := <-signalChannel
-		switch sig {
-		case os.Interrupt:
-			//handle SIGINT
-			log.Fatal(msg)
-		case syscall.SIGTERM:
-			//handle SIGTERM
+		// handle SIGINT and SIGTERM, closing the listener first so that a Unix
+		// socket file isn't left behind. A second signal cuts the lame-duck
+		// period and the drain short.
+		<-signalChannel
+		go func() {
+			<-signalChannel
 			log.Fatal(msg)
+		}()
+		ds.Close()
+		log.Fatal(msg)
+	}()
+
+	// SIGUSR1 toggles maintenance mode.
+	maintenanceChannel := make(chan os.Signal, 1)
+	signal.Notify(maintenanceChannel, syscall.SIGUSR1)
+	go func() {
+		for range maintenanceChannel {
+			ds.SetMaintenance(!ds.InMaintenance())
 		}
 	}()
 
-	// Note: DownloadServer structure is populated with OCI credentials taken from the
-	// environment. If these are coming from somewhere else then tjhey needc to be supplied
-	// after the structure is returned.
-	ds := downloadserver.NewDownloadServer()
 	ds.Debug = o.Debug
 	ds.CertPemFile = o.CertFile
 	ds.KeyPemFile = o.KeyFile
-	log.Info(fmt.Sprintf("Starting artifact download server, listening on port %d", o.Port))
+	ds.ClientCAFile = o.ClientCAFile
+	ds.MaxDownloadDuration = o.MaxDownloadDuration
+	ds.IdleTimeout = o.IdleTimeout
+	ds.SlowReadRate = o.SlowReadRate
+	ds.SlowReadWindow = o.SlowReadWindow
+	ds.SlowReadGrace = o.SlowReadGrace
+	ds.CASLayout = o.CASLayout
+	ds.ArtifactTemplate = o.ArtifactTemplate
+	ds.TCPKeepAlive = o.TCPKeepAlive
+	ds.TCPReadBuffer = o.TCPReadBuffer
+	ds.TCPWriteBuffer = o.TCPWriteBuffer
+	ds.TCPDelay = !o.TCPNoDelay
+	ds.MaxConnections = o.MaxConnections
+	ds.MaxDownloads = o.MaxDownloads
+	ds.DownloadQueue = o.DownloadQueue
+	ds.DownloadQueueWait = o.DownloadQueueWait
+	ds.ThroughputHalfLife = o.ThroughputHalfLife
+	ds.SlowDownloadThreshold = o.SlowThreshold
+	ds.ResumeSessionTTL = o.ResumeSessionTTL
+	ds.ResumeMargin = o.ResumeMargin
+	ds.DeadlineHeader = o.DeadlineHeader
+	ds.MaxClientDownloads = o.MaxClientDownloads
+	ds.MaxQueryLength = o.MaxQueryLength
+	ds.SocketPath = o.SocketPath
+	ds.DetectChanges = o.DetectChanges
+	ds.DetectContentType = o.DetectContentType
+	ds.ContentTypes = o.ContentTypes
+	ds.ServerTiming = o.ServerTiming
+	ds.OCITimeout = o.OCITimeout
+	ds.ArchiveWorkers = o.ArchiveWorkers
+	ds.TenancyQuotas = o.TenancyQuotas
+	ds.QuotaWindow = o.QuotaWindow
+	ds.GzipPassthrough = o.GzipPassthrough
+	ds.PARCache = o.PARCache
+	ds.PARRateLimit = o.PARRateLimit
+	ds.PARRateBurst = o.PARRateBurst
+	ds.PARRateWait = o.PARRateWait
+	ds.FollowIdleTimeout = o.FollowIdleTimeout
+	ds.ShutdownTimeout = o.ShutdownTimeout
+	ds.LameDuckPeriod = o.LameDuckPeriod
+	ds.DrainTimeout = o.DrainTimeout
+	ds.DigestHeader = o.DigestHeader
+	ds.Regions = o.Regions
+	ds.OCIEndpoint = o.OCIEndpoint
+	ds.ReadinessInterval = o.ReadinessInterval
+	ds.ReadinessFailures = o.ReadinessFailures
+	ds.PARSweepInterval = o.PARSweepInterval
+	ds.PARMaxAge = o.PARMaxAge
+	ds.RedirectPARTTL = o.RedirectPARTTL
+	ds.RedirectPARGrace = o.RedirectPARGrace
+	ds.FilenameMetadataKey = o.FilenameMetadataKey
+	ds.ObjectPrefix = o.ObjectPrefix
+	ds.ObjectNameEncoding = o.ObjectNameEncoding
+	ds.DenyPatterns = o.DenyPatterns
+	ds.IdentityPrefixes = o.IdentityPrefixes
+	ds.IdentityHeader = o.IdentityHeader
+	ds.TrustedProxies = o.TrustedProxies
+	ds.ResponseHeaders = o.ResponseHeaders
+	ds.EventWebhook = o.EventWebhook
+	ds.EventBuffer = o.EventBuffer
+	ds.AuditLog = o.AuditLog
+	ds.EncryptionKey = o.EncryptionKey
+	ds.TokenKey = o.TokenKey
+	ds.SSECustomerKeys = o.SSECustomerKeys
+	ds.Compress = o.Compress
+	ds.CompressMinSize = o.CompressMinSize
+	ds.MaxFilenameLength = o.MaxFilenameLength
+	ds.OverlongFilenames = o.OverlongFilenames
+	ds.ContentDisposition = o.ContentDisposition
+	ds.EmptyArtifacts = o.EmptyArtifacts
+	ds.CaseInsensitive = o.CaseInsensitive
+	ds.LocalSymlinks = o.LocalSymlinks
+	ds.DirectoryDefaults = o.DirectoryDefaults
+	ds.SelftestToken = o.SelftestToken
+	ds.DebugToken = o.DebugToken
+	ds.RestoreArchived = o.RestoreArchived
+	ds.RestoreHours = o.RestoreHours
+	ds.Maintenance = o.Maintenance
+	ds.MaintenanceRetry = o.MaintenanceRetry
+	ds.MaintenanceToken = o.MaintenanceToken
+	ds.DirectFetchThreshold = o.DirectFetchThreshold
+	ds.DirectRanges = o.DirectRanges
+	ds.HashChunkSize = o.HashChunkSize
+	ds.WriteChunkSize = o.WriteChunkSize
+	ds.Offload = o.Offload
+	ds.OffloadLocalPrefix = o.OffloadLocalPrefix
+	ds.OffloadOCIPrefix = o.OffloadOCIPrefix
+	ds.OCIParallelParts = o.OCIParallelParts
+	ds.OCIPartSize = o.OCIPartSize
+	if o.SocketPath != "" {
+		log.Info(fmt.Sprintf("Starting artifact download server, listening on socket %s", o.SocketPath))
+	} else {
+		log.Info(fmt.Sprintf("Starting artifact download server, listening on port %d", o.Port))
+	}
 	err = ds.OCIdownloadServer(o.Port)
 	if err != nil {
 		log.Fatal(err)
@@ -78,10 +666,99 @@ var serverAction = func(c *cli.Context) error {
 }
 
 type serverOptions struct {
-	Port     int
-	CertFile string
-	KeyFile  string
-	Debug    bool
+	Port                 int
+	CertFile             string
+	KeyFile              string
+	ClientCAFile         string
+	Debug                bool
+	MaxDownloadDuration  time.Duration
+	IdleTimeout          time.Duration
+	SlowReadRate         int64
+	SlowReadWindow       time.Duration
+	SlowReadGrace        time.Duration
+	CASLayout            string
+	ArtifactTemplate     string
+	TCPKeepAlive         time.Duration
+	TCPReadBuffer        int
+	TCPWriteBuffer       int
+	TCPNoDelay           bool
+	MaxConnections       int
+	MaxDownloads         int
+	DownloadQueue        int
+	DownloadQueueWait    time.Duration
+	ThroughputHalfLife   time.Duration
+	SlowThreshold        time.Duration
+	ResumeSessionTTL     time.Duration
+	ResumeMargin         int64
+	DeadlineHeader       string
+	MaxClientDownloads   int
+	MaxQueryLength       int
+	SocketPath           string
+	DetectChanges        bool
+	DetectContentType    bool
+	ContentTypes         []string
+	ServerTiming         bool
+	OCITimeout           time.Duration
+	ArchiveWorkers       int
+	TenancyQuotas        map[string]int64
+	QuotaWindow          time.Duration
+	GzipPassthrough      bool
+	PARCache             bool
+	PARRateLimit         float64
+	PARRateBurst         int
+	PARRateWait          time.Duration
+	FollowIdleTimeout    time.Duration
+	ShutdownTimeout      time.Duration
+	LameDuckPeriod       time.Duration
+	DrainTimeout         time.Duration
+	DigestHeader         bool
+	Regions              []string
+	OCIEndpoint          string
+	ReadinessInterval    time.Duration
+	ReadinessFailures    int
+	PARSweepInterval     time.Duration
+	PARMaxAge            time.Duration
+	RedirectPARTTL       time.Duration
+	RedirectPARGrace     time.Duration
+	FilenameMetadataKey  string
+	ObjectPrefix         string
+	ObjectNameEncoding   string
+	DenyPatterns         []string
+	IdentityPrefixes     []string
+	IdentityHeader       string
+	TrustedProxies       []string
+	ResponseHeaders      []string
+	EventWebhook         string
+	EventBuffer          int
+	AuditLog             string
+	EncryptionKey        []byte
+	TokenKey             []byte
+	SSECustomerKeys      map[string][]byte
+	Compress             bool
+	CompressMinSize      int64
+	MaxFilenameLength    int
+	OverlongFilenames    string
+	ContentDisposition   string
+	EmptyArtifacts       string
+	CaseInsensitive      bool
+	LocalSymlinks        string
+	DirectoryDefaults    []string
+	SelftestToken        string
+	DebugToken           string
+	RestoreArchived      bool
+	RestoreHours         int
+	Maintenance          bool
+	MaintenanceRetry     time.Duration
+	MaintenanceToken     string
+	DirectFetchThreshold int64
+	DirectRanges         bool
+	HashChunkSize        int64
+	WriteChunkSize       int
+	Offload              string
+	OffloadLocalPrefix   string
+	OffloadOCIPrefix     string
+	OCIParallelParts     int
+	OCIPartSize          int64
 }
 
 func parseServerOptions(c *cli.Context) (*serverOptions, error) {
@@ -89,18 +766,369 @@ func parseServerOptions(c *cli.Context) (*serverOptions, error) {
 	port := c.Int("port")
 	cert := c.String("certfile")
 	keyf := c.String("keyfile")
+	maxDuration := c.Duration("max-download-duration")
+	idleTimeout := c.Duration("idle-timeout")
+	casLayout := c.String("cas-layout")
+	keepAlive := c.Duration("tcp-keepalive")
+	readBuffer := c.Int("tcp-read-buffer")
+	writeBuffer := c.Int("tcp-write-buffer")
+	maxConns := c.Int("max-connections")
+	maxDownloads := c.Int("max-downloads")
+	downloadQueue := c.Int("download-queue")
+	queueWait := c.Duration("download-queue-wait")
+	halfLife := c.Duration("throughput-half-life")
+	maxClient := c.Int("max-client-downloads")
+	maxQuery := c.Int("max-query-length")
+	socket := c.String("socket")
+	ociTimeout := c.Duration("oci-timeout")
+	archiveWorkers := c.Int("archive-workers")
+	quotaWindow := c.Duration("quota-window")
+	parRate := c.Float64("par-rate-limit")
+	parBurst := c.Int("par-rate-burst")
+	parWait := c.Duration("par-rate-wait")
+	followIdle := c.Duration("follow-idle-timeout")
+	shutdownTimeout := c.Duration("shutdown-timeout")
+	lameDuck := c.Duration("lame-duck-period")
+	if lameDuck < 0 {
+		return nil, fmt.Errorf("invalid lame duck period: %s", lameDuck)
+	}
+	drainTimeout := c.Duration("drain-timeout")
+	if drainTimeout < 0 {
+		return nil, fmt.Errorf("invalid drain timeout: %s", drainTimeout)
+	}
+	var regions []string
+	for _, region := range strings.Split(c.String("oci-regions"), ",") {
+		if region = strings.TrimSpace(region); region != "" {
+			regions = append(regions, region)
+		}
+	}
+	encryptionKey, err := readEncryptionKey(c.String("encryption-key-file"))
+	if err != nil {
+		return nil, err
+	}
+	tokenKey, err := readTokenKey(c.String("token-key-file"))
+	if err != nil {
+		return nil, err
+	}
+	sseKeys, err := readSSECustomerKeys(c.StringSlice("sse-customer-key-file"))
+	if err != nil {
+		return nil, err
+	}
+	redirectTTL := c.Duration("redirect-par-ttl")
+	if redirectTTL <= 0 {
+		return nil, fmt.Errorf("invalid redirect par ttl: %s", redirectTTL)
+	}
+	readyInterval := c.Duration("readiness-interval")
+	if readyInterval <= 0 {
+		return nil, fmt.Errorf("invalid readiness interval: %s", readyInterval)
+	}
+	readyFailures := c.Int("readiness-failures")
+	if readyFailures < 1 {
+		return nil, fmt.Errorf("invalid readiness failures: %d", readyFailures)
+	}
+	sweepInterval := c.Duration("par-sweep-interval")
+	if sweepInterval < 0 {
+		return nil, fmt.Errorf("invalid par sweep interval: %s", sweepInterval)
+	}
+	// A maximum age below the redirect lifetime would delete PARs clients were
+	// just given.
+	maxAge := c.Duration("par-max-age")
+	if maxAge < 0 || (maxAge > 0 && maxAge < redirectTTL) {
+		return nil, fmt.Errorf("invalid par max age: %s", maxAge)
+	}
+	// The grace window is enforced by the sweeper, and past the redirect
+	// lifetime the PARs have expired anyway.
+	redirectGrace := c.Duration("redirect-par-grace")
+	if redirectGrace < 0 || redirectGrace > redirectTTL || (redirectGrace > 0 && sweepInterval == 0) {
+		return nil, fmt.Errorf("invalid redirect par grace: %s", redirectGrace)
+	}
+	compressMin := c.Int64("compress-min-size")
+	if compressMin < 0 {
+		return nil, fmt.Errorf("invalid compress min size: %d", compressMin)
+	}
+	maxFilename := c.Int("max-filename-length")
+	if maxFilename < 0 {
+		return nil, fmt.Errorf("invalid max filename length: %d", maxFilename)
+	}
+	overlong := c.String("overlong-filenames")
+	if overlong != downloadserver.FilenameTruncate && overlong != downloadserver.FilenameReject {
+		return nil, fmt.Errorf("invalid overlong filenames: %s", overlong)
+	}
+	directoryDefaults := c.StringSlice("directory-default")
+	for _, name := range directoryDefaults {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+			return nil, fmt.Errorf("invalid directory default: %s", name)
+		}
+	}
+	symlinks := c.String("local-symlinks")
+	if symlinks != downloadserver.SymlinksRefuse && symlinks != downloadserver.SymlinksContain && symlinks != downloadserver.SymlinksFollow {
+		return nil, fmt.Errorf("invalid local symlinks: %s", symlinks)
+	}
+	disposition := c.String("content-disposition")
+	emptyArtifacts := c.String("empty-artifacts")
+	if emptyArtifacts != downloadserver.EmptyOK && emptyArtifacts != downloadserver.EmptyNoContent {
+		return nil, fmt.Errorf("invalid empty artifacts: %s", emptyArtifacts)
+	}
+	// The prefix must itself be a plain path so artifact names can't be
+	// resolved outside of it.
+	objectPrefix := c.String("object-prefix")
+	if strings.HasPrefix(objectPrefix, "/") || strings.Contains("/"+objectPrefix+"/", "/../") || strings.Contains("/"+objectPrefix+"/", "/./") {
+		return nil, fmt.Errorf("invalid object prefix: %s", objectPrefix)
+	}
+	nameEncoding := c.String("object-name-encoding")
+	if nameEncoding != downloadserver.ObjectNamePath && nameEncoding != downloadserver.ObjectNameStrict && nameEncoding != downloadserver.ObjectNameNone {
+		return nil, fmt.Errorf("invalid object name encoding: %s", nameEncoding)
+	}
+	directThreshold := c.Int64("direct-fetch-threshold")
+	hashChunkSize := c.Int64("hash-chunk-size")
+	writeChunkSize := c.Int("write-chunk-size")
+	offload := c.String("offload")
+	offloadLocal := c.String("offload-local-prefix")
+	offloadOCI := c.String("offload-oci-prefix")
+	parallelParts := c.Int("oci-parallel-parts")
+	partSize := c.Int64("oci-part-size")
 	if !validPortNumber(port) {
 		return nil, fmt.Errorf("invalid port number: %d", port)
 	}
 	if !validateCredentials(cert, keyf) {
 		return nil, errors.New("both --certfile and --keyfile must be specified")
 	}
+	clientCA := c.String("client-ca-file")
+	if clientCA != "" && cert == "" {
+		return nil, errors.New("--client-ca-file requires --certfile and --keyfile")
+	}
+	if maxDuration < 0 {
+		return nil, fmt.Errorf("invalid max download duration: %s", maxDuration)
+	}
+	slowReadRate := c.Int64("slow-read-rate")
+	if slowReadRate < 0 {
+		return nil, fmt.Errorf("invalid slow read rate: %d", slowReadRate)
+	}
+	slowReadWindow := c.Duration("slow-read-window")
+	if slowReadWindow <= 0 {
+		return nil, fmt.Errorf("invalid slow read window: %s", slowReadWindow)
+	}
+	slowReadGrace := c.Duration("slow-read-grace")
+	if slowReadGrace <= 0 {
+		return nil, fmt.Errorf("invalid slow read grace: %s", slowReadGrace)
+	}
+	if !strings.Contains(casLayout, "{digest}") {
+		return nil, fmt.Errorf("cas layout must contain {digest}: %s", casLayout)
+	}
+	if keepAlive < 0 {
+		return nil, fmt.Errorf("invalid tcp keep-alive: %s", keepAlive)
+	}
+	if readBuffer < 0 {
+		return nil, fmt.Errorf("invalid tcp read buffer: %d", readBuffer)
+	}
+	if writeBuffer < 0 {
+		return nil, fmt.Errorf("invalid tcp write buffer: %d", writeBuffer)
+	}
+	if maxConns < 0 {
+		return nil, fmt.Errorf("invalid max connections: %d", maxConns)
+	}
+	if maxDownloads < 0 {
+		return nil, fmt.Errorf("invalid max downloads: %d", maxDownloads)
+	}
+	if downloadQueue < 0 {
+		return nil, fmt.Errorf("invalid download queue: %d", downloadQueue)
+	}
+	if queueWait <= 0 {
+		return nil, fmt.Errorf("invalid download queue wait: %s", queueWait)
+	}
+	if halfLife <= 0 {
+		return nil, fmt.Errorf("invalid throughput half-life: %s", halfLife)
+	}
+	slowThreshold := c.Duration("slow-download-threshold")
+	if slowThreshold < 0 {
+		return nil, fmt.Errorf("invalid slow download threshold: %s", slowThreshold)
+	}
+	resumeTTL := c.Duration("resume-session-ttl")
+	if resumeTTL <= 0 {
+		return nil, fmt.Errorf("invalid resume session ttl: %s", resumeTTL)
+	}
+	resumeMargin := c.Int64("resume-margin")
+	if resumeMargin < 0 {
+		return nil, fmt.Errorf("invalid resume margin: %d", resumeMargin)
+	}
+	if maxClient < 0 {
+		return nil, fmt.Errorf("invalid max client downloads: %d", maxClient)
+	}
+	if maxQuery < 0 {
+		return nil, fmt.Errorf("invalid max query length: %d", maxQuery)
+	}
+	if ociTimeout < 0 {
+		return nil, fmt.Errorf("invalid oci timeout: %s", ociTimeout)
+	}
+	if archiveWorkers < 1 {
+		return nil, fmt.Errorf("invalid archive workers: %d", archiveWorkers)
+	}
+	quotas, err := parseQuotas(c.String("tenancy-quotas"))
+	if err != nil {
+		return nil, err
+	}
+	if quotaWindow <= 0 {
+		return nil, fmt.Errorf("invalid quota window: %s", quotaWindow)
+	}
+	if parRate < 0 {
+		return nil, fmt.Errorf("invalid par rate limit: %g", parRate)
+	}
+	if parBurst < 1 {
+		return nil, fmt.Errorf("invalid par rate burst: %d", parBurst)
+	}
+	if parWait < 0 {
+		return nil, fmt.Errorf("invalid par rate wait: %s", parWait)
+	}
+	if idleTimeout <= 0 {
+		return nil, fmt.Errorf("invalid idle timeout: %s", idleTimeout)
+	}
+	if followIdle <= 0 {
+		return nil, fmt.Errorf("invalid follow idle timeout: %s", followIdle)
+	}
+	eventWebhook := c.String("event-webhook")
+	if eventWebhook != "" {
+		u, err := url.Parse(eventWebhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid event webhook: %s", eventWebhook)
+		}
+	}
+	eventBuffer := c.Int("event-buffer")
+	if eventBuffer < 1 {
+		return nil, fmt.Errorf("invalid event buffer: %d", eventBuffer)
+	}
+	restoreHours := c.Int("restore-hours")
+	if restoreHours < 1 || restoreHours > 240 {
+		return nil, fmt.Errorf("invalid restore hours: %d", restoreHours)
+	}
+	maintenanceRetry := c.Duration("maintenance-retry")
+	if maintenanceRetry <= 0 {
+		return nil, fmt.Errorf("invalid maintenance retry: %s", maintenanceRetry)
+	}
+	if shutdownTimeout <= 0 {
+		return nil, fmt.Errorf("invalid shutdown timeout: %s", shutdownTimeout)
+	}
+	if disposition != downloadserver.DispositionBoth && disposition != downloadserver.DispositionLegacy {
+		return nil, fmt.Errorf("invalid content disposition: %s", disposition)
+	}
+	if directThreshold < 0 {
+		return nil, fmt.Errorf("invalid direct fetch threshold: %d", directThreshold)
+	}
+	if hashChunkSize < 1 {
+		return nil, fmt.Errorf("invalid hash chunk size: %d", hashChunkSize)
+	}
+	if writeChunkSize < 0 || writeChunkSize > downloadserver.MaxWriteChunkSize {
+		return nil, fmt.Errorf("invalid write chunk size: %d", writeChunkSize)
+	}
+	if offload != "" && offload != downloadserver.OffloadAccelRedirect && offload != downloadserver.OffloadSendfile {
+		return nil, fmt.Errorf("invalid offload: %s", offload)
+	}
+	if offloadLocal != "" && !strings.HasPrefix(offloadLocal, "/") {
+		return nil, fmt.Errorf("invalid offload local prefix: %s", offloadLocal)
+	}
+	if offloadOCI != "" && !strings.HasPrefix(offloadOCI, "/") {
+		return nil, fmt.Errorf("invalid offload oci prefix: %s", offloadOCI)
+	}
+	if parallelParts < 0 {
+		return nil, fmt.Errorf("invalid oci parallel parts: %d", parallelParts)
+	}
+	if partSize < 1 {
+		return nil, fmt.Errorf("invalid oci part size: %d", partSize)
+	}
 
 	return &serverOptions{
-		Port:     port,
-		CertFile: cert,
-		KeyFile:  keyf,
-		Debug:    debug,
+		Port:                 port,
+		CertFile:             cert,
+		KeyFile:              keyf,
+		ClientCAFile:         clientCA,
+		Debug:                debug,
+		MaxDownloadDuration:  maxDuration,
+		IdleTimeout:          idleTimeout,
+		SlowReadRate:         slowReadRate,
+		SlowReadWindow:       slowReadWindow,
+		SlowReadGrace:        slowReadGrace,
+		CASLayout:            casLayout,
+		ArtifactTemplate:     c.String("artifact-template"),
+		TCPKeepAlive:         keepAlive,
+		TCPReadBuffer:        readBuffer,
+		TCPWriteBuffer:       writeBuffer,
+		TCPNoDelay:           c.BoolT("tcp-nodelay"),
+		MaxConnections:       maxConns,
+		MaxDownloads:         maxDownloads,
+		DownloadQueue:        downloadQueue,
+		DownloadQueueWait:    queueWait,
+		ThroughputHalfLife:   halfLife,
+		SlowThreshold:        slowThreshold,
+		ResumeSessionTTL:     resumeTTL,
+		ResumeMargin:         resumeMargin,
+		DeadlineHeader:       c.String("deadline-header"),
+		MaxClientDownloads:   maxClient,
+		MaxQueryLength:       maxQuery,
+		SocketPath:           socket,
+		DetectChanges:        c.Bool("detect-changes"),
+		DetectContentType:    c.Bool("detect-content-type"),
+		ContentTypes:         c.StringSlice("content-type"),
+		ServerTiming:         c.Bool("server-timing"),
+		OCITimeout:           ociTimeout,
+		ArchiveWorkers:       archiveWorkers,
+		TenancyQuotas:        quotas,
+		QuotaWindow:          quotaWindow,
+		GzipPassthrough:      c.Bool("gzip-passthrough"),
+		PARCache:             c.Bool("par-cache"),
+		PARRateLimit:         parRate,
+		PARRateBurst:         parBurst,
+		PARRateWait:          parWait,
+		FollowIdleTimeout:    followIdle,
+		ShutdownTimeout:      shutdownTimeout,
+		LameDuckPeriod:       lameDuck,
+		DrainTimeout:         drainTimeout,
+		DigestHeader:         c.Bool("digest-header"),
+		Regions:              regions,
+		OCIEndpoint:          c.String("oci-endpoint"),
+		ReadinessInterval:    readyInterval,
+		ReadinessFailures:    readyFailures,
+		PARSweepInterval:     sweepInterval,
+		PARMaxAge:            maxAge,
+		RedirectPARTTL:       redirectTTL,
+		RedirectPARGrace:     redirectGrace,
+		FilenameMetadataKey:  c.String("filename-metadata-key"),
+		ObjectPrefix:         objectPrefix,
+		ObjectNameEncoding:   nameEncoding,
+		DenyPatterns:         c.StringSlice("deny-pattern"),
+		IdentityPrefixes:     c.StringSlice("identity-prefix"),
+		IdentityHeader:       c.String("identity-header"),
+		TrustedProxies:       c.StringSlice("trusted-proxy"),
+		ResponseHeaders:      c.StringSlice("response-header"),
+		EventWebhook:         eventWebhook,
+		EventBuffer:          eventBuffer,
+		AuditLog:             c.String("audit-log"),
+		EncryptionKey:        encryptionKey,
+		TokenKey:             tokenKey,
+		SSECustomerKeys:      sseKeys,
+		Compress:             c.Bool("compress"),
+		CompressMinSize:      compressMin,
+		MaxFilenameLength:    maxFilename,
+		OverlongFilenames:    overlong,
+		ContentDisposition:   disposition,
+		EmptyArtifacts:       emptyArtifacts,
+		CaseInsensitive:      c.Bool("case-insensitive"),
+		LocalSymlinks:        symlinks,
+		DirectoryDefaults:    directoryDefaults,
+		SelftestToken:        c.String("selftest-token"),
+		DebugToken:           c.String("debug-token"),
+		RestoreArchived:      c.Bool("restore-archived"),
+		RestoreHours:         restoreHours,
+		Maintenance:          c.Bool("maintenance"),
+		MaintenanceRetry:     maintenanceRetry,
+		MaintenanceToken:     c.String("maintenance-token"),
+		DirectFetchThreshold: directThreshold,
+		DirectRanges:         c.Bool("direct-ranges"),
+		HashChunkSize:        hashChunkSize,
+		WriteChunkSize:       writeChunkSize,
+		Offload:              offload,
+		OffloadLocalPrefix:   offloadLocal,
+		OffloadOCIPrefix:     offloadOCI,
+		OCIParallelParts:     parallelParts,
+		OCIPartSize:          partSize,
 	}, nil
 }
 
@@ -109,6 +1137,68 @@ func validPortNumber(port int) bool {
 	return port > 0 && port < 65535
 }
 
+// parseQuotas parses a list of tenancy=bytes quotas.
+func parseQuotas(s string) (map[string]int64, error) {
+	quotas := make(map[string]int64)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid tenancy quota: %s", item)
+		}
+		n, err := strconv.ParseInt(kv[1], 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid tenancy quota: %s", item)
+		}
+		quotas[kv[0]] = n
+	}
+	return quotas, nil
+}
+
+// readEncryptionKey reads a hex encoded AES-256 key from path. No path means no key.
+func readEncryptionKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("invalid encryption key in %s: must be 64 hex characters", path)
+	}
+	return key, nil
+}
+
+// readSSECustomerKeys reads the base64 encoded AES-256 SSE-C keys of the
+// bucket=file entries.
+func readSSECustomerKeys(entries []string) (map[string][]byte, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	keys := make(map[string][]byte)
+	for _, entry := range entries {
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid sse customer key file: %s", entry)
+		}
+		data, err := ioutil.ReadFile(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("invalid sse customer key in %s: must be a base64 encoded 32 byte key", kv[1])
+		}
+		keys[kv[0]] = key
+	}
+	return keys, nil
+}
+
 // validate all HTTPS stuff is present
 func validateCredentials(cert string, keyf string) bool {
 	if cert == "" && keyf != "" {
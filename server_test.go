@@ -0,0 +1,52 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+// parseEnv parses the server options with the environment variable name set to
+// value and no flags.
+func parseEnv(t *testing.T, name string, value string) (*serverOptions, error) {
+	if old, ok := os.LookupEnv(name); ok {
+		defer os.Setenv(name, old)
+	} else {
+		defer os.Unsetenv(name)
+	}
+	os.Setenv(name, value)
+	var opts *serverOptions
+	cmd := serverCommand
+	cmd.Action = func(c *cli.Context) error {
+		var err error
+		opts, err = parseServerOptions(c)
+		return err
+	}
+	app := cli.NewApp()
+	app.Writer = ioutil.Discard
+	app.ErrWriter = ioutil.Discard
+	app.Commands = []cli.Command{cmd}
+	err := app.Run([]string{"runner-download", "server"})
+	return opts, err
+}
+
+func TestSocketBufferEnv(t *testing.T) {
+	for _, name := range []string{"TCP_READ_BUFFER", "TCP_WRITE_BUFFER"} {
+		opts, err := parseEnv(t, name, "65536")
+		if err != nil {
+			t.Fatalf("%s=65536: %v", name, err)
+		}
+		if opts.TCPReadBuffer+opts.TCPWriteBuffer != 65536 {
+			t.Errorf("%s=65536 gives buffers %d and %d", name, opts.TCPReadBuffer, opts.TCPWriteBuffer)
+		}
+		for _, value := range []string{"-1", "64k", "lots"} {
+			if _, err := parseEnv(t, name, value); err == nil {
+				t.Errorf("%s=%s accepted", name, value)
+			}
+		}
+	}
+}
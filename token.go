@@ -0,0 +1,97 @@
+// Copyright (c) 2018, 2019, Oracle and/or its affiliates. All rights reserved.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/wercker/runner-download/downloadserver"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+// minTokenKeyLength is the shortest secret accepted for signing download tokens.
+const minTokenKeyLength = 32
+
+var tokenKeyFlag = cli.StringFlag{
+	Name:   "token-key-file",
+	Usage:  "file holding the secret that signs download tokens, enables downloads from signed token paths",
+	EnvVar: "TOKEN_KEY_FILE",
+}
+
+var tokenCommand = cli.Command{
+	Name:   "token",
+	Usage:  "print a signed download path for an artifact",
+	Action: tokenAction,
+	Flags: []cli.Flag{
+		tokenKeyFlag,
+		cli.StringFlag{
+			Name:  "artifact",
+			Usage: "artifact to download, as given with a=",
+		},
+		cli.StringFlag{
+			Name:  "storepath",
+			Usage: "local storepath of the artifact, as given with s=",
+		},
+		cli.StringFlag{
+			Name:  "tenancy",
+			Usage: "OCI tenancy of the artifact, as given with t=",
+		},
+		cli.StringFlag{
+			Name:  "namespace",
+			Usage: "OCI namespace of the artifact, as given with n=",
+		},
+		cli.DurationFlag{
+			Name:  "ttl",
+			Value: time.Hour,
+			Usage: "time until the token expires",
+		},
+	},
+}
+
+var tokenAction = func(c *cli.Context) error {
+	key, err := readTokenKey(c.String("token-key-file"))
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return errors.New("--token-key-file is required")
+	}
+	if c.String("artifact") == "" {
+		return errors.New("--artifact is required")
+	}
+	if c.Duration("ttl") <= 0 {
+		return fmt.Errorf("invalid ttl: %s", c.Duration("ttl"))
+	}
+	token, err := downloadserver.SignDownloadToken(key, downloadserver.DownloadToken{
+		Artifact:  c.String("artifact"),
+		StorePath: c.String("storepath"),
+		Tenancy:   c.String("tenancy"),
+		Namespace: c.String("namespace"),
+		Expires:   time.Now().Add(c.Duration("ttl")).UTC().Truncate(time.Second),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("/api/v3/operator/artifact/download/%s\n", token)
+	return nil
+}
+
+// readTokenKey reads the secret for signing download tokens from path.
+func readTokenKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := []byte(strings.TrimSpace(string(data)))
+	if len(key) < minTokenKeyLength {
+		return nil, fmt.Errorf("invalid token key in %s: must be at least %d bytes", path, minTokenKeyLength)
+	}
+	return key, nil
+}